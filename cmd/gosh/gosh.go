@@ -1,24 +1,124 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/cover"
+	"github.com/pdk/gosh/debug"
+	"github.com/pdk/gosh/diag"
+	"github.com/pdk/gosh/doc"
+	"github.com/pdk/gosh/format"
+	"github.com/pdk/gosh/lsp"
+	"github.com/pdk/gosh/pprof"
 	"github.com/pdk/gosh/reader"
 	"github.com/pdk/gosh/repl"
+	"github.com/pdk/gosh/test"
+	"github.com/pdk/gosh/trace"
 )
 
+var vm = flag.Bool("vm", false, "run scripts in the bytecode machine, caching the bytecode in .goshc files")
+var maxDepth = flag.Int("max-depth", compile.DefaultMaxDepth, "maximum depth of function calls")
+var warn = flag.Bool("warn", false, "report warnings, e.g. of unused variables, before running scripts")
+var profile = flag.String("profile", "", "write a pprof profile of the time and memory gosh funcs and lines use to the file")
+var tracing = flag.Bool("trace", false, "log each node evaluated, with the values of its operands and results")
+var traceOut = flag.String("trace-out", "", "write the trace to the file, rather than stderr")
+var traceFormat = flag.String("trace-format", "text", "format of the trace: text, or json for JSON lines")
+var traceFunc = flag.String("trace-func", "", "trace only the funcs matching the regular expression; the top level is <top level>")
+var traceFile = flag.String("trace-file", "", "trace only the files matching the regular expression")
+var traceEvery = flag.Int("trace-every", 1, "log only one in every n evaluations traced")
+var errorFormat = flag.String("error-format", "text", "format of error messages: text, or json for editors")
+
 func main() {
 
-	if len(os.Args) > 1 {
-		inputName := os.Args[1]
+	flag.Parse()
+
+	if *errorFormat != "text" && *errorFormat != "json" {
+		fmt.Fprintf(os.Stderr, "unknown error format %q, expected text or json\n", *errorFormat)
+		os.Exit(2)
+	}
+
+	if *tracing && *profile != "" {
+		fmt.Fprintf(os.Stderr, "--trace and --profile cannot be used together\n")
+		os.Exit(2)
+	}
+
+	if *vm && (*tracing || *profile != "") {
+		fmt.Fprintf(os.Stderr, "--vm cannot be used with --trace or --profile\n")
+		os.Exit(2)
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "check" {
+		os.Exit(checkFiles(flag.Args()[1:]))
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "fmt" {
+		os.Exit(formatFiles(flag.Args()[1:]))
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "test" {
+		os.Exit(testFiles(flag.Args()[1:]))
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "cover" {
+		os.Exit(coverReport(flag.Args()[1:]))
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "doc" {
+		os.Exit(docFiles(flag.Args()[1:]))
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "debug" {
+		os.Exit(debugFile(flag.Args()[1:]))
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "lsp" {
+		if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			fmt.Fprintf(os.Stderr, "gosh lsp: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() > 0 {
+		inputName := flag.Arg(0)
+
+		if strings.HasSuffix(inputName, ".goshc") {
+			if *tracing || *profile != "" {
+				fmt.Fprintf(os.Stderr, "%s is bytecode, which can't be traced or profiled\n", inputName)
+				os.Exit(2)
+			}
+
+			prog, err := readCompiled(inputName)
+			if err != nil {
+				reportError(nil, err)
+				os.Exit(1)
+			}
+
+			run(prog)
+			return
+		}
+
 		input, err := reader.ReadLines(inputName)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s", err)
+			reportError(nil, err)
+			os.Exit(1)
+		}
+
+		if *vm {
+			executeCompiled(inputName, input, inputName+"c")
+			return
 		}
 
 		execute(inputName, input)
@@ -27,6 +127,12 @@ func main() {
 
 	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
 		input := reader.ReadLinesToStrings(os.Stdin)
+
+		if *vm {
+			executeCompiled("stdin", input, "")
+			return
+		}
+
 		execute("stdin", input)
 		return
 	}
@@ -37,14 +143,592 @@ func main() {
 
 func execute(inputName string, input []string) {
 
-	topContext := compile.GlobalScope()
+	prog, err := compile.Compile(inputName, input)
+	if err != nil {
+		reportError(nil, err)
+		os.Exit(1)
+	}
+
+	if !check(prog) {
+		os.Exit(1)
+	}
+
+	run(prog)
+}
+
+// executeCompiled runs the input in the bytecode machine. If a cache file is
+// named, the bytecode is read from it when it is up to date, and otherwise
+// written to it.
+func executeCompiled(inputName string, input []string, cacheName string) {
+
+	if cacheName != "" {
+		prog, err := readCompiled(cacheName)
+		if err == nil && prog.Checksum() == compile.Checksum(input) {
+			run(prog)
+			return
+		}
+	}
+
+	prog, err := compile.CompileBytecode(inputName, input)
+	if err != nil {
+		reportError(nil, err)
+		os.Exit(1)
+	}
+
+	if !check(prog) {
+		os.Exit(1)
+	}
+
+	if cacheName != "" {
+		writeCompiled(cacheName, prog)
+	}
+
+	run(prog)
+}
+
+// readCompiled reads a .goshc file.
+func readCompiled(fileName string) (*compile.Program, error) {
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return compile.ReadBytecode(f)
+}
+
+// writeCompiled writes a .goshc file. The file is only a cache, so failing to
+// write it is not an error.
+func writeCompiled(fileName string, prog *compile.Program) {
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return
+	}
+
+	err = prog.WriteBytecode(f)
+	f.Close()
+	if err != nil {
+		os.Remove(fileName)
+	}
+}
+
+// run runs a compiled program in a fresh global scope, profiling or tracing
+// it if asked.
+func run(prog *compile.Program) {
+
+	if *profile != "" {
+		runProfiled(prog)
+		return
+	}
+
+	if *tracing {
+		runTraced(prog)
+		return
+	}
+
+	vals, err := prog.Run(globalScope())
+	if err != nil {
+		reportError(prog, err)
+	}
+
+	report(vals)
+}
+
+// profilePeriod is the time between samples of a profiled program.
+const profilePeriod = 10 * time.Millisecond
+
+// runProfiled runs a program, sampling where it spends its time, and writes
+// the profile.
+func runProfiled(prog *compile.Program) {
+
+	pr, err := compile.NewProfiler(prog, profilePeriod)
+	if err != nil {
+		reportError(prog, err)
+		return
+	}
+
+	vals, err := pr.Run(globalScope())
+	if err != nil {
+		reportError(prog, err)
+	}
+
+	report(vals)
+
+	f, err := os.Create(*profile)
+	if err == nil {
+		err = pprof.Write(f, pr.Profile())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		reportError(nil, err)
+	}
+}
+
+// runTraced runs a program, logging each node it evaluates, as selected by the
+// trace flags.
+func runTraced(prog *compile.Program) {
+
+	options := compile.TraceOptions{Every: *traceEvery}
+
+	var err error
+	if *traceFunc != "" {
+		if options.Function, err = regexp.Compile(*traceFunc); err != nil {
+			reportError(nil, err)
+			return
+		}
+	}
+	if *traceFile != "" {
+		if options.File, err = regexp.Compile(*traceFile); err != nil {
+			reportError(nil, err)
+			return
+		}
+	}
+
+	var out io.Writer = os.Stderr
+	if *traceOut != "" {
+		f, err := os.Create(*traceOut)
+		if err != nil {
+			reportError(nil, err)
+			return
+		}
+		defer f.Close()
+
+		bw := bufio.NewWriter(f)
+		defer bw.Flush()
+		out = bw
+	}
+
+	tw, err := trace.NewWriter(out, *traceFormat)
+	if err != nil {
+		reportError(nil, err)
+		return
+	}
+
+	tr, err := compile.NewTracer(prog, options, tw.Log)
+	if err != nil {
+		reportError(prog, err)
+		return
+	}
+
+	vals, err := tr.Run(globalScope())
+	if err != nil {
+		reportError(prog, err)
+	}
+
+	report(vals)
+
+	if err := tw.Err(); err != nil {
+		reportError(nil, err)
+	}
+}
+
+// globalScope returns a new global scope, with the options given by flags.
+func globalScope() *compile.Variables {
+
+	scope := compile.GlobalScope()
+	scope.SetMaxDepth(*maxDepth)
+
+	return scope
+}
+
+// checkFiles checks scripts without running them, reporting all the problems
+// found, including type errors, and warnings. It returns the exit status: 1 if
+// any errors were found.
+func checkFiles(fileNames []string) int {
+
+	status := 0
+
+	for _, fileName := range fileNames {
+		input, err := reader.ReadLines(fileName)
+		if err != nil {
+			reportError(nil, err)
+			status = 1
+			continue
+		}
+
+		prog, err := compile.Analyze(fileName, input)
+		if err != nil {
+			reportError(nil, err)
+			status = 1
+			continue
+		}
+
+		var ds []diag.Diagnostic
+		for _, problem := range prog.Problems() {
+			d := diag.FromProblem(problem)
+			d.Suggest(prog.Unbound())
+			ds = append(ds, d)
+
+			if !problem.Warning {
+				status = 1
+			}
+		}
+
+		sort.SliceStable(ds, func(i, j int) bool {
+			if ds[i].Line != ds[j].Line {
+				return ds[i].Line < ds[j].Line
+			}
+			return ds[i].Column < ds[j].Column
+		})
+
+		p := printer()
+		for _, d := range ds {
+			p.Print(d)
+		}
+	}
+
+	return status
+}
+
+// formatFiles formats scripts, printing them, or with -w rewriting them, or
+// with -d printing the differences. It returns the exit status: 1 if any
+// couldn't be formatted.
+func formatFiles(args []string) int {
+
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the formatted source to the file, rather than printing it")
+	showDiff := flags.Bool("d", false, "print the differences the formatting makes, rather than the formatted source")
+	flags.Parse(args)
+
+	status := 0
+
+	for _, fileName := range flags.Args() {
+		input, err := reader.ReadLines(fileName)
+		if err != nil {
+			reportError(nil, err)
+			status = 1
+			continue
+		}
+
+		output, err := format.Source(fileName, input)
+		if err != nil {
+			reportError(nil, err)
+			status = 1
+			continue
+		}
+
+		switch {
+		case *showDiff:
+			fmt.Print(format.Diff(fileName, input, output))
+
+		case *write:
+			if strings.Join(input, "\n") == strings.Join(output, "\n") {
+				continue
+			}
+			err := ioutil.WriteFile(fileName, []byte(strings.Join(output, "\n")+"\n"), 0644)
+			if err != nil {
+				reportError(nil, err)
+				status = 1
+			}
+
+		default:
+			for _, line := range output {
+				fmt.Println(line)
+			}
+		}
+	}
+
+	return status
+}
+
+// docFiles documents the files named, and the .gosh files under the
+// directories named, by default the current directory: an index of their
+// declarations, or, given a name first which isn't a file, the declarations of
+// that name. It returns the exit status: 1 if the name isn't declared.
+func docFiles(args []string) int {
+
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	markdown := flags.Bool("md", false, "write the documentation as Markdown")
+	page := flags.Bool("html", false, "write the documentation as an HTML page")
+	flags.Parse(args)
+
+	paths, name := flags.Args(), ""
+	if len(paths) > 0 {
+		if _, err := os.Stat(paths[0]); err != nil {
+			name, paths = paths[0], paths[1:]
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	fileNames, err := doc.Find(paths)
+	if err != nil {
+		reportError(nil, err)
+		return 1
+	}
+
+	var pkgs []*doc.Package
+	for _, fileName := range fileNames {
+		input, err := reader.ReadLines(fileName)
+		if err != nil {
+			reportError(nil, err)
+			return 1
+		}
+		pkgs = append(pkgs, doc.Parse(fileName, input))
+	}
+
+	switch {
+	case name != "":
+		found := doc.Lookup(pkgs, name)
+		if len(found) == 0 {
+			fmt.Fprintf(os.Stderr, "gosh doc: no declaration of %s\n", name)
+			return 1
+		}
+		for i, d := range found {
+			if i > 0 {
+				fmt.Println()
+			}
+			err = doc.Text(os.Stdout, d)
+		}
+
+	case *markdown:
+		err = doc.Markdown(os.Stdout, pkgs)
+
+	case *page:
+		err = doc.HTML(os.Stdout, pkgs)
+
+	default:
+		err = doc.Index(os.Stdout, pkgs)
+	}
+
+	if err != nil {
+		reportError(nil, err)
+		return 1
+	}
+
+	return 0
+}
+
+// testFiles runs the tests of the files named, and the *_test.gosh files under
+// the directories named, by default the current directory. It returns the exit
+// status: 1 if any test failed.
+func testFiles(args []string) int {
+
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	run := flags.String("run", "", "run only the tests matching the regular expression")
+	verbose := flags.Bool("v", false, "report each test, not just each file")
+	coverage := flags.Bool("cover", false, "report the coverage of each file")
+	profile := flags.String("coverprofile", "", "write a coverage profile to the file, for gosh cover")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	r := test.Runner{
+		Printer: printer(),
+		Verbose: *verbose,
+		Scope:   globalScope,
+	}
+	// results go to stdout, with the failures among them
+	r.Printer.Out = os.Stdout
+	r.Printer.Color = r.Printer.Color && terminal.IsTerminal(int(os.Stdout.Fd()))
+
+	if *coverage || *profile != "" {
+		r.Coverage = compile.NewCoverage()
+	}
+
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosh test: bad -run: %s\n", err)
+			return 2
+		}
+		r.Run = re
+	}
+
+	fileNames, err := test.Find(paths)
+	if err != nil {
+		reportError(nil, err)
+		return 1
+	}
+
+	status := 0
+	if !r.Files(fileNames) {
+		status = 1
+	}
+
+	if *profile != "" {
+		f, err := os.Create(*profile)
+		if err == nil {
+			err = cover.WriteProfile(f, r.Coverage.Blocks())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			reportError(nil, err)
+			return 1
+		}
+	}
+
+	return status
+}
+
+// coverReport reports a coverage profile written by gosh test: the source of
+// each file, with how many times each line ran, or with -html, a page showing
+// the lines which ran and which never ran. It returns the exit status.
+func coverReport(args []string) int {
+
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	page := flags.String("html", "", "write an HTML report to the file, rather than annotated source to stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: gosh cover [-html=file.html] profile\n")
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		reportError(nil, err)
+		return 1
+	}
+	defer f.Close()
+
+	blocks, err := cover.ReadProfile(f)
+	if err != nil {
+		reportError(nil, err)
+		return 1
+	}
 
-	vals, err := repl.Evaluate(inputName, input, topContext)
+	sources := make(map[string][]string)
+	for _, s := range cover.Summarize(blocks) {
+		input, err := reader.ReadLines(s.File)
+		if err != nil {
+			reportError(nil, err)
+			return 1
+		}
+		sources[s.File] = input
+	}
+
+	if *page == "" {
+		err = cover.Annotate(os.Stdout, sources, blocks)
+	} else {
+		var out *os.File
+		out, err = os.Create(*page)
+		if err == nil {
+			err = cover.HTML(out, sources, blocks)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s", err)
+		reportError(nil, err)
+		return 1
 	}
 
+	return 0
+}
+
+// debugFile debugs a script in the terminal, or with --dap serves the Debug
+// Adapter Protocol on stdin and stdout, for an editor, which names the script
+// to launch. It returns the exit status.
+func debugFile(args []string) int {
+
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	dap := flags.Bool("dap", false, "serve the Debug Adapter Protocol on stdin and stdout")
+	flags.Parse(args)
+
+	if *dap {
+		if err := debug.NewServer(os.Stdin, os.Stdout, globalScope()).Serve(); err != nil {
+			fmt.Fprintf(os.Stderr, "gosh debug: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: gosh debug [--dap] [file.gosh]\n")
+		return 2
+	}
+
+	fileName := flags.Arg(0)
+
+	input, err := reader.ReadLines(fileName)
+	if err != nil {
+		reportError(nil, err)
+		return 1
+	}
+
+	prog, err := compile.Compile(fileName, input)
+	if err != nil {
+		reportError(nil, err)
+		return 1
+	}
+
+	if !check(prog) {
+		return 1
+	}
+
+	if err := debug.Start(prog, input, globalScope(), os.Stdin, os.Stdout); err != nil {
+		reportError(prog, err)
+		return 1
+	}
+
+	return 0
+}
+
+// check reports the problems found in a program before running it, returning
+// false if there are errors. Warnings are only reported with --warn.
+func check(prog *compile.Program) bool {
+
+	p := printer()
+	ok := true
+
+	for _, problem := range prog.Check() {
+		if problem.Warning && !*warn {
+			continue
+		}
+
+		d := diag.FromProblem(problem)
+		d.Suggest(prog.Unbound())
+		p.Print(d)
+
+		ok = ok && problem.Warning
+	}
+
+	return ok
+}
+
+// reportError prints diagnostics for an error, with suggestions for any
+// unbound identifiers of the program it names.
+func reportError(prog *compile.Program, err error) {
+
+	p := printer()
+
+	for _, d := range diag.FromErrors(err) {
+		if prog != nil {
+			d.Suggest(prog.Unbound())
+		}
+		p.Print(d)
+	}
+}
+
+// printer returns the printer of diagnostics chosen by flags. Text is colored
+// when stderr is a terminal, unless NO_COLOR is set.
+func printer() diag.Printer {
+
+	_, noColor := os.LookupEnv("NO_COLOR")
+
+	return diag.Printer{
+		Out:   os.Stderr,
+		JSON:  *errorFormat == "json",
+		Color: !noColor && terminal.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+// report prints the results of running a script.
+func report(vals []compile.Value) {
+
 	if len(vals) > 0 {
 		printable := []string{}
 		for _, v := range vals {
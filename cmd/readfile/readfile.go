@@ -21,7 +21,7 @@ func main() {
 	// 	fmt.Printf("%3d. %s\n", i+1, l)
 	// }
 
-	lex := lexer.New(result)
+	lex := lexer.New(os.Args[1], result)
 
 	// for t := lex.Next(); t != nil; t = lex.Next() {
 	// 	fmt.Printf("%s\n", t.String())
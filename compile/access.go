@@ -0,0 +1,420 @@
+package compile
+
+import (
+	"github.com/pdk/gosh/parse"
+	"github.com/pdk/gosh/token"
+)
+
+// ListOperator handles both list literals and indexing.
+// [a, b, c] ==> a new list
+// x[i] ==> the i'th item of list x, the field/key i of struct/map x
+func ListOperator(n *Node) (Evaluator, error) {
+
+	if n.arity == parse.Lefty {
+		return IndexOperator(n)
+	}
+
+	return ListLiteral(n)
+}
+
+// ListLiteral creates a new list.
+func ListLiteral(n *Node) (Evaluator, error) {
+
+	items, err := MultiValueOperator(n)
+	if err != nil {
+		return nil, err
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		vals, err := items(vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		return Values(NewList(vals...)), nil
+	}
+
+	return e, nil
+}
+
+// IndexOperator retrieves a value from a list, map or struct.
+func IndexOperator(n *Node) (Evaluator, error) {
+
+	if len(n.children) != 2 {
+		return nil, n.Error("expected a single index")
+	}
+
+	if isSlice(n) {
+		return SliceOperator(n)
+	}
+
+	target, index, err := LeftRightEvaluators(n)
+	if err != nil {
+		return nil, err
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		t, i, err := StandardBinaryEval(n, target, index, vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		v, err := indexValue(n, vars, t, i)
+
+		return Values(v), err
+	}
+
+	return e, nil
+}
+
+// isSlice checks if a node is x[i:j].
+func isSlice(n *Node) bool {
+	return n.IsToken(token.LSQR) && n.arity == parse.Lefty && len(n.children) == 2 &&
+		n.children[1].IsToken(token.COLON)
+}
+
+// SliceOperator gets a sub-list of a list. A nil bound is the start or end.
+// x[i:j] ==> a new list of the items of x from offset i to j, inclusive
+func SliceOperator(n *Node) (Evaluator, error) {
+
+	target, err := n.children[0].Evaluator()
+	if err != nil {
+		return nil, err
+	}
+
+	low, high, err := LeftRightEvaluators(n.children[1])
+	if err != nil {
+		return nil, err
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		t, err := StandardSingleEval(n, target, vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		l, h, err := StandardBinaryEval(n, low, high, vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		v, err := sliceValue(n, t, l, h)
+
+		return Values(v), err
+	}
+
+	return e, nil
+}
+
+// sliceValue gets the items of a list from offset low to high, inclusive.
+func sliceValue(n *Node, target, low, high Value) (Value, error) {
+
+	l, ok := target.(*List)
+	if !ok {
+		return nil, n.Error("cannot slice %T", target)
+	}
+
+	from, err := sliceBound(n, low, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := sliceBound(n, high, len(l.items)-1)
+	if err != nil {
+		return nil, err
+	}
+
+	if from < 0 || to >= len(l.items) || to < from-1 {
+		return nil, n.Error("slice [%d:%d] out of range, length %d", from, to, len(l.items))
+	}
+
+	return NewList(l.items[from : to+1]...), nil
+}
+
+// sliceBound converts a bound of a slice to an offset. nil is the default.
+func sliceBound(n *Node, bound Value, def int) (int, error) {
+
+	if bound == nil {
+		return def, nil
+	}
+
+	i, ok := bound.(int64)
+	if !ok {
+		return 0, n.Error("slice bounds must be ints, got %T", bound)
+	}
+
+	return int(i), nil
+}
+
+// indexValue gets the value at an index of a list, map or struct.
+func indexValue(n *Node, vars *Variables, target, index Value) (Value, error) {
+
+	switch t := target.(type) {
+	case *List:
+		i, ok := index.(int64)
+		if !ok {
+			return nil, n.Error("list index must be an int, got %T", index)
+		}
+		v, err := t.Get(int(i))
+		return v, n.IfError(err, "%s", err)
+
+	case *Map:
+		if i, ok := index.(int64); ok {
+			if i < 0 || int(i) >= len(t.keys) {
+				return nil, n.Error("map offset %d out of range, length %d", i, len(t.keys))
+			}
+			return t.values[i], nil
+		}
+		v, err := t.get(NewEquality(n), index)
+		if err != nil {
+			return nil, n.LocateError(err)
+		}
+		return v, nil
+
+	case *Struct:
+		switch i := index.(type) {
+		case int64:
+			fields := t.Fields()
+			if i < 0 || int(i) >= len(fields) {
+				return nil, n.Error("struct offset %d out of range, %d field(s)", i, len(fields))
+			}
+			return t.values[fields[i]], nil
+		case string:
+			return fieldValue(n, t, i)
+		}
+		return nil, n.Error("struct index must be an int or string, got %T", index)
+	}
+
+	return nil, n.Error("cannot index %T", target)
+}
+
+// fieldValue gets the value of a named field of a struct.
+func fieldValue(n *Node, s *Struct, name string) (Value, error) {
+
+	v, ok := s.Get(name)
+	if !ok {
+		return nil, n.Error("%s has no field %s", s.typeName, name)
+	}
+
+	return v, nil
+}
+
+// FieldAccess gets a field of a struct, or a key of a map.
+// x.f
+func FieldAccess(n *Node) (Evaluator, error) {
+
+	if len(n.children) != 2 || !n.children[1].IsToken(token.IDENT) {
+		return nil, n.Error("expected a field name after .")
+	}
+
+	target, err := LeftEval(n)
+	if err != nil {
+		return nil, err
+	}
+
+	name := n.children[1].Literal()
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		t, err := StandardSingleEval(n, target, vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		v, err := fieldOf(n, t, name)
+		if err != nil {
+			return Values(), err
+		}
+
+		return Values(v), nil
+	}
+
+	return e, nil
+}
+
+// fieldOf gets a field of a struct, or a key of a map.
+func fieldOf(n *Node, target Value, name string) (Value, error) {
+
+	switch t := target.(type) {
+	case *Struct:
+		return fieldValue(n, t, name)
+	case *Map:
+		v, _ := t.Get(name)
+		return v, nil
+	}
+
+	return nil, n.Error("cannot access field %s of %T", name, target)
+}
+
+// MethodApplication invokes a method. Methods of a struct are invoked with
+// the struct bound to the first parameter. Lists, maps and structs have
+// standard methods.
+// (m-apply x m args...)
+func MethodApplication(n *Node) (Evaluator, error) {
+
+	if len(n.children) < 2 || !n.children[1].IsToken(token.IDENT) {
+		return nil, n.Error("expected a method name after .")
+	}
+
+	target, err := LeftEval(n)
+	if err != nil {
+		return nil, err
+	}
+
+	name := n.children[1].Literal()
+
+	var argEvals []Evaluator
+	for _, child := range n.children[2:] {
+		eval, err := child.Evaluator()
+		if err != nil {
+			return nil, err
+		}
+
+		argEvals = append(argEvals, eval)
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		t, err := StandardSingleEval(n, target, vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		var args []Value
+		for _, eachEval := range argEvals {
+			val, err := eachEval(vars)
+			if err != nil {
+				return Values(), err
+			}
+			args = append(args, val...)
+		}
+
+		return applyMethod(n, vars, t, name, args)
+	}
+
+	return e, nil
+}
+
+// applyMethod invokes a standard method, or a method of a struct.
+func applyMethod(n *Node, vars *Variables, target Value, name string, args []Value) ([]Value, error) {
+
+	if m, ok := standardMethod(target, name); ok {
+		return m(n, vars, target, args)
+	}
+
+	if s, ok := target.(*Struct); ok {
+		if f, ok := s.Method(name); ok {
+			return callFunction(n, f, append(Values(s), args...))
+		}
+	}
+
+	return Values(), n.Error("%s has no method %s", TypeName(target), name)
+}
+
+// assigner sets a value, e.g. a variable, field or list item.
+type assigner func(vars *Variables, v Value) error
+
+// assignTarget returns an assigner for the left-hand side of an assignment,
+// which is a variable, x.f, or x[i].
+func assignTarget(target *Node) (assigner, error) {
+
+	switch {
+	case target.IsToken(token.IDENT):
+		name, addr := target.Literal(), target.address
+		return func(vars *Variables, v Value) error {
+			return vars.SetAt(name, addr, v)
+		}, nil
+
+	case target.IsToken(token.PERIOD) && len(target.children) == 2 && target.children[1].IsToken(token.IDENT):
+		obj, err := LeftEval(target)
+		if err != nil {
+			return nil, err
+		}
+		name := target.children[1].Literal()
+
+		return func(vars *Variables, v Value) error {
+			o, err := StandardSingleEval(target, obj, vars)
+			if err != nil {
+				return err
+			}
+			return setField(target, o, name, v)
+		}, nil
+
+	case target.IsToken(token.LSQR) && target.arity == parse.Lefty && len(target.children) == 2 && !isSlice(target):
+		obj, index, err := LeftRightEvaluators(target)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(vars *Variables, v Value) error {
+			o, i, err := StandardBinaryEval(target, obj, index, vars)
+			if err != nil {
+				return err
+			}
+			return setIndex(target, vars, o, i, v)
+		}, nil
+	}
+
+	return nil, target.Error("cannot assign to %s", target.Literal())
+}
+
+// setField sets a field of a struct, or a key of a map.
+func setField(n *Node, target Value, name string, v Value) error {
+
+	switch o := target.(type) {
+	case *Struct:
+		if _, ok := o.Get(name); !ok {
+			return n.Error("%s has no field %s", o.typeName, name)
+		}
+		o.Set(name, v)
+		return nil
+	case *Map:
+		o.Set(name, v)
+		return nil
+	}
+
+	return n.Error("cannot assign field %s of %T", name, target)
+}
+
+// setIndex sets the value at an index of a list, map or struct.
+func setIndex(n *Node, vars *Variables, target, index, v Value) error {
+
+	switch t := target.(type) {
+	case *List:
+		i, ok := index.(int64)
+		if !ok {
+			return n.Error("list index must be an int, got %T", index)
+		}
+		err := t.Set(int(i), v)
+		return n.IfError(err, "%s", err)
+
+	case *Map:
+		if err := t.put(NewEquality(n), index, v); err != nil {
+			return n.LocateError(err)
+		}
+		return nil
+
+	case *Struct:
+		switch i := index.(type) {
+		case int64:
+			fields := t.Fields()
+			if i < 0 || int(i) >= len(fields) {
+				return n.Error("struct offset %d out of range, %d field(s)", i, len(fields))
+			}
+			t.Set(fields[i], v)
+			return nil
+		case string:
+			if _, ok := t.Get(i); !ok {
+				return n.Error("%s has no field %s", t.typeName, i)
+			}
+			t.Set(i, v)
+			return nil
+		}
+		return n.Error("struct index must be an int or string, got %T", index)
+	}
+
+	return n.Error("cannot index %T", target)
+}
@@ -0,0 +1,263 @@
+package compile
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterBuiltin("assert", assertBuiltin)
+	RegisterBuiltin("assert_eq", assertEqBuiltin)
+	RegisterBuiltin("assert_error", assertErrorBuiltin)
+}
+
+// maxDifferences limits how many differences a failed assert_eq describes.
+const maxDifferences = 10
+
+// assertMessage returns the message given as the i'th argument of an assertion,
+// if there is one.
+func assertMessage(n *Node, name string, args []Value, i int) (string, error) {
+
+	if len(args) <= i {
+		return "", nil
+	}
+
+	message, err := StringArg(n, name, args, i)
+	if err != nil {
+		return "", err
+	}
+
+	return ": " + message, nil
+}
+
+// assert(cond) or assert(cond, message) fails unless cond is truthy.
+func assertBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "assert", args, 1, 2); err != nil {
+		return Values(), err
+	}
+
+	message, err := assertMessage(n, "assert", args, 1)
+	if err != nil {
+		return Values(), err
+	}
+
+	if !IsTruthy(args[0]) {
+		return Values(), n.callee().Error("assertion failed%s", message)
+	}
+
+	return Values(), nil
+}
+
+// assert_eq(got, want) or assert_eq(got, want, message) fails unless got ==
+// want. The failure describes where lists, maps and structs differ.
+func assertEqBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "assert_eq", args, 2, 3); err != nil {
+		return Values(), err
+	}
+
+	message, err := assertMessage(n, "assert_eq", args, 2)
+	if err != nil {
+		return Values(), err
+	}
+
+	diffs, err := differences(NewEquality(n), "", args[0], args[1])
+	if err != nil {
+		return Values(), n.LocateError(err)
+	}
+
+	if len(diffs) == 0 {
+		return Values(), nil
+	}
+
+	if len(diffs) == 1 && diffs[0].path == "" {
+		return Values(), n.callee().Error("assert_eq failed%s: %s", message, diffs[0])
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "assert_eq failed%s: got %s, want %s", message, describe(args[0]), describe(args[1]))
+
+	for i, d := range diffs {
+		if i == maxDifferences {
+			fmt.Fprintf(&sb, "\n\t... %d more differences", len(diffs)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "\n\t%s: %s", d.path, d)
+	}
+
+	return Values(), n.callee().Error("%s", sb.String())
+}
+
+// assert_error(vals...) fails unless the last of the values, usually the
+// results of a call, is an error. It returns the error.
+func assertErrorBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if len(args) > 0 {
+		if e, ok := args[len(args)-1].(*ErrorValue); ok {
+			return Values(e), nil
+		}
+	}
+
+	got := "nothing"
+	if len(args) > 0 {
+		got = describe(args[len(args)-1])
+	}
+
+	return Values(), n.callee().Error("assert_error failed: expected an error, got %s", got)
+}
+
+// describe formats a value for a failed assertion, with strings quoted.
+func describe(v Value) string {
+	return collectionString(v, make(map[Value]bool))
+}
+
+// difference is where two values differ: a path of indexes, keys and fields
+// from the values compared, what was got and wanted there, and a note of how
+// they differ, if it's not obvious.
+type difference struct {
+	path string
+	got  string
+	want string
+	note string
+}
+
+func (d difference) String() string {
+
+	s := fmt.Sprintf("got %s, want %s", d.got, d.want)
+	if d.note != "" {
+		s += ", " + d.note
+	}
+
+	return s
+}
+
+// differences compares got with want, returning where they differ. Lists, maps
+// and structs are compared element by element, so that the differences within
+// them are found, rather than just that they differ.
+func differences(eq *Equality, path string, got, want Value) ([]difference, error) {
+
+	switch g := got.(type) {
+	case *List:
+		w, ok := want.(*List)
+		if !ok || eq.comparing[[2]Value{g, w}] {
+			break
+		}
+		eq.comparing[[2]Value{g, w}] = true
+		defer delete(eq.comparing, [2]Value{g, w})
+
+		var diffs []difference
+		for i := 0; i < len(g.items) || i < len(w.items); i++ {
+			at := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(w.items):
+				diffs = append(diffs, difference{path: at, got: describe(g.items[i]), want: "nothing"})
+			case i >= len(g.items):
+				diffs = append(diffs, difference{path: at, got: "nothing", want: describe(w.items[i])})
+			default:
+				d, err := differences(eq, at, g.items[i], w.items[i])
+				if err != nil {
+					return nil, err
+				}
+				diffs = append(diffs, d...)
+			}
+		}
+		return diffs, nil
+
+	case *Map:
+		w, ok := want.(*Map)
+		if !ok || eq.comparing[[2]Value{g, w}] {
+			break
+		}
+		eq.comparing[[2]Value{g, w}] = true
+		defer delete(eq.comparing, [2]Value{g, w})
+
+		var diffs []difference
+		for i, k := range g.keys {
+			at := path + "[" + describe(k) + "]"
+			j, err := w.find(eq, k)
+			if err != nil {
+				return nil, err
+			}
+			if j < 0 {
+				diffs = append(diffs, difference{path: at, got: describe(g.values[i]), want: "nothing"})
+				continue
+			}
+			d, err := differences(eq, at, g.values[i], w.values[j])
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, d...)
+		}
+		for j, k := range w.keys {
+			i, err := g.find(eq, k)
+			if err != nil {
+				return nil, err
+			}
+			if i < 0 {
+				diffs = append(diffs, difference{path: path + "[" + describe(k) + "]", got: "nothing", want: describe(w.values[j])})
+			}
+		}
+		if len(diffs) == 0 {
+			same, err := eq.maps(g, w)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				diffs = append(diffs, difference{path: path, got: describe(g), want: describe(w), note: "with the keys in a different order"})
+			}
+		}
+		return diffs, nil
+
+	case *Struct:
+		w, ok := want.(*Struct)
+		if !ok || g.typeName != w.typeName || eq.comparing[[2]Value{g, w}] {
+			break
+		}
+		if _, custom := g.Method("equals"); custom {
+			break
+		}
+		eq.comparing[[2]Value{g, w}] = true
+		defer delete(eq.comparing, [2]Value{g, w})
+
+		var diffs []difference
+		for _, name := range g.Fields() {
+			d, err := differences(eq, path+"."+name, g.values[name], w.values[name])
+			if err != nil {
+				return nil, err
+			}
+			diffs = append(diffs, d...)
+		}
+		return diffs, nil
+
+	case string:
+		w, ok := want.(string)
+		if !ok || g == w {
+			break
+		}
+		at := 0
+		for at < len(g) && at < len(w) && g[at] == w[at] {
+			at++
+		}
+		return []difference{{path: path, got: describe(g), want: describe(w), note: fmt.Sprintf("which differ from byte %d", at)}}, nil
+	}
+
+	mismatch := []difference{{path: path, got: describe(got), want: describe(want)}}
+
+	if got != nil && want != nil && TypeName(got) != TypeName(want) && !(isNumber(got) && isNumber(want)) {
+		mismatch[0].got += " (" + TypeName(got) + ")"
+		mismatch[0].want += " (" + TypeName(want) + ")"
+		return mismatch, nil
+	}
+
+	same, err := eq.Equal(got, want)
+	if err != nil {
+		return nil, err
+	}
+
+	if same {
+		return nil, nil
+	}
+
+	return mismatch, nil
+}
@@ -0,0 +1,51 @@
+package compile_test
+
+import "testing"
+
+func TestAssert(t *testing.T) {
+
+	checkEval(t, `assert(1 < 2); assert(true, "fine"); "ok"`, "ok")
+
+	checkEvalErr(t, `assert(1 > 2)`, "assertion failed")
+	checkEvalErr(t, `assert(nil, "no value")`, "assertion failed: no value")
+	checkEvalErr(t, `assert()`, "assert expects 1 to 2 arguments, got 0")
+	checkEvalErr(t, `assert(false, 1)`, "assert expects a string for argument 2")
+}
+
+func TestAssertEq(t *testing.T) {
+
+	checkEval(t, `assert_eq(1 + 1, 2); assert_eq([1, "a"], [1, "a"]); assert_eq(1, 1.0); "ok"`, "ok")
+
+	checkEvalErr(t, `assert_eq(1 + 1, 3)`, "assert_eq failed: got 2, want 3")
+	checkEvalErr(t, `assert_eq(1, 2, "sums")`, "assert_eq failed: sums: got 1, want 2")
+	checkEvalErr(t, `assert_eq(1, "1")`, `got 1 (int64), want "1" (string)`)
+	checkEvalErr(t, `assert_eq("hello world", "hello there")`,
+		`got "hello world", want "hello there", which differ from byte 6`)
+
+	checkEvalErr(t, `assert_eq([1, 2, 3], [1, 5, 3, 4])`,
+		"got [1, 2, 3], want [1, 5, 3, 4]\n\t[1]: got 2, want 5\n\t[3]: got nothing, want 4")
+
+	checkEvalErr(t, `assert_eq(map("a", 1, "b", 2), map("a", 5, "c", 3))`,
+		"\n\t[\"a\"]: got 1, want 5\n\t[\"b\"]: got 2, want nothing\n\t[\"c\"]: got nothing, want 3")
+	checkEvalErr(t, `assert_eq(map("a", 1, "b", 2), map("b", 2, "a", 1))`, "with the keys in a different order")
+
+	checkEvalErr(t, `
+		struct point {
+			x := 0
+			y := [0]
+		}
+		assert_eq(point(1, [2, 4]), point(1, [3, 4]))`,
+		"\n\t.y[0]: got 2, want 3")
+}
+
+func TestAssertError(t *testing.T) {
+
+	checkEval(t, `
+		f := func() { return nil, error("boom") }
+		e := assert_error(f())
+		e`,
+		"boom")
+
+	checkEvalErr(t, `assert_error(1, nil)`, "assert_error failed: expected an error, got nil")
+	checkEvalErr(t, `assert_error()`, "assert_error failed: expected an error, got nothing")
+}
@@ -0,0 +1,90 @@
+package compile
+
+import "sort"
+
+// Builtin is a function implemented in go. Builtins are installed in the
+// global scope, and are invoked like any other function.
+type Builtin struct {
+	name  string
+	fn    BuiltinFunc
+	stack stackBuiltinFunc // instead of fn, for a builtin given the active calls
+}
+
+// BuiltinFunc implements a Builtin. The node is the function application, for
+// reporting errors.
+type BuiltinFunc func(n *Node, args []Value) ([]Value, error)
+
+// stackBuiltinFunc implements a Builtin which is also given the calls active
+// when it's applied, e.g. to keep them in an error it creates.
+type stackBuiltinFunc func(n *Node, calls []call, args []Value) ([]Value, error)
+
+var builtins = make(map[string]Builtin)
+
+// RegisterBuiltin adds a function to the builtins.
+func RegisterBuiltin(name string, fn BuiltinFunc) {
+	builtins[name] = Builtin{
+		name: name,
+		fn:   fn,
+	}
+}
+
+// registerStackBuiltin adds a function to the builtins, which is given the
+// active calls.
+func registerStackBuiltin(name string, fn stackBuiltinFunc) {
+	builtins[name] = Builtin{
+		name:  name,
+		stack: fn,
+	}
+}
+
+// IsBuiltin checks if a name is a builtin.
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// BuiltinNames returns the names of all the builtins, sorted.
+func BuiltinNames() []string {
+
+	var x []string
+	for name := range builtins {
+		x = append(x, name)
+	}
+	sort.Strings(x)
+
+	return x
+}
+
+// Name returns the name of the builtin.
+func (b Builtin) Name() string {
+	return b.name
+}
+
+// ArgCount checks that a builtin got between min and max arguments. max < 0
+// means no maximum.
+func ArgCount(n *Node, name string, args []Value, min, max int) error {
+
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		switch {
+		case min == max:
+			return n.Error("%s expects %d argument(s), got %d", name, min, len(args))
+		case max < 0:
+			return n.Error("%s expects at least %d argument(s), got %d", name, min, len(args))
+		default:
+			return n.Error("%s expects %d to %d arguments, got %d", name, min, max, len(args))
+		}
+	}
+
+	return nil
+}
+
+// StringArg returns the i'th argument, which must be a string.
+func StringArg(n *Node, name string, args []Value, i int) (string, error) {
+
+	s, ok := args[i].(string)
+	if !ok {
+		return "", n.Error("%s expects a string for argument %d, got %T", name, i+1, args[i])
+	}
+
+	return s, nil
+}
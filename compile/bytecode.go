@@ -0,0 +1,961 @@
+package compile
+
+import (
+	"strconv"
+
+	"github.com/pdk/gosh/parse"
+	"github.com/pdk/gosh/token"
+)
+
+// Opcode is a bytecode instruction.
+//
+// The machine has a stack of values, and a stack of marks. A mark records the
+// height of the value stack, so that an expression producing any number of
+// values (e.g. a function call) can be collected as a group: the values above
+// the top mark.
+type Opcode byte
+
+// The bytecode instructions. A and B are the operands of an instruction.
+const (
+	OpNil        Opcode = iota // push nil
+	OpConst                    // push Constants[A]
+	OpLoadLocal                // push slot A of the current frame
+	OpLoadOuter                // push slot B of the frame A levels out
+	OpLoadGlobal               // push the global named Constants[A]
+	OpStore                    // pop a value, and assign it to variable Vars[A]
+	OpTypeOf                   // push the name of the type in variable Vars[A], for nil(T)
+	OpDeclare                  // pop a type name, declare the type of variable Vars[A], push nil
+	OpPop                      // pop A values
+	OpCopy                     // push a copy of the value A below the top
+	OpMark                     // push a mark
+	OpDrop                     // pop the values above the top mark, and the mark
+	OpPopMark                  // pop the top mark, leaving the values
+	OpSingle                   // check that there's one value above the top mark, pop the mark
+	OpCount                    // check that there are A values above the top mark, pop the mark
+	OpCallee                   // check that there's one function above the top mark, pop the mark
+	OpBinary                   // pop two values, push the result of operator A
+	OpUnary                    // pop a value, push the result of prefix operator A
+	OpJump                     // continue at A
+	OpJumpFalse                // if the top value is falsy continue at A, otherwise pop it
+	OpJumpTrue                 // if the top value is truthy continue at A, otherwise pop it
+	OpConcat                   // pop A values, push their concatenation as strings
+	OpList                     // pop the values above the top mark, and the mark, push a list of them
+	OpIndex                    // pop target and index, push target[index]
+	OpSlice                    // pop target, low and high bounds, push target[low:high]
+	OpField                    // pop target, push field Constants[A] of target
+	OpSetField                 // pop target and value, set field Constants[A] of target
+	OpSetIndex                 // pop target, index and value, set target[index]
+	OpCall                     // pop args above the top mark, the mark, and a function, push the results of the call
+	OpMethod                   // pop args above the top mark, the mark, and a target, push the results of method Constants[A]
+	OpClosure                  // push a function of Funcs[A]
+	OpStruct                   // define struct Structs[A], push the type, or the instance of a literal
+	OpTry                      // handle errors until the matching OpPopHandler, by pushing an error value and continuing at A
+	OpResolve                  // until OpPopHandler, report errors as failing to resolve a function
+	OpLocate                   // until OpPopHandler, give errors the location of the instruction
+	OpPopHandler               // end the most recent OpTry, OpResolve or OpLocate
+	OpUnwind                   // drop values and marks above mark A, and handlers above B, e.g. for break
+	OpReturn                   // return the values above the top mark, or all values if there are no marks
+	OpTailCall                 // like OpCall, but the results are returned, replacing the current frame with the call's
+)
+
+var opcodeNames = [...]string{
+	OpNil:        "nil",
+	OpConst:      "const",
+	OpLoadLocal:  "load-local",
+	OpLoadOuter:  "load-outer",
+	OpLoadGlobal: "load-global",
+	OpStore:      "store",
+	OpTypeOf:     "type-of",
+	OpDeclare:    "declare",
+	OpPop:        "pop",
+	OpCopy:       "copy",
+	OpMark:       "mark",
+	OpDrop:       "drop",
+	OpPopMark:    "pop-mark",
+	OpSingle:     "single",
+	OpCount:      "count",
+	OpCallee:     "callee",
+	OpBinary:     "binary",
+	OpUnary:      "unary",
+	OpJump:       "jump",
+	OpJumpFalse:  "jump-false",
+	OpJumpTrue:   "jump-true",
+	OpConcat:     "concat",
+	OpList:       "list",
+	OpIndex:      "index",
+	OpSlice:      "slice",
+	OpField:      "field",
+	OpSetField:   "set-field",
+	OpSetIndex:   "set-index",
+	OpCall:       "call",
+	OpMethod:     "method",
+	OpClosure:    "closure",
+	OpStruct:     "struct",
+	OpTry:        "try",
+	OpResolve:    "resolve",
+	OpLocate:     "locate",
+	OpPopHandler: "pop-handler",
+	OpUnwind:     "unwind",
+	OpReturn:     "return",
+	OpTailCall:   "tail-call",
+}
+
+func (op Opcode) String() string {
+
+	if int(op) < len(opcodeNames) {
+		return opcodeNames[op]
+	}
+
+	return "op" + strconv.Itoa(int(op))
+}
+
+// Instruction is a single bytecode instruction. Site is the index of the node
+// which produced the instruction, for reporting errors, or -1.
+type Instruction struct {
+	Op   Opcode
+	A, B int
+	Site int
+}
+
+// Code is a compiled sequence of instructions, with the tables the
+// instructions refer to. The top level of a program, the body of each function,
+// and the initial value of each struct field are each compiled to a Code.
+type Code struct {
+	Instructions []Instruction
+	Constants    []Value
+	Vars         []VarRef
+	Funcs        []*FuncCode
+	Structs      []*StructCode
+	Sites        []Site
+
+	nodes     []*Node       // the nodes of the sites
+	siteIndex map[*Node]int // position of each node in nodes, while compiling
+}
+
+// VarRef names a variable, and where it lives.
+type VarRef struct {
+	Name    string
+	Address *Address
+}
+
+// FuncCode is a compiled function definition.
+type FuncCode struct {
+	Name       string
+	Parameters []string
+	Channels   []string
+	FrameSize  int
+	Code       *Code
+
+	body Evaluator // runs the code, for calls from outside the machine
+}
+
+// StructCode is a compiled struct definition. Var is the variable naming the
+// struct type, or -1 for a struct literal.
+type StructCode struct {
+	Name   string
+	Var    int
+	Fields []FieldCode
+
+	fields []structField // the fields, with evaluators which run the code
+}
+
+// FieldCode is the compiled initial value of a struct field.
+type FieldCode struct {
+	Name string
+	Code *Code
+}
+
+// loopContext tracks a while loop being compiled, for break and continue.
+type loopContext struct {
+	marks    int   // open marks at the start of the loop
+	handlers int   // open handlers at the start of the loop
+	next     int   // where continue goes
+	breaks   []int // jumps to patch with the end of the loop
+}
+
+// bytecodeCompiler compiles nodes to a Code. Marks and handlers count how many
+// are open at the current instruction, so that break and continue can unwind
+// to the state at the start of the loop.
+type bytecodeCompiler struct {
+	code     *Code
+	marks    int
+	handlers int
+	loops    []*loopContext
+}
+
+// Bytecode compiles an analyzed node to bytecode.
+func (n *Node) Bytecode() (*Code, error) {
+	return compileCode(n)
+}
+
+// compileCode compiles a node to a Code which returns the node's values.
+func compileCode(n *Node) (*Code, error) {
+
+	c := &bytecodeCompiler{
+		code: &Code{
+			siteIndex: make(map[*Node]int),
+		},
+	}
+
+	err := c.expr(n)
+	if err != nil {
+		return nil, err
+	}
+
+	c.emit(OpReturn, 0, 0, nil)
+	c.code.siteIndex = nil
+
+	return c.code, nil
+}
+
+// emit adds an instruction, and returns its position.
+func (c *bytecodeCompiler) emit(op Opcode, a, b int, site *Node) int {
+
+	switch op {
+	case OpMark:
+		c.marks++
+	case OpResolve, OpLocate, OpTry:
+		c.handlers++
+	case OpDrop, OpPopMark, OpSingle, OpCount, OpCallee, OpList, OpCall, OpMethod, OpTailCall:
+		c.marks--
+	case OpPopHandler:
+		c.handlers--
+	}
+
+	c.code.Instructions = append(c.code.Instructions, Instruction{
+		Op:   op,
+		A:    a,
+		B:    b,
+		Site: c.site(site),
+	})
+
+	return len(c.code.Instructions) - 1
+}
+
+// patch sets the target of a jump to the current position.
+func (c *bytecodeCompiler) patch(at int) {
+	c.code.Instructions[at].A = len(c.code.Instructions)
+}
+
+// site returns the index of a node in the sites.
+func (c *bytecodeCompiler) site(n *Node) int {
+
+	if n == nil {
+		return -1
+	}
+
+	if i, ok := c.code.siteIndex[n]; ok {
+		return i
+	}
+
+	c.code.nodes = append(c.code.nodes, n)
+	c.code.Sites = append(c.code.Sites, siteOf(n))
+	c.code.siteIndex[n] = len(c.code.nodes) - 1
+
+	return len(c.code.nodes) - 1
+}
+
+func (c *bytecodeCompiler) constant(v Value) int {
+
+	for i, k := range c.code.Constants {
+		if k == v {
+			return i
+		}
+	}
+
+	c.code.Constants = append(c.code.Constants, v)
+
+	return len(c.code.Constants) - 1
+}
+
+func (c *bytecodeCompiler) variable(ident *Node) int {
+	c.code.Vars = append(c.code.Vars, VarRef{
+		Name:    ident.Literal(),
+		Address: ident.address,
+	})
+	return len(c.code.Vars) - 1
+}
+
+// arity returns the number of values a node produces, or -1 if that's only
+// known when it's evaluated.
+func arity(n *Node) int {
+
+	switch n.Token() {
+	case token.FUNCAPPLY:
+		if isTypedNil(n) {
+			return 1
+		}
+		return -1
+
+	case token.ASSIGN:
+		lhs := n.children[0]
+		if lhs.IsToken(token.COMMA) {
+			return len(lhs.children)
+		}
+		return 1
+
+	case token.COMMA, token.LPAREN:
+		total := 0
+		for _, child := range n.children {
+			a := arity(child)
+			if a < 0 {
+				return -1
+			}
+			total += a
+		}
+		return total
+
+	case token.STMTS:
+		if len(n.children) == 0 {
+			return 0
+		}
+		return arity(n.children[len(n.children)-1])
+
+	case token.EXTERN:
+		return 0
+
+	case token.METHAPPLY, token.IF, token.WHILE, token.TRY,
+		token.RETURN, token.BREAK, token.CONTINUE:
+		return -1
+	}
+
+	return 1
+}
+
+// single compiles a node which must produce a single value. Failing that, the
+// error is reported at the site.
+func (c *bytecodeCompiler) single(n, site *Node) error {
+
+	if arity(n) == 1 {
+		return c.expr(n)
+	}
+
+	c.emit(OpMark, 0, 0, nil)
+
+	err := c.expr(n)
+	if err != nil {
+		return err
+	}
+
+	c.emit(OpSingle, 0, 0, site)
+
+	return nil
+}
+
+// discard compiles a node whose values are not needed.
+func (c *bytecodeCompiler) discard(n *Node) error {
+
+	a := arity(n)
+	if a < 0 {
+		c.emit(OpMark, 0, 0, nil)
+	}
+
+	err := c.expr(n)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case a < 0:
+		c.emit(OpDrop, 0, 0, nil)
+	case a > 0:
+		c.emit(OpPop, a, 0, nil)
+	}
+
+	return nil
+}
+
+// exprs compiles each of the nodes, producing all of their values.
+func (c *bytecodeCompiler) exprs(nodes []*Node) error {
+
+	for _, each := range nodes {
+		if err := c.expr(each); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expr compiles a node, producing its values.
+func (c *bytecodeCompiler) expr(n *Node) error {
+
+	if _, ok := binaryOperations[n.Token()]; ok && len(n.children) == 2 {
+		return c.binary(n)
+	}
+
+	switch n.Token() {
+	case token.IDENT:
+		c.load(n)
+		return nil
+
+	case token.INT:
+		i, err := strconv.ParseInt(n.Literal(), 10, 0)
+		if err != nil {
+			return n.Error("%s", err)
+		}
+		c.emit(OpConst, c.constant(i), 0, nil)
+		return nil
+
+	case token.FLOAT:
+		f, err := strconv.ParseFloat(n.Literal(), 64)
+		if err != nil {
+			return n.Error("%s", err)
+		}
+		c.emit(OpConst, c.constant(f), 0, nil)
+		return nil
+
+	case token.STRING:
+		c.emit(OpConst, c.constant(n.Literal()), 0, nil)
+		return nil
+
+	case token.CHAR:
+		c.emit(OpConst, c.constant([]rune(n.Literal())[0]), 0, nil)
+		return nil
+
+	case token.TRUE, token.FALSE:
+		c.emit(OpConst, c.constant(n.IsToken(token.TRUE)), 0, nil)
+		return nil
+
+	case token.NIL:
+		c.emit(OpNil, 0, 0, nil)
+		return nil
+
+	case token.INTERP:
+		for _, part := range n.children {
+			if err := c.single(part, n); err != nil {
+				return err
+			}
+		}
+		c.emit(OpConcat, len(n.children), 0, nil)
+		return nil
+
+	case token.MINUS, token.BIT_XOR, token.NOT:
+		if len(n.children) != 1 {
+			break
+		}
+		if err := c.single(n.children[0], n); err != nil {
+			return err
+		}
+		c.emit(OpUnary, int(n.Token()), 0, n)
+		return nil
+
+	case token.LOG_AND, token.LOG_OR:
+		return c.shortCircuit(n)
+
+	case token.COMMA, token.LPAREN:
+		return c.exprs(n.children)
+
+	case token.STMTS:
+		return c.statements(n)
+
+	case token.EXTERN:
+		return nil
+
+	case token.ASSIGN:
+		return c.assign(n)
+
+	case token.FUNC:
+		return c.function(n)
+
+	case token.FUNCAPPLY:
+		return c.call(n)
+
+	case token.METHAPPLY:
+		return c.method(n)
+
+	case token.IF:
+		return c.conditional(n)
+
+	case token.WHILE:
+		return c.loop(n)
+
+	case token.RETURN:
+		c.emit(OpMark, 0, 0, nil)
+		if n.tailCall {
+			if err := c.callOperands(n.children[0]); err != nil {
+				return err
+			}
+			c.emit(OpTailCall, 0, 0, n.children[0])
+		} else {
+			if err := c.exprs(n.children); err != nil {
+				return err
+			}
+			c.emit(OpReturn, 0, 0, nil)
+		}
+		c.marks--
+		return nil
+
+	case token.BREAK, token.CONTINUE:
+		return c.jumpOut(n)
+
+	case token.TRY:
+		at := c.emit(OpTry, 0, 0, n)
+		if err := c.expr(n.children[0]); err != nil {
+			return err
+		}
+		c.emit(OpPopHandler, 0, 0, nil)
+		c.patch(at)
+		return nil
+
+	case token.LSQR:
+		return c.list(n)
+
+	case token.PERIOD:
+		if len(n.children) != 2 || !n.children[1].IsToken(token.IDENT) {
+			return n.Error("expected a field name after .")
+		}
+		if err := c.single(n.children[0], n); err != nil {
+			return err
+		}
+		c.emit(OpField, c.constant(n.children[1].Literal()), 0, n)
+		return nil
+
+	case token.STRUCT:
+		return c.structDefinition(n)
+	}
+
+	return n.lexeme.Error("unknown operator %s", n.Literal())
+}
+
+// load pushes the value of a variable.
+func (c *bytecodeCompiler) load(n *Node) {
+
+	addr := n.address
+
+	switch {
+	case addr == nil || addr.Global():
+		c.emit(OpLoadGlobal, c.constant(n.Literal()), 0, n)
+	case addr.Depth == 0:
+		c.emit(OpLoadLocal, addr.Slot, 0, nil)
+	default:
+		c.emit(OpLoadOuter, addr.Depth, addr.Slot, nil)
+	}
+}
+
+func (c *bytecodeCompiler) binary(n *Node) error {
+
+	if err := c.single(n.children[0], n); err != nil {
+		return err
+	}
+
+	if err := c.single(n.children[1], n); err != nil {
+		return err
+	}
+
+	c.emit(OpBinary, int(n.Token()), 0, n)
+
+	return nil
+}
+
+// shortCircuit compiles && and ||, which evaluate the right side only if
+// needed.
+func (c *bytecodeCompiler) shortCircuit(n *Node) error {
+
+	if err := c.single(n.children[0], n); err != nil {
+		return err
+	}
+
+	op := OpJumpFalse
+	if n.IsToken(token.LOG_OR) {
+		op = OpJumpTrue
+	}
+	at := c.emit(op, 0, 0, nil)
+
+	if err := c.single(n.children[1], n); err != nil {
+		return err
+	}
+
+	c.patch(at)
+
+	return nil
+}
+
+// statements compiles a series of expressions, producing the values of the
+// last.
+func (c *bytecodeCompiler) statements(n *Node) error {
+
+	for i, child := range n.children {
+		var err error
+		if i == len(n.children)-1 {
+			err = c.expr(child)
+		} else {
+			err = c.discard(child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// assign compiles an assignment. The values assigned are left as the result.
+func (c *bytecodeCompiler) assign(n *Node) error {
+
+	lhs := n.children[0]
+
+	if lhs.IsToken(token.IDENT) && isTypedNil(n.children[1]) {
+		if err := c.typeOf(n.children[1]); err != nil {
+			return err
+		}
+		c.emit(OpDeclare, c.variable(lhs), 0, n)
+		return nil
+	}
+
+	targets := []*Node{lhs}
+	if lhs.IsToken(token.COMMA) {
+		targets = lhs.children
+	}
+
+	located := false
+	for _, t := range targets {
+		switch {
+		case t.IsToken(token.IDENT):
+		case t.IsToken(token.PERIOD) && len(t.children) == 2 && t.children[1].IsToken(token.IDENT),
+			t.IsToken(token.LSQR) && t.arity == parse.Lefty && len(t.children) == 2 && !isSlice(t):
+			located = true
+		default:
+			return n.Error("left-hand side of assignment must be one or more identifiers, fields or indexes")
+		}
+	}
+
+	rhs := n.children[1]
+	count := len(targets)
+
+	if arity(rhs) == count {
+		if err := c.expr(rhs); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OpMark, 0, 0, nil)
+		if err := c.expr(rhs); err != nil {
+			return err
+		}
+		c.emit(OpCount, count, 0, n)
+	}
+
+	if located {
+		c.emit(OpLocate, 0, 0, n)
+	}
+
+	for i, t := range targets {
+		below := count - 1 - i
+
+		switch {
+		case t.IsToken(token.IDENT):
+			c.emit(OpCopy, below, 0, nil)
+			c.emit(OpStore, c.variable(t), 0, n)
+
+		case t.IsToken(token.PERIOD):
+			if err := c.single(t.children[0], t); err != nil {
+				return err
+			}
+			c.emit(OpCopy, below+1, 0, nil)
+			c.emit(OpSetField, c.constant(t.children[1].Literal()), 0, t)
+
+		default:
+			if err := c.single(t.children[0], t); err != nil {
+				return err
+			}
+			if err := c.single(t.children[1], t); err != nil {
+				return err
+			}
+			c.emit(OpCopy, below+2, 0, nil)
+			c.emit(OpSetIndex, 0, 0, t)
+		}
+	}
+
+	if located {
+		c.emit(OpPopHandler, 0, 0, nil)
+	}
+
+	return nil
+}
+
+// typeOf compiles nil(T), pushing the name of type T.
+func (c *bytecodeCompiler) typeOf(n *Node) error {
+
+	if len(n.children) != 2 || !n.children[1].IsToken(token.IDENT) {
+		return n.Error("nil(...) expects a single type name")
+	}
+
+	c.emit(OpTypeOf, c.variable(n.children[1]), 0, n.children[1])
+
+	return nil
+}
+
+// function compiles a function definition. The body is compiled separately.
+func (c *bytecodeCompiler) function(n *Node) error {
+
+	if n.analysis == nil {
+		return n.Error("func has not been analyzed")
+	}
+
+	body, err := compileCode(n.analysis.body)
+	if err != nil {
+		return err
+	}
+
+	c.code.Funcs = append(c.code.Funcs, &FuncCode{
+		Name:       n.analysis.name,
+		Parameters: n.analysis.parameters,
+		Channels:   n.analysis.channels,
+		FrameSize:  n.analysis.SlotCount(),
+		Code:       body,
+	})
+
+	c.emit(OpClosure, len(c.code.Funcs)-1, 0, nil)
+
+	return nil
+}
+
+// call compiles a function application. Errors found while evaluating the
+// function itself are reported as failing to resolve it.
+func (c *bytecodeCompiler) call(n *Node) error {
+
+	if isTypedNil(n) {
+		if err := c.typeOf(n); err != nil {
+			return err
+		}
+		c.emit(OpPop, 1, 0, nil)
+		c.emit(OpNil, 0, 0, nil)
+		return nil
+	}
+
+	err := c.callOperands(n)
+	if err != nil {
+		return err
+	}
+
+	c.emit(OpCall, 0, 0, n)
+
+	return nil
+}
+
+// callOperands compiles the function and arguments of a function application,
+// leaving the function, and the arguments above a mark.
+func (c *bytecodeCompiler) callOperands(n *Node) error {
+
+	fn := n.children[0]
+	local := fn.IsToken(token.IDENT) && fn.address != nil && !fn.address.Global()
+
+	if !local {
+		c.emit(OpResolve, 0, 0, n)
+	}
+
+	if arity(fn) == 1 {
+		if err := c.expr(fn); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OpMark, 0, 0, nil)
+		if err := c.expr(fn); err != nil {
+			return err
+		}
+	}
+
+	if !local {
+		c.emit(OpPopHandler, 0, 0, nil)
+	}
+
+	if arity(fn) != 1 {
+		c.emit(OpCallee, 0, 0, n)
+	}
+
+	c.emit(OpMark, 0, 0, nil)
+
+	return c.exprs(n.children[1:])
+}
+
+func (c *bytecodeCompiler) method(n *Node) error {
+
+	if len(n.children) < 2 || !n.children[1].IsToken(token.IDENT) {
+		return n.Error("expected a method name after .")
+	}
+
+	if err := c.single(n.children[0], n); err != nil {
+		return err
+	}
+
+	c.emit(OpMark, 0, 0, nil)
+	if err := c.exprs(n.children[2:]); err != nil {
+		return err
+	}
+	c.emit(OpMethod, c.constant(n.children[1].Literal()), 0, n)
+
+	return nil
+}
+
+// conditional compiles if-then pairs, optionally followed by an else. If no
+// condition is true, and there's no else, the result is the last condition.
+func (c *bytecodeCompiler) conditional(n *Node) error {
+
+	var ends []int
+
+	for i := 0; i < len(n.children); i += 2 {
+
+		if i == len(n.children)-1 {
+			if err := c.expr(n.children[i]); err != nil {
+				return err
+			}
+			break
+		}
+
+		if err := c.single(n.children[i], n); err != nil {
+			return err
+		}
+		next := c.emit(OpJumpFalse, 0, 0, nil)
+
+		if err := c.expr(n.children[i+1]); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(OpJump, 0, 0, nil))
+
+		c.patch(next)
+		if i+2 < len(n.children) {
+			c.emit(OpPop, 1, 0, nil)
+		}
+	}
+
+	for _, at := range ends {
+		c.patch(at)
+	}
+
+	return nil
+}
+
+// loop compiles while. When the condition fails, it is the result. After a
+// break there is no result.
+func (c *bytecodeCompiler) loop(n *Node) error {
+
+	c.emit(OpMark, 0, 0, nil)
+
+	loop := &loopContext{
+		marks:    c.marks - 1,
+		handlers: c.handlers,
+		next:     len(c.code.Instructions),
+	}
+	c.loops = append(c.loops, loop)
+
+	if err := c.single(n.children[0], n); err != nil {
+		return err
+	}
+	end := c.emit(OpJumpFalse, 0, 0, nil)
+
+	if err := c.discard(n.children[1]); err != nil {
+		return err
+	}
+	c.emit(OpJump, loop.next, 0, nil)
+
+	c.patch(end)
+	for _, at := range loop.breaks {
+		c.patch(at)
+	}
+	c.emit(OpPopMark, 0, 0, nil)
+
+	c.loops = c.loops[:len(c.loops)-1]
+
+	return nil
+}
+
+// jumpOut compiles break and continue. Outside of a loop, they return from
+// the function with no values.
+func (c *bytecodeCompiler) jumpOut(n *Node) error {
+
+	if len(c.loops) == 0 {
+		c.emit(OpMark, 0, 0, nil)
+		c.emit(OpReturn, 0, 0, nil)
+		c.marks--
+		return nil
+	}
+
+	loop := c.loops[len(c.loops)-1]
+
+	c.emit(OpUnwind, loop.marks, loop.handlers, nil)
+
+	if n.IsToken(token.CONTINUE) {
+		c.emit(OpJump, loop.next, 0, nil)
+		return nil
+	}
+
+	loop.breaks = append(loop.breaks, c.emit(OpJump, 0, 0, nil))
+
+	return nil
+}
+
+// list compiles a list literal, or indexing.
+func (c *bytecodeCompiler) list(n *Node) error {
+
+	if n.arity != parse.Lefty {
+		c.emit(OpMark, 0, 0, nil)
+		if err := c.exprs(n.children); err != nil {
+			return err
+		}
+		c.emit(OpList, 0, 0, nil)
+		return nil
+	}
+
+	if len(n.children) != 2 {
+		return n.Error("expected a single index")
+	}
+
+	if err := c.single(n.children[0], n); err != nil {
+		return err
+	}
+
+	if isSlice(n) {
+		for _, bound := range n.children[1].children {
+			if err := c.single(bound, n); err != nil {
+				return err
+			}
+		}
+		c.emit(OpSlice, 0, 0, n)
+		return nil
+	}
+
+	if err := c.single(n.children[1], n); err != nil {
+		return err
+	}
+
+	c.emit(OpIndex, 0, 0, n)
+
+	return nil
+}
+
+// structDefinition compiles a struct definition or literal. The initial value
+// of each field is compiled separately.
+func (c *bytecodeCompiler) structDefinition(n *Node) error {
+
+	sc := &StructCode{Var: -1}
+
+	body := n.children[0]
+	if len(n.children) == 2 {
+		sc.Name = n.children[0].Literal()
+		sc.Var = c.variable(n.children[0])
+		body = n.children[1]
+	}
+
+	statements, err := structAssignments(body)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range statements {
+
+		init, err := compileCode(stmt.children[1])
+		if err != nil {
+			return err
+		}
+
+		sc.Fields = append(sc.Fields, FieldCode{
+			Name: stmt.children[0].Literal(),
+			Code: init,
+		})
+	}
+
+	c.code.Structs = append(c.code.Structs, sc)
+	c.emit(OpStruct, len(c.code.Structs)-1, 0, n)
+
+	return nil
+}
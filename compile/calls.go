@@ -0,0 +1,223 @@
+package compile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pdk/gosh/token"
+)
+
+// DefaultMaxDepth is the default limit on the depth of calls.
+const DefaultMaxDepth = 10000
+
+// call is an active call of a function: the name of the function, and where it
+// was called.
+type call struct {
+	name string
+	site *Node
+}
+
+func (c call) String() string {
+	if c.site == nil {
+		// a call made from go, e.g. of a test
+		return c.name
+	}
+	return fmt.Sprintf("%s, called at %s", c.name, c.site.callee().lexeme.Location())
+}
+
+// callee returns the node naming the function of a call, or method of a method
+// application.
+func (n *Node) callee() *Node {
+
+	switch {
+	case n.IsToken(token.FUNCAPPLY) && len(n.children) > 0:
+		return n.children[0]
+	case n.IsToken(token.METHAPPLY) && len(n.children) > 1:
+		return n.children[1]
+	}
+
+	return n
+}
+
+// callStack is the active calls of a program. It's shared by all the scopes
+// of the program, so that the depth of calls can be limited, rather than
+// running out of stack.
+type callStack struct {
+	calls    []call
+	maxDepth int
+	debugger *Debugger // if the program is being debugged
+	coverage *Coverage // if the program is recording its coverage
+	profiler *Profiler // if the program is being profiled
+	tracer   *Tracer   // if the program is being traced
+}
+
+func newCallStack() *callStack {
+	return &callStack{
+		maxDepth: DefaultMaxDepth,
+	}
+}
+
+// push adds a call, failing if that exceeds the maximum depth. A scope made
+// without a global scope has no call stack, and calls are not tracked.
+func (cs *callStack) push(name string, site *Node) error {
+
+	if cs == nil {
+		return nil
+	}
+
+	if len(cs.calls) >= cs.maxDepth {
+		return site.Error("maximum call depth of %d exceeded", cs.maxDepth)
+	}
+
+	cs.calls = append(cs.calls, call{name: name, site: site})
+
+	return nil
+}
+
+// pop removes the most recent call.
+func (cs *callStack) pop() {
+
+	if cs == nil {
+		return
+	}
+
+	cs.calls = cs.calls[:len(cs.calls)-1]
+}
+
+// replace replaces the most recent call, e.g. by a tail call.
+func (cs *callStack) replace(name string, site *Node) {
+
+	if cs == nil {
+		return
+	}
+
+	cs.calls[len(cs.calls)-1] = call{name: name, site: site}
+}
+
+// current returns a copy of the active calls.
+func (cs *callStack) current() []call {
+
+	if cs == nil || len(cs.calls) == 0 {
+		return nil
+	}
+
+	return append([]call(nil), cs.calls...)
+}
+
+// tracedError is an error raised inside a function, with the calls active
+// when it was raised.
+type tracedError struct {
+	err   error
+	calls []call
+}
+
+func (e *tracedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tracedError) Unwrap() error {
+	return e.err
+}
+
+// attach gives an error the current calls, unless it already has calls, or
+// there are none.
+func (cs *callStack) attach(err error) error {
+
+	if err == nil || cs == nil || len(cs.calls) == 0 || callsOf(err) != nil {
+		return err
+	}
+
+	return &tracedError{
+		err:   err,
+		calls: cs.current(),
+	}
+}
+
+// callsOf returns the calls active when an error was raised, if known.
+func callsOf(err error) []call {
+
+	var traced *tracedError
+	if errors.As(err, &traced) {
+		return traced.calls
+	}
+
+	return nil
+}
+
+// maxTraceLines limits how many calls are described by a trace.
+const maxTraceLines = 20
+
+// Trace describes the calls active when an error was raised, most recent
+// first, one per line, or returns "" if they're not known. Repeats of a call,
+// e.g. by recursion, are counted rather than listed.
+func Trace(err error) string {
+	return formatTrace(callsOf(err))
+}
+
+func formatTrace(calls []call) string {
+
+	var sb strings.Builder
+
+	i := len(calls) - 1
+	for lines := 0; i >= 0 && lines < maxTraceLines; lines++ {
+
+		c := calls[i]
+
+		repeats := 0
+		for i--; i >= 0 && calls[i] == c; i-- {
+			repeats++
+		}
+
+		fmt.Fprintf(&sb, "\tat %s", c)
+		if repeats > 0 {
+			fmt.Fprintf(&sb, " (and %d more times)", repeats)
+		}
+		sb.WriteString("\n")
+	}
+
+	if i >= 0 {
+		fmt.Fprintf(&sb, "\t... %d more calls\n", i+1)
+	}
+
+	return sb.String()
+}
+
+// SetMaxDepth sets the maximum depth of calls in the program using the scope.
+func (v *Variables) SetMaxDepth(maxDepth int) {
+
+	if v.calls != nil {
+		v.calls.maxDepth = maxDepth
+	}
+}
+
+// functionName is the name of a function, for traces.
+func functionName(name string) string {
+
+	if name == "" {
+		return "<anon>"
+	}
+
+	return name
+}
+
+// tailCall is a call of a function in tail position, i.e. return f(...).
+// Rather than making the call, which would need another go stack frame, the
+// returning function gives the call to callFunction, which makes it in place
+// of the returning function.
+type tailCall struct {
+	site *Node
+	fn   Function
+	args []Value
+}
+
+// TailCallValue constructs a ControlReturn Value which returns the results of
+// calling a function.
+func TailCallValue(site *Node, fn Function, args []Value) []Value {
+	return Values(
+		ControlValue{
+			which: ControlReturn,
+			tail:  &tailCall{site: site, fn: fn, args: args},
+		},
+	)
+}
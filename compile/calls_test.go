@@ -0,0 +1,218 @@
+package compile_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+)
+
+func TestTailCalls(t *testing.T) {
+
+	// far deeper than the maximum depth of calls
+	checkEval(t, `
+		count := func(n, acc) {
+			if n == 0 {
+				return acc
+			}
+			return count(n - 1, acc + 1)
+		}
+		count(50000, 0)`,
+		"50000")
+
+	checkEval(t, `
+		isEven := func(n) {
+			if n == 0 { return true }
+			return isOdd(n - 1)
+		}
+		isOdd := func(n) {
+			if n == 0 { return false }
+			return isEven(n - 1)
+		}
+		isEven(30001), isOdd(30001)`,
+		"false, true")
+
+	checkEval(t, `
+		loop := func(n) {
+			while true {
+				if n > 100 { return n }
+				return loop(n * 2)
+			}
+		}
+		loop(1)`,
+		"128")
+
+	checkEval(t, `
+		pair := func(a, b) { return b, a }
+		swap := func(a, b) { return pair(a, b) }
+		swap(1, 2)`,
+		"2, 1")
+
+	checkEval(t, `
+		f := func(s) { return type(s) }
+		f("x")`,
+		"string")
+
+	checkEvalErr(t, `
+		f := func(a) { return g() }
+		g := func(a) { return a }
+		f(1)`,
+		"number of arguments does not match number of parameters")
+}
+
+func TestMaxDepth(t *testing.T) {
+
+	checkTrace(t, `
+		deep := func(n) {
+			if n == 0 { return 0 }
+			return 1 + deep(n - 1)
+		}
+		deep(20000)`,
+		"maximum call depth of 10000 exceeded",
+		"\tat deep, called at testing:4:15 (and 9998 more times)\n\tat deep, called at testing:6:3\n")
+
+	// the error can be caught, and calls continue to work after it
+	checkEval(t, `
+		deep := func(n) {
+			if n == 0 { return 0 }
+			return 1 + deep(n - 1)
+		}
+		r := try(deep(20000))
+		iserror(r), deep(100), try(deep(20000)) == nil`,
+		"true, 100, false")
+}
+
+func TestSetMaxDepth(t *testing.T) {
+
+	input := `
+		deep := func(n) {
+			if n == 0 { return 0 }
+			return 1 + deep(n - 1)
+		}
+		deep(50)`
+
+	for _, b := range backends {
+		prog, err := b.compile(input)
+		if err != nil {
+			t.Fatalf("%s: did not expect error compiling, got: %s", b.name, err)
+		}
+
+		scope := compile.GlobalScope()
+		scope.SetMaxDepth(50)
+
+		_, err = prog.Run(scope)
+		if err == nil || !strings.Contains(err.Error(), "maximum call depth of 50 exceeded") {
+			t.Errorf("%s: expected maximum call depth of 50 exceeded, got: %v", b.name, err)
+		}
+
+		scope.SetMaxDepth(51)
+
+		vals, err := prog.Run(scope)
+		if err != nil {
+			t.Errorf("%s: did not expect error, got: %s", b.name, err)
+		} else if got := compile.ToString(vals[0]); got != "50" {
+			t.Errorf("%s: expected 50 but got %s", b.name, got)
+		}
+	}
+}
+
+// checkTrace checks that a script fails with an error containing matchErr,
+// raised in the calls described by trace, with every backend.
+func checkTrace(t *testing.T, input, matchErr, trace string) {
+
+	for _, b := range backends {
+		_, err := evaluateWith(b.compile, input)
+		if err == nil {
+			t.Errorf("%s: expected error with %q for input %q, but got nil", b.name, matchErr, input)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), matchErr) {
+			t.Errorf("%s: expected error with %q, but got %s", b.name, matchErr, err)
+		}
+
+		if got := compile.Trace(err); got != trace {
+			t.Errorf("%s: expected trace\n%s\nbut got\n%s", b.name, trace, got)
+		}
+	}
+}
+
+// program is a script with an error raised a few calls deep.
+const program = `
+	helper := func(x) {
+		return x * 2
+	}
+	process := func(items) {
+		total := 0
+		i := 0
+		while i < items.len() {
+			total := total + helper(items[i])
+			i := i + 1
+		}
+		return total
+	}
+	`
+
+func TestStackTraces(t *testing.T) {
+
+	checkTrace(t, program+`process([1, "two"])`,
+		"cannot apply * to string and int64",
+		"\tat helper, called at testing:9:21\n\tat process, called at testing:14:2\n")
+
+	// no calls, no trace
+	checkTrace(t, `x := 1; x * "a"`, "cannot apply * to int64 and string", "")
+
+	// a tail call replaces the caller
+	checkTrace(t, program+`
+		f := func(x) { return process(x) }
+		f(["a"])`,
+		"cannot apply * to string and int64",
+		"\tat helper, called at testing:9:21\n\tat process, called at testing:15:25\n")
+
+	// methods are named for their struct
+	checkTrace(t, `
+		struct counter {
+			n := 0
+			add := func(c, x) {
+				c.n := c.n + x
+				return c.n
+			}
+		}
+		c := counter()
+		c.add(1)
+		c.add("one")`,
+		"cannot apply + to int64 and string",
+		"\tat counter.add, called at testing:11:5\n")
+
+	// functions which aren't named are <anon>
+	checkTrace(t, `
+		apply := func(f, x) { return f(x) + 0 }
+		apply(func(x) { return x / 0 }, 1)`,
+		"integer division by zero",
+		"\tat <anon>, called at testing:2:32\n\tat apply, called at testing:3:3\n")
+}
+
+func TestStacktraceBuiltin(t *testing.T) {
+
+	checkEval(t, program+`
+		r := try(process([1, "two"]))
+		stacktrace(r)`,
+		`["helper, called at testing:9:21", "process, called at testing:15:12"]`)
+
+	checkEval(t, `
+		f := func() { return try(1 / 0 + 0) }
+		stacktrace(f())`,
+		`["f, called at testing:3:14"]`)
+
+	checkEval(t, `stacktrace(try(len(1))), stacktrace(error("x")), stacktrace(nil)`, "[], [], []")
+
+	// error, errorf and wrap keep the calls active when the error is created
+	checkEval(t, `
+		check := func(n) {
+			if n < 0 { return errorf("negative: %d", n) }
+			return error("fine")
+		}
+		validate := func(n) { e := check(n); return e }
+		stacktrace(validate(-1)), stacktrace(wrap(validate(1), "w"))`,
+		`["check, called at testing:6:30", "validate, called at testing:7:14"], []`)
+}
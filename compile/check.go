@@ -0,0 +1,314 @@
+package compile
+
+import (
+	"strings"
+
+	"github.com/pdk/gosh/token"
+	"github.com/pdk/gosh/u"
+)
+
+// Problem is a mistake found in a program without running it. Warnings are
+// suspicious code, which doesn't stop the program from running.
+type Problem struct {
+	Err     error // located where the mistake was found
+	Warning bool
+	at      *Node
+}
+
+// Check finds mistakes in a program without running it. Uses of identifiers
+// which are not bound anywhere, and externs of names which are not bound in an
+// enclosing scope, are errors, as are operators which always fail, e.g. 1/0.
+// Locals of funcs which are assigned but never read, and parameters which are
+// never used, are warnings, unless their names start with _. The whole program
+// is checked as written, including code folding drops. Problems are returned
+// in the order they appear.
+func (p *Program) Check() []Problem {
+
+	c := p.check()
+
+	for _, u := range c.unbound {
+		c.problems = append(c.problems, Problem{
+			Err: u.node.Error("undefined variable %s", u.Name),
+			at:  u.node,
+		})
+	}
+
+	for _, f := range c.funcs {
+		c.unused(f)
+	}
+
+	c.problems = append(c.problems, p.folded...)
+
+	sortProblems(c.problems)
+
+	return c.problems
+}
+
+// check walks the tree of a program, if it has one.
+// Problems returns the problems found by Check and CheckTypes, in the order
+// they appear. A mistake found by both, e.g. the operator of "a" + 1 which
+// always fails, is reported once.
+func (p *Program) Problems() []Problem {
+
+	problems := p.Check()
+	found := len(problems)
+
+	for _, t := range p.CheckTypes() {
+		if !hasProblem(problems[:found], t) {
+			problems = append(problems, t)
+		}
+	}
+
+	sortProblems(problems)
+
+	return problems
+}
+
+// hasProblem checks if a problem with the same message, at the same place, is
+// in a list of problems.
+func hasProblem(problems []Problem, p Problem) bool {
+
+	for _, q := range problems {
+		if q.at.lexeme.LineNo() == p.at.lexeme.LineNo() &&
+			q.at.lexeme.CharNo() == p.at.lexeme.CharNo() &&
+			errorMessage(q.Err) == errorMessage(p.Err) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Program) check() *checker {
+
+	c := &checker{
+		missing:  make(map[*Analysis]map[string]bool),
+		used:     make(map[*Analysis]map[string]bool),
+		assigned: make(map[*Analysis]map[string]*Node),
+		methods:  make(map[*Node]bool),
+	}
+
+	if root, top := p.tree(); root != nil {
+		c.walk(root, top)
+	}
+
+	return c
+}
+
+// checker finds the unbound identifiers of a program, and which variables of
+// its funcs are used, following the same rules as ScopeAnalysis for which
+// identifiers are uses of variables.
+type checker struct {
+	missing  map[*Analysis]map[string]bool
+	unbound  []Unbound
+	problems []Problem
+	used     map[*Analysis]map[string]bool  // variables read, by the func binding them
+	assigned map[*Analysis]map[string]*Node // first assignment of each local of a func
+	funcs    []*Node
+	methods  map[*Node]bool // funcs assigned to the fields of structs
+}
+
+func (c *checker) walk(n *Node, scope *Analysis) {
+
+	switch {
+	case n.IsToken(token.FUNC) && n.analysis != nil:
+		c.funcs = append(c.funcs, n)
+		c.walk(n.analysis.body, n.analysis)
+		return
+
+	case n.IsToken(token.METHAPPLY) && len(n.children) >= 2:
+		c.walk(n.children[0], scope)
+		c.walkAll(n.children[2:], scope)
+		return
+
+	case n.IsToken(token.PERIOD) && len(n.children) > 0:
+		c.walk(n.children[0], scope)
+		return
+
+	case n.IsToken(token.STRUCT):
+		body := n.children[len(n.children)-1]
+		if len(n.children) == 2 {
+			c.assign(n.children[0], scope)
+		}
+		statements := []*Node{body}
+		if body.IsToken(token.STMTS) {
+			statements = body.children
+		}
+		for _, stmt := range statements {
+			if stmt.IsToken(token.ASSIGN) && len(stmt.children) == 2 {
+				c.methods[stmt.children[1]] = true
+				c.walk(stmt.children[1], scope)
+				continue
+			}
+			c.walk(stmt, scope)
+		}
+		return
+
+	case isTypedNil(n):
+		for _, child := range n.children[1:] {
+			if !(child.IsToken(token.IDENT) && IsBuiltinType(child.Literal())) {
+				c.walk(child, scope)
+			}
+		}
+		return
+
+	case n.IsToken(token.EXTERN):
+		for _, child := range n.children {
+			if child.IsToken(token.IDENT) && !scope.parent.BoundInAncestor(child.Literal()) {
+				c.problems = append(c.problems, Problem{
+					Err: child.Error("extern %s is not bound in any enclosing scope", child.Literal()),
+					at:  child,
+				})
+			}
+		}
+		return
+
+	case n.IsToken(token.ASSIGN, token.QASSIGN, token.ACCUM) && len(n.children) > 0:
+		// a plain assignment to a variable doesn't read it, but ?= and +=
+		// do.
+		targets := []*Node{n.children[0]}
+		if n.children[0].IsToken(token.COMMA, token.LPAREN) {
+			targets = n.children[0].children
+		}
+		for _, t := range targets {
+			if t.IsToken(token.IDENT) {
+				c.assign(t, scope)
+				if n.IsToken(token.ASSIGN) {
+					continue
+				}
+			}
+			c.walk(t, scope)
+		}
+		c.walkAll(n.children[1:], scope)
+		return
+
+	case n.IsToken(token.IDENT):
+		c.read(n, scope)
+		return
+	}
+
+	c.walkAll(n.children, scope)
+}
+
+func (c *checker) walkAll(nodes []*Node, scope *Analysis) {
+	for _, n := range nodes {
+		c.walk(n, scope)
+	}
+}
+
+// read notes a use of an identifier: either it's unbound, or it uses a
+// variable.
+func (c *checker) read(n *Node, scope *Analysis) {
+
+	name := n.Literal()
+
+	if c.missingIn(scope)[name] {
+		c.unbound = append(c.unbound, Unbound{
+			Name:        name,
+			Line:        n.lexeme.LineNo(),
+			Column:      n.lexeme.CharNo(),
+			Suggestions: suggestions(name, scope.boundNames()),
+			node:        n,
+		})
+		return
+	}
+
+	if n.address == nil || n.address.Global() {
+		return
+	}
+
+	binder := scope
+	for i := 0; i < n.address.Depth; i++ {
+		binder = binder.parent
+	}
+
+	if c.used[binder] == nil {
+		c.used[binder] = make(map[string]bool)
+	}
+	c.used[binder][name] = true
+}
+
+// assign notes the first assignment of a local of a func.
+func (c *checker) assign(n *Node, scope *Analysis) {
+
+	if !scope.isFunction() || !scope.locals[n.Literal()] {
+		return
+	}
+
+	if c.assigned[scope] == nil {
+		c.assigned[scope] = make(map[string]*Node)
+	}
+	if _, ok := c.assigned[scope][n.Literal()]; !ok {
+		c.assigned[scope][n.Literal()] = n
+	}
+}
+
+// missingIn returns the names which are not bound for a scope, see
+// MissingBinding, excluding builtins.
+func (c *checker) missingIn(scope *Analysis) map[string]bool {
+
+	if m, ok := c.missing[scope]; ok {
+		return m
+	}
+
+	m := make(map[string]bool)
+	for _, name := range scope.MissingBinding() {
+		if !IsBuiltin(name) && !scope.externs[name] {
+			m[name] = true
+		}
+	}
+
+	c.missing[scope] = m
+
+	return m
+}
+
+// unused warns of the parameters and locals of a func which are never read.
+// The first parameter of a method, bound to the struct, needn't be used.
+func (c *checker) unused(f *Node) {
+
+	a := f.analysis
+
+	params := f.children[0].identNodes()
+	if c.methods[f] && len(params) > 0 {
+		params = params[1:]
+	}
+
+	for _, p := range params {
+		if !c.used[a][p.Literal()] && !strings.HasPrefix(p.Literal(), "_") {
+			c.problems = append(c.problems, Problem{
+				Err:     p.Error("parameter %s is never used", p.Literal()),
+				Warning: true,
+				at:      p,
+			})
+		}
+	}
+
+	for name, at := range c.assigned[a] {
+		if c.used[a][name] || strings.HasPrefix(name, "_") ||
+			u.StringIn(name, a.parameters) || u.StringIn(name, a.channels) {
+			continue
+		}
+
+		c.problems = append(c.problems, Problem{
+			Err:     at.Error("%s is assigned but never used", name),
+			Warning: true,
+			at:      at,
+		})
+	}
+}
+
+// identNodes returns the identifiers of a list of names, e.g. parameters.
+func (n *Node) identNodes() []*Node {
+
+	if n.IsToken(token.IDENT) {
+		return []*Node{n}
+	}
+
+	var idents []*Node
+	for _, c := range n.children {
+		idents = append(idents, c.identNodes()...)
+	}
+
+	return idents
+}
@@ -0,0 +1,144 @@
+package compile_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// checkProblems checks that checking a script finds the expected problems, in
+// order. Each is described as "warning|error: location: message".
+func checkProblems(t *testing.T, input string, expected ...string) {
+
+	t.Helper()
+
+	prog, err := compileString(input)
+	if err != nil {
+		t.Fatalf("did not expect error compiling %q, got: %s", input, err)
+	}
+
+	var got []string
+	for _, p := range prog.Check() {
+		severity := "error"
+		if p.Warning {
+			severity = "warning"
+		}
+		// drop the source line from the error
+		parts := strings.SplitN(p.Err.Error(), ": ", 3)
+		got = append(got, fmt.Sprintf("%s: %s: %s", severity, parts[0], parts[len(parts)-1]))
+	}
+
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected problems\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestCheckUnbound(t *testing.T) {
+
+	checkProblems(t, `
+		count := 1
+		f := func(n) {
+			if n > 1000 {
+				return cuont + n
+			}
+			return count + n
+		}
+		f(1)`,
+		"error: testing:5:12: undefined variable cuont")
+
+	// builtins, globals defined later, and struct types are bound
+	checkProblems(t, `
+		f := func() { return g() + [1].len() }
+		g := func() { return 1 }
+		struct point { x := 0 }
+		p := point()
+		p.x + f()`)
+}
+
+func TestCheckUnused(t *testing.T) {
+
+	checkProblems(t, `
+		f := func(a, b, _c) {
+			x := 1
+			y := 2
+			_z := 3
+			return a + y
+		}
+		f(1, 2, 3)`,
+		"warning: testing:2:16: parameter b is never used",
+		"warning: testing:3:4: x is assigned but never used")
+
+	// reads from closures count as uses, but a closure's own locals are
+	// different variables
+	checkProblems(t, `
+		f := func(a) {
+			n := 0
+			m := 0
+			inc := func() { m := 1; return n + a }
+			return inc
+		}
+		f(1)`,
+		"warning: testing:4:4: m is assigned but never used",
+		"warning: testing:5:20: m is assigned but never used")
+
+	// assigning to a parameter doesn't use it
+	checkProblems(t, `
+		f := func(a) {
+			a := 1
+			return 2
+		}
+		f(1)`,
+		"warning: testing:2:13: parameter a is never used")
+
+	// methods needn't use the struct they're invoked on
+	checkProblems(t, `
+		struct greeter {
+			name := ""
+			hello := func(me, to) {
+				return "hello"
+			}
+		}
+		greeter("x").hello("y")`,
+		"warning: testing:4:22: parameter to is never used")
+
+	// only the locals of funcs are checked
+	checkProblems(t, `x := 1; y := 2; y`)
+}
+
+func TestCheckExterns(t *testing.T) {
+
+	checkProblems(t, `
+		c := 1
+		f := func() {
+			extern c, d
+			c := 2
+			d := 3
+		}
+		f()`,
+		"error: testing:4:14: extern d is not bound in any enclosing scope")
+}
+
+func TestProblems(t *testing.T) {
+
+	prog, err := compileString(`
+		y := "a" + 1
+		z := 1
+		z := "s"`)
+	if err != nil {
+		t.Fatalf("did not expect error compiling, got: %s", err)
+	}
+
+	var got []string
+	for _, p := range prog.Problems() {
+		got = append(got, p.Err.Error())
+	}
+
+	expected := []string{
+		"testing:2:12: \t\ty := \"a\" + 1: cannot apply + to string and int64",
+		"testing:4:3: \t\tz := \"s\": attempt to convert variable z from type int64 to type string",
+	}
+
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected problems\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,280 @@
+package compile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterBuiltin("map", mapBuiltin)
+}
+
+// List is an ordered collection of values, of any/mixed types.
+type List struct {
+	items []Value
+}
+
+// NewList returns a new list holding the given values.
+func NewList(vals ...Value) *List {
+	return &List{
+		items: append([]Value{}, vals...),
+	}
+}
+
+// Len returns the number of items in the list.
+func (l *List) Len() int {
+	return len(l.items)
+}
+
+// Get returns the i'th item of the list.
+func (l *List) Get(i int) (Value, error) {
+
+	if i < 0 || i >= len(l.items) {
+		return nil, fmt.Errorf("list index %d out of range, length %d", i, len(l.items))
+	}
+
+	return l.items[i], nil
+}
+
+// Set sets the i'th item of the list.
+func (l *List) Set(i int, v Value) error {
+
+	if i < 0 || i >= len(l.items) {
+		return fmt.Errorf("list index %d out of range, length %d", i, len(l.items))
+	}
+
+	l.items[i] = v
+
+	return nil
+}
+
+// Append adds values to the end of the list.
+func (l *List) Append(vals ...Value) {
+	l.items = append(l.items, vals...)
+}
+
+// Map maps keys to values. Keys are kept in the order first assigned. Keys are
+// strings, or structs; a key is found by its hash, then by Equality amongst
+// the keys having the same hash.
+type Map struct {
+	keys    []Value
+	hashes  []string // of each key
+	values  []Value
+	buckets map[string][]int // offsets of the keys, by hash
+}
+
+// NewMap returns a new, empty map.
+func NewMap() *Map {
+	return &Map{
+		buckets: make(map[string][]int),
+	}
+}
+
+// Len returns the number of keys in the map.
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the keys of the map, in order.
+func (m *Map) Keys() []Value {
+	return append([]Value{}, m.keys...)
+}
+
+// Get returns the value for a string key, and whether the key is in the map.
+func (m *Map) Get(key string) (Value, bool) {
+
+	for _, i := range m.buckets[stringHash(key)] {
+		if m.keys[i] == Value(key) {
+			return m.values[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Set sets the value for a string key.
+func (m *Map) Set(key string, v Value) {
+
+	h := stringHash(key)
+	for _, i := range m.buckets[h] {
+		if m.keys[i] == Value(key) {
+			m.values[i] = v
+			return
+		}
+	}
+
+	m.add(h, key, v)
+}
+
+// Delete removes a string key from the map.
+func (m *Map) Delete(key string) {
+
+	for _, i := range m.buckets[stringHash(key)] {
+		if m.keys[i] == Value(key) {
+			m.remove(i)
+			return
+		}
+	}
+}
+
+// find returns the offset of a key, or -1, comparing keys with eq.
+func (m *Map) find(eq *Equality, key Value) (int, error) {
+
+	h, err := eq.hash(key)
+	if err != nil {
+		return -1, err
+	}
+
+	return m.lookup(eq, h, key)
+}
+
+func (m *Map) lookup(eq *Equality, h string, key Value) (int, error) {
+
+	for _, i := range m.buckets[h] {
+		same, err := eq.equal(m.keys[i], key)
+		if err != nil {
+			return -1, err
+		}
+		if same {
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// get returns the value for a key, or nil, comparing keys with eq.
+func (m *Map) get(eq *Equality, key Value) (Value, error) {
+
+	i, err := m.find(eq, key)
+	if i < 0 || err != nil {
+		return nil, err
+	}
+
+	return m.values[i], nil
+}
+
+// put sets the value for a key, comparing keys with eq.
+func (m *Map) put(eq *Equality, key, v Value) error {
+
+	h, err := eq.hash(key)
+	if err != nil {
+		return err
+	}
+
+	i, err := m.lookup(eq, h, key)
+	if err != nil {
+		return err
+	}
+
+	if i >= 0 {
+		m.values[i] = v
+		return nil
+	}
+
+	m.add(h, key, v)
+
+	return nil
+}
+
+// del removes a key from the map, comparing keys with eq.
+func (m *Map) del(eq *Equality, key Value) error {
+
+	i, err := m.find(eq, key)
+	if i >= 0 {
+		m.remove(i)
+	}
+
+	return err
+}
+
+func (m *Map) add(h string, key, v Value) {
+	m.buckets[h] = append(m.buckets[h], len(m.keys))
+	m.keys = append(m.keys, key)
+	m.hashes = append(m.hashes, h)
+	m.values = append(m.values, v)
+}
+
+// remove drops the i'th key, and renumbers the keys after it.
+func (m *Map) remove(i int) {
+
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	m.hashes = append(m.hashes[:i], m.hashes[i+1:]...)
+	m.values = append(m.values[:i], m.values[i+1:]...)
+
+	m.buckets = make(map[string][]int)
+	for j, h := range m.hashes {
+		m.buckets[h] = append(m.buckets[h], j)
+	}
+}
+
+// dup returns a shallow copy of the map.
+func (m *Map) dup() *Map {
+
+	d := NewMap()
+	for i, k := range m.keys {
+		d.add(m.hashes[i], k, m.values[i])
+	}
+
+	return d
+}
+
+// map(k, v, ...) returns a new map, with the pairs of keys and values given.
+func mapBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if len(args)%2 != 0 {
+		return Values(), n.Error("map expects pairs of keys and values, got %d argument(s)", len(args))
+	}
+
+	m := NewMap()
+	eq := NewEquality(n)
+	for i := 0; i < len(args); i += 2 {
+		if err := m.put(eq, args[i], args[i+1]); err != nil {
+			return Values(), n.LocateError(err)
+		}
+	}
+
+	return Values(m), nil
+}
+
+// collectionString converts lists, maps and structs to strings. Values already
+// being printed are shown as "..." to avoid looping on cycles.
+func collectionString(v Value, printing map[Value]bool) string {
+
+	switch v2 := v.(type) {
+	case *List, *Map, *Struct:
+		if printing[v2] {
+			return "..."
+		}
+		printing[v2] = true
+		defer delete(printing, v2)
+	case string:
+		return strconv.Quote(v2)
+	default:
+		return ToString(v)
+	}
+
+	var parts []string
+
+	switch v2 := v.(type) {
+	case *List:
+		for _, x := range v2.items {
+			parts = append(parts, collectionString(x, printing))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+
+	case *Map:
+		for i, k := range v2.keys {
+			parts = append(parts, collectionString(k, printing)+": "+collectionString(v2.values[i], printing))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+
+	default:
+		s := v2.(*Struct)
+		for _, name := range s.Fields() {
+			parts = append(parts, name+": "+collectionString(s.values[name], printing))
+		}
+		return s.typeName + "{" + strings.Join(parts, ", ") + "}"
+	}
+}
@@ -0,0 +1,135 @@
+package compile_test
+
+import "testing"
+
+func TestLists(t *testing.T) {
+
+	checkEval(t, `[1, "foo", true]`, `[1, "foo", true]`)
+	checkEval(t, `l := [1, 2, 3]; l[0], l[2]`, "1, 3")
+	checkEval(t, `l := [1, 2, 3]; l[1] := 5; l`, "[1, 5, 3]")
+	checkEval(t, `l := []; l.append("apple"); l.append(2, 3); l.len(), l`, `3, ["apple", 2, 3]`)
+	checkEval(t, `l := [1, 2]; l.pop(), l`, "2, [1]")
+	checkEval(t, `l := [1]; d := l.dup(); d.append(2); l, d`, "[1], [1, 2]")
+	checkEval(t, `l := [1]; l.append(l); l`, "[1, ...]")
+
+	checkEval(t, `l := [1, 2, 3, 4]; l[1:3], l[3:], l[:1], l[:], l[2:1]`, "[2, 3, 4], [4], [1, 2], [1, 2, 3, 4], []")
+	checkEval(t, `l := [1, 2, 3]; s := l[0:1]; s.append(9); l, s`, "[1, 2, 3], [1, 2, 9]")
+
+	checkEvalErr(t, `l := [1, 2]; l[5]`, "list index 5 out of range, length 2")
+	checkEvalErr(t, `l := [1, 2]; l[1:2]`, "slice [1:2] out of range, length 2")
+	checkEvalErr(t, `l := [1, 2]; l["a":]`, "slice bounds must be ints, got string")
+	checkEvalErr(t, `"abc"[0:1]`, "cannot slice string")
+	checkEvalErr(t, `[].pop()`, "cannot pop from an empty list")
+	checkEvalErr(t, `[].nope()`, "list has no method nope")
+}
+
+func TestMaps(t *testing.T) {
+
+	checkEval(t, `map()`, "{}")
+	checkEval(t, `m := map("a", 1, "b", 2); m, m["b"], m[0], m.a, m["nope"]`, `{"a": 1, "b": 2}, 2, 1, 1, nil`)
+	checkEval(t, `m := map(); m["x"] := 1; m.y := 2; m["x"] := 3; m`, `{"x": 3, "y": 2}`)
+	checkEval(t, `m := map("a", 1, "b", 2, "c", 3); m.del("b"); m.len(), m.keys(), m.values()`, `2, ["a", "c"], [1, 3]`)
+	checkEval(t, `m := map("a", 1); d := m.dup(); d["b"] := 2; m, d`, `{"a": 1}, {"a": 1, "b": 2}`)
+	checkEval(t, `map("a", [1]) == map("a", [1]), map("a", 1) == map("a", 2), map("a", 1, "b", 2) == map("b", 2, "a", 1)`, "true, false, false")
+
+	checkEval(t, `
+		struct point {
+			x := 0
+			y := 0
+		}
+		m := map(point(1, 2), "a")
+		m[point(1, 2)] := "b"
+		m[point(2, 1)] := "c"
+		m, m[point(2, 1)], m.keys()`,
+		`{point{x: 1, y: 2}: "b", point{x: 2, y: 1}: "c"}, c, [point{x: 1, y: 2}, point{x: 2, y: 1}]`)
+
+	checkEval(t, `
+		struct user {
+			id := ""
+			note := ""
+			equals := func(a, b) {
+				return a.id == b.id
+			}
+			hash := func(me) {
+				return me.id
+			}
+		}
+		m := map(user("a", "ann"), 1, user("b", "bob"), 2)
+		m.del(user("b", "robert"))
+		m[user("a", "")], m.len()`,
+		"1, 1")
+
+	checkEval(t, `
+		struct weird {
+			hash := func(me) {
+				return "same"
+			}
+		}
+		m := map("same", 1)
+		m[weird()] := 2
+		m.len(), m["same"], m[weird()]`,
+		"2, 1, 2")
+
+	checkEvalErr(t, `
+		struct bad {
+			hash := func(me) {
+				return 1
+			}
+		}
+		map(bad(), 1)`,
+		"hash method of bad must return a string")
+	checkEvalErr(t, `map("a")`, "map expects pairs of keys and values, got 1 argument(s)")
+	checkEvalErr(t, `map(1, 2)`, "map keys must be strings")
+	checkEvalErr(t, `map().nope()`, "map has no method nope")
+}
+
+func TestStructs(t *testing.T) {
+
+	checkEval(t, `
+		struct myStruct {
+			x := 0
+			s := ""
+			f := func(_, a, c) {
+				return a + _.s + c
+			}
+		}
+		s := myStruct(42, "hello")
+		s, s.x, s.f("<", ">"), s[1], s["x"]`,
+		`myStruct{x: 42, s: "hello"}, 42, <hello>, hello, 42`)
+
+	checkEval(t, `
+		struct fooBar {
+			x := 0
+			fooBar := func(me, x) {
+				me.x := x + 42
+			}
+		}
+		fooBar(1).x`,
+		"43")
+
+	checkEval(t, `
+		s := struct {
+			x := 1
+			w := "foo"
+			m := func(me) { return me.x }
+		}
+		s.x := 2
+		s[1] := "bar"
+		s, s.flds(), s.methods(), s.m()`,
+		`struct{x: 2, w: "bar"}, ["x", "w"], ["m"], 2`)
+
+	checkEval(t, `
+		struct counter { n := 0 }
+		a := counter()
+		b := a.dup()
+		b.n := 1
+		a.n, b.n`,
+		"0, 1")
+
+	checkEval(t, `struct e {}; e()`, "e{}")
+
+	checkEvalErr(t, `struct p { x := 0 }; p(1, 2)`, "too many arguments for struct p")
+	checkEvalErr(t, `struct p { x := 0 }; p().y`, "p has no field y")
+	checkEvalErr(t, `struct p { x := 0 }; q := p(); q.y := 1`, "p has no field y")
+	checkEvalErr(t, `struct p { x := 0; 1 + 2 }`, "struct body may only contain field assignments")
+}
@@ -0,0 +1,269 @@
+package compile
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Comparison orders values, for <, <=, >, >= and sorting.
+//
+// Numbers of different types are promoted before comparing: a rune compared
+// with an int is treated as an int, and an int or rune compared with a float
+// is treated as a float. NaN cannot be ordered.
+//
+// Strings order by bytes, false is before true, times order chronologically,
+// and lists order lexicographically. Structs can be ordered if they define a
+// compare(a,b) method, which returns an int less than, equal to or greater than
+// 0. Otherwise values of different types cannot be compared.
+type Comparison struct {
+	n *Node // for reporting errors, and invoking compare methods
+
+	// pairs of lists currently being compared, to avoid looping on cycles.
+	comparing map[[2]Value]bool
+}
+
+// NewComparison returns a Comparison which reports errors at the given node,
+// which may be nil.
+func NewComparison(n *Node) *Comparison {
+	return &Comparison{
+		n:         n,
+		comparing: make(map[[2]Value]bool),
+	}
+}
+
+// CompareValues returns -1, 0 or 1 as left is less than, equal to, or greater
+// than right.
+func CompareValues(left, right Value) (int, error) {
+	return NewComparison(nil).Compare(left, right)
+}
+
+// Compare returns -1, 0 or 1 as left is less than, equal to, or greater than
+// right.
+func (c *Comparison) Compare(left, right Value) (int, error) {
+
+	if isNumber(left) && isNumber(right) {
+		return compareNumbers(left, right)
+	}
+
+	if left == nil || right == nil {
+		return 0, fmt.Errorf("cannot order nil")
+	}
+
+	if reflect.TypeOf(left) != reflect.TypeOf(right) {
+		return 0, fmt.Errorf("cannot compare values of different types, %T and %T", left, right)
+	}
+
+	switch lv := left.(type) {
+	case string:
+		return strings.Compare(lv, right.(string)), nil
+	case bool:
+		return compareBools(lv, right.(bool)), nil
+	case time.Time:
+		return compareTimes(lv, right.(time.Time)), nil
+	case *List:
+		return c.lists(lv, right.(*List))
+	case *Struct:
+		if f, ok := lv.Method("compare"); ok {
+			return c.invokeCompare(f, lv, right)
+		}
+		return 0, fmt.Errorf("cannot order %s values, %s has no compare method", lv.typeName, lv.typeName)
+	}
+
+	return 0, fmt.Errorf("cannot order values of type %s", TypeName(left))
+}
+
+// isNumber checks if a value is an int, float or rune.
+func isNumber(v Value) bool {
+
+	switch v.(type) {
+	case int64, float64, rune:
+		return true
+	}
+
+	return false
+}
+
+// compareNumbers compares numbers, promoting as needed.
+func compareNumbers(left, right Value) (int, error) {
+
+	_, leftFloat := left.(float64)
+	_, rightFloat := right.(float64)
+
+	if !leftFloat && !rightFloat {
+		return compareInts(asInt64(left), asInt64(right)), nil
+	}
+
+	l, r := asFloat64(left), asFloat64(right)
+	if math.IsNaN(l) || math.IsNaN(r) {
+		return 0, fmt.Errorf("cannot order NaN")
+	}
+
+	switch {
+	case l < r:
+		return -1, nil
+	case l > r:
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func asInt64(v Value) int64 {
+
+	if r, ok := v.(rune); ok {
+		return int64(r)
+	}
+
+	return v.(int64)
+}
+
+func asFloat64(v Value) float64 {
+
+	if f, ok := v.(float64); ok {
+		return f
+	}
+
+	return float64(asInt64(v))
+}
+
+func compareInts(l, r int64) int {
+
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+
+	return 0
+}
+
+func compareBools(l, r bool) int {
+
+	switch {
+	case l == r:
+		return 0
+	case r:
+		return -1
+	}
+
+	return 1
+}
+
+func compareTimes(l, r time.Time) int {
+
+	switch {
+	case l.Before(r):
+		return -1
+	case l.After(r):
+		return 1
+	}
+
+	return 0
+}
+
+// lists compares lists lexicographically.
+func (c *Comparison) lists(left, right *List) (int, error) {
+
+	if left == right {
+		return 0, nil
+	}
+
+	pair := [2]Value{left, right}
+	if c.comparing[pair] {
+		return 0, nil
+	}
+
+	c.comparing[pair] = true
+	defer delete(c.comparing, pair)
+
+	for i := 0; i < len(left.items) && i < len(right.items); i++ {
+		r, err := c.Compare(left.items[i], right.items[i])
+		if r != 0 || err != nil {
+			return r, err
+		}
+	}
+
+	return compareInts(int64(len(left.items)), int64(len(right.items))), nil
+}
+
+// invokeCompare calls a struct's compare(a,b) method.
+func (c *Comparison) invokeCompare(f Function, left, right Value) (int, error) {
+
+	result, err := callFunction(c.n, f, Values(left, right))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(result) == 1 {
+		if i, ok := result[0].(int64); ok {
+			return compareInts(i, 0), nil
+		}
+	}
+
+	return 0, fmt.Errorf("compare method must return a single int")
+}
+
+// Sort sorts a list in place. The sort is stable.
+func (c *Comparison) Sort(l *List) error {
+
+	var err error
+
+	sort.SliceStable(l.items, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+
+		var r int
+		r, err = c.Compare(l.items[i], l.items[j])
+
+		return r < 0
+	})
+
+	return err
+}
+
+func init() {
+	RegisterBuiltin("compare", compareBuiltin)
+	RegisterBuiltin("sort", sortBuiltin)
+}
+
+// compare(a, b) returns -1, 0 or 1 as a is less than, equal to, or greater
+// than b.
+func compareBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "compare", args, 2, 2); err != nil {
+		return Values(), err
+	}
+
+	r, err := CompareValues(args[0], args[1])
+	if err != nil {
+		return Values(), n.LocateError(err)
+	}
+
+	return Values(int64(r)), nil
+}
+
+// sort(list) sorts a list in place, and returns it.
+func sortBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "sort", args, 1, 1); err != nil {
+		return Values(), err
+	}
+
+	l, ok := args[0].(*List)
+	if !ok {
+		return Values(), n.Error("sort expects a list for argument 1, got %T", args[0])
+	}
+
+	err := NewComparison(n).Sort(l)
+	if err != nil {
+		return Values(), n.LocateError(err)
+	}
+
+	return Values(l), nil
+}
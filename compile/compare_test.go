@@ -0,0 +1,90 @@
+package compile_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pdk/gosh/compile"
+)
+
+func TestOrdering(t *testing.T) {
+
+	checkEval(t, `1 < 2, 2 <= 2, 3 > 2, 2 >= 3`, "true, true, true, false")
+	checkEval(t, `"a" < "b", "b" <= "a"`, "true, false")
+	checkEval(t, `'a' < 'b', 'z' > 'y'`, "true, true")
+	checkEval(t, `false < true, true <= false`, "true, false")
+	checkEval(t, `a := now(); b := now(); a <= b`, "true")
+
+	checkEvalErr(t, `"a" < 1`, "cannot compare values of different types, string and int64")
+	checkEvalErr(t, `nil < 1`, "cannot order nil")
+	checkEvalErr(t, `struct p { x := 0 }; p() < p()`, "cannot order p values, p has no compare method")
+}
+
+func TestNumericPromotion(t *testing.T) {
+
+	checkEval(t, `1 < 1.5, 2 > 1.5, 1 <= 1.0, 1 == 1.0, 2 != 2.5`, "true, true, true, true, true")
+	checkEval(t, `'a' == 97, 'a' < 98, 'a' < 97.5`, "true, true, true")
+	checkEval(t, `[1, 2] == [1.0, 2.0]`, "true")
+}
+
+func TestListOrdering(t *testing.T) {
+
+	checkEval(t, `[1, 2] < [1, 3], [1, 2] < [1, 2, 0], [2] > [1, 9], [1] <= [1]`, "true, true, true, true")
+	checkEval(t, `["a", 1] < ["a", 2]`, "true")
+
+	checkEvalErr(t, `[1] < ["a"]`, "cannot compare values of different types")
+}
+
+func TestCompareMethod(t *testing.T) {
+
+	checkEval(t, `
+		struct version {
+			major := 0
+			minor := 0
+			compare := func(a, b) {
+				if a.major != b.major {
+					return a.major - b.major
+				}
+				return a.minor - b.minor
+			}
+		}
+		version(1, 2) < version(1, 10), version(2, 0) > version(1, 10), version(1, 1) <= version(1, 1)`,
+		"true, true, true")
+}
+
+func TestSort(t *testing.T) {
+
+	checkEval(t, `sort([3, 1.5, 2, 'a'])`, "[1.5, 2, 3, a]")
+	checkEval(t, `sort(["pear", "apple", "fig"])`, `["apple", "fig", "pear"]`)
+	checkEval(t, `l := [[2], [1, 5], [1]]; sort(l); l`, "[[1], [1, 5], [2]]")
+	checkEval(t, `compare(1, 2), compare("b", "a"), compare(2, 2.0)`, "-1, 1, 0")
+
+	checkEval(t, `
+		struct item {
+			name := ""
+			rank := 0
+			compare := func(a, b) { return a.rank - b.rank }
+		}
+		l := sort([item("c", 3), item("a", 1), item("b", 2)])
+		l[0].name, l[1].name, l[2].name`,
+		"a, b, c")
+
+	checkEvalErr(t, `sort([1, "a"])`, "cannot compare values of different types")
+	checkEvalErr(t, `sort(1)`, "sort expects a list for argument 1")
+}
+
+func TestCompareValues(t *testing.T) {
+
+	early := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+
+	r, err := compile.CompareValues(early, late)
+	if err != nil || r != -1 {
+		t.Errorf("expected earlier time to order first, got %d, %v", r, err)
+	}
+
+	r, err = compile.CompareValues(late, late.In(time.Local))
+	if err != nil || r != 0 {
+		t.Errorf("expected same instant in different zones to be equal, got %d, %v", r, err)
+	}
+}
@@ -0,0 +1,111 @@
+package compile_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/reader"
+)
+
+// evaluate runs a script in a fresh global scope.
+func evaluate(input string) ([]compile.Value, error) {
+
+	prog, err := compileString(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return prog.Run(compile.GlobalScope())
+}
+
+// compileString compiles a script.
+func compileString(input string) (*compile.Program, error) {
+	return compile.Compile("testing", reader.ReadLinesToStrings(strings.NewReader(input)))
+}
+
+// backends compile a script for each way of running it: the tree of
+// evaluators, the bytecode machine, and bytecode read back from a .goshc file.
+var backends = []struct {
+	name    string
+	compile func(input string) (*compile.Program, error)
+}{
+	{"tree", compileString},
+	{"vm", compileBytecode},
+	{"goshc", roundTripBytecode},
+}
+
+// compileBytecode compiles a script to bytecode.
+func compileBytecode(input string) (*compile.Program, error) {
+	return compile.CompileBytecode("testing", reader.ReadLinesToStrings(strings.NewReader(input)))
+}
+
+// roundTripBytecode compiles a script to bytecode, writes it, and reads it
+// back.
+func roundTripBytecode(input string) (*compile.Program, error) {
+
+	prog, err := compileBytecode(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = prog.WriteBytecode(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return compile.ReadBytecode(&buf)
+}
+
+// evaluateWith runs a script compiled by a backend in a fresh global scope.
+func evaluateWith(compiler func(string) (*compile.Program, error), input string) ([]compile.Value, error) {
+
+	prog, err := compiler(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return prog.Run(compile.GlobalScope())
+}
+
+// checkEval checks that a script evaluates to values which print as expected,
+// with every backend.
+func checkEval(t *testing.T, input, expected string) {
+
+	for _, b := range backends {
+		vals, err := evaluateWith(b.compile, input)
+		if err != nil {
+			t.Errorf("%s: did not expect error for input %q, got: %s", b.name, input, err)
+			continue
+		}
+
+		var printable []string
+		for _, v := range vals {
+			printable = append(printable, compile.ToString(v))
+		}
+
+		got := strings.Join(printable, ", ")
+		if got != expected {
+			t.Errorf("%s: input %q: expected %s but got %s", b.name, input, expected, got)
+		}
+	}
+}
+
+// checkEvalErr checks that a script fails with an error containing matchErr,
+// with every backend.
+func checkEvalErr(t *testing.T, input, matchErr string) {
+
+	for _, b := range backends {
+		_, err := evaluateWith(b.compile, input)
+		if err == nil {
+			t.Errorf("%s: expected error with %q for input %q, but got nil", b.name, matchErr, input)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), matchErr) {
+			t.Errorf("%s: expected error with %q, but got %s", b.name, matchErr, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package compile
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pdk/gosh/token"
+)
+
+// Block is a statement, or an arm of a branch, of a program, and how many
+// times it ran.
+type Block struct {
+	File   string
+	Line   int
+	Column int
+	Branch string // "" for a statement, or the arm of a branch: then, else, none, right or short
+	Count  int
+}
+
+// The arms of branches. An if with no else has the arm "none", taken when no
+// condition is true. && and || have the arms "right", taken when the right side
+// is evaluated, and "short", when it's not.
+const (
+	armThen  = "then"
+	armElse  = "else"
+	armNone  = "none"
+	armRight = "right"
+	armShort = "short"
+)
+
+// branchKey is an arm of a branch: the index of the body of an if, or of the
+// side of && or ||, or -1 for no arm.
+type branchKey struct {
+	node *Node
+	arm  int
+}
+
+// Coverage counts the statements and branches run by programs. Each program is
+// added, and then run in scopes which record their coverage.
+type Coverage struct {
+	mu         sync.Mutex
+	blocks     []*Block
+	statements map[*Node]*Block
+	branches   map[branchKey]*Block
+}
+
+// NewCoverage returns a coverage of no programs.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		statements: make(map[*Node]*Block),
+		branches:   make(map[branchKey]*Block),
+	}
+}
+
+// SetCoverage makes the program using the scope record its coverage.
+func (v *Variables) SetCoverage(c *Coverage) {
+
+	if v.calls != nil {
+		v.calls.coverage = c
+	}
+}
+
+// Add adds the statements and branches of a program, none of which has run.
+func (c *Coverage) Add(p *Program) error {
+
+	if p.root == nil || p.code != nil {
+		return fmt.Errorf("%s is compiled to bytecode, which cannot record coverage", p.name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(p.name, p.root)
+
+	return nil
+}
+
+func (c *Coverage) add(file string, n *Node) {
+
+	block := func(at *Node, branch string) *Block {
+		b := &Block{File: file, Line: at.lexeme.LineNo(), Column: at.lexeme.CharNo(), Branch: branch}
+		c.blocks = append(c.blocks, b)
+		return b
+	}
+
+	if n.statement && c.statements[n] == nil {
+		c.statements[n] = block(n, "")
+	}
+
+	switch {
+	case n.IsToken(token.IF):
+		for i, body := range n.children {
+			switch {
+			case i == len(n.children)-1 && i%2 == 0:
+				c.branches[branchKey{n, i}] = block(body, armElse)
+			case i%2 == 1:
+				c.branches[branchKey{n, i}] = block(body, armThen)
+			}
+		}
+		if len(n.children)%2 == 0 {
+			c.branches[branchKey{n, -1}] = block(n, armNone)
+		}
+
+	case n.IsToken(token.LOG_AND, token.LOG_OR) && len(n.children) == 2:
+		c.branches[branchKey{n, 1}] = block(n, armRight)
+		c.branches[branchKey{n, 0}] = block(n, armShort)
+	}
+
+	for _, child := range n.children {
+		c.add(file, child)
+	}
+}
+
+// statement counts a run of a statement.
+func (c *Coverage) statement(n *Node) {
+
+	c.mu.Lock()
+	if b := c.statements[n]; b != nil {
+		b.Count++
+	}
+	c.mu.Unlock()
+}
+
+// branch counts a run of an arm of a branch.
+func (c *Coverage) branch(n *Node, arm int) {
+
+	c.mu.Lock()
+	if b := c.branches[branchKey{n, arm}]; b != nil {
+		b.Count++
+	}
+	c.mu.Unlock()
+}
+
+// coverBranch counts an arm of a branch, if the program is recording its
+// coverage.
+func coverBranch(n *Node, vars *Variables, arm int) {
+
+	if calls := vars.callStack(); calls != nil && calls.coverage != nil {
+		calls.coverage.branch(n, arm)
+	}
+}
+
+// Blocks returns the statements and branches of the programs, in order of file,
+// line and column.
+func (c *Coverage) Blocks() []Block {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var blocks []Block
+	for _, b := range c.blocks {
+		blocks = append(blocks, *b)
+	}
+
+	SortBlocks(blocks)
+
+	return blocks
+}
+
+// SortBlocks sorts blocks by file, line and column, with statements before the
+// branches at the same place.
+func SortBlocks(blocks []Block) {
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		switch {
+		case a.File != b.File:
+			return a.File < b.File
+		case a.Line != b.Line:
+			return a.Line < b.Line
+		case a.Column != b.Column:
+			return a.Column < b.Column
+		}
+		return a.Branch == "" && b.Branch != ""
+	})
+}
@@ -0,0 +1,75 @@
+package compile_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+)
+
+func TestCoverage(t *testing.T) {
+
+	input := `sign := func(x) {
+	if x < 0 {
+		return -1
+	} else if x > 0 {
+		return 1
+	}
+	0
+}
+small := func(x) { x > -10 && x < 10 }
+sign(5), sign(7), small(20)`
+
+	prog, err := compile.Compile("testing", strings.Split(input, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := compile.NewCoverage()
+	if err := c.Add(prog); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := compile.GlobalScope()
+	vars.SetCoverage(c)
+
+	if _, err := prog.Run(vars); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, b := range c.Blocks() {
+		kind := "statement"
+		if b.Branch != "" {
+			kind = "branch " + b.Branch
+		}
+		got = append(got, fmt.Sprintf("%d:%d %s %d", b.Line, b.Column, kind, b.Count))
+	}
+
+	expected := `1:6 statement 1
+2:2 statement 2
+2:2 branch none 0
+3:3 statement 0
+3:3 branch then 0
+5:3 statement 2
+5:3 branch then 2
+7:2 statement 0
+9:7 statement 1
+9:28 statement 1
+9:28 branch right 1
+9:28 branch short 0
+10:17 statement 1`
+
+	if strings.Join(got, "\n") != expected {
+		t.Errorf("expected coverage\n%s\ngot\n%s", expected, strings.Join(got, "\n"))
+	}
+
+	bytecode, err := compile.CompileBytecode("testing", []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(bytecode); err == nil {
+		t.Errorf("expected an error recording the coverage of bytecode")
+	}
+}
@@ -0,0 +1,551 @@
+package compile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/parse"
+	"github.com/pdk/gosh/token"
+)
+
+// markStatements marks the statements of a tree, i.e. the nodes which the
+// debugger can stop before, and coverage counts: the top level, the statements of blocks, and the
+// bodies of ifs, whiles and funcs.
+func (n *Node) markStatements() {
+	n.markStatement()
+	n.markBodies()
+}
+
+// markBodies marks the bodies of the ifs, whiles and funcs of a tree.
+func (n *Node) markBodies() {
+
+	switch {
+	case n.IsToken(token.IF):
+		// if c0 { b1 } else if c2 { b3 } else { b4 }
+		for i, c := range n.children {
+			if i%2 == 1 || (i > 0 && i == len(n.children)-1) {
+				c.markStatement()
+			}
+		}
+
+	case n.IsToken(token.WHILE) && len(n.children) == 2:
+		n.children[1].markStatement()
+
+	case n.IsToken(token.FUNC) && len(n.children) == 3:
+		n.children[2].markStatement()
+	}
+
+	for _, c := range n.children {
+		c.markBodies()
+	}
+}
+
+// markStatement marks a node as a statement, or each node of a block.
+func (n *Node) markStatement() {
+
+	if n.IsToken(token.STMTS) {
+		for _, c := range n.children {
+			c.statement = true
+		}
+		return
+	}
+
+	if n.lexeme != nil {
+		n.statement = true
+	}
+}
+
+// instrumented wraps the evaluator of a statement, to count it if the program
+// is recording its coverage, note it if the program is being profiled, and
+// give control to the debugger, if there is one, before evaluating it.
+func instrumented(n *Node, eval Evaluator) Evaluator {
+
+	return func(vars *Variables) ([]Value, error) {
+
+		if calls := vars.callStack(); calls != nil {
+			if calls.coverage != nil {
+				calls.coverage.statement(n)
+			}
+			if calls.profiler != nil {
+				calls.profiler.statement(n, calls)
+			}
+			if calls.debugger != nil {
+				calls.debugger.statement(n, vars, len(calls.calls))
+			}
+		}
+
+		return eval(vars)
+	}
+}
+
+// stepMode is how a debugged program runs until it next stops.
+type stepMode int
+
+const (
+	running  stepMode = iota // until a breakpoint
+	stepIn                   // to the next statement
+	stepOver                 // to the next statement of the same call, or one it returns to
+	stepOut                  // to the next statement of the call which called this one
+)
+
+// Event is something that happened to a debugged program: it stopped, at a
+// line and column, or it exited, with its results.
+type Event struct {
+	Reason string // entry, breakpoint, step, pause or exited
+	Line   int
+	Column int
+	Values []Value // the results of the program, when it exited
+	Err    error   // the error the program failed with, when it exited
+}
+
+// Exited checks if the event is the end of the program.
+func (e Event) Exited() bool {
+	return e.Reason == "exited"
+}
+
+// StackFrame is an active call of a stopped program, or the top level, and
+// the line and column it's stopped at.
+type StackFrame struct {
+	Name   string
+	Line   int
+	Column int
+}
+
+// Scope is a set of variables visible from a frame.
+type Scope struct {
+	Name      string // Locals, Closure or Globals
+	Variables []Variable
+}
+
+// Variable is a variable of a stopped program, and its value.
+type Variable struct {
+	Name  string
+	Value string
+	Type  string
+	val   Value
+}
+
+// Captured returns the variables captured by a func, i.e. those of the funcs
+// enclosing its definition, or nothing if the variable isn't a func.
+func (v Variable) Captured() []Variable {
+
+	f, ok := v.val.(Function)
+	if !ok {
+		return nil
+	}
+
+	var captured []Variable
+	for _, s := range scopesOf(f.scope) {
+		if s.Name != "Globals" {
+			captured = append(captured, s.Variables...)
+		}
+	}
+
+	return captured
+}
+
+// Debugger runs a program, stopping before statements at breakpoints, or
+// after steps, so that its variables can be inspected, and expressions
+// evaluated. The program runs in its own goroutine. Events are received from
+// Events, and after each stop, the program is resumed by Continue, StepIn,
+// StepOver or StepOut. Only programs compiled by Compile can be debugged.
+type Debugger struct {
+	prog   *Program
+	scopes map[*Node]*Analysis // enclosing each statement
+	events chan Event
+	resume chan stepMode
+
+	mu          sync.Mutex
+	breakpoints map[int]bool
+	calls       *callStack
+	frames      []debugFrame // by depth of calls
+	mode        stepMode
+	from        debugFrame // where the program last stopped
+	last        debugFrame // the last statement run
+	pausing     bool
+	stopped     bool
+	evaluating  bool
+}
+
+// debugFrame is the statement being run at a depth of calls.
+type debugFrame struct {
+	node  *Node
+	vars  *Variables
+	depth int
+}
+
+// NewDebugger returns a debugger of a program.
+func NewDebugger(p *Program) (*Debugger, error) {
+
+	if p.root == nil || p.code != nil {
+		return nil, fmt.Errorf("%s is compiled to bytecode, which cannot be debugged", p.name)
+	}
+
+	d := &Debugger{
+		prog:        p,
+		scopes:      make(map[*Node]*Analysis),
+		events:      make(chan Event),
+		resume:      make(chan stepMode),
+		breakpoints: make(map[int]bool),
+	}
+
+	d.findScopes(p.root, p.analysis)
+
+	return d, nil
+}
+
+// findScopes notes the analysis of the func enclosing each statement.
+func (d *Debugger) findScopes(n *Node, scope *Analysis) {
+
+	if n.IsToken(token.FUNC) && n.analysis != nil {
+		scope = n.analysis
+	}
+
+	if n.statement {
+		d.scopes[n] = scope
+	}
+
+	for _, c := range n.children {
+		d.findScopes(c, scope)
+	}
+}
+
+// SetBreakpoints replaces the breakpoints, returning the lines where they were
+// set: those where a statement starts.
+func (d *Debugger) SetBreakpoints(lines []int) []int {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	starts := make(map[int]bool)
+	for n := range d.scopes {
+		starts[n.lexeme.LineNo()] = true
+	}
+
+	d.breakpoints = make(map[int]bool)
+
+	var set []int
+	for _, line := range lines {
+		if starts[line] {
+			d.breakpoints[line] = true
+			set = append(set, line)
+		}
+	}
+
+	return set
+}
+
+// Start runs the program in a scope, in its own goroutine. If stopOnEntry is
+// true, it stops before the first statement.
+func (d *Debugger) Start(vars *Variables, stopOnEntry bool) {
+
+	d.calls = vars.callStack()
+	if d.calls == nil {
+		d.calls = newCallStack()
+		vars.calls = d.calls
+	}
+	d.calls.debugger = d
+
+	if stopOnEntry {
+		d.mode = stepIn
+	}
+
+	go func() {
+		vals, err := d.prog.Run(vars)
+		d.events <- Event{Reason: "exited", Values: vals, Err: err}
+		close(d.events)
+	}()
+}
+
+// Events returns the events of the program. It's closed after the program
+// exits.
+func (d *Debugger) Events() <-chan Event {
+	return d.events
+}
+
+// statement is called before running each statement, and stops the program
+// if it should.
+func (d *Debugger) statement(n *Node, vars *Variables, depth int) {
+
+	d.mu.Lock()
+
+	if d.evaluating {
+		d.mu.Unlock()
+		return
+	}
+
+	here := debugFrame{node: n, vars: vars, depth: depth}
+
+	for len(d.frames) <= depth {
+		d.frames = append(d.frames, debugFrame{})
+	}
+	d.frames = d.frames[:depth+1]
+	d.frames[depth] = here
+
+	reason := d.stopReason(here)
+	d.last = here
+
+	if reason == "" {
+		d.mu.Unlock()
+		return
+	}
+
+	d.pausing = false
+	d.stopped = true
+	d.from = here
+	d.mu.Unlock()
+
+	d.events <- Event{Reason: reason, Line: n.lexeme.LineNo(), Column: n.lexeme.CharNo()}
+
+	mode := <-d.resume
+
+	d.mu.Lock()
+	d.mode = mode
+	d.stopped = false
+	d.mu.Unlock()
+}
+
+// stopReason returns why the program should stop before a statement, or "".
+// Stepping, and breakpoints, skip the other statements on the line they're
+// at, unless it's run again, e.g. by a loop.
+func (d *Debugger) stopReason(here debugFrame) string {
+
+	line := here.node.lexeme.LineNo()
+
+	sameLine := func(f debugFrame) bool {
+		return f.node != nil && f.depth == here.depth &&
+			f.node.lexeme.LineNo() == line && f.node != here.node
+	}
+
+	if d.pausing {
+		return "pause"
+	}
+
+	switch d.mode {
+	case stepIn:
+		if d.from.node == nil {
+			return "entry"
+		}
+		if !sameLine(d.from) {
+			return "step"
+		}
+
+	case stepOver:
+		if here.depth < d.from.depth || here.depth == d.from.depth && !sameLine(d.from) {
+			return "step"
+		}
+
+	case stepOut:
+		if here.depth < d.from.depth {
+			return "step"
+		}
+	}
+
+	if d.breakpoints[line] && !sameLine(d.last) {
+		return "breakpoint"
+	}
+
+	return ""
+}
+
+// Continue resumes a stopped program, until a breakpoint.
+func (d *Debugger) Continue() {
+	d.resume <- running
+}
+
+// StepIn resumes a stopped program, until the next statement, which may be in
+// a func it calls.
+func (d *Debugger) StepIn() {
+	d.resume <- stepIn
+}
+
+// StepOver resumes a stopped program, until the next statement in the same
+// call, or one which it returns to.
+func (d *Debugger) StepOver() {
+	d.resume <- stepOver
+}
+
+// StepOut resumes a stopped program, until it returns from the current call.
+func (d *Debugger) StepOut() {
+	d.resume <- stepOut
+}
+
+// Pause stops a running program before its next statement.
+func (d *Debugger) Pause() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pausing = true
+}
+
+// Stack returns the active calls of a stopped program, most recent first,
+// ending with the top level.
+func (d *Debugger) Stack() []StackFrame {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.stopped {
+		return nil
+	}
+
+	var stack []StackFrame
+	for depth := len(d.frames) - 1; depth >= 0; depth-- {
+		f := d.frames[depth]
+		if f.node == nil {
+			continue
+		}
+
+		name := "<top level>"
+		if depth > 0 && depth <= len(d.calls.calls) {
+			name = d.calls.calls[depth-1].name
+		}
+
+		stack = append(stack, StackFrame{
+			Name:   name,
+			Line:   f.node.lexeme.LineNo(),
+			Column: f.node.lexeme.CharNo(),
+		})
+	}
+
+	return stack
+}
+
+// frame returns the frame of a stopped program, counting from the most recent
+// call, as Stack does.
+func (d *Debugger) frame(i int) (debugFrame, error) {
+
+	if !d.stopped {
+		return debugFrame{}, fmt.Errorf("the program is not stopped")
+	}
+
+	var active []debugFrame
+	for depth := len(d.frames) - 1; depth >= 0; depth-- {
+		if d.frames[depth].node != nil {
+			active = append(active, d.frames[depth])
+		}
+	}
+
+	if i < 0 || i >= len(active) {
+		return debugFrame{}, fmt.Errorf("no frame %d", i)
+	}
+
+	return active[i], nil
+}
+
+// Scopes returns the variables visible from a frame of a stopped program: the
+// locals of the call, those of the funcs enclosing it, and the globals.
+func (d *Debugger) Scopes(frame int) ([]Scope, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := d.frame(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	return scopesOf(f.vars), nil
+}
+
+// scopesOf returns the variables of a scope, and those enclosing it.
+func scopesOf(vars *Variables) []Scope {
+
+	var scopes []Scope
+
+	for v := vars; v != nil; v = v.parent {
+		s := Scope{Name: "Closure"}
+		if len(scopes) == 0 {
+			s.Name = "Locals"
+		}
+
+		switch {
+		case v.values == nil:
+			for slot, name := range v.names {
+				s.Variables = append(s.Variables, variableOf(name, v.slots[slot].value))
+			}
+
+		case v.parent == nil:
+			s.Name = "Globals"
+			fallthrough
+
+		default:
+			var names []string
+			for name, b := range v.values {
+				if _, builtin := b.value.(Builtin); !(builtin && IsBuiltin(name)) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				s.Variables = append(s.Variables, variableOf(name, v.values[name].value))
+			}
+		}
+
+		scopes = append(scopes, s)
+	}
+
+	return scopes
+}
+
+func variableOf(name string, val Value) Variable {
+
+	typ := "nil"
+	if val != nil {
+		typ = TypeName(val)
+	}
+
+	return Variable{Name: name, Value: ToString(val), Type: typ, val: val}
+}
+
+// Evaluate evaluates an expression in a frame of a stopped program, where it
+// can use the variables visible from the frame. The debugger doesn't stop in
+// any funcs the expression calls.
+func (d *Debugger) Evaluate(frame int, expr string) ([]Value, error) {
+
+	d.mu.Lock()
+	f, err := d.frame(frame)
+	if err != nil {
+		d.mu.Unlock()
+		return nil, err
+	}
+	d.evaluating = true
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		d.evaluating = false
+		d.mu.Unlock()
+	}()
+
+	ast, err := parse.New(lexer.New("<eval>", strings.Split(expr, "\n"))).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	n := ConvertParseToCompile(ast)
+
+	scope := d.scopes[f.node]
+
+	collector := NewAnalysis()
+	collector.parent = scope
+	err = n.ScopeAnalysis(collector)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Resolve(scope)
+
+	n.Fold()
+
+	eval, err := n.Evaluator()
+	if err != nil {
+		return nil, err
+	}
+
+	return eval(f.vars)
+}
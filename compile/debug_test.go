@@ -0,0 +1,214 @@
+package compile_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdk/gosh/compile"
+)
+
+const debugInput = `total := 0
+add := func(a, b) {
+	sum := a + b
+	return sum
+}
+x := add(1, 2)
+y := add(x, 3)
+total := x + y`
+
+func debugger(t *testing.T, input string, breakpoints []int, stopOnEntry bool) *compile.Debugger {
+
+	t.Helper()
+
+	prog, err := compile.Compile("testing", strings.Split(input, "\n"))
+	if err != nil {
+		t.Fatalf("did not expect error compiling %q, got: %s", input, err)
+	}
+
+	d, err := compile.NewDebugger(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.SetBreakpoints(breakpoints)
+	d.Start(compile.GlobalScope(), stopOnEntry)
+
+	return d
+}
+
+// expectStop waits for the program to stop, expecting the reason and line.
+func expectStop(t *testing.T, d *compile.Debugger, reason string, line int) {
+
+	t.Helper()
+
+	select {
+	case e := <-d.Events():
+		if e.Reason != reason || e.Line != line {
+			t.Fatalf("expected to stop for %s at line %d, got %s at line %d (%v)", reason, line, e.Reason, e.Line, e.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected to stop for %s at line %d, but the program didn't stop", reason, line)
+	}
+}
+
+func expectValue(t *testing.T, d *compile.Debugger, frame int, expr, expected string) {
+
+	t.Helper()
+
+	vals, err := d.Evaluate(frame, expr)
+	if err != nil {
+		t.Fatalf("did not expect error evaluating %s, got: %s", expr, err)
+	}
+
+	var got []string
+	for _, v := range vals {
+		got = append(got, compile.ToString(v))
+	}
+
+	if strings.Join(got, ", ") != expected {
+		t.Errorf("expected %s to be %s, got %s", expr, expected, strings.Join(got, ", "))
+	}
+}
+
+func TestDebugBreakpoints(t *testing.T) {
+
+	d := debugger(t, debugInput, []int{3, 5}, false)
+
+	expectStop(t, d, "breakpoint", 3)
+
+	var stack []string
+	for _, f := range d.Stack() {
+		stack = append(stack, fmt.Sprintf("%s %d:%d", f.Name, f.Line, f.Column))
+	}
+	if got := strings.Join(stack, ", "); got != "add 3:6, <top level> 6:3" {
+		t.Errorf("expected the stack of add, got %s", got)
+	}
+
+	expectValue(t, d, 0, "a + b", "3")
+	expectValue(t, d, 1, "total", "0")
+
+	d.Continue()
+	expectStop(t, d, "breakpoint", 3)
+	expectValue(t, d, 0, "a", "3")
+
+	d.Continue()
+	select {
+	case e := <-d.Events():
+		if !e.Exited() || e.Err != nil || len(e.Values) != 1 || compile.ToString(e.Values[0]) != "9" {
+			t.Errorf("expected the program to exit with 9, got %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the program to exit")
+	}
+}
+
+func TestDebugStepping(t *testing.T) {
+
+	d := debugger(t, debugInput, nil, true)
+
+	expectStop(t, d, "entry", 1)
+
+	d.StepOver()
+	expectStop(t, d, "step", 2)
+
+	d.StepOver()
+	expectStop(t, d, "step", 6)
+
+	d.StepIn()
+	expectStop(t, d, "step", 3)
+
+	d.StepOver()
+	expectStop(t, d, "step", 4)
+
+	d.StepOut()
+	expectStop(t, d, "step", 7)
+
+	d.StepOver()
+	expectStop(t, d, "step", 8)
+
+	d.Continue()
+	if e := <-d.Events(); !e.Exited() {
+		t.Errorf("expected the program to exit, got %+v", e)
+	}
+}
+
+func TestDebugLoops(t *testing.T) {
+
+	input := "i := 0\nwhile i < 3 {\n\ti := i + 1\n}\ni"
+
+	// a breakpoint in a loop stops each time round
+	d := debugger(t, input, []int{3}, false)
+	for i := 0; i < 3; i++ {
+		expectStop(t, d, "breakpoint", 3)
+		expectValue(t, d, 0, "i", fmt.Sprint(i))
+		d.Continue()
+	}
+	if e := <-d.Events(); !e.Exited() {
+		t.Errorf("expected the program to exit, got %+v", e)
+	}
+
+	// and so does stepping over the body
+	d = debugger(t, input, []int{3}, false)
+	expectStop(t, d, "breakpoint", 3)
+	d.StepOver()
+	expectStop(t, d, "step", 3)
+	expectValue(t, d, 0, "i", "1")
+	d.SetBreakpoints(nil)
+	d.Continue()
+	<-d.Events()
+}
+
+func TestDebugScopes(t *testing.T) {
+
+	d := debugger(t, `adder := func(n) {
+	func(x) {
+		x + n
+	}
+}
+add2 := adder(2)
+add2(1)`, []int{3}, false)
+
+	expectStop(t, d, "breakpoint", 3)
+
+	scopes, err := d.Scopes(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, s := range scopes {
+		var vars []string
+		for _, v := range s.Variables {
+			vars = append(vars, v.Name+"="+v.Value)
+		}
+		got = append(got, s.Name+": "+strings.Join(vars, " "))
+	}
+
+	expected := "Locals: x=1\nClosure: n=2\nGlobals: add2=func(x)[]{...} adder=func(n)[]{...}"
+	if strings.Join(got, "\n") != expected {
+		t.Errorf("expected scopes\n%s\ngot\n%s", expected, strings.Join(got, "\n"))
+	}
+
+	// the variables a func captures
+	add2 := scopes[2].Variables[0]
+	if captured := add2.Captured(); len(captured) != 1 || captured[0].Name != "n" || captured[0].Value != "2" {
+		t.Errorf("expected add2 to capture n=2, got %v", captured)
+	}
+
+	d.Continue()
+	<-d.Events()
+}
+
+func TestDebugBytecode(t *testing.T) {
+
+	prog, err := compile.CompileBytecode("testing", []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := compile.NewDebugger(prog); err == nil {
+		t.Errorf("expected an error debugging bytecode")
+	}
+}
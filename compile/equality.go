@@ -0,0 +1,243 @@
+package compile
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Equality compares values for ==. Structs compare by type then field values,
+// unless the struct defines an equals(a,b) method. Lists compare element-wise,
+// and maps by keys, in order, then values.
+type Equality struct {
+	n *Node // for reporting errors, and invoking equals methods
+
+	// pairs of collections currently being compared. Reaching a pair again
+	// means there's a cycle, and the pair is equal unless shown otherwise.
+	comparing map[[2]Value]bool
+}
+
+// NewEquality returns an Equality which reports errors at the given node,
+// which may be nil.
+func NewEquality(n *Node) *Equality {
+	return &Equality{
+		n:         n,
+		comparing: make(map[[2]Value]bool),
+	}
+}
+
+// EqualValues returns true/false if the two values are equal. If they are of
+// different types, return an error.
+func EqualValues(left, right Value) (bool, error) {
+	return NewEquality(nil).Equal(left, right)
+}
+
+// NotEqualValues returns true/false if the two values are not equal. If they are of
+// different types, return an error.
+func NotEqualValues(left, right Value) (bool, error) {
+
+	r, err := EqualValues(left, right)
+
+	return !r, err
+}
+
+// Equal compares two values. It is an error to compare values of different
+// types, other than nil and numbers. Within lists, maps and structs, values of
+// different types are simply not equal.
+func (eq *Equality) Equal(left, right Value) (bool, error) {
+
+	if left != nil && right != nil && reflect.TypeOf(left) != reflect.TypeOf(right) &&
+		!(isNumber(left) && isNumber(right)) {
+
+		return false, fmt.Errorf("cannot compare values of different types, %T and %T", left, right)
+	}
+
+	return eq.equal(left, right)
+}
+
+func (eq *Equality) equal(left, right Value) (bool, error) {
+
+	if left == nil || right == nil {
+		return left == nil && right == nil, nil
+	}
+
+	if isNumber(left) && isNumber(right) {
+		// numbers are promoted as for ordering. NaN is not equal to anything.
+		r, err := compareNumbers(left, right)
+		return r == 0 && err == nil, nil
+	}
+
+	if reflect.TypeOf(left) != reflect.TypeOf(right) {
+		return false, nil
+	}
+
+	switch lv := left.(type) {
+	case Function:
+		return false, fmt.Errorf("cannot compare functions")
+	case Builtin:
+		return lv.name == right.(Builtin).name, nil
+	case time.Time:
+		return lv.Equal(right.(time.Time)), nil
+	case *List:
+		return eq.recursive(lv, right, func() (bool, error) {
+			return eq.lists(lv, right.(*List))
+		})
+	case *Map:
+		return eq.recursive(lv, right, func() (bool, error) {
+			return eq.maps(lv, right.(*Map))
+		})
+	case *Struct:
+		if f, ok := lv.Method("equals"); ok {
+			return eq.invokeEquals(f, lv, right)
+		}
+		return eq.recursive(lv, right, func() (bool, error) {
+			return eq.structs(lv, right.(*Struct))
+		})
+	}
+
+	return left == right, nil
+}
+
+// recursive guards comparison of collections which may contain themselves.
+func (eq *Equality) recursive(left, right Value, compare func() (bool, error)) (bool, error) {
+
+	if left == right {
+		return true, nil
+	}
+
+	pair := [2]Value{left, right}
+	if eq.comparing[pair] {
+		return true, nil
+	}
+
+	eq.comparing[pair] = true
+	defer delete(eq.comparing, pair)
+
+	return compare()
+}
+
+func (eq *Equality) lists(left, right *List) (bool, error) {
+
+	if len(left.items) != len(right.items) {
+		return false, nil
+	}
+
+	for i := range left.items {
+		same, err := eq.equal(left.items[i], right.items[i])
+		if !same || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (eq *Equality) maps(left, right *Map) (bool, error) {
+
+	if len(left.keys) != len(right.keys) {
+		return false, nil
+	}
+
+	for i, k := range left.keys {
+		same, err := eq.equal(k, right.keys[i])
+		if !same || err != nil {
+			return false, err
+		}
+
+		same, err = eq.equal(left.values[i], right.values[i])
+		if !same || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// hash returns the hash of a map key. Keys which are equal must hash the same,
+// so a struct hashes to its type, plus the result of its hash method if it has
+// one; keys of a type without hash methods are then told apart by Equality.
+func (eq *Equality) hash(key Value) (string, error) {
+
+	switch k := key.(type) {
+	case string:
+		return stringHash(k), nil
+	case *Struct:
+		f, ok := k.Method("hash")
+		if !ok {
+			return "struct " + k.typeName, nil
+		}
+		result, err := callFunction(eq.n, f, Values(k))
+		if err != nil {
+			return "", err
+		}
+		if len(result) == 1 {
+			if s, ok := result[0].(string); ok {
+				return "struct " + k.typeName + " " + s, nil
+			}
+		}
+		return "", fmt.Errorf("hash method of %s must return a string", k.typeName)
+	}
+
+	return "", fmt.Errorf("map keys must be strings or structs, got %T", key)
+}
+
+// stringHash returns the hash of a string map key.
+func stringHash(key string) string {
+	return "string " + key
+}
+
+func (eq *Equality) structs(left, right *Struct) (bool, error) {
+
+	if left.typeName != right.typeName {
+		return false, nil
+	}
+
+	leftFields, rightFields := left.Fields(), right.Fields()
+	if len(leftFields) != len(rightFields) {
+		return false, nil
+	}
+
+	for i, name := range leftFields {
+		if rightFields[i] != name {
+			return false, nil
+		}
+
+		same, err := eq.equal(left.values[name], right.values[name])
+		if !same || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// invokeEquals calls a struct's equals(a,b) method.
+func (eq *Equality) invokeEquals(f Function, left, right Value) (bool, error) {
+
+	result, err := callFunction(eq.n, f, Values(left, right))
+	if err != nil {
+		return false, err
+	}
+
+	if len(result) != 1 {
+		return false, fmt.Errorf("equals method must return a single value, got %d", len(result))
+	}
+
+	return IsTruthy(result[0]), nil
+}
+
+// IndexOf returns the offset of the first item in the list equal to v, or -1.
+func (eq *Equality) IndexOf(l *List, v Value) (int, error) {
+
+	for i, x := range l.items {
+		same, err := eq.equal(x, v)
+		if err != nil {
+			return -1, err
+		}
+		if same {
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
@@ -0,0 +1,120 @@
+package compile_test
+
+import (
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+)
+
+func TestListEquality(t *testing.T) {
+
+	checkEval(t, `[1, 2, 3] == [1, 2, 3]`, "true")
+	checkEval(t, `[1, 2, 3] == [1, 2]`, "false")
+	checkEval(t, `[1, "a", [true]] == [1, "a", [true]]`, "true")
+	checkEval(t, `[1, "a"] == [1, 2]`, "false")
+	checkEval(t, `[1, 2] != [2, 1]`, "true")
+	checkEval(t, `[] == []`, "true")
+	checkEval(t, `l := [1]; l == nil, nil == l`, "false, false")
+
+	checkEvalErr(t, `[1] == 1`, "cannot compare values of different types")
+}
+
+func TestStructEquality(t *testing.T) {
+
+	checkEval(t, `
+		struct point {
+			x := 0
+			y := 0
+			norm := func(p) { return p.x + p.y }
+		}
+		point(1, 2) == point(1, 2), point(1, 2) == point(2, 1)`,
+		"true, false")
+
+	checkEval(t, `
+		struct a { x := 1 }
+		struct b { x := 1 }
+		a() == b(), a() == a()`,
+		"false, true")
+
+	checkEval(t, `
+		struct named {
+			name := ""
+			id := 0
+			equals := func(a, b) { return a.id == b.id }
+		}
+		named("x", 1) == named("y", 1), named("x", 1) != named("x", 2)`,
+		"true, true")
+
+	checkEval(t, `struct { a := 1 } == struct { a := 1 }`, "true")
+}
+
+func TestCyclicEquality(t *testing.T) {
+
+	checkEval(t, `
+		struct binaryTree {
+			value := ""
+			left := nil
+			right := nil
+		}
+		a := binaryTree("a")
+		a.left := a
+		b := binaryTree("a")
+		b.left := b
+		c := binaryTree("c")
+		c.left := c
+		a == b, a == c`,
+		"true, false")
+
+	checkEval(t, `
+		l := [1]
+		l.append(l)
+		m := [1]
+		m.append(m)
+		l == m`,
+		"true")
+}
+
+func TestListSearch(t *testing.T) {
+
+	checkEval(t, `
+		struct p { x := 0 }
+		l := [1, "two", [3], p(4)]
+		l.contains([3]), l.contains(p(4)), l.contains(p(5)), l.index("two"), l.index(5)`,
+		"true, true, false, 1, -1")
+}
+
+func TestMapEquality(t *testing.T) {
+
+	a := compile.NewMap()
+	a.Set("x", int64(1))
+	a.Set("y", compile.NewList("z"))
+
+	b := compile.NewMap()
+	b.Set("x", int64(1))
+	b.Set("y", compile.NewList("z"))
+
+	c := compile.NewMap()
+	c.Set("y", compile.NewList("z"))
+	c.Set("x", int64(1))
+
+	checkEqual(t, a, b, true)
+	checkEqual(t, a, c, false) // keys in a different order
+
+	b.Set("x", int64(2))
+	checkEqual(t, a, b, false)
+}
+
+func checkEqual(t *testing.T, left, right compile.Value, expected bool) {
+
+	same, err := compile.EqualValues(left, right)
+	if err != nil {
+		t.Errorf("did not expect error comparing %s and %s, got: %s",
+			compile.ToString(left), compile.ToString(right), err)
+		return
+	}
+
+	if same != expected {
+		t.Errorf("comparing %s and %s: expected %t but got %t",
+			compile.ToString(left), compile.ToString(right), expected, same)
+	}
+}
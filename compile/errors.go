@@ -0,0 +1,294 @@
+package compile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/token"
+)
+
+// ErrorValue is an error, as a value. Functions return errors alongside their
+// results. An error may wrap another error, its cause.
+type ErrorValue struct {
+	message string
+	cause   *ErrorValue
+	lexeme  *lexer.Lexeme // where the error was created
+	calls   []call        // the calls active when it was created, or raised
+}
+
+// NewError creates an error value, created at the given node. For a function
+// application, that's where the function is named.
+func NewError(n *Node, message string, cause *ErrorValue) *ErrorValue {
+	return newError(n, message, cause, nil)
+}
+
+// newError creates an error value, created at the given node, during the
+// given calls.
+func newError(n *Node, message string, cause *ErrorValue, calls []call) *ErrorValue {
+
+	at := n
+	if n.IsToken(token.FUNCAPPLY) && len(n.children) > 0 {
+		at = n.children[0]
+	}
+
+	return &ErrorValue{
+		message: message,
+		cause:   cause,
+		lexeme:  at.lexeme,
+		calls:   calls,
+	}
+}
+
+// ErrorFromFailure converts a failure (an evaluator error) into an error value.
+// The location is taken from the failure if known, otherwise the given node.
+// The calls active when the failure was raised are kept, for stacktrace.
+func ErrorFromFailure(n *Node, err error) *ErrorValue {
+
+	var lexErr *lexer.Error
+	if errors.As(err, &lexErr) {
+		lex := lexErr.Lexeme
+		return &ErrorValue{
+			message: lexErr.Message,
+			lexeme:  &lex,
+			calls:   callsOf(err),
+		}
+	}
+
+	return newError(n, err.Error(), nil, callsOf(err))
+}
+
+// Error returns the message of the error.
+func (e *ErrorValue) Error() string {
+	return e.message
+}
+
+// Unwrap returns the cause of the error, or nil.
+func (e *ErrorValue) Unwrap() error {
+	if e.cause == nil {
+		return nil
+	}
+	return e.cause
+}
+
+// Location returns where the error was created, e.g. "foo.gosh:12:4".
+func (e *ErrorValue) Location() string {
+	if e.lexeme == nil {
+		return ""
+	}
+	return e.lexeme.Location()
+}
+
+// InChain checks if target is this error, or any error in its chain of causes.
+func (e *ErrorValue) InChain(target *ErrorValue) bool {
+
+	for x := e; x != nil; x = x.cause {
+		if x == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+func init() {
+	registerStackBuiltin("error", errorBuiltin)
+	registerStackBuiltin("errorf", errorfBuiltin)
+	registerStackBuiltin("wrap", wrapBuiltin)
+	RegisterBuiltin("unwrap", unwrapBuiltin)
+	RegisterBuiltin("is", isBuiltin)
+	RegisterBuiltin("iserror", isErrorBuiltin)
+	RegisterBuiltin("location", locationBuiltin)
+	RegisterBuiltin("stacktrace", stacktraceBuiltin)
+}
+
+// errorArg returns the i'th argument, which must be an error or nil.
+func errorArg(n *Node, name string, args []Value, i int) (*ErrorValue, error) {
+
+	if args[i] == nil {
+		return nil, nil
+	}
+
+	e, ok := args[i].(*ErrorValue)
+	if !ok {
+		return nil, n.Error("%s expects an error for argument %d, got %T", name, i+1, args[i])
+	}
+
+	return e, nil
+}
+
+// error(message) or error(message, cause)
+func errorBuiltin(n *Node, calls []call, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "error", args, 1, 2); err != nil {
+		return Values(), err
+	}
+
+	message, err := StringArg(n, "error", args, 0)
+	if err != nil {
+		return Values(), err
+	}
+
+	var cause *ErrorValue
+	if len(args) == 2 {
+		cause, err = errorArg(n, "error", args, 1)
+		if err != nil {
+			return Values(), err
+		}
+	}
+
+	return Values(newError(n, message, cause, calls)), nil
+}
+
+// errorf(format, args...) formats a message, as go's fmt.Errorf. If the format
+// has a %w verb, the first error in the args is the cause.
+func errorfBuiltin(n *Node, calls []call, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "errorf", args, 1, -1); err != nil {
+		return Values(), err
+	}
+
+	format, err := StringArg(n, "errorf", args, 0)
+	if err != nil {
+		return Values(), err
+	}
+
+	var cause *ErrorValue
+	if strings.Contains(format, "%w") {
+		for _, a := range args[1:] {
+			if e, ok := a.(*ErrorValue); ok {
+				cause = e
+				break
+			}
+		}
+		format = strings.Replace(format, "%w", "%v", -1)
+	}
+
+	message := fmt.Sprintf(format, args[1:]...)
+
+	return Values(newError(n, message, cause, calls)), nil
+}
+
+// wrap(err, message) creates a new error with err as the cause.
+func wrapBuiltin(n *Node, calls []call, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "wrap", args, 2, 2); err != nil {
+		return Values(), err
+	}
+
+	cause, err := errorArg(n, "wrap", args, 0)
+	if err != nil {
+		return Values(), err
+	}
+
+	message, err := StringArg(n, "wrap", args, 1)
+	if err != nil {
+		return Values(), err
+	}
+
+	if cause == nil {
+		// nothing to wrap
+		return Values(nil), nil
+	}
+
+	return Values(newError(n, message+": "+cause.message, cause, calls)), nil
+}
+
+// unwrap(err) returns the cause of err, or nil.
+func unwrapBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "unwrap", args, 1, 1); err != nil {
+		return Values(), err
+	}
+
+	e, err := errorArg(n, "unwrap", args, 0)
+	if err != nil {
+		return Values(), err
+	}
+
+	if e == nil || e.cause == nil {
+		return Values(nil), nil
+	}
+
+	return Values(e.cause), nil
+}
+
+// is(err, target) checks if target is err, or any error in its chain of causes.
+func isBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "is", args, 2, 2); err != nil {
+		return Values(), err
+	}
+
+	e, err := errorArg(n, "is", args, 0)
+	if err != nil {
+		return Values(), err
+	}
+
+	target, err := errorArg(n, "is", args, 1)
+	if err != nil {
+		return Values(), err
+	}
+
+	if e == nil || target == nil {
+		return Values(e == target), nil
+	}
+
+	return Values(e.InChain(target)), nil
+}
+
+// iserror(v) checks if a value is an error.
+func isErrorBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "iserror", args, 1, 1); err != nil {
+		return Values(), err
+	}
+
+	_, ok := args[0].(*ErrorValue)
+
+	return Values(ok), nil
+}
+
+// location(err) returns where an error was created.
+func locationBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "location", args, 1, 1); err != nil {
+		return Values(), err
+	}
+
+	e, err := errorArg(n, "location", args, 0)
+	if err != nil {
+		return Values(), err
+	}
+
+	if e == nil {
+		return Values(""), nil
+	}
+
+	return Values(e.Location()), nil
+}
+
+// stacktrace(err) returns the calls which were active when an error was
+// created, or a failure caught by try was raised, most recent first, e.g. "f,
+// called at foo.gosh:12:4".
+func stacktraceBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "stacktrace", args, 1, 1); err != nil {
+		return Values(), err
+	}
+
+	e, err := errorArg(n, "stacktrace", args, 0)
+	if err != nil {
+		return Values(), err
+	}
+
+	var lines []Value
+	if e != nil {
+		for i := len(e.calls) - 1; i >= 0; i-- {
+			lines = append(lines, e.calls[i].String())
+		}
+	}
+
+	return Values(NewList(lines...)), nil
+}
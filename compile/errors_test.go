@@ -0,0 +1,52 @@
+package compile_test
+
+import "testing"
+
+func TestErrorValues(t *testing.T) {
+
+	checkEval(t, `error("boom")`, "boom")
+	checkEval(t, `e := error("boom"); e && "failed" || "ok"`, "failed")
+	checkEval(t, `e := nil; e && "failed" || "ok"`, "ok")
+	checkEval(t, `errorf("bad %s: %d", "thing", 42)`, "bad thing: 42")
+	checkEval(t, `iserror(error("x")), iserror("x"), iserror(nil)`, "true, false, false")
+	checkEval(t, `location(error("x"))`, "testing:1:10")
+
+	checkEvalErr(t, `error(1)`, "error expects a string for argument 1")
+	checkEvalErr(t, `error()`, "error expects 1 to 2 arguments, got 0")
+}
+
+func TestErrorWrapping(t *testing.T) {
+
+	checkEval(t, `
+		notFound := error("not found")
+		lookup := func(k) {
+			return nil, wrap(notFound, "lookup " + k)
+		}
+		v, err := lookup("b")
+		err, is(err, notFound), unwrap(err) == notFound, is(notFound, err)`,
+		"lookup b: not found, true, true, false")
+
+	checkEval(t, `
+		base := error("base")
+		e := errorf("outer: %w", base)
+		e, is(e, base), unwrap(e) == base`,
+		"outer: base, true, true")
+
+	checkEval(t, `wrap(nil, "nothing")`, "nil")
+	checkEval(t, `is(nil, nil), is(error("a"), nil), is(error("a"), error("a"))`, "true, false, false")
+	checkEval(t, `unwrap(error("a"))`, "nil")
+}
+
+func TestTry(t *testing.T) {
+
+	checkEval(t, `try(2 + 3)`, "5")
+	checkEval(t, `try(1 / 0)`, "integer division by zero")
+	checkEval(t, `r := try(1 / 0); iserror(r), location(r)`, "true, testing:1:12")
+	checkEval(t, `
+		f := func(x) { return x + "one" }
+		r := try(f(1))
+		r`,
+		"cannot apply + to int64 and string")
+
+	checkEvalErr(t, `1 % 0`, "integer division by zero")
+}
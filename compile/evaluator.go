@@ -2,9 +2,9 @@ package compile
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/pdk/gosh/token"
-	"github.com/pdk/gosh/u"
 )
 
 // Evaluator is a function that can be evaluated. It may return some Values
@@ -23,6 +23,8 @@ func init() {
 		token.INT:        IntegerLiteral,
 		token.FLOAT:      FloatLiteral,
 		token.STRING:     StringLiteral,
+		token.CHAR:       RuneLiteral,
+		token.INTERP:     InterpolationOperator,
 		token.PLUS:       AdditionOperator,
 		token.MINUS:      SubtractionOperator,
 		token.MODULO:     ModuloOperation,
@@ -44,13 +46,25 @@ func init() {
 		token.GRTR:       GreaterThanOperator,
 		token.GRTR_EQUAL: GreaterThanEqualOperator,
 		token.NOT:        NotOperator,
+		token.TRY:        TryOperator,
 		token.LOG_AND:    LogicalAndOperator,
 		token.LOG_OR:     LogicialOrOperator,
+		token.LOG_XOR:    LogicalXorOperator,
+		token.BIT_AND:    BitAndOperator,
+		token.BIT_OR:     BitOrOperator,
+		token.BIT_XOR:    BitXorOperator,
+		token.BIT_CLEAR:  BitClearOperator,
+		token.SHL:        ShiftLeftOperator,
+		token.SHR:        ShiftRightOperator,
 		token.IF:         ConditionalOperator,
 		token.WHILE:      LoopOperator,
 		token.RETURN:     ReturnOperator,
 		token.BREAK:      BreakOperator,
 		token.CONTINUE:   ContinueOperator,
+		token.LSQR:       ListOperator,
+		token.PERIOD:     FieldAccess,
+		token.METHAPPLY:  MethodApplication,
+		token.STRUCT:     StructDefinition,
 	}
 }
 
@@ -63,12 +77,51 @@ func (n *Node) Evaluator() (Evaluator, error) {
 		return nil, n.lexeme.Error("unknown operator %s", n.Literal())
 	}
 
-	return producer(n)
+	eval, err := producer(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.statement {
+		eval = instrumented(n, eval)
+	}
+
+	if n.traced {
+		eval = traced(n, eval)
+	}
+
+	return eval, nil
 }
 
 // FuncApplication applies a function to arguments.
 func FuncApplication(n *Node) (Evaluator, error) {
 
+	if isTypedNil(n) {
+		return TypedNilLiteral(n)
+	}
+
+	operands, err := callOperands(n)
+	if err != nil {
+		return nil, err
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		fn, values, err := operands(vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		return applyFunction(n, vars.callStack(), fn, values)
+	}
+
+	return e, nil
+}
+
+// callOperands returns a function which evaluates the function and arguments
+// of a function application.
+func callOperands(n *Node) (func(*Variables) (Value, []Value, error), error) {
+
 	funcResolver, err := LeftEval(n)
 	if err != nil {
 		return nil, err
@@ -85,85 +138,138 @@ func FuncApplication(n *Node) (Evaluator, error) {
 		paramEvals = append(paramEvals, eval)
 	}
 
-	e := func(vars *Variables) ([]Value, error) {
+	e := func(vars *Variables) (Value, []Value, error) {
 
 		fr, err := funcResolver(vars)
 		if err != nil {
-			return Values(), n.Error("unable to resolve function: %s", err)
+			return nil, nil, n.Error("unable to resolve function: %s", errorMessage(err))
 		}
 
 		if len(fr) != 1 {
-			return Values(), n.Error("cannot apply multiple values as a function")
-		}
-
-		f, ok := fr[0].(Function)
-		if !ok {
-			return Values(), n.Error("cannot apply a non-function")
+			return nil, nil, n.Error("cannot apply multiple values as a function")
 		}
 
 		var values []Value
 		for _, eachEval := range paramEvals {
 			val, err := eachEval(vars)
 			if err != nil {
-				return Values(), err
+				return nil, nil, err
 			}
 			values = append(values, val...)
 		}
 
+		return fr[0], values, nil
+	}
+
+	return e, nil
+}
+
+// applyFunction applies a builtin, function or struct type to arguments. The
+// calls are those active, which some builtins are given.
+func applyFunction(n *Node, calls *callStack, fn Value, values []Value) ([]Value, error) {
+
+	switch f := fn.(type) {
+	case Builtin:
+		if f.stack != nil {
+			return f.stack(n, calls.current(), values)
+		}
+		return f.fn(n, values)
+	case *StructType:
+		return instantiateStruct(n, f, values)
+	case Function:
+		return callFunction(n, f, values)
+	}
+
+	return Values(), n.Error("cannot apply a non-function")
+}
+
+// Apply applies a function to arguments from go, e.g. to run the tests of a
+// script. The call has no site.
+func Apply(fn Function, values ...Value) ([]Value, error) {
+	return callFunction(nil, fn, values)
+}
+
+// callFunction invokes a function with the given arguments. The call gets a
+// new frame, whose parent is the scope in which the function was defined. The
+// parameters are the first slots of the frame.
+//
+// If the function returns a tail call, that call is made in place of the
+// function, so that tail recursion does not grow the stack.
+func callFunction(n *Node, f Function, values []Value) ([]Value, error) {
+
+	calls := f.scope.callStack()
+
+	for {
 		if len(f.parameters) != len(values) {
 			return Values(), n.Error("number of arguments does not match number of parameters")
 		}
 
-		scope := NewScope(vars)
-
-		for n, v := range f.captured.values {
-			scope.SetRef(n, v)
+		err := calls.push(functionName(f.name), n)
+		if err != nil {
+			return Values(), err
 		}
 
-		for _, l := range f.locals {
-			scope.Set(l, nil)
+		frame := NewFrame(f.scope, f.frameSize)
+		frame.names = f.slotNames
+
+		for i, v := range values {
+			frame.SetSlot(i, v)
 		}
 
-		for i, p := range f.parameters {
-			scope.Set(p, values[i])
+		result, err := f.body(frame)
+		if err != nil {
+			err = calls.attach(err)
 		}
 
-		result, err := f.body(scope)
+		calls.pop()
+
+		if !IsControlValue(result) {
+			return result, err
+		}
 
-		if IsControlValue(result) {
+		tail := result[0].(ControlValue).tail
+		if tail == nil || err != nil {
 			return WrappedValues(result), err
 		}
 
-		return result, err
+		n, f, values = tail.site, tail.fn, tail.args
 	}
-
-	return e, nil
 }
 
 // FuncDefinition returns a function.
 func FuncDefinition(n *Node) (Evaluator, error) {
 
-	e := func(vars *Variables) ([]Value, error) {
+	if n.analysis == nil {
+		return nil, n.Error("func has not been analyzed")
+	}
 
-		bodyEval, err := n.analysis.body.Evaluator()
-		if err != nil {
-			return nil, err
-		}
+	// The body is compiled once, here, and shared by every Function value
+	// created when the definition is evaluated.
+	bodyEval, err := n.analysis.body.Evaluator()
+	if err != nil {
+		return nil, err
+	}
+
+	frameSize := n.analysis.SlotCount()
+
+	slotNames := make([]string, frameSize)
+	for name, slot := range n.analysis.slots {
+		slotNames[slot] = name
+	}
+
+	// Free variables are not captured here. They are found at their addresses
+	// when used, through the scope in which the function is defined, so a
+	// function can refer to itself, or to globals defined after it.
+	e := func(vars *Variables) ([]Value, error) {
 
 		f := Function{
+			name:       n.analysis.name,
 			parameters: n.analysis.parameters,
 			channels:   n.analysis.channels,
-			locals:     u.KeysOf(n.analysis.locals),
+			frameSize:  frameSize,
+			slotNames:  slotNames,
 			body:       bodyEval,
-			captured:   NewScope(vars),
-		}
-
-		for _, v := range n.analysis.FreeVariables() {
-			ref, err := vars.Reference(v)
-			if err != nil {
-				return Values(), n.Error("unable to capture free variable %s: %s", v, err)
-			}
-			f.captured.SetRef(v, ref)
+			scope:      vars,
 		}
 
 		return Values(f), nil
@@ -254,6 +360,52 @@ func StringLiteral(n *Node) (Evaluator, error) {
 	return e, nil
 }
 
+// RuneLiteral returns the value of a rune literal, e.g. 'a'.
+func RuneLiteral(n *Node) (Evaluator, error) {
+
+	r := []rune(n.Literal())[0]
+
+	e := func(vars *Variables) ([]Value, error) {
+		return Values(r), nil
+	}
+
+	return e, nil
+}
+
+// InterpolationOperator evaluates the parts of an interpolated string, and
+// concatenates the results.
+func InterpolationOperator(n *Node) (Evaluator, error) {
+
+	var parts []Evaluator
+
+	for _, child := range n.children {
+		eval, err := child.Evaluator()
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, eval)
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		var sb strings.Builder
+
+		for _, part := range parts {
+			val, err := StandardSingleEval(n, part, vars)
+			if err != nil {
+				return Values(), err
+			}
+
+			sb.WriteString(ToString(val))
+		}
+
+		return Values(sb.String()), nil
+	}
+
+	return e, nil
+}
+
 // StatementsEvaluator evaluates a series of expressions, returning the value of the last expression.
 func StatementsEvaluator(n *Node) (Evaluator, error) {
 
@@ -328,13 +480,21 @@ func MultiValueOperator(n *Node) (Evaluator, error) {
 func VariableLookup(n *Node) (Evaluator, error) {
 
 	varName := n.Literal()
+	addr := n.address
+
+	if addr != nil && !addr.Global() {
+		e := func(vars *Variables) ([]Value, error) {
+			return Values(vars.frame(addr.Depth).slots[addr.Slot].value), nil
+		}
+		return e, nil
+	}
 
 	e := func(vars *Variables) ([]Value, error) {
 
 		v, err := vars.Value(varName)
 
 		if err != nil {
-			return Values(), err
+			return Values(), n.LocateError(err)
 		}
 
 		return Values(v), nil
@@ -346,21 +506,25 @@ func VariableLookup(n *Node) (Evaluator, error) {
 // AssignValues evaluates the right-hand side and sets variables on the left-hand side.
 func AssignValues(n *Node) (Evaluator, error) {
 
-	var varNames []string
-
 	lhs := n.children[0]
-	switch lhs.Token() {
-	case token.IDENT:
-		varNames = append(varNames, lhs.Literal())
-	case token.COMMA:
-		for _, v := range lhs.children {
-			if !v.IsToken(token.IDENT) {
-				return nil, n.Error("left-hand side of assignment must be one or more identifiers")
-			}
-			varNames = append(varNames, v.Literal())
+
+	// x := nil(T) declares the type of x, with a nil value.
+	if lhs.IsToken(token.IDENT) && isTypedNil(n.children[1]) {
+		return TypedNilDeclaration(n)
+	}
+
+	targets := []*Node{lhs}
+	if lhs.IsToken(token.COMMA) {
+		targets = lhs.children
+	}
+
+	var assigners []assigner
+	for _, t := range targets {
+		a, err := assignTarget(t)
+		if err != nil {
+			return nil, n.Error("left-hand side of assignment must be one or more identifiers, fields or indexes")
 		}
-	default:
-		return nil, n.Error("left-hand side of assignment must be one or more identifiers")
+		assigners = append(assigners, a)
 	}
 
 	right, err := RightEval(n)
@@ -375,14 +539,14 @@ func AssignValues(n *Node) (Evaluator, error) {
 			return Values(), err
 		}
 
-		if len(varNames) != len(r) {
+		if len(assigners) != len(r) {
 			return Values(), n.Error("count of variables on left does not match number of results on right side")
 		}
 
-		for i, n := range varNames {
-			_, err := vars.Set(n, r[i])
+		for i, assign := range assigners {
+			err := assign(vars, r[i])
 			if err != nil {
-				return Values(), err
+				return Values(), n.LocateError(err)
 			}
 		}
 
@@ -392,6 +556,80 @@ func AssignValues(n *Node) (Evaluator, error) {
 	return e, nil
 }
 
+// isTypedNil checks if a node is a typed nil, nil(T).
+func isTypedNil(n *Node) bool {
+	return n.IsToken(token.FUNCAPPLY) && len(n.children) > 0 && n.children[0].IsToken(token.NIL)
+}
+
+// TypedNilLiteral returns nil, after checking that nil(T) names a type.
+func TypedNilLiteral(n *Node) (Evaluator, error) {
+
+	if len(n.children) != 2 || !n.children[1].IsToken(token.IDENT) {
+		return nil, n.Error("nil(...) expects a single type name")
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		_, err := typedNilType(n, vars)
+
+		return Values(nil), err
+	}
+
+	return e, nil
+}
+
+// TypedNilDeclaration handles x := nil(T), which sets x to nil and declares
+// its type.
+func TypedNilDeclaration(n *Node) (Evaluator, error) {
+
+	typedNil := n.children[1]
+	if len(typedNil.children) != 2 || !typedNil.children[1].IsToken(token.IDENT) {
+		return nil, typedNil.Error("nil(...) expects a single type name")
+	}
+
+	name := n.children[0].Literal()
+	addr := n.children[0].address
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		typ, err := typedNilType(typedNil, vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		err = vars.DeclareAt(name, addr, typ)
+
+		return Values(nil), n.IfError(err, "%s", err)
+	}
+
+	return e, nil
+}
+
+// typedNilType returns the name of the type in nil(T). T is either a builtin
+// type, or a variable holding a struct type.
+func typedNilType(n *Node, vars *Variables) (string, error) {
+	ident := n.children[1]
+	return namedType(ident, vars, ident.Literal(), ident.address)
+}
+
+// namedType returns the name of the type named by a variable holding a struct
+// type, or a builtin type.
+func namedType(n *Node, vars *Variables, name string, addr *Address) (string, error) {
+
+	if b, err := vars.ReferenceAt(name, addr); err == nil {
+		v := b.Value()
+		if st, ok := v.(*StructType); ok {
+			return st.name, nil
+		}
+	}
+
+	if IsBuiltinType(name) {
+		return name, nil
+	}
+
+	return "", n.Error("%s is not a type", name)
+}
+
 // SingleValue checks that we're dealing with a single value.
 func SingleValue(n *Node, vals []Value) (Value, error) {
 
@@ -452,47 +690,65 @@ func LeftRightEvaluators(n *Node) (Evaluator, Evaluator, error) {
 	return left, right, err
 }
 
-// valueEvaluator is to construct a minimal Evaluator when we've already
-// evaluated the result. Don't double-eval an evaluator!
-func valueEvaluator(v Value) Evaluator {
-	return func(vars *Variables) ([]Value, error) {
-		return []Value{v}, nil
+// binaryOperator produces an evaluator which evaluates both operands, each a
+// single value, and then applies the operation.
+func binaryOperator(n *Node, op binaryOperation) (Evaluator, error) {
+
+	left, right, err := LeftRightEvaluators(n)
+	if err != nil {
+		return nil, err
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+
+		leftVal, rightVal, err := StandardBinaryEval(n, left, right, vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		v, err := op(n, leftVal, rightVal)
+		if err != nil {
+			return Values(), err
+		}
+
+		return Values(v), nil
 	}
+
+	return e, nil
 }
 
-// AdditionOperator returns the value of an addition operation.
-func AdditionOperator(n *Node) (Evaluator, error) {
+// unaryOperator produces an evaluator which evaluates the operand, a single
+// value, and then applies the operation.
+func unaryOperator(n *Node, op unaryOperation) (Evaluator, error) {
 
-	left, right, err := LeftRightEvaluators(n)
+	operand, err := n.children[0].Evaluator()
 	if err != nil {
 		return nil, err
 	}
 
 	e := func(vars *Variables) ([]Value, error) {
 
-		leftVal, rightVal, err := StandardBinaryEval(n, left, right, vars)
+		val, err := StandardSingleEval(n, operand, vars)
 		if err != nil {
 			return Values(), err
 		}
 
-		r, ok := TryBinaryStringOp(leftVal, rightVal, func(s1, s2 string) string {
-			return s1 + s2
-		})
-		if ok {
-			return Values(r), nil
+		v, err := op(n, val)
+		if err != nil {
+			return Values(), err
 		}
 
-		return BinaryNumericOperation(n, valueEvaluator(leftVal), valueEvaluator(rightVal), vars,
-			func(a, b int64) int64 {
-				return a + b
-			}, func(a, b float64) float64 {
-				return a + b
-			})
+		return Values(v), nil
 	}
 
 	return e, nil
 }
 
+// AdditionOperator returns the value of an addition operation.
+func AdditionOperator(n *Node) (Evaluator, error) {
+	return binaryOperator(n, addValues)
+}
+
 // TryBinaryStringOp checks if the two values are strings, and then applies the
 // op if so. Returns false if either value is not a string.
 func TryBinaryStringOp(left, right Value, op func(string, string) string) (string, bool) {
@@ -544,49 +800,6 @@ func TryBinaryFloat64Op(left, right Value, op func(float64, float64) float64) (f
 	return op(lFloat, rFloat), true
 }
 
-// BinaryIntOperation evaluates left and right sides, confirms they are int64
-// results, and produces a new result by applying the given function.
-func BinaryIntOperation(n *Node, left, right Evaluator, vars *Variables, op func(int64, int64) int64) ([]Value, error) {
-
-	leftVal, rightVal, err := StandardBinaryEval(n, left, right, vars)
-	if err != nil {
-		return Values(), err
-	}
-
-	r, ok := TryBinaryInt64Op(leftVal, rightVal, op)
-	if ok {
-		return Values(r), nil
-	}
-
-	return Values(), n.Error("cannot apply %s to %T and %T",
-		n.Literal(), leftVal, rightVal)
-}
-
-// BinaryNumericOperation evaluates left and right sides, confirms they are int64
-// results, and produces a new result by applying the given function.
-func BinaryNumericOperation(n *Node, left, right Evaluator, vars *Variables,
-	intOp func(int64, int64) int64,
-	floatOp func(float64, float64) float64) ([]Value, error) {
-
-	leftVal, rightVal, err := StandardBinaryEval(n, left, right, vars)
-	if err != nil {
-		return Values(), err
-	}
-
-	r, ok := TryBinaryInt64Op(leftVal, rightVal, intOp)
-	if ok {
-		return Values(r), nil
-	}
-
-	r2, ok := TryBinaryFloat64Op(leftVal, rightVal, floatOp)
-	if ok {
-		return Values(r2), nil
-	}
-
-	return Values(), n.Error("cannot apply %s to %T and %T",
-		n.Literal(), leftVal, rightVal)
-}
-
 // SubtractionOperator returns the value of an addition operation.
 func SubtractionOperator(n *Node) (Evaluator, error) {
 
@@ -594,170 +807,111 @@ func SubtractionOperator(n *Node) (Evaluator, error) {
 		return NegativeOperation(n)
 	}
 
-	left, right, err := LeftRightEvaluators(n)
-	if err != nil {
-		return nil, err
-	}
-
-	e := func(vars *Variables) ([]Value, error) {
-
-		return BinaryNumericOperation(n, left, right, vars, func(a, b int64) int64 {
-			return a - b
-		}, func(a, b float64) float64 {
-			return a - b
-		})
-	}
-
-	return e, nil
+	return binaryOperator(n, subtractValues)
 }
 
 // MultiplicationOperator returns the value of an addition operation.
 func MultiplicationOperator(n *Node) (Evaluator, error) {
-
-	left, right, err := LeftRightEvaluators(n)
-	if err != nil {
-		return nil, err
-	}
-
-	e := func(vars *Variables) ([]Value, error) {
-
-		return BinaryNumericOperation(n, left, right, vars, func(a, b int64) int64 {
-			return a * b
-		}, func(a, b float64) float64 {
-			return a * b
-		})
-	}
-
-	return e, nil
+	return binaryOperator(n, multiplyValues)
 }
 
 // DivisionOperator returns the value of an addition operation.
 func DivisionOperator(n *Node) (Evaluator, error) {
-
-	left, right, err := LeftRightEvaluators(n)
-	if err != nil {
-		return nil, err
-	}
-
-	e := func(vars *Variables) ([]Value, error) {
-
-		return BinaryNumericOperation(n, left, right, vars, func(a, b int64) int64 {
-			return a / b
-		}, func(a, b float64) float64 {
-			return a / b
-		})
-
-	}
-
-	return e, nil
+	return binaryOperator(n, divideValues)
 }
 
 // ModuloOperation returns the value of an addition operation.
 func ModuloOperation(n *Node) (Evaluator, error) {
-
-	left, right, err := LeftRightEvaluators(n)
-	if err != nil {
-		return nil, err
-	}
-
-	e := func(vars *Variables) ([]Value, error) {
-
-		return BinaryIntOperation(n, left, right, vars, func(a, b int64) int64 {
-			return a % b
-		})
-	}
-
-	return e, nil
+	return binaryOperator(n, moduloValues)
 }
 
-// NegativeOperation handles unary -, return the negative of the value.
-func NegativeOperation(n *Node) (Evaluator, error) {
-
-	operand, err := n.children[0].Evaluator()
-	if err != nil {
-		return nil, err
-	}
-
-	e := func(vars *Variables) ([]Value, error) {
-
-		result, err := operand(vars)
-		if err != nil {
-			return Values(), err
-		}
+// BitAndOperator returns the bitwise and of two integers.
+func BitAndOperator(n *Node) (Evaluator, error) {
+	return binaryOperator(n, bitAndValues)
+}
 
-		val, err := SingleValue(n, result)
-		if err != nil {
-			return Values(), err
-		}
+// BitOrOperator returns the bitwise or of two integers.
+func BitOrOperator(n *Node) (Evaluator, error) {
+	return binaryOperator(n, bitOrValues)
+}
 
-		switch v := val.(type) {
-		case int64:
-			return Values(-v), nil
-		case float64:
-			return Values(-v), nil
-		}
+// BitXorOperator returns the bitwise xor of two integers. As a prefix
+// operator, returns the bitwise complement.
+func BitXorOperator(n *Node) (Evaluator, error) {
 
-		return Values(), n.Error("cannot apply - (negative) to %T", val)
+	if len(n.children) == 1 {
+		return ComplementOperation(n)
 	}
 
-	return e, nil
+	return binaryOperator(n, bitXorValues)
 }
 
-// ComparisonOperator applies the given comparison.
-func ComparisonOperator(n *Node, comp func(Value, Value) (bool, error)) (Evaluator, error) {
-
-	left, right, err := LeftRightEvaluators(n)
-	if err != nil {
-		return nil, err
-	}
-
-	e := func(vars *Variables) ([]Value, error) {
+// BitClearOperator returns the first integer with the bits set in the second
+// integer cleared (and not).
+func BitClearOperator(n *Node) (Evaluator, error) {
+	return binaryOperator(n, bitClearValues)
+}
 
-		leftVal, rightVal, err := StandardBinaryEval(n, left, right, vars)
-		if err != nil {
-			return Values(), err
-		}
+// ShiftLeftOperator handles ... shl ...
+func ShiftLeftOperator(n *Node) (Evaluator, error) {
+	return binaryOperator(n, shiftLeftValues)
+}
 
-		result, err := comp(leftVal, rightVal)
+// ShiftRightOperator handles ... shr ... The shift is arithmetic (sign
+// extending).
+func ShiftRightOperator(n *Node) (Evaluator, error) {
+	return binaryOperator(n, shiftRightValues)
+}
 
-		return Values(result), n.IfError(err, "%s", err)
-	}
+// ComplementOperation handles unary ^, returns the bitwise complement of an
+// integer.
+func ComplementOperation(n *Node) (Evaluator, error) {
+	return unaryOperator(n, complementValue)
+}
 
-	return e, nil
+// NegativeOperation handles unary -, return the negative of the value.
+func NegativeOperation(n *Node) (Evaluator, error) {
+	return unaryOperator(n, negateValue)
 }
 
 // EqualOperator checks equality.
 func EqualOperator(n *Node) (Evaluator, error) {
-	return ComparisonOperator(n, EqualValues)
+	return binaryOperator(n, equalTo)
 }
 
 // NotEqualOperator checks inequality.
 func NotEqualOperator(n *Node) (Evaluator, error) {
-	return ComparisonOperator(n, NotEqualValues)
+	return binaryOperator(n, notEqualTo)
 }
 
 // LessThanOperator checks less than.
 func LessThanOperator(n *Node) (Evaluator, error) {
-	return ComparisonOperator(n, LessThanValue)
+	return binaryOperator(n, lessThan)
 }
 
-// LessThanEqualOperator checks greater than.
+// LessThanEqualOperator checks less than or equal.
 func LessThanEqualOperator(n *Node) (Evaluator, error) {
-	return ComparisonOperator(n, LessThanEqualValue)
+	return binaryOperator(n, lessThanEqual)
 }
 
-// GreaterThanOperator checks less than.
+// GreaterThanOperator checks greater than.
 func GreaterThanOperator(n *Node) (Evaluator, error) {
-	return ComparisonOperator(n, GreaterThanValue)
+	return binaryOperator(n, greaterThan)
 }
 
-// GreaterThanEqualOperator checks greater than.
+// GreaterThanEqualOperator checks greater than or equal.
 func GreaterThanEqualOperator(n *Node) (Evaluator, error) {
-	return ComparisonOperator(n, GreaterThanEqualValue)
+	return binaryOperator(n, greaterThanEqual)
 }
 
 // NotOperator evaluate logical !
 func NotOperator(n *Node) (Evaluator, error) {
+	return unaryOperator(n, notValue)
+}
+
+// TryOperator evaluates an expression, converting a failure into an error
+// value. If the expression succeeds, its values are the result.
+func TryOperator(n *Node) (Evaluator, error) {
 
 	operand, err := n.children[0].Evaluator()
 	if err != nil {
@@ -766,12 +920,12 @@ func NotOperator(n *Node) (Evaluator, error) {
 
 	e := func(vars *Variables) ([]Value, error) {
 
-		val, err := StandardSingleEval(n, operand, vars)
+		vals, err := operand(vars)
 		if err != nil {
-			return Values(), err
+			return Values(ErrorFromFailure(n, vars.callStack().attach(err))), nil
 		}
 
-		return Values(!IsTruthy(val)), nil
+		return vals, nil
 	}
 
 	return e, nil
@@ -794,9 +948,12 @@ func LogicalAndOperator(n *Node) (Evaluator, error) {
 
 		if !IsTruthy(leftVal) {
 			// short-circuit return on False
+			coverBranch(n, vars, 0)
 			return Values(leftVal), nil
 		}
 
+		coverBranch(n, vars, 1)
+
 		rightVal, err := StandardSingleEval(n, right, vars)
 		return Values(rightVal), err
 	}
@@ -821,9 +978,12 @@ func LogicialOrOperator(n *Node) (Evaluator, error) {
 
 		if IsTruthy(leftVal) {
 			// short-circuit return on True
+			coverBranch(n, vars, 0)
 			return Values(leftVal), nil
 		}
 
+		coverBranch(n, vars, 1)
+
 		rightVal, err := StandardSingleEval(n, right, vars)
 		return Values(rightVal), err
 	}
@@ -831,6 +991,12 @@ func LogicialOrOperator(n *Node) (Evaluator, error) {
 	return e, nil
 }
 
+// LogicalXorOperator handles ... ^^ ... Both sides are always evaluated, and
+// the result is true if exactly one of them is truthy.
+func LogicalXorOperator(n *Node) (Evaluator, error) {
+	return binaryOperator(n, xorValues)
+}
+
 // LoopOperator handles while ... { ... }
 func LoopOperator(n *Node) (Evaluator, error) {
 
@@ -873,19 +1039,48 @@ func LoopOperator(n *Node) (Evaluator, error) {
 // ReturnOperator wraps values in a ReturnValue.
 func ReturnOperator(n *Node) (Evaluator, error) {
 
-	eval := func(vars *Variables) ([]Value, error) {
-		return Values(), nil
+	// return may have several children, e.g. (return a b), which produce
+	// multiple values, same as (, a b).
+	eval, err := MultiValueOperator(n)
+	if err != nil {
+		return nil, err
 	}
-	var err error
-	if len(n.children) > 0 {
-		eval, err = n.children[0].Evaluator()
+
+	if n.tailCall {
+		return tailCallOperator(n)
 	}
+
+	e := func(vars *Variables) ([]Value, error) {
+		result, err := eval(vars)
+		return ReturnValue(result), err
+	}
+
+	return e, nil
+}
+
+// tailCallOperator returns a call of a function, return f(...), as a tail
+// call, to be made by callFunction. Builtins are applied directly.
+func tailCallOperator(n *Node) (Evaluator, error) {
+
+	call := n.children[0]
+
+	operands, err := callOperands(call)
 	if err != nil {
 		return nil, err
 	}
 
 	e := func(vars *Variables) ([]Value, error) {
-		result, err := eval(vars)
+
+		fn, values, err := operands(vars)
+		if err != nil {
+			return Values(), err
+		}
+
+		if f, ok := fn.(Function); ok {
+			return TailCallValue(call, f, values), nil
+		}
+
+		result, err := applyFunction(call, vars.callStack(), fn, values)
 		return ReturnValue(result), err
 	}
 
@@ -933,6 +1128,11 @@ func ConditionalOperator(n *Node) (Evaluator, error) {
 
 		for i := 0; i < len(evals); i += 2 {
 
+			if i == len(evals)-1 {
+				// the final else clause
+				coverBranch(n, vars, i)
+			}
+
 			results, err = evals[i](vars)
 			if i >= len(evals)-1 || err != nil {
 				// either err or final else clause
@@ -947,10 +1147,13 @@ func ConditionalOperator(n *Node) (Evaluator, error) {
 
 			if IsTruthy(oneVal) {
 				// found a truthy conditional, evaluate and return the then-clause
+				coverBranch(n, vars, i+1)
 				return evals[i+1](vars)
 			}
 		}
 
+		coverBranch(n, vars, -1)
+
 		return results, nil
 	}
 
@@ -0,0 +1,277 @@
+package compile
+
+import (
+	"strconv"
+
+	"github.com/pdk/gosh/token"
+)
+
+// Fold simplifies an analyzed tree before it is compiled. Operators whose
+// operands are all literals are evaluated, and replaced by a literal of the
+// result. Branches of an if which cannot be taken are dropped, as are
+// statements after a return, break or continue.
+//
+// An operator which fails on literal operands, e.g. 1/0, is left as it is, so
+// that it fails if and when it's evaluated. Each is returned as an error at the
+// operator, unless it's inside a try, which turns the error into a value.
+func (n *Node) Fold() []Problem {
+
+	var problems []Problem
+	n.fold(true, &problems)
+
+	return problems
+}
+
+// fold folds a node, after folding its children. If strict, operators failing
+// on literal operands are added to the problems.
+func (n *Node) fold(strict bool, problems *[]Problem) {
+
+	switch n.Token() {
+	case token.IF:
+		n.foldConditional(strict, problems)
+		return
+	case token.TRY:
+		strict = false
+	case token.STMTS:
+		n.dropUnreachable()
+	}
+
+	for _, child := range n.children {
+		child.fold(strict, problems)
+	}
+
+	switch n.Token() {
+	case token.STMTS:
+		return
+
+	case token.LPAREN:
+		if len(n.children) == 1 && n.children[0].isLiteral() {
+			n.become(n.children[0])
+		}
+		return
+
+	case token.LOG_AND, token.LOG_OR:
+		n.foldShortCircuit()
+		return
+
+	case token.INTERP:
+		n.foldInterpolation()
+		return
+	}
+
+	v, ok, err := n.evaluateLiterals()
+	if err != nil && strict {
+		*problems = append(*problems, Problem{Err: err, at: n})
+	}
+
+	if ok {
+		n.becomeLiteral(v)
+	}
+}
+
+// evaluateLiterals applies an operator to literal operands. It reports whether
+// the node is an operator whose operands are all literals.
+func (n *Node) evaluateLiterals() (Value, bool, error) {
+
+	var operands []Value
+	for _, child := range n.children {
+		v, ok := child.literalValue()
+		if !ok {
+			return nil, false, nil
+		}
+		operands = append(operands, v)
+	}
+
+	var v Value
+	var err error
+
+	switch len(operands) {
+	case 1:
+		op, ok := unaryOperations[n.Token()]
+		if !ok {
+			return nil, false, nil
+		}
+		v, err = op(n, operands[0])
+	case 2:
+		op, ok := binaryOperations[n.Token()]
+		if !ok {
+			return nil, false, nil
+		}
+		v, err = op(n, operands[0], operands[1])
+	default:
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return v, true, nil
+}
+
+// foldConditional drops the branches of an if whose conditions are literals.
+// A true condition makes its branch the else, and drops those after it. A
+// false condition is dropped with its branch, except that a final false
+// condition without an else is kept as the else, since that's the value of the
+// if when no branch is taken.
+func (n *Node) foldConditional(strict bool, problems *[]Problem) {
+
+	var kept []*Node
+
+	for i := 0; i < len(n.children); i += 2 {
+
+		cond := n.children[i]
+		cond.fold(strict, problems)
+
+		if i == len(n.children)-1 {
+			// the else
+			kept = append(kept, cond)
+			break
+		}
+
+		then := n.children[i+1]
+
+		v, ok := cond.literalValue()
+		if !ok {
+			then.fold(strict, problems)
+			kept = append(kept, cond, then)
+			continue
+		}
+
+		if IsTruthy(v) {
+			then.fold(strict, problems)
+			kept = append(kept, then)
+			break
+		}
+
+		if i == len(n.children)-2 {
+			kept = append(kept, cond)
+		}
+	}
+
+	if len(kept) == 1 {
+		n.become(kept[0])
+		return
+	}
+
+	n.children = kept
+}
+
+// foldShortCircuit folds && and || when the left operand is a literal which
+// decides the result, or both operands are literals.
+func (n *Node) foldShortCircuit() {
+
+	if len(n.children) != 2 {
+		return
+	}
+
+	left, ok := n.children[0].literalValue()
+	if !ok {
+		return
+	}
+
+	if IsTruthy(left) == n.IsToken(token.LOG_OR) {
+		n.become(n.children[0])
+		return
+	}
+
+	if n.children[1].isLiteral() {
+		n.become(n.children[1])
+	}
+}
+
+// foldInterpolation replaces an interpolated string whose parts are all
+// literals with a string literal.
+func (n *Node) foldInterpolation() {
+
+	s := ""
+	for _, part := range n.children {
+		v, ok := part.literalValue()
+		if !ok {
+			return
+		}
+		s += ToString(v)
+	}
+
+	n.becomeLiteral(s)
+}
+
+// dropUnreachable drops statements following a return, break or continue.
+func (n *Node) dropUnreachable() {
+
+	for i, child := range n.children {
+		if child.IsToken(token.RETURN, token.BREAK, token.CONTINUE) {
+			n.children = n.children[:i+1]
+			return
+		}
+	}
+}
+
+// isLiteral reports whether a node is a literal.
+func (n *Node) isLiteral() bool {
+	_, ok := n.literalValue()
+	return ok
+}
+
+// literalValue returns the value of a literal.
+func (n *Node) literalValue() (Value, bool) {
+
+	switch n.Token() {
+	case token.INT:
+		i, err := strconv.ParseInt(n.Literal(), 10, 0)
+		return i, err == nil
+	case token.FLOAT:
+		f, err := strconv.ParseFloat(n.Literal(), 64)
+		return f, err == nil
+	case token.STRING:
+		return n.Literal(), true
+	case token.CHAR:
+		return []rune(n.Literal())[0], true
+	case token.TRUE:
+		return true, true
+	case token.FALSE:
+		return false, true
+	case token.NIL:
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// becomeLiteral replaces a node with a literal of a value, at the same
+// location. Values which have no literal are left alone.
+func (n *Node) becomeLiteral(v Value) {
+
+	var tok token.Token
+	var lit string
+
+	switch v := v.(type) {
+	case int64:
+		tok, lit = token.INT, strconv.FormatInt(v, 10)
+	case float64:
+		tok, lit = token.FLOAT, strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		tok, lit = token.STRING, v
+	case rune:
+		tok, lit = token.CHAR, string(v)
+	case bool:
+		tok, lit = token.FALSE, "false"
+		if v {
+			tok, lit = token.TRUE, "true"
+		}
+	case nil:
+		tok, lit = token.NIL, "nil"
+	default:
+		return
+	}
+
+	n.become(&Node{
+		lexeme: n.lexeme.Rewrite(tok, lit),
+	})
+}
+
+// become replaces a node with another, in place, so that anything referring to
+// the node, e.g. the analysis of a function, sees the replacement.
+func (n *Node) become(other *Node) {
+	*n = *other
+}
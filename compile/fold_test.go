@@ -0,0 +1,88 @@
+package compile_test
+
+import "testing"
+
+func TestFoldLiterals(t *testing.T) {
+
+	checkEval(t, `60 * 60 * 24`, "86400")
+	checkEval(t, `-(2 + 3), ^0, !true`, "-5, -1, false")
+	checkEval(t, `1.5 * 2.0, 7.0 / 2.0`, "3, 3.5")
+	checkEval(t, `"a" + "b" + "c"`, "abc")
+	checkEval(t, `1 < 2, 2 <= 1.5, "a" == "a", nil != nil`, "true, false, true, false")
+	checkEval(t, `x := 3; "x is ${x}, one is ${1}", "${1 + 1}"`, "x is 3, one is 1, 2")
+	checkEval(t, `x := 10; x * (2 + 3)`, "50")
+	checkEval(t, `false || "yes", nil && 1, true && 2`, "yes, nil, 2")
+	checkEval(t, `x := 0; true || x, false && x`, "true, false")
+}
+
+func TestFoldConditionals(t *testing.T) {
+
+	checkEval(t, `if true { 1 } else { 2 }`, "1")
+	checkEval(t, `if false { 1 } else { 2 }`, "2")
+	checkEval(t, `if false { 1 }`, "false")
+	checkEval(t, `x := 5; if false { 1 } else if x > 3 { 2 } else { 3 }`, "2")
+	checkEval(t, `x := 1; if x > 3 { 1 } else if true { 2 } else { 3 }`, "2")
+	checkEval(t, `x := 1; if x > 3 { 1 } else if nil { 2 }`, "nil")
+	checkEval(t, `if false { 1 / 0 } else { "fine" }`, "fine")
+}
+
+func TestFoldUnreachable(t *testing.T) {
+
+	checkEval(t, `
+		f := func() {
+			return 1
+			1 / 0
+		}
+		f()`, "1")
+
+	checkEval(t, `
+		i := 0
+		while i < 3 {
+			i := i + 1
+			continue
+			i := 100
+		}
+		i`, "3")
+}
+
+func TestFoldErrors(t *testing.T) {
+
+	// errors are raised when the code is evaluated, and not before
+	checkEvalErr(t, `
+		f := func() {
+			return 60 / (2 - 2)
+		}
+		f()`,
+		"testing:3:14: \t\t\treturn 60 / (2 - 2): integer division by zero")
+	checkEval(t, `f := func() { return 1 / 0 }; "never called"`, "never called")
+
+	checkEvalErr(t, `x := 1 + "a"`, "cannot apply + to int64 and string")
+	checkEvalErr(t, `-"a"`, "cannot apply - (negative) to string")
+
+	// inside a try, the error is a value
+	checkEval(t, `f := func() { return try(1 / 0) }; f()`, "integer division by zero")
+}
+
+func TestFoldProblems(t *testing.T) {
+
+	// operators which always fail are errors, except inside a try
+	checkProblems(t, `
+		f := func() {
+			return 60 / (2 - 2)
+		}
+		g := func() { return try(1 / 0) }`,
+		"error: testing:3:14: integer division by zero")
+
+	// code which folding drops is still checked
+	checkProblems(t, `
+		if false {
+			cuont + 1
+		}
+		f := func() {
+			return 1
+			x := 2
+		}
+		f()`,
+		"error: testing:3:4: undefined variable cuont",
+		"warning: testing:7:4: x is assigned but never used")
+}
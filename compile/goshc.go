@@ -0,0 +1,133 @@
+package compile
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/token"
+)
+
+// bytecodeFormat identifies a compiled (.goshc) file. Change it whenever the
+// instructions, or the numbering of tokens, changes, so that stale files are
+// not loaded.
+const bytecodeFormat = "goshc 3"
+
+// Site is where an instruction came from: the lexeme of a node, and the lexemes
+// of its immediate children.
+type Site struct {
+	Token    token.Token
+	Literal  string
+	Line     int
+	Char     int
+	Children []Site
+}
+
+// bytecodeFile is the content of a compiled file. The input is kept to report
+// the source line of errors.
+type bytecodeFile struct {
+	Format   string
+	Name     string
+	Input    []string
+	Checksum string
+	Code     *Code
+}
+
+// Checksum returns a checksum of an input, used to check that a compiled file
+// is up to date.
+func Checksum(input []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(input, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// siteOf records where a node came from.
+func siteOf(n *Node) Site {
+
+	s := lexemeSite(n.lexeme)
+	for _, child := range n.children {
+		s.Children = append(s.Children, lexemeSite(child.lexeme))
+	}
+
+	return s
+}
+
+func lexemeSite(lex *lexer.Lexeme) Site {
+	return Site{
+		Token:   lex.Token(),
+		Literal: lex.Literal(),
+		Line:    lex.LineNo(),
+		Char:    lex.CharNo(),
+	}
+}
+
+// node rebuilds a node from a site, for reporting errors.
+func (s Site) node(lex *lexer.Lexer) *Node {
+
+	lexeme := lex.LexemeAt(s.Token, s.Literal, s.Line, s.Char)
+	n := &Node{lexeme: &lexeme}
+
+	for _, child := range s.Children {
+		n.children = append(n.children, child.node(lex))
+	}
+
+	return n
+}
+
+// restoreNodes rebuilds the nodes of the sites of the code, and of the code it
+// contains.
+func (c *Code) restoreNodes(lex *lexer.Lexer) {
+
+	c.nodes = make([]*Node, len(c.Sites))
+	for i, s := range c.Sites {
+		c.nodes[i] = s.node(lex)
+	}
+
+	for _, fc := range c.Funcs {
+		fc.Code.restoreNodes(lex)
+	}
+
+	for _, sc := range c.Structs {
+		for _, fc := range sc.Fields {
+			fc.Code.restoreNodes(lex)
+		}
+	}
+}
+
+// WriteBytecode writes a program compiled to bytecode, e.g. to a .goshc file.
+func (p *Program) WriteBytecode(w io.Writer) error {
+
+	if p.code == nil {
+		return fmt.Errorf("%s was not compiled to bytecode", p.name)
+	}
+
+	return gob.NewEncoder(w).Encode(bytecodeFile{
+		Format:   bytecodeFormat,
+		Name:     p.name,
+		Input:    p.input,
+		Checksum: p.checksum,
+		Code:     p.code,
+	})
+}
+
+// ReadBytecode reads a program written by WriteBytecode.
+func ReadBytecode(r io.Reader) (*Program, error) {
+
+	var f bytecodeFile
+
+	err := gob.NewDecoder(r).Decode(&f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read compiled program: %s", err)
+	}
+
+	if f.Format != bytecodeFormat {
+		return nil, fmt.Errorf("cannot read compiled program: format is %q, expected %q", f.Format, bytecodeFormat)
+	}
+
+	f.Code.restoreNodes(lexer.Source(f.Name, f.Input))
+
+	return bytecodeProgram(f.Name, f.Input, f.Code), nil
+}
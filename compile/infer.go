@@ -0,0 +1,417 @@
+package compile
+
+import (
+	"sort"
+
+	"github.com/pdk/gosh/parse"
+	"github.com/pdk/gosh/token"
+	"github.com/pdk/gosh/u"
+)
+
+// CheckTypes infers the types of variables from the values they're initialized
+// with, and of expressions from their operands, and reports the type errors it
+// can prove without running the program:
+//
+//   - assigning a value of one type to a variable of another
+//   - applying an operator to operands of the wrong types
+//   - calling a func with the wrong number of arguments
+//   - sending values of different types on a channel
+//
+// Where a type isn't known, e.g. of a parameter, or the result of a call,
+// nothing is reported. Problems are returned in the order they appear.
+func (p *Program) CheckTypes() []Problem {
+
+	if root, _ := p.tree(); root == nil {
+		return nil
+	}
+
+	tc := p.typeCheck()
+
+	sortProblems(tc.problems)
+
+	return tc.problems
+}
+
+// typeCheck infers the types of the variables of a program which has a tree.
+func (p *Program) typeCheck() *typeChecker {
+
+	root, top := p.tree()
+
+	tc := &typeChecker{
+		top:      top,
+		vars:     make(map[variable]string),
+		funcs:    make(map[variable]*Node),
+		assigned: make(map[variable]int),
+		channels: make(map[variable]string),
+	}
+
+	tc.collect(root, top)
+	tc.infer(root, top)
+
+	return tc
+}
+
+// variable identifies a variable by the scope binding it, and its name.
+type variable struct {
+	scope *Analysis
+	name  string
+}
+
+// typeChecker infers the types of variables, and checks the types of
+// expressions.
+type typeChecker struct {
+	top      *Analysis
+	vars     map[variable]string // inferred type of each variable
+	funcs    map[variable]*Node  // func assigned to each variable
+	assigned map[variable]int    // number of assignments to each variable
+	channels map[variable]string // type of the values sent on each channel
+	problems []Problem
+}
+
+// variableOf returns the variable an identifier refers to.
+func (tc *typeChecker) variableOf(ident *Node, scope *Analysis) variable {
+
+	if ident.address == nil || ident.address.Global() {
+		return variable{scope: tc.top, name: ident.Literal()}
+	}
+
+	binder := scope
+	for i := 0; i < ident.address.Depth; i++ {
+		binder = binder.parent
+	}
+
+	return variable{scope: binder, name: ident.Literal()}
+}
+
+func (tc *typeChecker) report(at *Node, mesg string, args ...interface{}) {
+	tc.problems = append(tc.problems, Problem{
+		Err: at.Error(mesg, args...),
+		at:  at,
+	})
+}
+
+// collect counts the assignments to each variable, and notes the funcs
+// assigned to them, so that calls can be checked wherever they appear.
+func (tc *typeChecker) collect(n *Node, scope *Analysis) {
+
+	if n.IsToken(token.FUNC) && n.analysis != nil {
+		tc.collect(n.analysis.body, n.analysis)
+		return
+	}
+
+	if n.IsToken(token.ASSIGN, token.QASSIGN, token.ACCUM) && len(n.children) == 2 {
+		for _, t := range assignTargets(n) {
+			if t.IsToken(token.IDENT) {
+				tc.assigned[tc.variableOf(t, scope)]++
+			}
+		}
+
+		target, value := n.children[0], n.children[1]
+		if target.IsToken(token.IDENT) && value.IsToken(token.FUNC) {
+			tc.funcs[tc.variableOf(target, scope)] = value
+		}
+	}
+
+	for _, c := range n.children {
+		tc.collect(c, scope)
+	}
+}
+
+// assignTargets returns the targets of an assignment.
+func assignTargets(n *Node) []*Node {
+
+	lhs := n.children[0]
+	if lhs.IsToken(token.COMMA, token.LPAREN) {
+		return lhs.children
+	}
+
+	return []*Node{lhs}
+}
+
+// infer checks the types of a node and the nodes it contains, returning the
+// type of its value, or "" if that's not known.
+func (tc *typeChecker) infer(n *Node, scope *Analysis) string {
+
+	switch {
+	case n.IsToken(token.FUNC) && n.analysis != nil:
+		tc.infer(n.analysis.body, n.analysis)
+		return "func"
+
+	case isTypedNil(n):
+		if len(n.children) == 2 && n.children[1].IsToken(token.IDENT) {
+			return n.children[1].Literal()
+		}
+		return ""
+
+	case n.IsToken(token.ASSIGN) && len(n.children) == 2:
+		tc.assignment(n, scope)
+		return ""
+
+	case n.IsToken(token.FUNCAPPLY):
+		tc.inferAll(n.children, scope)
+		tc.checkCall(n, scope)
+		return ""
+
+	case n.IsToken(token.LPIPE) && len(n.children) == 2:
+		tc.send(n, scope)
+		return ""
+
+	case n.IsToken(token.METHAPPLY) && len(n.children) >= 2:
+		tc.infer(n.children[0], scope)
+		tc.inferAll(n.children[2:], scope)
+		return ""
+
+	case n.IsToken(token.PERIOD) && len(n.children) > 0:
+		tc.infer(n.children[0], scope)
+		return ""
+
+	case n.IsToken(token.STRUCT):
+		body := n.children[len(n.children)-1]
+		statements := []*Node{body}
+		if body.IsToken(token.STMTS) {
+			statements = body.children
+		}
+		for _, stmt := range statements {
+			if stmt.IsToken(token.ASSIGN) && len(stmt.children) == 2 {
+				tc.infer(stmt.children[1], scope)
+				continue
+			}
+			tc.infer(stmt, scope)
+		}
+		if len(n.children) == 2 {
+			tc.assign(n.children[0], "type", scope)
+		}
+		return ""
+
+	case n.IsToken(token.EXTERN):
+		return ""
+
+	case n.IsToken(token.IDENT):
+		return tc.vars[tc.variableOf(n, scope)]
+	}
+
+	switch n.Token() {
+	case token.INT:
+		return "int64"
+	case token.FLOAT:
+		return "float64"
+	case token.STRING:
+		return "string"
+	case token.CHAR:
+		return "rune"
+	case token.TRUE, token.FALSE:
+		return "bool"
+	case token.NIL:
+		return ""
+	}
+
+	types := tc.inferAll(n.children, scope)
+
+	switch {
+	case n.IsToken(token.INTERP):
+		return "string"
+
+	case n.IsToken(token.LSQR) && n.arity != parse.Lefty, isSlice(n):
+		return "list"
+
+	case len(types) == 2 && binaryOperations[n.Token()] != nil:
+		return tc.binary(n, types[0], types[1])
+
+	case len(types) == 1 && unaryOperations[n.Token()] != nil:
+		return tc.unary(n, types[0])
+	}
+
+	return ""
+}
+
+func (tc *typeChecker) inferAll(nodes []*Node, scope *Analysis) []string {
+
+	var types []string
+	for _, n := range nodes {
+		types = append(types, tc.infer(n, scope))
+	}
+
+	return types
+}
+
+// assignment checks an assignment, pairing targets with values when there are
+// as many of each.
+func (tc *typeChecker) assignment(n *Node, scope *Analysis) {
+
+	targets := assignTargets(n)
+
+	values := []*Node{n.children[1]}
+	if n.children[1].IsToken(token.COMMA) {
+		values = n.children[1].children
+	}
+
+	types := tc.inferAll(values, scope)
+
+	for i, t := range targets {
+		if !t.IsToken(token.IDENT) {
+			tc.infer(t, scope)
+			continue
+		}
+
+		if len(targets) == len(values) {
+			tc.assign(t, types[i], scope)
+		}
+	}
+}
+
+// assign infers the type of a variable from the first value of a known type
+// assigned to it, and checks that later values are of the same type.
+func (tc *typeChecker) assign(ident *Node, typ string, scope *Analysis) {
+
+	if typ == "" {
+		return
+	}
+
+	v := tc.variableOf(ident, scope)
+
+	known := tc.vars[v]
+	if known == "" {
+		tc.vars[v] = typ
+		return
+	}
+
+	if known != typ {
+		tc.report(ident, "attempt to convert variable %s from type %s to type %s", v.name, known, typ)
+	}
+}
+
+// scalarTypes are the types which the operators are defined for.
+var scalarTypes = map[string]bool{
+	"bool":    true,
+	"int64":   true,
+	"float64": true,
+	"string":  true,
+	"rune":    true,
+}
+
+// binary checks the operands of a binary operator, and returns the type of
+// its result.
+func (tc *typeChecker) binary(n *Node, left, right string) string {
+
+	switch n.Token() {
+	case token.EQUAL, token.NOT_EQUAL, token.LESS, token.LESS_EQUAL,
+		token.GRTR, token.GRTR_EQUAL, token.LOG_XOR:
+		return "bool"
+	}
+
+	if !scalarTypes[left] || !scalarTypes[right] {
+		return ""
+	}
+
+	var accepts []string
+	switch n.Token() {
+	case token.PLUS:
+		accepts = []string{"int64", "float64", "string"}
+	case token.MINUS, token.MULT, token.DIV:
+		accepts = []string{"int64", "float64"}
+	default:
+		accepts = []string{"int64"}
+	}
+
+	if left == right && u.StringIn(left, accepts) {
+		return left
+	}
+
+	tc.report(n, "cannot apply %s to %s and %s", n.Literal(), left, right)
+
+	return ""
+}
+
+// unary checks the operand of a prefix operator, and returns the type of its
+// result.
+func (tc *typeChecker) unary(n *Node, operand string) string {
+
+	switch n.Token() {
+	case token.NOT:
+		return "bool"
+
+	case token.MINUS:
+		if !scalarTypes[operand] || operand == "int64" || operand == "float64" {
+			return operand
+		}
+		tc.report(n, "cannot apply - (negative) to %s", operand)
+
+	case token.BIT_XOR:
+		if !scalarTypes[operand] || operand == "int64" {
+			return operand
+		}
+		tc.report(n, "cannot apply ^ (complement) to %s", operand)
+	}
+
+	return ""
+}
+
+// checkCall checks the number of arguments of a call of a variable which is
+// only ever assigned a func.
+func (tc *typeChecker) checkCall(n *Node, scope *Analysis) {
+
+	callee := n.children[0]
+	if !callee.IsToken(token.IDENT) {
+		return
+	}
+
+	v := tc.variableOf(callee, scope)
+
+	f := tc.funcs[v]
+	if f == nil || tc.assigned[v] != 1 {
+		return
+	}
+
+	args := 0
+	for _, arg := range n.children[1:] {
+		a := arity(arg)
+		if a < 0 {
+			return
+		}
+		args += a
+	}
+
+	if params := len(f.analysis.parameters); args != params {
+		tc.report(callee, "number of arguments does not match number of parameters: %s takes %d, called with %d",
+			v.name, params, args)
+	}
+}
+
+// send checks that the values sent on a channel are all of the same type.
+func (tc *typeChecker) send(n *Node, scope *Analysis) {
+
+	typ := tc.infer(n.children[1], scope)
+
+	ch := n.children[0]
+	if !ch.IsToken(token.IDENT) {
+		tc.infer(ch, scope)
+		return
+	}
+
+	v := tc.variableOf(ch, scope)
+	if !u.StringIn(v.name, v.scope.channels) || typ == "" {
+		return
+	}
+
+	known := tc.channels[v]
+	if known == "" {
+		tc.channels[v] = typ
+		return
+	}
+
+	if known != typ {
+		tc.report(n, "cannot send %s on channel %s, which carries %s", typ, v.name, known)
+	}
+}
+
+// sortProblems puts problems in the order they appear.
+func sortProblems(problems []Problem) {
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i].at.lexeme, problems[j].at.lexeme
+		if a.LineNo() != b.LineNo() {
+			return a.LineNo() < b.LineNo()
+		}
+		return a.CharNo() < b.CharNo()
+	})
+}
@@ -0,0 +1,127 @@
+package compile_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/reader"
+)
+
+// checkTypes checks that checking the types of a script finds errors
+// containing the expected messages, in order.
+func checkTypes(t *testing.T, input string, expected ...string) {
+
+	t.Helper()
+
+	prog, err := compile.Analyze("testing", reader.ReadLinesToStrings(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("did not expect error analyzing %q, got: %s", input, err)
+	}
+
+	problems := prog.CheckTypes()
+	if len(problems) != len(expected) {
+		t.Errorf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+		return
+	}
+
+	for i, p := range problems {
+		if p.Warning || !strings.Contains(p.Err.Error(), expected[i]) {
+			t.Errorf("expected error with %q, got %s", expected[i], p.Err)
+		}
+	}
+}
+
+func TestInferAssignments(t *testing.T) {
+
+	checkTypes(t, `
+		n := 1
+		n := n + 1
+		n := "one"`,
+		"testing:4:3: \t\tn := \"one\": attempt to convert variable n from type int64 to type string")
+
+	// nil doesn't set the type, but a typed nil does
+	checkTypes(t, `
+		x := nil
+		x := "a"
+		y := nil(int64)
+		y := 2.5`,
+		"attempt to convert variable y from type int64 to type float64")
+
+	// the variables of funcs are separate from globals of the same name
+	checkTypes(t, `
+		x := 1
+		f := func() { x := "a"; return x }
+		g := func() { extern x; x := "b" }`,
+		"testing:4:27: \t\tg := func() { extern x; x := \"b\" }: attempt to convert variable x from type int64 to type string")
+
+	checkTypes(t, `a, b := 1, "b"; a, b := 2, "c"; b, a := 3, 4`,
+		"convert variable b from type string to type int64")
+
+	// parameters, and the results of calls, are not known
+	checkTypes(t, `
+		f := func(p) { p := "x"; return p }
+		x := f(1)
+		x := "y"`)
+}
+
+func TestInferOperators(t *testing.T) {
+
+	checkTypes(t, `
+		s := "a"
+		i := 1
+		f := 1.5
+		s + i, i * f, i % 2, f % 2.0, s - s, -s, i & 2, s < s, i == s, "${i}" + s`,
+		"cannot apply + to string and int64",
+		"cannot apply * to int64 and float64",
+		"cannot apply % to float64 and float64",
+		"cannot apply - to string and string",
+		"cannot apply - (negative) to string")
+
+	// the types of results are inferred
+	checkTypes(t, `
+		i := 1
+		j := i * 2 + 3
+		j := "x"
+		b := i < j
+		b + 1`,
+		"convert variable j from type int64 to type string",
+		"cannot apply + to bool and int64")
+
+	// struct values aren't checked
+	checkTypes(t, `
+		struct point { x := 0 }
+		p := nil(point)
+		p + 1`)
+}
+
+func TestInferCalls(t *testing.T) {
+
+	checkTypes(t, `
+		add := func(a, b) { return a + b }
+		add(1, 2)
+		add(1)
+		add(1, 2, 3)
+		add(add(1, 2))
+		add((1, 2))`,
+		"testing:4:3: \t\tadd(1): number of arguments does not match number of parameters: add takes 2, called with 1",
+		"add takes 2, called with 3")
+
+	// variables assigned more than once may hold different funcs
+	checkTypes(t, `
+		f := func(a) { return a }
+		f := func(a, b) { return a }
+		f(1)`)
+}
+
+func TestInferChannels(t *testing.T) {
+
+	checkTypes(t, `
+		g := func() [i, j] {
+			i << "a"
+			j << 1
+			i << "b"
+			j << "c"
+		}`,
+		"testing:6:6: \t\t\tj << \"c\": cannot send string on channel j, which carries int64")
+}
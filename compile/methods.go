@@ -0,0 +1,225 @@
+package compile
+
+// methodFunc implements a standard method of a list, map or struct.
+type methodFunc func(n *Node, vars *Variables, target Value, args []Value) ([]Value, error)
+
+var (
+	listMethods   map[string]methodFunc
+	mapMethods    map[string]methodFunc
+	structMethods map[string]methodFunc
+)
+
+func init() {
+	listMethods = map[string]methodFunc{
+		"append":   listAppend,
+		"len":      listLen,
+		"pop":      listPop,
+		"dup":      listDup,
+		"contains": listContains,
+		"index":    listIndex,
+	}
+
+	mapMethods = map[string]methodFunc{
+		"del":    mapDel,
+		"len":    mapLen,
+		"dup":    mapDup,
+		"keys":   mapKeys,
+		"values": mapValues,
+	}
+
+	structMethods = map[string]methodFunc{
+		"dup":     structDup,
+		"flds":    structFlds,
+		"methods": structMeths,
+	}
+}
+
+// standardMethod finds a standard method of a value. Standard methods cannot
+// be redefined by structs.
+func standardMethod(target Value, name string) (methodFunc, bool) {
+
+	var m methodFunc
+
+	switch target.(type) {
+	case *List:
+		m = listMethods[name]
+	case *Map:
+		m = mapMethods[name]
+	case *Struct:
+		m = structMethods[name]
+	}
+
+	return m, m != nil
+}
+
+// methodArgs checks the number of arguments of a standard method.
+func methodArgs(n *Node, name string, args []Value, count int) error {
+
+	if len(args) != count {
+		return n.Error("%s expects %d argument(s), got %d", name, count, len(args))
+	}
+
+	return nil
+}
+
+// append(x, ...) adds items to the end of the list, and returns the list.
+func listAppend(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	l := target.(*List)
+	l.Append(args...)
+
+	return Values(l), nil
+}
+
+func listLen(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "len", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(int64(target.(*List).Len())), nil
+}
+
+// pop() removes and returns the last item of the list.
+func listPop(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "pop", args, 0); err != nil {
+		return Values(), err
+	}
+
+	l := target.(*List)
+	if len(l.items) == 0 {
+		return Values(), n.Error("cannot pop from an empty list")
+	}
+
+	last := l.items[len(l.items)-1]
+	l.items = l.items[:len(l.items)-1]
+
+	return Values(last), nil
+}
+
+func listDup(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "dup", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(NewList(target.(*List).items...)), nil
+}
+
+// contains(x) checks if an item of the list is == x.
+func listContains(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "contains", args, 1); err != nil {
+		return Values(), err
+	}
+
+	i, err := NewEquality(n).IndexOf(target.(*List), args[0])
+	if err != nil {
+		return Values(), n.LocateError(err)
+	}
+
+	return Values(i >= 0), nil
+}
+
+// index(x) returns the offset of the first item of the list == x, or -1.
+func listIndex(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "index", args, 1); err != nil {
+		return Values(), err
+	}
+
+	i, err := NewEquality(n).IndexOf(target.(*List), args[0])
+	if err != nil {
+		return Values(), n.LocateError(err)
+	}
+
+	return Values(int64(i)), nil
+}
+
+func mapDel(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "del", args, 1); err != nil {
+		return Values(), err
+	}
+
+	if err := target.(*Map).del(NewEquality(n), args[0]); err != nil {
+		return Values(), n.LocateError(err)
+	}
+
+	return Values(), nil
+}
+
+func mapLen(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "len", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(int64(target.(*Map).Len())), nil
+}
+
+func mapDup(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "dup", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(target.(*Map).dup()), nil
+}
+
+func mapKeys(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "keys", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(NewList(target.(*Map).keys...)), nil
+}
+
+func mapValues(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "values", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(NewList(target.(*Map).values...)), nil
+}
+
+func structDup(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "dup", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(target.(*Struct).dup()), nil
+}
+
+func structFlds(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "flds", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(stringList(target.(*Struct).Fields())), nil
+}
+
+func structMeths(n *Node, vars *Variables, target Value, args []Value) ([]Value, error) {
+
+	if err := methodArgs(n, "methods", args, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(stringList(target.(*Struct).Methods())), nil
+}
+
+// stringList converts a slice of strings to a list.
+func stringList(x []string) *List {
+
+	l := NewList()
+	for _, s := range x {
+		l.Append(s)
+	}
+
+	return l
+}
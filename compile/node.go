@@ -1,8 +1,10 @@
 package compile
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 
 	"github.com/pdk/gosh/lexer"
@@ -13,10 +15,14 @@ import (
 
 // Node is a node in a "compile" processing tree.
 type Node struct {
-	lexeme   *lexer.Lexeme
-	children []*Node
-	arity    parse.Arity
-	analysis *Analysis
+	lexeme    *lexer.Lexeme
+	children  []*Node
+	arity     parse.Arity
+	analysis  *Analysis
+	address   *Address // where an identifier's variable lives, see Resolve
+	tailCall  bool     // a return of a call, from a func, see Resolve
+	statement bool     // a statement, where the debugger can stop
+	traced    bool     // logged by a tracer, see NewTracer
 }
 
 // Analysis returns the analysis of the node.
@@ -33,6 +39,8 @@ type Analysis struct {
 	externs     map[string]bool
 	body        *Node
 	parent      *Analysis
+	slots       map[string]int // frame slot of each parameter, channel and local
+	name        string         // the name a func is assigned to, if any
 }
 
 // NewAnalysis returns a new Analysis.
@@ -132,11 +140,38 @@ func ConvertParseToCompile(ast *parse.Node) *Node {
 	return node
 }
 
-// Error returns an error associated with a particular node.
+// Error returns an error associated with a particular node. A nil node gives
+// an error with no location.
 func (n *Node) Error(mesg string, args ...interface{}) error {
+	if n == nil {
+		return fmt.Errorf(mesg, args...)
+	}
 	return n.lexeme.Error(mesg, args...)
 }
 
+// LocateError gives an error the location of the node, unless it already has
+// a location.
+func (n *Node) LocateError(err error) error {
+
+	var lexErr *lexer.Error
+	if errors.As(err, &lexErr) {
+		return err
+	}
+
+	return n.Error("%s", err)
+}
+
+// errorMessage returns the message of an error, without its location.
+func errorMessage(err error) string {
+
+	var lexErr *lexer.Error
+	if errors.As(err, &lexErr) {
+		return lexErr.Message
+	}
+
+	return err.Error()
+}
+
 // IfError returns nil if err == nil, otherwise returns a non-nil error.
 func (n *Node) IfError(err error, mesg string, args ...interface{}) error {
 	if err == nil {
@@ -224,6 +259,8 @@ func (n *Node) FuncAnalysis(parent *Analysis) (bool, error) {
 		delete(collector.locals, e)
 	}
 
+	collector.assignSlots()
+
 	return true, nil
 }
 
@@ -236,12 +273,7 @@ func (n *Node) MethApplyAnalysis(collector *Analysis) (bool, error) {
 	}
 
 	// first child is obj
-	ident, err := n.children[0].PrimaryIdent()
-	if err != nil {
-		return true, err
-	}
-
-	collector.identifiers[ident] = true
+	n.children[0].ScopeAnalysis(collector)
 	// second child is meth name. skip
 	// third and subsequent are expressions to eval as params
 	for _, each := range n.children[2:] {
@@ -258,30 +290,54 @@ func (n *Node) AssignAnalysis(collector *Analysis) error {
 		return nil
 	}
 
-	// identify left-most identifiers of nodes on left side as local
-	// variables.
+	// identify identifiers on left side as local variables. Assigning to a
+	// field or index (x.f := ..., x[i] := ...) does not make x local.
 
 	leftHandSide := n.children[0]
 
+	targets := []*Node{leftHandSide}
 	if leftHandSide.IsToken(token.COMMA, token.LPAREN) {
-		for _, each := range leftHandSide.children {
-			id, err := each.PrimaryIdent()
-			if err != nil {
-				return err
-			}
-			collector.locals[id] = true
+		targets = leftHandSide.children
+	}
+
+	for _, each := range targets {
+		if each.IsToken(token.IDENT) {
+			collector.locals[each.Literal()] = true
 		}
+	}
 
-		return nil
+	return nil
+}
+
+// StructAnalysis checks if the node is a struct definition, and handles if so.
+// The name of a struct type is a local. Field names are not, but the
+// expressions giving their initial values are analyzed.
+// Return true if handled, false if not.
+func (n *Node) StructAnalysis(collector *Analysis) (bool, error) {
+
+	if !n.IsToken(token.STRUCT) {
+		return false, nil
 	}
 
-	id, err := leftHandSide.PrimaryIdent()
-	if err != nil {
-		return err
+	body := n.children[len(n.children)-1]
+	if len(n.children) == 2 {
+		collector.locals[n.children[0].Literal()] = true
 	}
 
-	collector.locals[id] = true
-	return nil
+	statements := []*Node{body}
+	if body.IsToken(token.STMTS) {
+		statements = body.children
+	}
+
+	for _, stmt := range statements {
+		if stmt.IsToken(token.ASSIGN) && len(stmt.children) == 2 {
+			stmt.children[1].ScopeAnalysis(collector)
+			continue
+		}
+		stmt.ScopeAnalysis(collector)
+	}
+
+	return true, nil
 }
 
 // ScopeAnalysis crawls the tree and identifies identifiers to find free
@@ -298,6 +354,21 @@ func (n *Node) ScopeAnalysis(collector *Analysis) error {
 		return err
 	}
 
+	done, err = n.StructAnalysis(collector)
+	if done || err != nil {
+		return err
+	}
+
+	if isTypedNil(n) {
+		// nil(int64) names a builtin type, not a variable.
+		for _, c := range n.children[1:] {
+			if !(c.IsToken(token.IDENT) && IsBuiltinType(c.Literal())) {
+				c.ScopeAnalysis(collector)
+			}
+		}
+		return nil
+	}
+
 	n.AssignAnalysis(collector)
 
 	if n.IsToken(token.EXTERN) {
@@ -386,3 +457,122 @@ func (n *Node) AllFuncs() []*Node {
 
 	return x
 }
+
+// isFunction checks if the analysis is of a func, rather than of top level
+// code.
+func (a *Analysis) isFunction() bool {
+	return a != nil && a.body != nil
+}
+
+// assignSlots numbers the variables of a func: parameters first, then
+// channels, then the remaining locals in name order.
+func (a *Analysis) assignSlots() {
+
+	a.slots = make(map[string]int)
+
+	add := func(name string) {
+		if _, ok := a.slots[name]; !ok {
+			a.slots[name] = len(a.slots)
+		}
+	}
+
+	for _, p := range a.parameters {
+		add(p)
+	}
+	for _, c := range a.channels {
+		add(c)
+	}
+
+	locals := names(a.locals)
+	sort.Strings(locals)
+	for _, l := range locals {
+		add(l)
+	}
+}
+
+// SlotCount returns the number of slots needed by a frame of the func.
+func (a *Analysis) SlotCount() int {
+	return len(a.slots)
+}
+
+// Address locates a variable. Variables of funcs live in frame slots: Depth
+// counts how many enclosing funcs out the variable is bound, and Slot is its
+// index in that func's frame. A negative Slot means the variable is global, and
+// is looked up by name when used.
+type Address struct {
+	Depth int
+	Slot  int
+}
+
+// Global checks if the address refers to a global variable.
+func (a *Address) Global() bool {
+	return a.Slot < 0
+}
+
+// resolve finds the address of a name used in the scope of the analysis.
+func (a *Analysis) resolve(name string) *Address {
+
+	depth := 0
+	for scope := a; scope.isFunction(); scope = scope.parent {
+		if slot, ok := scope.slots[name]; ok {
+			return &Address{Depth: depth, Slot: slot}
+		}
+		depth++
+	}
+
+	return &Address{Slot: -1}
+}
+
+// nameMethods names the methods of a struct type, e.g. Point.String.
+func (n *Node) nameMethods(typeName string) {
+
+	body := n.children[len(n.children)-1]
+
+	statements := []*Node{body}
+	if body.IsToken(token.STMTS) {
+		statements = body.children
+	}
+
+	for _, stmt := range statements {
+		if stmt.IsToken(token.ASSIGN) && len(stmt.children) == 2 && stmt.children[0].IsToken(token.IDENT) {
+			if f := stmt.children[1]; f.IsToken(token.FUNC) && f.analysis != nil {
+				f.analysis.name = typeName + "." + stmt.children[0].Literal()
+			}
+		}
+	}
+}
+
+// Resolve assigns an address to every identifier in the tree. It must follow
+// ScopeAnalysis, which determines the variables bound by each func.
+//
+// Funcs assigned to a variable are named for it, and returns of a call from a
+// func are marked as tail calls.
+func (n *Node) Resolve(scope *Analysis) {
+
+	if n.IsToken(token.FUNC) && n.analysis != nil {
+		scope = n.analysis
+	}
+
+	if n.IsToken(token.IDENT) {
+		n.address = scope.resolve(n.Literal())
+	}
+
+	if n.IsToken(token.ASSIGN) && len(n.children) == 2 && n.children[0].IsToken(token.IDENT) {
+		if f := n.children[1]; f.IsToken(token.FUNC) && f.analysis != nil && f.analysis.name == "" {
+			f.analysis.name = n.children[0].Literal()
+		}
+	}
+
+	if n.IsToken(token.STRUCT) && len(n.children) == 2 {
+		n.nameMethods(n.children[0].Literal())
+	}
+
+	if n.IsToken(token.RETURN) && scope.isFunction() && len(n.children) == 1 {
+		call := n.children[0]
+		n.tailCall = call.IsToken(token.FUNCAPPLY) && !isTypedNil(call)
+	}
+
+	for _, c := range n.children {
+		c.Resolve(scope)
+	}
+}
@@ -0,0 +1,262 @@
+package compile
+
+import "github.com/pdk/gosh/token"
+
+// binaryOperation applies an operator to two values. The node is the operator,
+// for reporting errors.
+type binaryOperation func(n *Node, left, right Value) (Value, error)
+
+// unaryOperation applies a prefix operator to a value.
+type unaryOperation func(n *Node, operand Value) (Value, error)
+
+// binaryOperations are the operators which evaluate both operands, and then
+// combine them.
+var binaryOperations = map[token.Token]binaryOperation{
+	token.PLUS:       addValues,
+	token.MINUS:      subtractValues,
+	token.MULT:       multiplyValues,
+	token.DIV:        divideValues,
+	token.MODULO:     moduloValues,
+	token.BIT_AND:    bitAndValues,
+	token.BIT_OR:     bitOrValues,
+	token.BIT_XOR:    bitXorValues,
+	token.BIT_CLEAR:  bitClearValues,
+	token.SHL:        shiftLeftValues,
+	token.SHR:        shiftRightValues,
+	token.EQUAL:      equalTo,
+	token.NOT_EQUAL:  notEqualTo,
+	token.LESS:       lessThan,
+	token.LESS_EQUAL: lessThanEqual,
+	token.GRTR:       greaterThan,
+	token.GRTR_EQUAL: greaterThanEqual,
+	token.LOG_XOR:    xorValues,
+}
+
+// unaryOperations are the prefix operators.
+var unaryOperations = map[token.Token]unaryOperation{
+	token.MINUS:   negateValue,
+	token.BIT_XOR: complementValue,
+	token.NOT:     notValue,
+}
+
+// intOperation applies an operation to two ints.
+func intOperation(n *Node, left, right Value, op func(int64, int64) int64) (Value, error) {
+
+	r, ok := TryBinaryInt64Op(left, right, op)
+	if ok {
+		return r, nil
+	}
+
+	return nil, n.Error("cannot apply %s to %T and %T",
+		n.Literal(), left, right)
+}
+
+// numericOperation applies an operation to two ints, or two floats.
+func numericOperation(n *Node, left, right Value,
+	intOp func(int64, int64) int64,
+	floatOp func(float64, float64) float64) (Value, error) {
+
+	r, ok := TryBinaryInt64Op(left, right, intOp)
+	if ok {
+		return r, nil
+	}
+
+	r2, ok := TryBinaryFloat64Op(left, right, floatOp)
+	if ok {
+		return r2, nil
+	}
+
+	return nil, n.Error("cannot apply %s to %T and %T",
+		n.Literal(), left, right)
+}
+
+// addValues adds numbers, or concatenates strings.
+func addValues(n *Node, left, right Value) (Value, error) {
+
+	r, ok := TryBinaryStringOp(left, right, func(s1, s2 string) string {
+		return s1 + s2
+	})
+	if ok {
+		return r, nil
+	}
+
+	return numericOperation(n, left, right, func(a, b int64) int64 {
+		return a + b
+	}, func(a, b float64) float64 {
+		return a + b
+	})
+}
+
+func subtractValues(n *Node, left, right Value) (Value, error) {
+	return numericOperation(n, left, right, func(a, b int64) int64 {
+		return a - b
+	}, func(a, b float64) float64 {
+		return a - b
+	})
+}
+
+func multiplyValues(n *Node, left, right Value) (Value, error) {
+	return numericOperation(n, left, right, func(a, b int64) int64 {
+		return a * b
+	}, func(a, b float64) float64 {
+		return a * b
+	})
+}
+
+func divideValues(n *Node, left, right Value) (Value, error) {
+
+	if right == int64(0) {
+		return nil, n.Error("integer division by zero")
+	}
+
+	return numericOperation(n, left, right, func(a, b int64) int64 {
+		return a / b
+	}, func(a, b float64) float64 {
+		return a / b
+	})
+}
+
+func moduloValues(n *Node, left, right Value) (Value, error) {
+
+	if right == int64(0) {
+		return nil, n.Error("integer division by zero")
+	}
+
+	return intOperation(n, left, right, func(a, b int64) int64 {
+		return a % b
+	})
+}
+
+func bitAndValues(n *Node, left, right Value) (Value, error) {
+	return intOperation(n, left, right, func(a, b int64) int64 {
+		return a & b
+	})
+}
+
+func bitOrValues(n *Node, left, right Value) (Value, error) {
+	return intOperation(n, left, right, func(a, b int64) int64 {
+		return a | b
+	})
+}
+
+func bitXorValues(n *Node, left, right Value) (Value, error) {
+	return intOperation(n, left, right, func(a, b int64) int64 {
+		return a ^ b
+	})
+}
+
+func bitClearValues(n *Node, left, right Value) (Value, error) {
+	return intOperation(n, left, right, func(a, b int64) int64 {
+		return a &^ b
+	})
+}
+
+func shiftLeftValues(n *Node, left, right Value) (Value, error) {
+	return shiftValues(n, left, right, func(a int64, b uint64) int64 {
+		return a << b
+	})
+}
+
+// shiftRightValues shifts right. The shift is arithmetic (sign extending).
+func shiftRightValues(n *Node, left, right Value) (Value, error) {
+	return shiftValues(n, left, right, func(a int64, b uint64) int64 {
+		return a >> b
+	})
+}
+
+// shiftValues applies a shift to an integer. The count must not be negative.
+func shiftValues(n *Node, left, right Value, op func(int64, uint64) int64) (Value, error) {
+
+	a, aOk := left.(int64)
+	b, bOk := right.(int64)
+	if !aOk || !bOk {
+		return nil, n.Error("cannot apply %s to %T and %T",
+			n.Literal(), left, right)
+	}
+
+	if b < 0 {
+		return nil, n.Error("negative shift count %d", b)
+	}
+
+	return op(a, uint64(b)), nil
+}
+
+// equalTo checks equality, invoking struct equals methods.
+func equalTo(n *Node, left, right Value) (Value, error) {
+
+	same, err := NewEquality(n).Equal(left, right)
+	if err != nil {
+		return nil, n.LocateError(err)
+	}
+
+	return same, nil
+}
+
+func notEqualTo(n *Node, left, right Value) (Value, error) {
+
+	same, err := equalTo(n, left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	return !same.(bool), nil
+}
+
+// ordered orders the values, and applies the given test to the result of the
+// comparison.
+func ordered(n *Node, left, right Value, test func(int) bool) (Value, error) {
+
+	r, err := NewComparison(n).Compare(left, right)
+	if err != nil {
+		return nil, n.LocateError(err)
+	}
+
+	return test(r), nil
+}
+
+func lessThan(n *Node, left, right Value) (Value, error) {
+	return ordered(n, left, right, func(r int) bool { return r < 0 })
+}
+
+func lessThanEqual(n *Node, left, right Value) (Value, error) {
+	return ordered(n, left, right, func(r int) bool { return r <= 0 })
+}
+
+func greaterThan(n *Node, left, right Value) (Value, error) {
+	return ordered(n, left, right, func(r int) bool { return r > 0 })
+}
+
+func greaterThanEqual(n *Node, left, right Value) (Value, error) {
+	return ordered(n, left, right, func(r int) bool { return r >= 0 })
+}
+
+// xorValues is true if exactly one of the values is truthy.
+func xorValues(n *Node, left, right Value) (Value, error) {
+	return IsTruthy(left) != IsTruthy(right), nil
+}
+
+func complementValue(n *Node, operand Value) (Value, error) {
+
+	v, ok := operand.(int64)
+	if !ok {
+		return nil, n.Error("cannot apply ^ (complement) to %T", operand)
+	}
+
+	return ^v, nil
+}
+
+func negateValue(n *Node, operand Value) (Value, error) {
+
+	switch v := operand.(type) {
+	case int64:
+		return -v, nil
+	case float64:
+		return -v, nil
+	}
+
+	return nil, n.Error("cannot apply - (negative) to %T", operand)
+}
+
+func notValue(n *Node, operand Value) (Value, error) {
+	return !IsTruthy(operand), nil
+}
@@ -0,0 +1,224 @@
+package compile
+
+import (
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Profile is where a program spent its time, and allocated memory, by the
+// stacks of gosh funcs and lines running.
+type Profile struct {
+	Start    time.Time
+	Duration time.Duration
+	Period   time.Duration // between samples
+	Samples  []ProfileSample
+}
+
+// ProfileSample is the time spent, and memory allocated, while a stack was
+// running.
+type ProfileSample struct {
+	Stack []ProfileFrame // the running line first, then the calls it's in
+	Count int64          // how many times the stack was sampled
+	Time  time.Duration
+	Bytes int64
+}
+
+// ProfileFrame is a func, or the top level, and the line of it running, or
+// calling the next frame.
+type ProfileFrame struct {
+	Function string
+	File     string
+	Line     int
+	Column   int
+}
+
+// allocsMetric is the bytes allocated by the go runtime, ever.
+const allocsMetric = "/gc/heap/allocs:bytes"
+
+// Profiler samples a program as it runs. A ticker marks a sample as due, and
+// the program takes it at the next statement, so the program is only ever
+// inspected by the goroutine running it. The time, and memory allocated, since
+// the last sample are attributed to the stack of the last statement.
+type Profiler struct {
+	prog   *Program
+	period time.Duration
+	due    int32 // set by the ticker
+
+	// the func, call site and statement running at each depth of calls, as of
+	// the last statement, which was at depth
+	frames []profileFrame
+	depth  int
+
+	start   time.Time
+	last    time.Time
+	allocs  []metrics.Sample
+	lastMem uint64
+
+	samples map[string]*ProfileSample
+	order   []string
+	elapsed time.Duration
+}
+
+type profileFrame struct {
+	function  string
+	site      *Node // where the func was called, in the frame before
+	statement *Node
+}
+
+// NewProfiler returns a profiler of a program, sampling it every period.
+func NewProfiler(p *Program, period time.Duration) (*Profiler, error) {
+
+	if p.root == nil || p.code != nil {
+		return nil, fmt.Errorf("%s is compiled to bytecode, which cannot be profiled", p.name)
+	}
+
+	return &Profiler{
+		prog:    p,
+		period:  period,
+		allocs:  []metrics.Sample{{Name: allocsMetric}},
+		samples: make(map[string]*ProfileSample),
+	}, nil
+}
+
+// Run runs the program in a scope, sampling it until it returns.
+func (pr *Profiler) Run(vars *Variables) ([]Value, error) {
+
+	calls := vars.callStack()
+	if calls == nil {
+		calls = newCallStack()
+		vars.calls = calls
+	}
+	calls.profiler = pr
+
+	pr.start = time.Now()
+	pr.last = pr.start
+	pr.lastMem = pr.memory()
+
+	ticker := time.NewTicker(pr.period)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				atomic.StoreInt32(&pr.due, 1)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	vals, err := pr.prog.Run(vars)
+
+	ticker.Stop()
+	close(done)
+	calls.profiler = nil
+
+	// the time since the last sample goes to the last statement
+	pr.sample()
+	pr.elapsed = time.Since(pr.start)
+
+	return vals, err
+}
+
+// memory returns the bytes allocated, ever.
+func (pr *Profiler) memory() uint64 {
+
+	metrics.Read(pr.allocs)
+	if pr.allocs[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return pr.allocs[0].Value.Uint64()
+}
+
+// statement takes a sample, if one is due, then notes the statement about to
+// run, at a depth of calls.
+func (pr *Profiler) statement(n *Node, calls *callStack) {
+
+	if atomic.LoadInt32(&pr.due) != 0 {
+		atomic.StoreInt32(&pr.due, 0)
+		pr.sample()
+	}
+
+	depth := len(calls.calls)
+	for len(pr.frames) <= depth {
+		pr.frames = append(pr.frames, profileFrame{})
+	}
+
+	f := profileFrame{function: "<top level>", statement: n}
+	if depth > 0 {
+		c := calls.calls[depth-1]
+		f.function, f.site = c.name, c.site
+	}
+
+	pr.frames[depth] = f
+	pr.depth = depth
+}
+
+// sample attributes the time and memory since the last sample to the stack of
+// the last statement.
+func (pr *Profiler) sample() {
+
+	now := time.Now()
+	mem := pr.memory()
+
+	elapsed, allocated := now.Sub(pr.last), mem-pr.lastMem
+	pr.last, pr.lastMem = now, mem
+
+	if len(pr.frames) == 0 {
+		// no statement has run
+		return
+	}
+
+	var stack []ProfileFrame
+	var key strings.Builder
+
+	for d := pr.depth; d >= 0; d-- {
+		at := pr.frames[d].statement
+		if d < pr.depth && pr.frames[d+1].site != nil {
+			at = pr.frames[d+1].site.callee()
+		}
+		if at == nil || at.lexeme == nil {
+			continue
+		}
+
+		frame := ProfileFrame{
+			Function: pr.frames[d].function,
+			File:     pr.prog.name,
+			Line:     at.lexeme.LineNo(),
+			Column:   at.lexeme.CharNo(),
+		}
+		stack = append(stack, frame)
+		fmt.Fprintf(&key, "%s:%d:%d;", frame.Function, frame.Line, frame.Column)
+	}
+
+	s := pr.samples[key.String()]
+	if s == nil {
+		s = &ProfileSample{Stack: stack}
+		pr.samples[key.String()] = s
+		pr.order = append(pr.order, key.String())
+	}
+
+	s.Count++
+	s.Time += elapsed
+	s.Bytes += int64(allocated)
+}
+
+// Profile returns the profile of the program, once it has run.
+func (pr *Profiler) Profile() Profile {
+
+	p := Profile{
+		Start:    pr.start,
+		Duration: pr.elapsed,
+		Period:   pr.period,
+	}
+
+	for _, key := range pr.order {
+		p.Samples = append(p.Samples, *pr.samples[key])
+	}
+
+	return p
+}
@@ -0,0 +1,64 @@
+package compile_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdk/gosh/compile"
+)
+
+func TestProfiler(t *testing.T) {
+
+	input := `square := func(x) {
+	y := x * x
+	y
+}
+total := square(3) + 1`
+
+	prog, err := compile.Compile("testing", strings.Split(input, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no sample falls due, so the run is sampled once, at its last statement
+	pr, err := compile.NewProfiler(prog, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vals, err := pr.Run(compile.GlobalScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || compile.ToString(vals[0]) != "10" {
+		t.Errorf("expected the program to return 10, got %v", vals)
+	}
+
+	p := pr.Profile()
+	if p.Period != time.Hour || p.Duration <= 0 || len(p.Samples) != 1 {
+		t.Fatalf("expected one sample of the run, got %+v", p)
+	}
+
+	var stack []string
+	for _, f := range p.Samples[0].Stack {
+		stack = append(stack, fmt.Sprintf("%s %s:%d:%d", f.Function, f.File, f.Line, f.Column))
+	}
+
+	expected := "square testing:3:2, <top level> testing:5:10"
+	if strings.Join(stack, ", ") != expected {
+		t.Errorf("expected stack %s, got %s", expected, strings.Join(stack, ", "))
+	}
+	if s := p.Samples[0]; s.Count != 1 || s.Time <= 0 {
+		t.Errorf("expected the sample to count the time of the run, got %+v", s)
+	}
+
+	bytecode, err := compile.CompileBytecode("testing", []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compile.NewProfiler(bytecode, time.Hour); err == nil {
+		t.Errorf("expected an error profiling bytecode")
+	}
+}
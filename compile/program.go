@@ -0,0 +1,203 @@
+package compile
+
+import (
+	"fmt"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/parse"
+)
+
+// Program is a compiled script. All the evaluators, including the bodies of
+// functions, are produced once by Compile, so a Program can be run any number
+// of times without recompiling. A program compiled by CompileBytecode runs in
+// the bytecode machine instead, and can be written to a .goshc file.
+type Program struct {
+	name     string
+	input    []string
+	checksum string
+	root     *Node
+	analysis *Analysis // of the top level of the tree
+	code     *Code
+	eval     Evaluator
+
+	ast       *parse.Node // parsed, to check the program as written
+	unfolded  *Node       // the tree before folding, see tree
+	unfoldTop *Analysis
+	folded    []Problem // operators failing on literal operands, see Fold
+}
+
+// Compile lexes, parses, analyzes and compiles the input.
+func Compile(inputName string, input []string) (*Program, error) {
+
+	ast, err := parse.New(lexer.New(inputName, input)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return CompileTree(inputName, ast)
+}
+
+// CompileTree analyzes and compiles a parse tree.
+func CompileTree(inputName string, ast *parse.Node) (*Program, error) {
+
+	root, top, folded, err := analyze(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	eval, err := root.Evaluator()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Program{
+		name:     inputName,
+		root:     root,
+		analysis: top,
+		eval:     eval,
+		ast:      ast,
+		folded:   folded,
+	}, nil
+}
+
+// Analyze lexes, parses and analyzes the input, without compiling it, so that
+// it can be checked even if it uses features which can't yet be run. The
+// program cannot be run.
+func Analyze(inputName string, input []string) (*Program, error) {
+
+	ast, err := parse.New(lexer.New(inputName, input)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	root, top, folded, err := analyze(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Program{
+		name:     inputName,
+		input:    input,
+		root:     root,
+		analysis: top,
+		ast:      ast,
+		folded:   folded,
+	}, nil
+}
+
+// CompileBytecode lexes, parses, analyzes and compiles the input to bytecode.
+func CompileBytecode(inputName string, input []string) (*Program, error) {
+
+	ast, err := parse.New(lexer.New(inputName, input)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	root, top, folded, err := analyze(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := root.Bytecode()
+	if err != nil {
+		return nil, err
+	}
+
+	prog := bytecodeProgram(inputName, input, code)
+	prog.root = root
+	prog.analysis = top
+	prog.ast = ast
+	prog.folded = folded
+
+	return prog, nil
+}
+
+func bytecodeProgram(inputName string, input []string, code *Code) *Program {
+	return &Program{
+		name:     inputName,
+		input:    input,
+		checksum: Checksum(input),
+		code:     code,
+		eval: func(vars *Variables) ([]Value, error) {
+			return execute(code, vars)
+		},
+	}
+}
+
+// analyze converts a parse tree, resolves its variables, and folds literals.
+// It returns the tree, the analysis of its top level, and the problems found
+// folding it.
+func analyze(ast *parse.Node) (*Node, *Analysis, []Problem, error) {
+
+	root, top, err := resolve(ast)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	root.markStatements()
+
+	return root, top, root.Fold(), nil
+}
+
+// resolve converts a parse tree, and resolves its variables. It returns the
+// tree, and the analysis of its top level.
+func resolve(ast *parse.Node) (*Node, *Analysis, error) {
+
+	root := ConvertParseToCompile(ast)
+
+	top := NewAnalysis()
+
+	err := root.ScopeAnalysis(top)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root.Resolve(top)
+
+	return root, top, nil
+}
+
+// Name returns the name of the input the program was compiled from.
+func (p *Program) Name() string {
+	return p.name
+}
+
+// Checksum returns the checksum of the input of a program compiled to
+// bytecode, or "" for other programs.
+func (p *Program) Checksum() string {
+	return p.checksum
+}
+
+// Root returns the root of the compiled tree. A program read from a .goshc
+// file has no tree.
+func (p *Program) Root() *Node {
+	return p.root
+}
+
+// tree returns the tree of the program as written, before folding drops
+// branches which can't be taken and statements which can't be reached, with
+// the analysis of its top level. The static checks look at all of it. A
+// program read from a .goshc file has no tree.
+func (p *Program) tree() (*Node, *Analysis) {
+
+	if p.unfolded == nil && p.ast != nil {
+		// analyzed once already, so this can't fail
+		p.unfolded, p.unfoldTop, _ = resolve(p.ast)
+	}
+
+	if p.unfolded == nil {
+		return p.root, p.analysis
+	}
+
+	return p.unfolded, p.unfoldTop
+}
+
+// Run evaluates the program in the given scope.
+func (p *Program) Run(vars *Variables) ([]Value, error) {
+
+	if p.eval == nil {
+		return Values(), fmt.Errorf("%s was analyzed, but not compiled", p.name)
+	}
+
+	return p.eval(vars)
+}
@@ -0,0 +1,194 @@
+package compile_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+)
+
+// closures creates a new closure on every iteration of a loop.
+const closures = `
+	i := 0
+	total := 0
+	while i < 1000 {
+		add := func(a) {
+			twice := func(b) { return b + b }
+			return twice(a) + i
+		}
+		total := add(1) + total
+		i := i + 1
+	}
+	total`
+
+func TestProgramRunsRepeatedly(t *testing.T) {
+
+	prog, err := compileString(closures)
+	if err != nil {
+		t.Fatalf("did not expect error compiling, got: %s", err)
+	}
+
+	for run := 0; run < 3; run++ {
+		vals, err := prog.Run(compile.GlobalScope())
+		if err != nil {
+			t.Fatalf("did not expect error on run %d, got: %s", run, err)
+		}
+
+		if got := compile.ToString(vals[0]); got != "501500" {
+			t.Errorf("run %d: expected 501500 but got %s", run, got)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+
+	_, err := compileString(`x := (1 + `)
+	if err == nil {
+		t.Errorf("expected a parse error, but got nil")
+	}
+
+	_, err = compileString(`x := y[1, 2]`)
+	if err == nil {
+		t.Errorf("expected a compile error, but got nil")
+	}
+}
+
+// benchmarkProgram compiles a script once, and runs it b.N times.
+func benchmarkProgram(b *testing.B, compiler func(string) (*compile.Program, error), input string) {
+
+	prog, err := compiler(input)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := prog.Run(compile.GlobalScope())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkClosures runs a script which creates closures in a loop. Function
+// bodies are compiled once, not each time a closure is created.
+func BenchmarkClosures(b *testing.B) {
+	benchmarkProgram(b, compileString, closures)
+}
+
+// BenchmarkClosuresVM runs the closures script in the bytecode machine.
+func BenchmarkClosuresVM(b *testing.B) {
+	benchmarkProgram(b, compileBytecode, closures)
+}
+
+// BenchmarkCompileAndRun compiles the script for every run, for comparison
+// with BenchmarkClosures.
+func BenchmarkCompileAndRun(b *testing.B) {
+
+	for i := 0; i < b.N; i++ {
+		_, err := evaluate(closures)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// funcLiterals evaluates a func literal on every iteration of a loop, and
+// calls the func it makes.
+const funcLiterals = `
+	i := 0
+	total := 0
+	while i < 10000 {
+		double := func(x) { return x * 2 }
+		total := total + double(i)
+		i := i + 1
+	}
+	total`
+
+// BenchmarkFuncLiterals runs a loop evaluating a func literal. Each evaluation
+// only captures the scope, as the body is compiled once.
+func BenchmarkFuncLiterals(b *testing.B) {
+	benchmarkProgram(b, compileString, funcLiterals)
+}
+
+// BenchmarkFuncLiteralsVM runs the func literal loop in the bytecode machine.
+func BenchmarkFuncLiteralsVM(b *testing.B) {
+	benchmarkProgram(b, compileBytecode, funcLiterals)
+}
+
+// loops is a loop-heavy function, whose variables all live in frame slots.
+const loops = `
+	sum := func(n) {
+		total := 0
+		i := 0
+		while i < n {
+			j := 0
+			while j < 10 {
+				total := total + i * j
+				j := j + 1
+			}
+			i := i + 1
+		}
+		return total
+	}
+	sum(1000)`
+
+func TestLoops(t *testing.T) {
+	checkEval(t, loops, "22477500")
+}
+
+// BenchmarkLoops runs nested loops inside a function.
+func BenchmarkLoops(b *testing.B) {
+	benchmarkProgram(b, compileString, loops)
+}
+
+// BenchmarkLoopsVM runs the nested loops in the bytecode machine.
+func BenchmarkLoopsVM(b *testing.B) {
+	benchmarkProgram(b, compileBytecode, loops)
+}
+
+func TestBytecodeFile(t *testing.T) {
+
+	prog, err := compileBytecode(closures)
+	if err != nil {
+		t.Fatalf("did not expect error compiling, got: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = prog.WriteBytecode(&buf)
+	if err != nil {
+		t.Fatalf("did not expect error writing, got: %s", err)
+	}
+
+	data := buf.Bytes()
+
+	read, err := compile.ReadBytecode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("did not expect error reading, got: %s", err)
+	}
+
+	if read.Checksum() != prog.Checksum() || read.Name() != prog.Name() {
+		t.Errorf("expected %s %s, but read %s %s", prog.Name(), prog.Checksum(), read.Name(), read.Checksum())
+	}
+
+	vals, err := read.Run(compile.GlobalScope())
+	if err != nil {
+		t.Fatalf("did not expect error running, got: %s", err)
+	}
+	if got := compile.ToString(vals[0]); got != "501500" {
+		t.Errorf("expected 501500 but got %s", got)
+	}
+
+	_, err = compile.ReadBytecode(bytes.NewReader(data[:len(data)/2]))
+	if err == nil {
+		t.Errorf("expected an error reading a truncated file, but got nil")
+	}
+
+	tree, err := compileString(closures)
+	if err != nil {
+		t.Fatalf("did not expect error compiling, got: %s", err)
+	}
+	if tree.WriteBytecode(&buf) == nil {
+		t.Errorf("expected an error writing a program not compiled to bytecode, but got nil")
+	}
+}
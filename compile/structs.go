@@ -0,0 +1,251 @@
+package compile
+
+import "github.com/pdk/gosh/token"
+
+// StructType is a struct definition. Calling it creates a new instance.
+type StructType struct {
+	name   string
+	fields []structField
+	scope  *Variables // where the struct was defined
+}
+
+// structField is a field of a struct definition, with the expression which
+// produces its initial value.
+type structField struct {
+	name string
+	init Evaluator
+}
+
+// Name returns the name of the struct type.
+func (st *StructType) Name() string {
+	return st.name
+}
+
+// Struct is an instance of a struct. Fields bound to functions are methods.
+type Struct struct {
+	typeName string
+	names    []string
+	values   map[string]Value
+}
+
+// NewStruct returns a new struct instance, with no fields.
+func NewStruct(typeName string) *Struct {
+	return &Struct{
+		typeName: typeName,
+		values:   make(map[string]Value),
+	}
+}
+
+// TypeName returns the name of the type of the struct.
+func (s *Struct) TypeName() string {
+	return s.typeName
+}
+
+// Get returns the value of a field, and whether the field exists.
+func (s *Struct) Get(name string) (Value, bool) {
+	v, ok := s.values[name]
+	return v, ok
+}
+
+// Set sets the value of a field, adding the field if new.
+func (s *Struct) Set(name string, v Value) {
+
+	if _, ok := s.values[name]; !ok {
+		s.names = append(s.names, name)
+	}
+
+	s.values[name] = v
+}
+
+// Fields returns the names of the fields which are not methods, in order.
+func (s *Struct) Fields() []string {
+
+	var x []string
+	for _, name := range s.names {
+		if _, isFunc := s.values[name].(Function); !isFunc {
+			x = append(x, name)
+		}
+	}
+
+	return x
+}
+
+// Methods returns the names of the fields which are methods, in order.
+func (s *Struct) Methods() []string {
+
+	var x []string
+	for _, name := range s.names {
+		if _, isFunc := s.values[name].(Function); isFunc {
+			x = append(x, name)
+		}
+	}
+
+	return x
+}
+
+// Method returns the function bound to a name, if there is one.
+func (s *Struct) Method(name string) (Function, bool) {
+	f, ok := s.values[name].(Function)
+	return f, ok
+}
+
+// dup returns a shallow copy of the struct.
+func (s *Struct) dup() *Struct {
+
+	d := NewStruct(s.typeName)
+	for _, name := range s.names {
+		d.Set(name, s.values[name])
+	}
+
+	return d
+}
+
+// StructDefinition defines a struct type, or creates a struct from a literal.
+// struct name { ... } ==> binds name to a new struct type
+// struct { ... } ==> a new struct instance
+func StructDefinition(n *Node) (Evaluator, error) {
+
+	name := ""
+	body := n.children[0]
+	if len(n.children) == 2 {
+		name = n.children[0].Literal()
+		body = n.children[1]
+	}
+
+	fields, err := structFields(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var addr *Address
+	if name != "" {
+		addr = n.children[0].address
+	}
+
+	e := func(vars *Variables) ([]Value, error) {
+		v, err := defineStruct(n, vars, name, addr, fields)
+		if err != nil {
+			return Values(), err
+		}
+		return Values(v), nil
+	}
+
+	return e, nil
+}
+
+// defineStruct binds a name to a new struct type, or, when name is "", creates
+// an instance from a struct literal.
+func defineStruct(n *Node, vars *Variables, name string, addr *Address, fields []structField) (Value, error) {
+
+	st := &StructType{
+		name:   name,
+		fields: fields,
+		scope:  vars,
+	}
+
+	if name == "" {
+		st.name = "struct"
+		return st.newInstance(n)
+	}
+
+	err := vars.SetAt(name, addr, st)
+	if err != nil {
+		return nil, n.Error("%s", err)
+	}
+
+	return st, nil
+}
+
+// structAssignments returns the field assignments of a struct body.
+func structAssignments(body *Node) ([]*Node, error) {
+
+	statements := []*Node{body}
+	if body.IsToken(token.STMTS) {
+		statements = body.children
+	}
+
+	var assignments []*Node
+
+	for _, stmt := range statements {
+		if stmt.IsToken(token.SEMI) && len(stmt.children) == 0 {
+			continue
+		}
+
+		if !stmt.IsToken(token.ASSIGN) || !stmt.children[0].IsToken(token.IDENT) {
+			return nil, stmt.Error("struct body may only contain field assignments")
+		}
+
+		assignments = append(assignments, stmt)
+	}
+
+	return assignments, nil
+}
+
+// structFields collects the field assignments of a struct body.
+func structFields(body *Node) ([]structField, error) {
+
+	statements, err := structAssignments(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []structField
+
+	for _, stmt := range statements {
+
+		init, err := RightEval(stmt)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, structField{
+			name: stmt.children[0].Literal(),
+			init: init,
+		})
+	}
+
+	return fields, nil
+}
+
+// newInstance creates a struct with the fields set to their initial values.
+func (st *StructType) newInstance(n *Node) (*Struct, error) {
+
+	s := NewStruct(st.name)
+
+	for _, f := range st.fields {
+		v, err := StandardSingleEval(n, f.init, st.scope)
+		if err != nil {
+			return nil, err
+		}
+		s.Set(f.name, v)
+	}
+
+	return s, nil
+}
+
+// instantiateStruct creates an instance of a struct type. If the struct has a
+// constructor (a method having the name of the struct) it is invoked with the
+// arguments. Otherwise the arguments are assigned to fields by order.
+func instantiateStruct(n *Node, st *StructType, args []Value) ([]Value, error) {
+
+	s, err := st.newInstance(n)
+	if err != nil {
+		return Values(), err
+	}
+
+	if ctor, ok := s.Method(st.name); ok {
+		_, err := callFunction(n, ctor, append(Values(s), args...))
+		return Values(s), err
+	}
+
+	fields := s.Fields()
+	if len(args) > len(fields) {
+		return Values(), n.Error("too many arguments for struct %s, which has %d field(s)", st.name, len(fields))
+	}
+
+	for i, v := range args {
+		s.Set(fields[i], v)
+	}
+
+	return Values(s), nil
+}
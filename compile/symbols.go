@@ -0,0 +1,443 @@
+package compile
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/token"
+)
+
+// Occurrence is a use of a variable in the input, or a binding of it, i.e. a
+// parameter, a channel, or the target of an assignment. Line and Column count
+// from 1.
+type Occurrence struct {
+	Name   string
+	Line   int
+	Column int
+	Binds  bool
+}
+
+// Symbol is a func, a method of a struct, or a struct, named by the variable
+// it's assigned to. Detail describes it, e.g. the parameters of a func. The
+// symbol spans from Line, Column to EndLine, EndColumn, which is just after its
+// closing brace.
+type Symbol struct {
+	Name       string // e.g. Point.String, for a method
+	Kind       string // func, method or struct
+	Detail     string
+	Parameters []string // of a func or method
+	Line       int
+	Column     int
+	EndLine    int
+	EndColumn  int
+}
+
+// Definition finds the variable used at a line and column, and returns where
+// it's first bound.
+func (p *Program) Definition(line, column int) (Occurrence, bool) {
+
+	for _, o := range p.References(line, column) {
+		if o.Binds {
+			return o, true
+		}
+	}
+
+	return Occurrence{}, false
+}
+
+// References finds the variable used at a line and column, and returns all its
+// occurrences, in the order they appear.
+func (p *Program) References(line, column int) []Occurrence {
+
+	x := p.index()
+	if x == nil {
+		return nil
+	}
+
+	v, ok := x.variableAt(line, column)
+	if !ok {
+		return nil
+	}
+
+	return x.occurrences[v]
+}
+
+// Describe describes the variable used at a line and column: for a func, its
+// parameters, as ToString formats them, and otherwise its type, if that can be
+// inferred.
+func (p *Program) Describe(line, column int) (string, bool) {
+
+	x := p.index()
+	if x == nil {
+		return "", false
+	}
+
+	v, ok := x.variableAt(line, column)
+	if !ok {
+		return "", false
+	}
+
+	if f := x.tc.funcs[v]; f != nil && x.tc.assigned[v] == 1 {
+		return v.name + " := " + describeFunc(f), true
+	}
+
+	if typ := x.tc.vars[v]; typ != "" {
+		return v.name + " " + typ, true
+	}
+
+	return v.name, true
+}
+
+// describeFunc formats the parameters and channels of a func.
+func describeFunc(f *Node) string {
+	return ToString(Function{
+		parameters: f.analysis.parameters,
+		channels:   f.analysis.channels,
+	})
+}
+
+// Symbols returns the funcs and structs assigned to variables, and the methods
+// of structs, in the order they appear.
+func (p *Program) Symbols() []Symbol {
+
+	root, _ := p.tree()
+	if root == nil {
+		return nil
+	}
+
+	var symbols []Symbol
+
+	var walk func(n *Node)
+
+	// walkStruct adds a struct, and the funcs assigned to its fields as its
+	// methods.
+	walkStruct := func(n *Node, name string) {
+
+		symbols = append(symbols, symbolOf(n, name, "struct", "struct"))
+
+		body := n.children[len(n.children)-1]
+		statements := []*Node{body}
+		if body.IsToken(token.STMTS) {
+			statements = body.children
+		}
+
+		for _, stmt := range statements {
+			if stmt.IsToken(token.ASSIGN) && len(stmt.children) == 2 && stmt.children[0].IsToken(token.IDENT) {
+				if f := stmt.children[1]; f.IsToken(token.FUNC) && f.analysis != nil {
+					symbols = append(symbols, symbolOf(f, name+"."+stmt.children[0].Literal(), "method", describeFunc(f)))
+					walk(f.analysis.body)
+					continue
+				}
+			}
+			walk(stmt)
+		}
+	}
+
+	walk = func(n *Node) {
+
+		switch {
+		case n.IsToken(token.ASSIGN) && len(n.children) == 2 &&
+			n.children[0].IsToken(token.IDENT) && n.children[1].IsToken(token.STRUCT):
+			walkStruct(n.children[1], n.children[0].Literal())
+			return
+
+		case n.IsToken(token.STRUCT) && len(n.children) == 2:
+			walkStruct(n, n.children[0].Literal())
+			return
+
+		case n.IsToken(token.FUNC) && n.analysis != nil && n.analysis.name != "":
+			symbols = append(symbols, symbolOf(n, n.analysis.name, "func", describeFunc(n)))
+		}
+
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+
+	walk(root)
+
+	return symbols
+}
+
+func symbolOf(n *Node, name, kind, detail string) Symbol {
+
+	end := closingBrace(n.lexeme)
+
+	sym := Symbol{
+		Name:      name,
+		Kind:      kind,
+		Detail:    detail,
+		Line:      n.lexeme.LineNo(),
+		Column:    n.lexeme.CharNo(),
+		EndLine:   end.LineNo(),
+		EndColumn: end.CharNo() + 1,
+	}
+
+	if n.IsToken(token.FUNC) && n.analysis != nil {
+		sym.Parameters = n.analysis.parameters
+	}
+
+	return sym
+}
+
+// closingBrace finds the } closing the block which follows a lexeme, e.g. the
+// body of a func, skipping its parameters and channels. If it can't be found,
+// the lexeme is returned.
+func closingBrace(lex *lexer.Lexeme) lexer.Lexeme {
+
+	if lex == nil || lex.Lexer() == nil {
+		return lexer.Lexeme{}
+	}
+
+	lexemes := lex.Lexer().Lexemes()
+
+	start := -1
+	for i, l := range lexemes {
+		if l.LineNo() == lex.LineNo() && l.CharNo() == lex.CharNo() {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return *lex
+	}
+
+	groups, braces := 0, 0
+	for _, l := range lexemes[start:] {
+		switch l.Token() {
+		case token.LPAREN, token.LSQR:
+			groups++
+		case token.RPAREN, token.RSQR:
+			groups--
+		case token.LBRACE:
+			if groups == 0 {
+				braces++
+			}
+		case token.RBRACE:
+			if groups == 0 {
+				braces--
+				if braces == 0 {
+					return l
+				}
+			}
+		}
+	}
+
+	return *lex
+}
+
+// NamesInScope returns the names which can be used at a line and column: the
+// variables bound in the innermost func there and the scopes enclosing it, and
+// the builtins, sorted.
+func (p *Program) NamesInScope(line, column int) []string {
+
+	x := p.index()
+	if x == nil {
+		return BuiltinNames()
+	}
+
+	scope := x.scopeAt(line, column)
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range scope.boundNames() {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// symbolIndex locates the variables of a program.
+type symbolIndex struct {
+	tc          *typeChecker
+	idents      []identAt
+	occurrences map[variable][]Occurrence
+	scopes      []scopeAt
+}
+
+// identAt is an identifier in the input, and the variable it refers to.
+type identAt struct {
+	lexeme *lexer.Lexeme
+	v      variable
+}
+
+// scopeAt is the extent of a func in the input.
+type scopeAt struct {
+	analysis   *Analysis
+	start, end lexer.Lexeme
+}
+
+// index finds the variable each identifier of a program refers to, following
+// the same rules as ScopeAnalysis. It returns nil for a program without a
+// tree.
+func (p *Program) index() *symbolIndex {
+
+	root, top := p.tree()
+	if root == nil {
+		return nil
+	}
+
+	x := &symbolIndex{
+		tc:          p.typeCheck(),
+		occurrences: make(map[variable][]Occurrence),
+	}
+
+	x.walk(root, top)
+
+	for v, os := range x.occurrences {
+		sort.SliceStable(os, func(i, j int) bool {
+			if os[i].Line != os[j].Line {
+				return os[i].Line < os[j].Line
+			}
+			return os[i].Column < os[j].Column
+		})
+		x.occurrences[v] = os
+	}
+
+	return x
+}
+
+// add notes an occurrence of a variable.
+func (x *symbolIndex) add(n *Node, scope *Analysis, binds bool) {
+
+	if n.lexeme == nil {
+		return
+	}
+
+	v := x.tc.variableOf(n, scope)
+
+	x.idents = append(x.idents, identAt{lexeme: n.lexeme, v: v})
+	x.occurrences[v] = append(x.occurrences[v], Occurrence{
+		Name:   v.name,
+		Line:   n.lexeme.LineNo(),
+		Column: n.lexeme.CharNo(),
+		Binds:  binds,
+	})
+}
+
+func (x *symbolIndex) walk(n *Node, scope *Analysis) {
+
+	switch {
+	case n.IsToken(token.FUNC) && n.analysis != nil:
+		x.scopes = append(x.scopes, scopeAt{
+			analysis: n.analysis,
+			start:    *n.lexeme,
+			end:      closingBrace(n.lexeme),
+		})
+		for _, names := range n.children[:2] {
+			for _, ident := range names.identNodes() {
+				x.add(ident, n.analysis, true)
+			}
+		}
+		x.walk(n.analysis.body, n.analysis)
+		return
+
+	case n.IsToken(token.METHAPPLY) && len(n.children) >= 2:
+		x.walk(n.children[0], scope)
+		x.walkAll(n.children[2:], scope)
+		return
+
+	case n.IsToken(token.PERIOD) && len(n.children) > 0:
+		x.walk(n.children[0], scope)
+		return
+
+	case n.IsToken(token.STRUCT):
+		body := n.children[len(n.children)-1]
+		if len(n.children) == 2 {
+			x.add(n.children[0], scope, true)
+		}
+		statements := []*Node{body}
+		if body.IsToken(token.STMTS) {
+			statements = body.children
+		}
+		for _, stmt := range statements {
+			if stmt.IsToken(token.ASSIGN) && len(stmt.children) == 2 {
+				x.walk(stmt.children[1], scope)
+				continue
+			}
+			x.walk(stmt, scope)
+		}
+		return
+
+	case isTypedNil(n):
+		for _, child := range n.children[1:] {
+			if !(child.IsToken(token.IDENT) && IsBuiltinType(child.Literal())) {
+				x.walk(child, scope)
+			}
+		}
+		return
+
+	case n.IsToken(token.ASSIGN, token.QASSIGN, token.ACCUM) && len(n.children) > 0:
+		for _, t := range assignTargets(n) {
+			if t.IsToken(token.IDENT) {
+				x.add(t, scope, true)
+				continue
+			}
+			x.walk(t, scope)
+		}
+		x.walkAll(n.children[1:], scope)
+		return
+
+	case n.IsToken(token.IDENT):
+		x.add(n, scope, false)
+		return
+	}
+
+	x.walkAll(n.children, scope)
+}
+
+func (x *symbolIndex) walkAll(nodes []*Node, scope *Analysis) {
+	for _, n := range nodes {
+		x.walk(n, scope)
+	}
+}
+
+// variableAt finds the variable of the identifier at a line and column.
+func (x *symbolIndex) variableAt(line, column int) (variable, bool) {
+
+	for _, id := range x.idents {
+		start := id.lexeme.CharNo()
+		end := start + utf8.RuneCountInString(id.lexeme.Literal())
+		if id.lexeme.LineNo() == line && start <= column && column <= end {
+			return id.v, true
+		}
+	}
+
+	return variable{}, false
+}
+
+// scopeAt finds the innermost func containing a line and column, or the top
+// level.
+func (x *symbolIndex) scopeAt(line, column int) *Analysis {
+
+	scope := x.tc.top
+	var inner *scopeAt
+
+	for i := range x.scopes {
+		s := &x.scopes[i]
+		if !within(line, column, s.start, s.end) {
+			continue
+		}
+		if inner == nil || within(s.start.LineNo(), s.start.CharNo(), inner.start, inner.end) {
+			inner = s
+			scope = s.analysis
+		}
+	}
+
+	return scope
+}
+
+// within checks if a line and column lies between two lexemes.
+func within(line, column int, start, end lexer.Lexeme) bool {
+
+	after := line > start.LineNo() || (line == start.LineNo() && column >= start.CharNo())
+	before := line < end.LineNo() || (line == end.LineNo() && column <= end.CharNo())
+
+	return after && before
+}
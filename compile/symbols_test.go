@@ -0,0 +1,146 @@
+package compile_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/u"
+)
+
+const symbolsInput = `total := 0
+add := func(a, b) {
+	sum := a + b
+	total := sum
+	return sum
+}
+x := add(1, 2) + total
+Point := struct {
+	x := 0
+	String := func() { "point" }
+}`
+
+func analyzed(t *testing.T, input string) *compile.Program {
+
+	t.Helper()
+
+	prog, err := compile.Analyze("testing", strings.Split(input, "\n"))
+	if err != nil {
+		t.Fatalf("did not expect error analyzing %q, got: %s", input, err)
+	}
+
+	return prog
+}
+
+func locations(os []compile.Occurrence) string {
+
+	var locs []string
+	for _, o := range os {
+		locs = append(locs, fmt.Sprintf("%d:%d", o.Line, o.Column))
+	}
+
+	return strings.Join(locs, " ")
+}
+
+func TestDefinition(t *testing.T) {
+
+	prog := analyzed(t, symbolsInput)
+
+	tests := []struct {
+		line, column int
+		expected     string
+	}{
+		{3, 10, "2:13"}, // a, a parameter
+		{5, 9, "3:2"},   // sum, a local
+		{7, 6, "2:1"},   // add
+		{7, 18, "1:1"},  // total, the global
+		{4, 2, "4:2"},   // total, the local which hides it
+	}
+
+	for _, test := range tests {
+		def, ok := prog.Definition(test.line, test.column)
+		if !ok {
+			t.Errorf("expected a definition at %d:%d", test.line, test.column)
+			continue
+		}
+		if got := fmt.Sprintf("%d:%d", def.Line, def.Column); got != test.expected {
+			t.Errorf("expected definition at %d:%d to be %s, got %s", test.line, test.column, test.expected, got)
+		}
+	}
+
+	if _, ok := prog.Definition(3, 7); ok {
+		t.Errorf("expected no definition at :=")
+	}
+}
+
+func TestReferences(t *testing.T) {
+
+	prog := analyzed(t, symbolsInput)
+
+	if got := locations(prog.References(1, 1)); got != "1:1 7:18" {
+		t.Errorf("expected references of the global total, got %s", got)
+	}
+
+	if got := locations(prog.References(3, 2)); got != "3:2 4:11 5:9" {
+		t.Errorf("expected references of sum, got %s", got)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+
+	prog := analyzed(t, symbolsInput)
+
+	tests := []struct {
+		line, column int
+		expected     string
+	}{
+		{7, 6, "add := func(a, b)[]{...}"},
+		{1, 1, "total int64"},
+		{3, 10, "a"},
+	}
+
+	for _, test := range tests {
+		got, ok := prog.Describe(test.line, test.column)
+		if !ok || got != test.expected {
+			t.Errorf("expected description at %d:%d to be %q, got %q", test.line, test.column, test.expected, got)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+
+	prog := analyzed(t, symbolsInput)
+
+	var got []string
+	for _, s := range prog.Symbols() {
+		got = append(got, fmt.Sprintf("%s %s %d:%d-%d:%d", s.Kind, s.Name, s.Line, s.Column, s.EndLine, s.EndColumn))
+	}
+
+	expected := []string{
+		"func add 2:8-6:2",
+		"struct Point 8:10-11:2",
+		"method Point.String 10:12-10:30",
+	}
+
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected symbols\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestNamesInScope(t *testing.T) {
+
+	prog := analyzed(t, symbolsInput)
+
+	inside := prog.NamesInScope(4, 1)
+	for _, name := range []string{"a", "b", "sum", "total", "add", "Point"} {
+		if !u.StringIn(name, inside) {
+			t.Errorf("expected %s to be in scope inside add, got %v", name, inside)
+		}
+	}
+
+	outside := prog.NamesInScope(7, 1)
+	if u.StringIn("sum", outside) || !u.StringIn("total", outside) {
+		t.Errorf("expected total but not sum to be in scope at the top level, got %v", outside)
+	}
+}
@@ -0,0 +1,17 @@
+package compile
+
+import "time"
+
+func init() {
+	RegisterBuiltin("now", nowBuiltin)
+}
+
+// now() returns the current time.
+func nowBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "now", args, 0, 0); err != nil {
+		return Values(), err
+	}
+
+	return Values(time.Now()), nil
+}
@@ -0,0 +1,246 @@
+package compile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pdk/gosh/token"
+)
+
+// TraceOptions selects the evaluations a tracer logs.
+type TraceOptions struct {
+	File     *regexp.Regexp // the files to trace, or nil for all
+	Function *regexp.Regexp // the funcs to trace, or nil for all; the top level is <top level>
+	Every    int            // log one in every Every evaluations selected, 0 or 1 for all
+}
+
+// TraceEvent is the evaluation of a node: where it is, the values of its
+// operands, and the values it produced, or the error it failed with. Values
+// are described as they would be printed, shortened if long.
+type TraceEvent struct {
+	Token    string // e.g. IDENT, or FUNCAPPLY
+	Literal  string // e.g. the name of a variable, or an operator
+	File     string
+	Line     int
+	Column   int
+	Function string // the func evaluating the node, or <top level>
+	Depth    int    // of calls
+	Inputs   []string
+	Results  []string
+	Err      error
+}
+
+// maxTraced is the longest description of a value in a trace.
+const maxTraced = 80
+
+// Tracer logs each node a program evaluates.
+type Tracer struct {
+	eval    Evaluator
+	options TraceOptions
+	log     func(TraceEvent)
+
+	evaluating []tracing // the nodes being evaluated, innermost last
+	selected   int       // evaluations selected by the options, so far
+}
+
+// tracing is a node being evaluated, and the values of its operands so far.
+type tracing struct {
+	depth  int  // of calls
+	logged bool // whether it will be logged, when it's evaluated
+	inputs []string
+}
+
+// NewTracer returns a tracer of a program, which logs each evaluation the
+// options select. The nodes of the program are compiled again, to evaluators
+// which log themselves, so runs of the program which aren't traced aren't
+// slowed.
+func NewTracer(p *Program, options TraceOptions, log func(TraceEvent)) (*Tracer, error) {
+
+	if p.root == nil || p.code != nil {
+		return nil, fmt.Errorf("%s is compiled to bytecode, which cannot be traced", p.name)
+	}
+
+	p.root.markTraced()
+
+	eval, err := p.root.Evaluator()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tracer{
+		eval:    eval,
+		options: options,
+		log:     log,
+	}, nil
+}
+
+// markTraced marks the nodes of a tree to be traced. Blocks are not, as their
+// values are those of their last statements.
+func (n *Node) markTraced() {
+
+	n.traced = n.lexeme != nil && !n.IsToken(token.STMTS)
+
+	for _, c := range n.children {
+		c.markTraced()
+	}
+}
+
+// Run runs the program in a scope, logging its evaluations until it returns.
+func (tr *Tracer) Run(vars *Variables) ([]Value, error) {
+
+	calls := vars.callStack()
+	if calls == nil {
+		calls = newCallStack()
+		vars.calls = calls
+	}
+	calls.tracer = tr
+
+	vals, err := tr.eval(vars)
+
+	calls.tracer = nil
+	tr.evaluating = nil
+
+	return vals, err
+}
+
+// traced wraps the evaluator of a node, to log its evaluation if the program
+// is being traced.
+func traced(n *Node, eval Evaluator) Evaluator {
+
+	return func(vars *Variables) ([]Value, error) {
+
+		calls := vars.callStack()
+		if calls == nil || calls.tracer == nil {
+			return eval(vars)
+		}
+
+		tr := calls.tracer
+		tr.enter(n, calls)
+
+		vals, err := eval(vars)
+
+		tr.exit(n, calls, vals, err)
+
+		return vals, err
+	}
+}
+
+// function returns the name of the func running, at the top of the calls.
+func (cs *callStack) function() string {
+
+	if len(cs.calls) == 0 {
+		return "<top level>"
+	}
+
+	return cs.calls[len(cs.calls)-1].name
+}
+
+// file returns the name of the input a node was compiled from.
+func (n *Node) file() string {
+
+	if n.lexeme.Lexer() == nil {
+		return ""
+	}
+
+	return n.lexeme.Lexer().InputName()
+}
+
+// enter notes a node about to be evaluated, deciding whether to log it.
+func (tr *Tracer) enter(n *Node, calls *callStack) {
+
+	logged := (tr.options.File == nil || tr.options.File.MatchString(n.file())) &&
+		(tr.options.Function == nil || tr.options.Function.MatchString(calls.function()))
+
+	if logged {
+		tr.selected++
+		logged = tr.options.Every <= 1 || tr.selected%tr.options.Every == 1
+	}
+
+	tr.evaluating = append(tr.evaluating, tracing{depth: len(calls.calls), logged: logged})
+}
+
+// exit logs a node which has been evaluated, if it was selected, and gives its
+// values to the node it's an operand of, if that's in the same call.
+func (tr *Tracer) exit(n *Node, calls *callStack, vals []Value, err error) {
+
+	t := tr.evaluating[len(tr.evaluating)-1]
+	tr.evaluating = tr.evaluating[:len(tr.evaluating)-1]
+
+	var results []string
+	if len(tr.evaluating) > 0 {
+		parent := &tr.evaluating[len(tr.evaluating)-1]
+		if parent.logged && parent.depth == t.depth && err == nil {
+			results = describeTraced(vals)
+			parent.inputs = append(parent.inputs, results...)
+		}
+	}
+
+	if !t.logged {
+		return
+	}
+
+	if results == nil && err == nil {
+		results = describeTraced(vals)
+	}
+
+	tr.log(TraceEvent{
+		Token:    n.Token().String(),
+		Literal:  n.Literal(),
+		File:     n.file(),
+		Line:     n.lexeme.LineNo(),
+		Column:   n.lexeme.CharNo(),
+		Function: calls.function(),
+		Depth:    t.depth,
+		Inputs:   t.inputs,
+		Results:  results,
+		Err:      err,
+	})
+}
+
+// describeTraced describes values for a trace, including those which change
+// the flow of control, e.g. a return of values.
+func describeTraced(vals []Value) []string {
+
+	var descs []string
+
+	for _, v := range vals {
+		descs = append(descs, describeTracedValue(v))
+	}
+
+	return descs
+}
+
+func describeTracedValue(v Value) string {
+
+	control, ok := v.(ControlValue)
+	if !ok {
+		return shorten(describe(v))
+	}
+
+	switch {
+	case control.which == ControlBreak:
+		return "break"
+	case control.which == ControlContinue:
+		return "continue"
+	case control.tail != nil:
+		name := control.tail.fn.name
+		if name == "" {
+			name = "func"
+		}
+		return fmt.Sprintf("return %s(%s)", name, strings.Join(describeTraced(control.tail.args), ", "))
+	}
+
+	return "return " + strings.Join(describeTraced(control.returnValues), ", ")
+}
+
+// shorten shortens a description longer than maxTraced.
+func shorten(s string) string {
+
+	runes := []rune(s)
+	if len(runes) <= maxTraced {
+		return s
+	}
+
+	return string(runes[:maxTraced-3]) + "..."
+}
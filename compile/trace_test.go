@@ -0,0 +1,105 @@
+package compile_test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+)
+
+// trace runs the input, returning the events logged, each described as
+// "line:column TOKEN function depth (inputs) => results".
+func trace(t *testing.T, input string, options compile.TraceOptions) []string {
+
+	t.Helper()
+
+	prog, err := compile.Compile("testing", strings.Split(input, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	tr, err := compile.NewTracer(prog, options, func(e compile.TraceEvent) {
+		s := fmt.Sprintf("%d:%d %s %s %d (%s) => %s", e.Line, e.Column, e.Token, e.Function, e.Depth,
+			strings.Join(e.Inputs, ", "), strings.Join(e.Results, ", "))
+		if e.Err != nil {
+			s += " error"
+		}
+		events = append(events, s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Run(compile.GlobalScope())
+
+	// running the program untraced logs nothing
+	logged := len(events)
+	if _, err := prog.Run(compile.GlobalScope()); err == nil && len(events) != logged {
+		t.Errorf("expected an untraced run to log nothing, got %s", events[logged:])
+	}
+
+	return events
+}
+
+func TestTracer(t *testing.T) {
+
+	input := `square := func(x) {
+	return x * x
+}
+total := square(3) + 1`
+
+	expected := `1:11 FUNC <top level> 0 () => func(x)[]{...}
+1:8 ASSIGN <top level> 0 (func(x)[]{...}) => func(x)[]{...}
+4:10 IDENT <top level> 0 () => func(x)[]{...}
+4:17 INT <top level> 0 () => 3
+2:9 IDENT square 1 () => 3
+2:13 IDENT square 1 () => 3
+2:11 MULT square 1 (3, 3) => 9
+2:2 RETURN square 1 (9) => return 9
+4:16 FUNCAPPLY <top level> 0 (func(x)[]{...}, 3) => 9
+4:22 INT <top level> 0 () => 1
+4:20 PLUS <top level> 0 (9, 1) => 10
+4:7 ASSIGN <top level> 0 (10) => 10`
+
+	got := trace(t, input, compile.TraceOptions{})
+	if strings.Join(got, "\n") != expected {
+		t.Errorf("expected trace\n%s\ngot\n%s", expected, strings.Join(got, "\n"))
+	}
+
+	// evaluations are counted as they start: return, *, x, x
+	got = trace(t, input, compile.TraceOptions{Function: regexp.MustCompile("^square$"), Every: 2})
+	expected = `2:9 IDENT square 1 () => 3
+2:2 RETURN square 1 (9) => return 9`
+	if strings.Join(got, "\n") != expected {
+		t.Errorf("expected every other evaluation in square\n%s\ngot\n%s", expected, strings.Join(got, "\n"))
+	}
+
+	got = trace(t, input, compile.TraceOptions{File: regexp.MustCompile("other")})
+	if len(got) != 0 {
+		t.Errorf("expected no trace of other files, got %s", got)
+	}
+}
+
+func TestTracerErrors(t *testing.T) {
+
+	got := trace(t, `f := func(x) { x / 0 }
+f(1)`, compile.TraceOptions{Function: regexp.MustCompile("^f$")})
+
+	expected := `1:16 IDENT f 1 () => 1
+1:20 INT f 1 () => 0
+1:18 DIV f 1 (1, 0) =>  error`
+	if strings.Join(got, "\n") != expected {
+		t.Errorf("expected trace\n%s\ngot\n%s", expected, strings.Join(got, "\n"))
+	}
+
+	bytecode, err := compile.CompileBytecode("testing", []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compile.NewTracer(bytecode, compile.TraceOptions{}, func(compile.TraceEvent) {}); err == nil {
+		t.Errorf("expected an error tracing bytecode")
+	}
+}
@@ -0,0 +1,76 @@
+package compile
+
+import (
+	"fmt"
+	"time"
+)
+
+// builtinTypes are the names of the types which are not structs.
+var builtinTypes = map[string]bool{
+	"bool":    true,
+	"int64":   true,
+	"float64": true,
+	"string":  true,
+	"rune":    true,
+	"list":    true,
+	"map":     true,
+	"func":    true,
+	"error":   true,
+	"time":    true,
+	"type":    true,
+}
+
+// IsBuiltinType checks if a name is the name of a builtin (non-struct) type.
+func IsBuiltinType(name string) bool {
+	return builtinTypes[name]
+}
+
+// TypeName returns the name of the type of a value. The type of a struct is
+// the name of the struct.
+func TypeName(v Value) string {
+
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case int64:
+		return "int64"
+	case float64:
+		return "float64"
+	case string:
+		return "string"
+	case rune:
+		return "rune"
+	case *List:
+		return "list"
+	case *Map:
+		return "map"
+	case *Struct:
+		return v.typeName
+	case Function, Builtin:
+		return "func"
+	case *ErrorValue:
+		return "error"
+	case time.Time:
+		return "time"
+	case *StructType:
+		return "type"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func init() {
+	RegisterBuiltin("type", typeBuiltin)
+}
+
+// type(v) returns the name of the type of a value.
+func typeBuiltin(n *Node, args []Value) ([]Value, error) {
+
+	if err := ArgCount(n, "type", args, 1, 1); err != nil {
+		return Values(), err
+	}
+
+	return Values(TypeName(args[0])), nil
+}
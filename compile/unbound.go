@@ -0,0 +1,124 @@
+package compile
+
+import (
+	"sort"
+)
+
+// Unbound is a use of an identifier which is not bound in any enclosing scope,
+// nor a builtin, with the names it might be a typo of.
+type Unbound struct {
+	Name        string
+	Line        int
+	Column      int
+	Suggestions []string
+	node        *Node
+}
+
+// Unbound returns the uses of identifiers which are not bound anywhere, in the
+// order they appear. A program read from a .goshc file has no tree, and
+// reports none.
+func (p *Program) Unbound() []Unbound {
+	return p.check().unbound
+}
+
+// boundNames returns the names bound in the scope and its ancestors, and the
+// builtins.
+func (a *Analysis) boundNames() []string {
+
+	names := BuiltinNames()
+
+	for scope := a; scope != nil; scope = scope.parent {
+		names = append(names, scope.parameters...)
+		names = append(names, scope.channels...)
+		for l := range scope.locals {
+			names = append(names, l)
+		}
+	}
+
+	return names
+}
+
+// maxSuggestions limits how many names are suggested for a typo.
+const maxSuggestions = 3
+
+// suggestions returns the candidates which are close to a name, closest first.
+func suggestions(name string, candidates []string) []string {
+
+	maxDistance := len(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	distances := make(map[string]int)
+	var close []string
+
+	for _, c := range candidates {
+		if _, seen := distances[c]; seen || c == name {
+			continue
+		}
+		d := editDistance(name, c)
+		distances[c] = d
+		if d <= maxDistance {
+			close = append(close, c)
+		}
+	}
+
+	sort.Slice(close, func(i, j int) bool {
+		di, dj := distances[close[i]], distances[close[j]]
+		if di != dj {
+			return di < dj
+		}
+		return close[i] < close[j]
+	})
+
+	if len(close) > maxSuggestions {
+		close = close[:maxSuggestions]
+	}
+
+	return close
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent characters needed to change a into b.
+func editDistance(a, b string) int {
+
+	ra, rb := []rune(a), []rune(b)
+
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+
+	m := first
+	for _, x := range rest {
+		if x < m {
+			m = x
+		}
+	}
+
+	return m
+}
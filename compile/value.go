@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-
-	"reflect"
 )
 
 // Value is a value that is the result of an evaluation.
@@ -33,15 +31,19 @@ const (
 type ControlValue struct {
 	which        ControlType
 	returnValues []Value
+	tail         *tailCall // a call whose results are returned, see TailCallValue
 }
 
 // Function is a evaluatable thing.
 type Function struct {
+	name       string // the name the function was defined with, or ""
 	parameters []string
 	channels   []string
-	locals     []string
-	captured   *Variables
+	frameSize  int        // number of slots in a frame of the function
+	slotNames  []string   // the names of the variables in the slots of a frame
+	scope      *Variables // where the function was defined
 	body       Evaluator
+	code       *FuncCode // the bytecode of the function, if compiled to bytecode
 }
 
 // BreakValue constructs a ControlBreak Value.
@@ -113,6 +115,14 @@ func ToString(v Value) string {
 	}
 
 	switch v2 := v.(type) {
+	case Builtin:
+		return fmt.Sprintf("builtin %s(...)", v2.name)
+	case *ErrorValue:
+		return v2.Error()
+	case *List, *Map, *Struct:
+		return collectionString(v2, make(map[Value]bool))
+	case *StructType:
+		return "struct " + v2.name
 	case bool:
 		if v2 {
 			return "true"
@@ -122,6 +132,8 @@ func ToString(v Value) string {
 		return strconv.FormatInt(v2, 10)
 	case float64:
 		return strconv.FormatFloat(v2, 'f', -1, 64)
+	case rune:
+		return string(v2)
 	default:
 		return fmt.Sprintf("%s", v)
 	}
@@ -142,99 +154,3 @@ func IsTruthy(v interface{}) bool {
 	// not nil, not a bool, so it's a non-nil value, aka "truthy"
 	return true
 }
-
-// EqualValues returns true/false if the two values are equal. If they are of
-// different types, return an error.
-func EqualValues(left, right Value) (bool, error) {
-
-	if reflect.TypeOf(left) != reflect.TypeOf(right) {
-		return false, fmt.Errorf("cannot compare values of different types")
-	}
-
-	return left == right, nil
-}
-
-// NotEqualValues returns true/false if the two values are not equal. If they are of
-// different types, return an error.
-func NotEqualValues(left, right Value) (bool, error) {
-
-	r, err := EqualValues(left, right)
-
-	return !r, err
-}
-
-// LessThanEqualValue returns true/false and/or an error.
-func LessThanEqualValue(left, right Value) (bool, error) {
-
-	if reflect.TypeOf(left) != reflect.TypeOf(right) {
-		return false, fmt.Errorf("cannot compare values of different types, %T and %T", left, right)
-	}
-
-	switch lv := left.(type) {
-	case int64:
-		return lv <= right.(int64), nil
-	case float64:
-		return lv <= right.(float64), nil
-	case string:
-		return lv <= right.(string), nil
-	}
-
-	return false, fmt.Errorf("don't know how to compare those types: %T, %T", left, right)
-}
-
-// GreaterThanEqualValue returns true/false and/or an error.
-func GreaterThanEqualValue(left, right Value) (bool, error) {
-
-	if reflect.TypeOf(left) != reflect.TypeOf(right) {
-		return false, fmt.Errorf("cannot compare values of different types, %T and %T", left, right)
-	}
-
-	switch lv := left.(type) {
-	case int64:
-		return lv >= right.(int64), nil
-	case float64:
-		return lv >= right.(float64), nil
-	case string:
-		return lv >= right.(string), nil
-	}
-
-	return false, fmt.Errorf("don't know how to compare those types: %T, %T", left, right)
-}
-
-// GreaterThanValue returns true/false and/or an error.
-func GreaterThanValue(left, right Value) (bool, error) {
-
-	if reflect.TypeOf(left) != reflect.TypeOf(right) {
-		return false, fmt.Errorf("cannot compare values of different types, %T and %T", left, right)
-	}
-
-	switch lv := left.(type) {
-	case int64:
-		return lv > right.(int64), nil
-	case float64:
-		return lv > right.(float64), nil
-	case string:
-		return lv > right.(string), nil
-	}
-
-	return false, fmt.Errorf("don't know how to compare those types: %T, %T", left, right)
-}
-
-// LessThanValue returns true/false and/or an error.
-func LessThanValue(left, right Value) (bool, error) {
-
-	if reflect.TypeOf(left) != reflect.TypeOf(right) {
-		return false, fmt.Errorf("cannot compare values of different types, %T and %T", left, right)
-	}
-
-	switch lv := left.(type) {
-	case int64:
-		return lv < right.(int64), nil
-	case float64:
-		return lv < right.(float64), nil
-	case string:
-		return lv < right.(string), nil
-	}
-
-	return false, fmt.Errorf("don't know how to compare those types: %T, %T", left, right)
-}
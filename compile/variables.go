@@ -2,43 +2,124 @@ package compile
 
 import (
 	"fmt"
-	"reflect"
 )
 
-// Variables is a standard value-by-name store.
+// Variables is a scope of variables. The global scope, and any child scopes
+// made by NewScope, store values by name. Each name is bound to a cell, which
+// may be shared with other scopes.
+//
+// A call of a func gets a frame, which stores the func's parameters, channels
+// and locals in slots, at the addresses given by Resolve. The parent of a frame
+// is the scope in which the func was defined, so closures see the variables of
+// enclosing funcs.
 type Variables struct {
-	values map[string]*Value
+	values map[string]*Binding
+	slots  []Binding
 	parent *Variables
+	calls  *callStack // shared by all the scopes of a program
+	names  []string   // of the slots of a frame, for debugging
 }
 
-// GlobalScope returns a new global scope map.
+// Binding is a cell holding the value of a variable. A variable's type is set
+// by the first non-nil value assigned, or declared with a typed nil, and then
+// cannot change.
+type Binding struct {
+	value    Value
+	declared string // the type of the variable, "" if not yet known
+}
+
+// GlobalScope returns a new global scope map, with the builtins installed.
 func GlobalScope() *Variables {
 	v := Variables{
-		values: make(map[string]*Value),
+		values: make(map[string]*Binding),
+		calls:  newCallStack(),
 	}
+
+	for name, b := range builtins {
+		v.values[name] = newBinding(b)
+	}
+
 	return &v
 }
 
 // NewScope returns a new child variable scope.
 func NewScope(parent *Variables) *Variables {
 	v := Variables{
-		values: make(map[string]*Value),
+		values: make(map[string]*Binding),
 		parent: parent,
+		calls:  parent.callStack(),
 	}
 
 	return &v
 }
 
-// Reference returns a reference (pointer) to a value.
-func (v *Variables) Reference(name string) (*Value, error) {
+// NewFrame returns a new frame with the given number of slots.
+func NewFrame(parent *Variables, size int) *Variables {
+	return &Variables{
+		slots:  make([]Binding, size),
+		parent: parent,
+		calls:  parent.callStack(),
+	}
+}
+
+// callStack returns the call stack of the scope, if any.
+func (v *Variables) callStack() *callStack {
+
+	if v == nil {
+		return nil
+	}
+
+	return v.calls
+}
+
+// newBinding returns a new cell, typed by the value if it is not nil.
+func newBinding(val Value) *Binding {
+
+	b := &Binding{value: val}
+	if val != nil {
+		b.declared = TypeName(val)
+	}
+
+	return b
+}
+
+// Value returns the current value of the binding.
+func (b *Binding) Value() Value {
+	return b.value
+}
+
+// DeclaredType returns the type of the binding, or "" if not yet known.
+func (b *Binding) DeclaredType() string {
+	return b.declared
+}
+
+// set updates the value of the cell, checking the type does not change.
+func (b *Binding) set(name string, val Value) error {
+
+	if val != nil {
+		t := TypeName(val)
+		if b.declared != "" && b.declared != t {
+			return fmt.Errorf("attempt to convert variable %s from type %s to type %s",
+				name, b.declared, t)
+		}
+		b.declared = t
+	}
+
+	b.value = val
+
+	return nil
+}
+
+// Reference returns a reference to the binding of a variable.
+func (v *Variables) Reference(name string) (*Binding, error) {
 
 	if v == nil {
 		return nil, fmt.Errorf("attempt to access undefined variable %s", name)
 	}
 
-	val, ok := v.values[name]
+	b, ok := v.values[name]
 	if ok {
-		return val, nil
+		return b, nil
 	}
 
 	return v.parent.Reference(name)
@@ -47,39 +128,125 @@ func (v *Variables) Reference(name string) (*Value, error) {
 // Value returns the value for the given name.
 func (v *Variables) Value(name string) (Value, error) {
 
-	if v == nil {
-		return nil, fmt.Errorf("attempt to access undefined variable %s", name)
+	b, err := v.Reference(name)
+	if err != nil {
+		return nil, err
 	}
 
-	val, ok := v.values[name]
-	if ok {
-		return *val, nil
+	return b.value, nil
+}
+
+// SetRef binds a name to an existing cell, e.g. one shared with another scope.
+func (v *Variables) SetRef(name string, b *Binding) {
+	v.values[name] = b
+}
+
+// SetSlot binds a slot of a frame to a new value, without regard to the type
+// of any previous value. It is used to pass arguments to a call.
+func (v *Variables) SetSlot(slot int, val Value) {
+	v.slots[slot] = *newBinding(val)
+}
+
+// frame returns the frame depth levels out from this one.
+func (v *Variables) frame(depth int) *Variables {
+
+	for ; depth > 0; depth-- {
+		v = v.parent
 	}
 
-	return v.parent.Value(name)
+	return v
 }
 
-// SetRef sets a variable reference (pointer).
-func (v *Variables) SetRef(name string, val *Value) {
-	v.values[name] = val
+// named returns the nearest scope that stores values by name.
+func (v *Variables) named() *Variables {
+
+	for v.values == nil {
+		v = v.parent
+	}
+
+	return v
 }
 
-// Set will set a value in the variable map.
-func (v *Variables) Set(name string, val Value) (Value, error) {
+// ReferenceAt returns a reference to the binding of a variable at an address.
+// Without an address, the name is looked up through the scope chain.
+func (v *Variables) ReferenceAt(name string, addr *Address) (*Binding, error) {
+
+	if addr == nil || addr.Global() {
+		return v.Reference(name)
+	}
 
-	cur, ok := v.values[name]
+	return &v.frame(addr.Depth).slots[addr.Slot], nil
+}
+
+// SetAt sets the value of a variable at an address. A global variable which is
+// not yet bound is created in the nearest scope that stores values by name.
+func (v *Variables) SetAt(name string, addr *Address, val Value) error {
+
+	if addr == nil || addr.Global() {
+		if v.values == nil {
+			if b, err := v.Reference(name); err == nil {
+				return b.set(name, val)
+			}
+		}
+		_, err := v.named().Set(name, val)
+		return err
+	}
 
-	if !ok || *cur == nil {
-		v.values[name] = &val
+	return v.frame(addr.Depth).slots[addr.Slot].set(name, val)
+}
+
+// DeclareAt sets the type of a variable at an address, with a nil value.
+func (v *Variables) DeclareAt(name string, addr *Address, typeName string) error {
+
+	if addr == nil || addr.Global() {
+		if v.values == nil {
+			if b, err := v.Reference(name); err == nil {
+				return b.declare(name, typeName)
+			}
+		}
+		return v.named().Declare(name, typeName)
+	}
+
+	return v.frame(addr.Depth).slots[addr.Slot].declare(name, typeName)
+}
+
+// Set will set a value in the variable map. If the name is already bound in
+// this scope, the value is updated in place, so that all scopes sharing the
+// cell see the update.
+func (v *Variables) Set(name string, val Value) (Value, error) {
+
+	b, ok := v.values[name]
+	if !ok {
+		v.values[name] = newBinding(val)
 		return val, nil
 	}
 
-	if reflect.TypeOf(*cur) != reflect.TypeOf(val) {
-		return val, fmt.Errorf("attempt to convert variable %s from type %T to type %T",
-			name, *cur, val)
+	return val, b.set(name, val)
+}
+
+// Declare sets the type of a variable, with a nil value. It is an error if
+// the variable already has a different type.
+func (v *Variables) Declare(name, typeName string) error {
+
+	b, ok := v.values[name]
+	if !ok {
+		v.values[name] = &Binding{declared: typeName}
+		return nil
+	}
+
+	return b.declare(name, typeName)
+}
+
+// declare sets the type of the cell, with a nil value.
+func (b *Binding) declare(name, typeName string) error {
+
+	if b.declared != "" && b.declared != typeName {
+		return fmt.Errorf("attempt to convert variable %s from type %s to type %s",
+			name, b.declared, typeName)
 	}
 
-	*cur = val
+	b.declared = typeName
+	b.value = nil
 
-	return val, nil
+	return nil
 }
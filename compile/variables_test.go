@@ -0,0 +1,129 @@
+package compile_test
+
+import "testing"
+
+func TestTypeStickiness(t *testing.T) {
+
+	checkEval(t, `x := 1; x := 2; x`, "2")
+	checkEval(t, `x := nil; x := "a"; x`, "a")
+	checkEval(t, `x := "a"; x := nil; x := "b"; x`, "b")
+
+	checkEvalErr(t, `x := 1; x := "a"`, `testing:1:11: x := 1; x := "a": attempt to convert variable x from type int64 to type string`)
+	checkEvalErr(t, `x := 1; x := nil; x := 1.5`, "attempt to convert variable x from type int64 to type float64")
+	checkEvalErr(t, `
+		x := nil
+		x := [1]
+		x := "a"`,
+		"testing:4:5")
+	checkEvalErr(t, `
+		struct a { v := 0 }
+		struct b { v := 0 }
+		x := a()
+		x := b()`,
+		"attempt to convert variable x from type a to type b")
+}
+
+func TestTypedNil(t *testing.T) {
+
+	checkEval(t, `x := nil(int64); x`, "nil")
+	checkEval(t, `x := nil(string); x := "a"; x`, "a")
+	checkEval(t, `struct p { v := 0 }; x := nil(p); x := p(1); x.v`, "1")
+	checkEval(t, `
+		struct binaryTree {
+			value := ""
+			left := nil(binaryTree)
+			right := nil(binaryTree)
+		}
+		t := binaryTree("a")
+		t.left := binaryTree("b")
+		t.left.value, t.right`,
+		"b, nil")
+	checkEval(t, `f := func() { y := nil(list); return y }; f()`, "nil")
+
+	checkEvalErr(t, `x := nil(int64); x := "a"`, "attempt to convert variable x from type int64 to type string")
+	checkEvalErr(t, `x := 1; x := nil(string)`, "testing:1:11: x := 1; x := nil(string): attempt to convert variable x from type int64 to type string")
+	checkEvalErr(t, `x := nil(nope)`, "nope is not a type")
+}
+
+func TestClosureSharesVariables(t *testing.T) {
+
+	checkEval(t, `
+		x := nil
+		get := func() { return x }
+		x := 5
+		get()`,
+		"5")
+
+	checkEval(t, `
+		n := 0
+		inc := func() {
+			extern n
+			n := n + 1
+		}
+		inc()
+		inc()
+		n`,
+		"2")
+
+	checkEvalErr(t, `
+		n := 0
+		bad := func() {
+			extern n
+			n := "x"
+		}
+		bad()`,
+		"testing:5:6")
+}
+
+func TestRecursionAndForwardReferences(t *testing.T) {
+
+	checkEval(t, `
+		fact := func(n) {
+			if n <= 1 { return 1 }
+			return n * fact(n - 1)
+		}
+		fact(10)`,
+		"3628800")
+
+	checkEval(t, `
+		f := func() { return later() }
+		later := func() { return 42 }
+		f()`,
+		"42")
+
+	checkEvalErr(t, `f := func() { return nope }; f()`, "attempt to access undefined variable nope")
+}
+
+func TestNestedClosures(t *testing.T) {
+
+	checkEval(t, `
+		adder := func(a) { func(b) { func(c) { a + b + c } } }
+		adder(1)(2)(3)`,
+		"6")
+
+	checkEval(t, `
+		counter := func() {
+			c := 0
+			func() {
+				extern c
+				c := c + 1
+			}
+		}
+		inc := counter()
+		other := counter()
+		inc()
+		inc()
+		other()
+		inc()`,
+		"3")
+
+	checkEval(t, `
+		f := func() {
+			struct pt { x := 1; y := 2 }
+			p := pt(5, 6)
+			q := nil(pt)
+			p.x + p.y
+		}
+		f()`,
+		"11")
+}
@@ -0,0 +1,583 @@
+package compile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pdk/gosh/token"
+)
+
+// handlerKind is what a handler does with an error.
+type handlerKind int
+
+const (
+	tryHandler     handlerKind = iota // convert the error to an error value
+	resolveHandler                    // report failing to resolve a function
+	locateHandler                     // give the error a location
+)
+
+// handler is an active OpTry, OpResolve or OpLocate. If an error occurs, the
+// stacks are restored to how they were when the handler began.
+type handler struct {
+	kind   handlerKind
+	target int // where to continue, for try
+	height int // height of the value stack
+	marks  int // height of the mark stack
+	site   *Node
+}
+
+// machineFrame is a Code being run by the machine: the top level of a program,
+// or a call of a function.
+type machineFrame struct {
+	code     *Code
+	pc       int
+	vars     *Variables
+	base     int // height of the value stack when the frame began
+	markBase int // height of the mark stack when the frame began
+	handlers []handler
+}
+
+// machine runs bytecode. Calls of functions compiled to bytecode push a new
+// frame, rather than recursing in go. Each frame but the first is also on the
+// call stack.
+type machine struct {
+	stack  []Value
+	marks  []int
+	frames []*machineFrame
+	calls  *callStack
+}
+
+var binaryOperationTable [token.TransformResultsEnd]binaryOperation
+var unaryOperationTable [token.TransformResultsEnd]unaryOperation
+
+func init() {
+	for tok, op := range binaryOperations {
+		binaryOperationTable[tok] = op
+	}
+	for tok, op := range unaryOperations {
+		unaryOperationTable[tok] = op
+	}
+}
+
+// execute runs code in the given scope, and returns the values it returns.
+func execute(code *Code, vars *Variables) ([]Value, error) {
+
+	m := &machine{
+		stack: make([]Value, 0, 16),
+		calls: vars.callStack(),
+	}
+
+	m.frames = append(m.frames, &machineFrame{
+		code: code,
+		vars: vars,
+	})
+
+	return m.run()
+}
+
+// node returns the node of a site, or nil.
+func (c *Code) node(site int) *Node {
+
+	if site < 0 {
+		return nil
+	}
+
+	return c.nodes[site]
+}
+
+func (m *machine) push(v Value) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *machine) pop() Value {
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+// popMark pops the top mark, and returns it.
+func (m *machine) popMark() int {
+	mark := m.marks[len(m.marks)-1]
+	m.marks = m.marks[:len(m.marks)-1]
+	return mark
+}
+
+// popGroup pops the values above the top mark, and the mark.
+func (m *machine) popGroup() []Value {
+
+	start := m.popMark()
+
+	group := make([]Value, len(m.stack)-start)
+	copy(group, m.stack[start:])
+	m.stack = m.stack[:start]
+
+	return group
+}
+
+func (m *machine) run() ([]Value, error) {
+
+	f := m.frames[len(m.frames)-1]
+
+	for {
+		ins := &f.code.Instructions[f.pc]
+		site := f.code.node(ins.Site)
+		f.pc++
+
+		var err error
+
+		switch ins.Op {
+		case OpNil:
+			m.push(nil)
+
+		case OpConst:
+			m.push(f.code.Constants[ins.A])
+
+		case OpLoadLocal:
+			m.push(f.vars.slots[ins.A].value)
+
+		case OpLoadOuter:
+			m.push(f.vars.frame(ins.A).slots[ins.B].value)
+
+		case OpLoadGlobal:
+			var v Value
+			v, err = f.vars.Value(f.code.Constants[ins.A].(string))
+			if err == nil {
+				m.push(v)
+			} else {
+				err = site.LocateError(err)
+			}
+
+		case OpStore:
+			ref := f.code.Vars[ins.A]
+			err = f.vars.SetAt(ref.Name, ref.Address, m.pop())
+			if err != nil {
+				err = site.LocateError(err)
+			}
+
+		case OpTypeOf:
+			ref := f.code.Vars[ins.A]
+			var typ string
+			typ, err = namedType(site, f.vars, ref.Name, ref.Address)
+			if err == nil {
+				m.push(typ)
+			}
+
+		case OpDeclare:
+			ref := f.code.Vars[ins.A]
+			err = f.vars.DeclareAt(ref.Name, ref.Address, m.pop().(string))
+			if err != nil {
+				err = site.Error("%s", err)
+			} else {
+				m.push(nil)
+			}
+
+		case OpPop:
+			m.stack = m.stack[:len(m.stack)-ins.A]
+
+		case OpCopy:
+			m.push(m.stack[len(m.stack)-1-ins.A])
+
+		case OpMark:
+			m.marks = append(m.marks, len(m.stack))
+
+		case OpDrop:
+			m.stack = m.stack[:m.popMark()]
+
+		case OpPopMark:
+			m.popMark()
+
+		case OpSingle:
+			if count := len(m.stack) - m.popMark(); count != 1 {
+				err = site.Error("expected single value but got %d", count)
+			}
+
+		case OpCount:
+			if count := len(m.stack) - m.popMark(); count != ins.A {
+				err = site.Error("count of variables on left does not match number of results on right side")
+			}
+
+		case OpCallee:
+			if count := len(m.stack) - m.popMark(); count != 1 {
+				err = site.Error("cannot apply multiple values as a function")
+			}
+
+		case OpBinary:
+			right := m.pop()
+			left := m.pop()
+			var v Value
+			v, err = binaryOperationTable[ins.A](site, left, right)
+			if err == nil {
+				m.push(v)
+			}
+
+		case OpUnary:
+			var v Value
+			v, err = unaryOperationTable[ins.A](site, m.pop())
+			if err == nil {
+				m.push(v)
+			}
+
+		case OpJump:
+			f.pc = ins.A
+
+		case OpJumpFalse:
+			if !IsTruthy(m.stack[len(m.stack)-1]) {
+				f.pc = ins.A
+			} else {
+				m.pop()
+			}
+
+		case OpJumpTrue:
+			if IsTruthy(m.stack[len(m.stack)-1]) {
+				f.pc = ins.A
+			} else {
+				m.pop()
+			}
+
+		case OpConcat:
+			var sb strings.Builder
+			for _, v := range m.stack[len(m.stack)-ins.A:] {
+				sb.WriteString(ToString(v))
+			}
+			m.stack = m.stack[:len(m.stack)-ins.A]
+			m.push(sb.String())
+
+		case OpList:
+			m.push(NewList(m.popGroup()...))
+
+		case OpIndex:
+			index := m.pop()
+			var v Value
+			v, err = indexValue(site, f.vars, m.pop(), index)
+			if err == nil {
+				m.push(v)
+			}
+
+		case OpSlice:
+			high := m.pop()
+			low := m.pop()
+			var v Value
+			v, err = sliceValue(site, m.pop(), low, high)
+			if err == nil {
+				m.push(v)
+			}
+
+		case OpField:
+			var v Value
+			v, err = fieldOf(site, m.pop(), f.code.Constants[ins.A].(string))
+			if err == nil {
+				m.push(v)
+			}
+
+		case OpSetField:
+			v := m.pop()
+			err = setField(site, m.pop(), f.code.Constants[ins.A].(string), v)
+
+		case OpSetIndex:
+			v := m.pop()
+			index := m.pop()
+			err = setIndex(site, f.vars, m.pop(), index, v)
+
+		case OpCall:
+			args := m.popGroup()
+			err = m.call(site, m.pop(), args)
+
+		case OpMethod:
+			args := m.popGroup()
+			err = m.method(site, f.vars, m.pop(), f.code.Constants[ins.A].(string), args)
+
+		case OpClosure:
+			m.push(closure(f.code.Funcs[ins.A], f.vars))
+
+		case OpStruct:
+			sc := f.code.Structs[ins.A]
+			var addr *Address
+			if sc.Var >= 0 {
+				addr = f.code.Vars[sc.Var].Address
+			}
+			var v Value
+			v, err = defineStruct(site, f.vars, sc.Name, addr, sc.structFields())
+			if err == nil {
+				m.push(v)
+			}
+
+		case OpTry, OpResolve, OpLocate:
+			kind := tryHandler
+			switch ins.Op {
+			case OpResolve:
+				kind = resolveHandler
+			case OpLocate:
+				kind = locateHandler
+			}
+			f.handlers = append(f.handlers, handler{
+				kind:   kind,
+				target: ins.A,
+				height: len(m.stack),
+				marks:  len(m.marks),
+				site:   site,
+			})
+
+		case OpPopHandler:
+			f.handlers = f.handlers[:len(f.handlers)-1]
+
+		case OpUnwind:
+			mark := f.markBase + ins.A
+			m.stack = m.stack[:m.marks[mark]]
+			m.marks = m.marks[:mark+1]
+			f.handlers = f.handlers[:ins.B]
+
+		case OpReturn:
+			if results, done := m.ret(); done {
+				return results, nil
+			}
+
+		case OpTailCall:
+			args := m.popGroup()
+			fn := m.pop()
+			if g, ok := fn.(Function); ok && g.code != nil {
+				err = m.tailCall(site, g, args)
+				break
+			}
+			err = m.call(site, fn, args)
+			if err == nil {
+				if results, done := m.ret(); done {
+					return results, nil
+				}
+			}
+
+		default:
+			err = fmt.Errorf("unknown instruction %s", ins.Op)
+		}
+
+		if err != nil {
+			err = m.recover(m.calls.attach(err))
+			if err != nil {
+				return Values(), err
+			}
+		}
+
+		f = m.frames[len(m.frames)-1]
+	}
+}
+
+// ret returns from the current frame the values above the top mark, or all
+// its values if it has no marks. It reports whether that was the first frame,
+// which ends the run.
+func (m *machine) ret() ([]Value, bool) {
+
+	f := m.frames[len(m.frames)-1]
+
+	start := f.base
+	if len(m.marks) > f.markBase {
+		start = m.marks[len(m.marks)-1]
+	}
+
+	results := make([]Value, len(m.stack)-start)
+	copy(results, m.stack[start:])
+
+	m.stack = m.stack[:f.base]
+	m.marks = m.marks[:f.markBase]
+	m.popFrame()
+
+	if len(m.frames) == 0 {
+		return results, true
+	}
+
+	m.stack = append(m.stack, results...)
+
+	return nil, false
+}
+
+// popFrame removes the current frame, and its call.
+func (m *machine) popFrame() {
+
+	m.frames = m.frames[:len(m.frames)-1]
+	if len(m.frames) > 0 {
+		m.calls.pop()
+	}
+}
+
+// call applies a function to arguments. A function compiled to bytecode gets
+// a new frame in the machine. Anything else is applied directly, and its
+// results pushed.
+func (m *machine) call(site *Node, fn Value, args []Value) error {
+
+	if f, ok := fn.(Function); ok && f.code != nil {
+
+		frame, err := functionFrame(site, f, args)
+		if err != nil {
+			return err
+		}
+
+		err = m.calls.push(functionName(f.name), site)
+		if err != nil {
+			return err
+		}
+
+		m.frames = append(m.frames, &machineFrame{
+			code:     f.code.Code,
+			vars:     frame,
+			base:     len(m.stack),
+			markBase: len(m.marks),
+		})
+
+		return nil
+	}
+
+	results, err := applyFunction(site, m.calls, fn, args)
+	if err != nil {
+		return err
+	}
+
+	m.stack = append(m.stack, results...)
+
+	return nil
+}
+
+// tailCall replaces the current frame with a call of a function compiled to
+// bytecode, whose results are returned in place of the current frame's.
+func (m *machine) tailCall(site *Node, f Function, args []Value) error {
+
+	frame, err := functionFrame(site, f, args)
+	if err != nil {
+		return err
+	}
+
+	current := m.frames[len(m.frames)-1]
+
+	m.stack = m.stack[:current.base]
+	m.marks = m.marks[:current.markBase]
+
+	m.calls.replace(functionName(f.name), site)
+
+	m.frames[len(m.frames)-1] = &machineFrame{
+		code:     f.code.Code,
+		vars:     frame,
+		base:     current.base,
+		markBase: current.markBase,
+	}
+
+	return nil
+}
+
+// functionFrame returns a new frame for a call of a function, with the
+// parameters set to the arguments.
+func functionFrame(site *Node, f Function, args []Value) (*Variables, error) {
+
+	if len(f.parameters) != len(args) {
+		return nil, site.Error("number of arguments does not match number of parameters")
+	}
+
+	frame := NewFrame(f.scope, f.frameSize)
+	for i, v := range args {
+		frame.SetSlot(i, v)
+	}
+
+	return frame, nil
+}
+
+// method invokes a method. Methods of structs compiled to bytecode are called
+// in the machine.
+func (m *machine) method(site *Node, vars *Variables, target Value, name string, args []Value) error {
+
+	if s, ok := target.(*Struct); ok {
+		if _, std := standardMethod(target, name); !std {
+			if f, ok := s.Method(name); ok && f.code != nil {
+				return m.call(site, f, append(Values(s), args...))
+			}
+		}
+	}
+
+	results, err := applyMethod(site, vars, target, name, args)
+	if err != nil {
+		return err
+	}
+
+	m.stack = append(m.stack, results...)
+
+	return nil
+}
+
+// recover looks for a handler for an error, unwinding frames which have none.
+// It returns the error if there's no try to catch it.
+func (m *machine) recover(err error) error {
+
+	for {
+		f := m.frames[len(m.frames)-1]
+
+		for len(f.handlers) > 0 {
+			h := f.handlers[len(f.handlers)-1]
+			f.handlers = f.handlers[:len(f.handlers)-1]
+
+			m.stack = m.stack[:h.height]
+			m.marks = m.marks[:h.marks]
+
+			switch h.kind {
+			case tryHandler:
+				m.push(ErrorFromFailure(h.site, err))
+				f.pc = h.target
+				return nil
+			case resolveHandler:
+				err = h.site.Error("unable to resolve function: %s", errorMessage(err))
+			case locateHandler:
+				err = h.site.LocateError(err)
+			}
+		}
+
+		if len(m.frames) == 1 {
+			return err
+		}
+
+		err = m.calls.attach(err)
+
+		m.stack = m.stack[:f.base]
+		m.marks = m.marks[:f.markBase]
+		m.popFrame()
+	}
+}
+
+// closure creates a function value from compiled code.
+func closure(fc *FuncCode, vars *Variables) Function {
+	return Function{
+		name:       fc.Name,
+		parameters: fc.Parameters,
+		channels:   fc.Channels,
+		frameSize:  fc.FrameSize,
+		scope:      vars,
+		body:       fc.evaluator(),
+		code:       fc,
+	}
+}
+
+// evaluator returns an Evaluator which runs the code of the function, for
+// when it's called from outside the machine, e.g. by sort.
+func (fc *FuncCode) evaluator() Evaluator {
+
+	if fc.body == nil {
+		code := fc.Code
+		fc.body = func(vars *Variables) ([]Value, error) {
+			return execute(code, vars)
+		}
+	}
+
+	return fc.body
+}
+
+// structFields returns the fields of the struct, with evaluators which run the
+// code giving the initial values.
+func (sc *StructCode) structFields() []structField {
+
+	if sc.fields == nil {
+		for _, fc := range sc.Fields {
+			code := fc.Code
+			sc.fields = append(sc.fields, structField{
+				name: fc.Name,
+				init: func(vars *Variables) ([]Value, error) {
+					return execute(code, vars)
+				},
+			})
+		}
+	}
+
+	return sc.fields
+}
@@ -0,0 +1,128 @@
+package cover_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/cover"
+)
+
+const source = `sign := func(x) {
+	if x < 0 {
+		return -1
+	}
+	1
+}
+sign(5)`
+
+// run runs the source, returning its coverage.
+func run(t *testing.T) []compile.Block {
+
+	t.Helper()
+
+	prog, err := compile.Compile("sign.gosh", strings.Split(source, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := compile.NewCoverage()
+	if err := c.Add(prog); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := compile.GlobalScope()
+	vars.SetCoverage(c)
+
+	if _, err := prog.Run(vars); err != nil {
+		t.Fatal(err)
+	}
+
+	return c.Blocks()
+}
+
+func TestProfile(t *testing.T) {
+
+	blocks := run(t)
+
+	var profile bytes.Buffer
+	if err := cover.WriteProfile(&profile, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `mode: count
+sign.gosh:1:6 statement 1
+sign.gosh:2:2 statement 1
+sign.gosh:2:2 branch:none 1
+sign.gosh:3:3 statement 0
+sign.gosh:3:3 branch:then 0
+sign.gosh:5:2 statement 1
+sign.gosh:7:5 statement 1
+`
+	if profile.String() != expected {
+		t.Errorf("expected profile\n%s\ngot\n%s", expected, profile.String())
+	}
+
+	// profiles can be concatenated, adding the counts
+	doubled := profile.String() + strings.TrimPrefix(profile.String(), "mode: count\n")
+
+	read, err := cover.ReadProfile(strings.NewReader(doubled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(blocks) || read[0].Count != 2 || read[4] != (compile.Block{File: "sign.gosh", Line: 3, Column: 3, Branch: "then"}) {
+		t.Errorf("expected the blocks with their counts doubled, got %+v", read)
+	}
+
+	for _, bad := range []string{"", "mode: set\n", "mode: count\nsign.gosh:1 statement 1\n", "mode: count\nsign.gosh:1:2 loop 1\n"} {
+		if _, err := cover.ReadProfile(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error reading profile %q", bad)
+		}
+	}
+}
+
+func TestReports(t *testing.T) {
+
+	blocks := run(t)
+
+	summaries := cover.Summarize(blocks)
+	if len(summaries) != 1 || summaries[0].String() != "80.0% of statements, 50.0% of branches" {
+		t.Errorf("expected a summary of sign.gosh, got %v", summaries)
+	}
+
+	sources := map[string][]string{"sign.gosh": strings.Split(source, "\n")}
+
+	var text bytes.Buffer
+	if err := cover.Annotate(&text, sources, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `sign.gosh: 80.0% of statements, 50.0% of branches
+    1      1 | sign := func(x) {
+    2      1 | 	if x < 0 {
+    3  never | 		return -1  <-- condition never true
+    4        | 	}
+    5      1 | 	1
+    6        | }
+    7      1 | sign(5)
+`
+	if text.String() != expected {
+		t.Errorf("expected annotated source\n%s\ngot\n%s", expected, text.String())
+	}
+
+	var page bytes.Buffer
+	if err := cover.HTML(&page, sources, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<h2>sign.gosh</h2>`,
+		`<span class="uncovered" title="never ran">		return -1</span>  <span class="missed">condition never true</span>`,
+		`<span class="covered" title="ran 1 times">	if x &lt; 0 {</span>`,
+	} {
+		if !strings.Contains(page.String(), want) {
+			t.Errorf("expected the page to contain %s, got\n%s", want, page.String())
+		}
+	}
+}
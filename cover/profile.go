@@ -0,0 +1,130 @@
+// Package cover writes and reads the coverage profiles of gosh programs, and
+// reports them: as a summary, as annotated source, or as HTML.
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pdk/gosh/compile"
+)
+
+// header starts a profile.
+const header = "mode: count"
+
+// WriteProfile writes blocks as a profile, one per line, e.g.
+//
+//	mode: count
+//	add.gosh:3:6 statement 2
+//	add.gosh:4:2 branch:else 0
+func WriteProfile(w io.Writer, blocks []compile.Block) error {
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, header)
+
+	for _, b := range blocks {
+		kind := "statement"
+		if b.Branch != "" {
+			kind = "branch:" + b.Branch
+		}
+		fmt.Fprintf(bw, "%s:%d:%d %s %d\n", b.File, b.Line, b.Column, kind, b.Count)
+	}
+
+	return bw.Flush()
+}
+
+// ReadProfile reads the blocks of a profile, in order of file, line and
+// column. The counts of a block listed more than once are added.
+func ReadProfile(r io.Reader) ([]compile.Block, error) {
+
+	scanner := bufio.NewScanner(r)
+
+	if !scanner.Scan() || scanner.Text() != header {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("not a coverage profile, expected %q", header)
+	}
+
+	var blocks []compile.Block
+	seen := make(map[compile.Block]int)
+
+	for lineNo := 2; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		b, err := parseBlock(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d of coverage profile: %s", lineNo, err)
+		}
+
+		count := b.Count
+		b.Count = 0
+		if i, ok := seen[b]; ok {
+			blocks[i].Count += count
+			continue
+		}
+
+		seen[b] = len(blocks)
+		b.Count = count
+		blocks = append(blocks, b)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	compile.SortBlocks(blocks)
+
+	return blocks, nil
+}
+
+// parseBlock parses a line of a profile. The fields are taken from the right,
+// as file names may have spaces.
+func parseBlock(line string) (compile.Block, error) {
+
+	var b compile.Block
+
+	bad := fmt.Errorf("expected file:line:column kind count, got %q", line)
+
+	fields := make([]string, 5)
+	rest := line
+	for i, sep := range []string{" ", " ", ":", ":"} {
+		at := strings.LastIndex(rest, sep)
+		if at < 0 {
+			return b, bad
+		}
+		fields[4-i] = rest[at+1:]
+		rest = rest[:at]
+	}
+	fields[0] = rest
+
+	b.File = fields[0]
+
+	var err error
+	if b.Line, err = strconv.Atoi(fields[1]); err != nil {
+		return b, bad
+	}
+	if b.Column, err = strconv.Atoi(fields[2]); err != nil {
+		return b, bad
+	}
+	if b.Count, err = strconv.Atoi(fields[4]); err != nil {
+		return b, bad
+	}
+
+	switch kind := fields[3]; {
+	case kind == "statement":
+	case strings.HasPrefix(kind, "branch:"):
+		b.Branch = strings.TrimPrefix(kind, "branch:")
+	default:
+		return b, bad
+	}
+
+	return b, nil
+}
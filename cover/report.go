@@ -0,0 +1,214 @@
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/pdk/gosh/compile"
+)
+
+// Summary is the coverage of a file.
+type Summary struct {
+	File          string
+	Statements    int
+	StatementsRun int
+	Branches      int // arms of branches
+	BranchesTaken int
+}
+
+// Summarize sums the coverage of each file of blocks, in order of file.
+func Summarize(blocks []compile.Block) []Summary {
+
+	var summaries []Summary
+
+	for _, b := range blocks {
+		if len(summaries) == 0 || summaries[len(summaries)-1].File != b.File {
+			summaries = append(summaries, Summary{File: b.File})
+		}
+		s := &summaries[len(summaries)-1]
+
+		if b.Branch == "" {
+			s.Statements++
+			if b.Count > 0 {
+				s.StatementsRun++
+			}
+			continue
+		}
+
+		s.Branches++
+		if b.Count > 0 {
+			s.BranchesTaken++
+		}
+	}
+
+	return summaries
+}
+
+// String describes the coverage, e.g. "85.7% of statements, 50.0% of
+// branches".
+func (s Summary) String() string {
+
+	if s.Statements == 0 {
+		return "[no statements]"
+	}
+
+	text := fmt.Sprintf("%.1f%% of statements", percent(s.StatementsRun, s.Statements))
+	if s.Branches > 0 {
+		text += fmt.Sprintf(", %.1f%% of branches", percent(s.BranchesTaken, s.Branches))
+	}
+
+	return text
+}
+
+func percent(n, of int) float64 {
+	return 100 * float64(n) / float64(of)
+}
+
+// missed describes an arm of a branch which was never taken.
+var missed = map[string]string{
+	"then":  "condition never true",
+	"else":  "else never taken",
+	"none":  "conditions never all false",
+	"right": "right side never evaluated",
+	"short": "never short-circuited",
+}
+
+// line is the coverage of a line of source.
+type line struct {
+	statements int
+	count      int      // the most times a statement of the line ran
+	missed     []string // the arms of branches never taken
+}
+
+// ran checks if a line has statements, and if any of them ran.
+func (l line) ran() (hasStatements, ran bool) {
+	return l.statements > 0, l.count > 0
+}
+
+// lines returns the coverage of the lines of each file, by line number.
+func lines(blocks []compile.Block) map[string]map[int]*line {
+
+	files := make(map[string]map[int]*line)
+
+	for _, b := range blocks {
+		if files[b.File] == nil {
+			files[b.File] = make(map[int]*line)
+		}
+		l := files[b.File][b.Line]
+		if l == nil {
+			l = &line{}
+			files[b.File][b.Line] = l
+		}
+
+		switch {
+		case b.Branch == "":
+			l.statements++
+			if b.Count > l.count {
+				l.count = b.Count
+			}
+		case b.Count == 0:
+			l.missed = append(l.missed, missed[b.Branch])
+		}
+	}
+
+	return files
+}
+
+// Annotate writes the source of each file of blocks, with how many times each
+// line ran. Lines with statements which never ran are marked, as are branches
+// never taken. The sources are the lines of each file.
+func Annotate(w io.Writer, sources map[string][]string, blocks []compile.Block) error {
+
+	bw := bufio.NewWriter(w)
+	covered := lines(blocks)
+
+	for i, s := range Summarize(blocks) {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "%s: %s\n", s.File, s)
+
+		for n, text := range sources[s.File] {
+			count := ""
+			l := covered[s.File][n+1]
+			if l == nil {
+				l = &line{}
+			}
+
+			switch has, ran := l.ran(); {
+			case ran:
+				count = fmt.Sprint(l.count)
+			case has:
+				count = "never"
+			}
+
+			note := ""
+			if len(l.missed) > 0 {
+				note = "  <-- " + strings.Join(l.missed, ", ")
+			}
+
+			fmt.Fprintf(bw, "%5d %6s | %s%s\n", n+1, count, text, note)
+		}
+	}
+
+	return bw.Flush()
+}
+
+const style = `body { font-family: sans-serif; }
+pre { font-family: monospace; line-height: 1.3; }
+.covered { background: #dfd; }
+.uncovered { background: #fdd; }
+.missed { color: #a00; font-style: italic; }
+.lineno { color: #888; }`
+
+// HTML writes a page showing the source of each file of blocks, with the lines
+// which ran, and those which never ran, highlighted. The sources are the lines
+// of each file.
+func HTML(w io.Writer, sources map[string][]string, blocks []compile.Block) error {
+
+	bw := bufio.NewWriter(w)
+	covered := lines(blocks)
+
+	fmt.Fprintf(bw, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>gosh coverage</title>\n<style>\n%s\n</style>\n</head>\n<body>\n", style)
+
+	for _, s := range Summarize(blocks) {
+		fmt.Fprintf(bw, "<h2>%s</h2>\n<p>%s</p>\n<pre>\n", html.EscapeString(s.File), html.EscapeString(s.String()))
+
+		for n, text := range sources[s.File] {
+			class, title := "", ""
+			l := covered[s.File][n+1]
+			if l == nil {
+				l = &line{}
+			}
+
+			switch has, ran := l.ran(); {
+			case ran && len(l.missed) == 0:
+				class, title = "covered", fmt.Sprintf("ran %d times", l.count)
+			case ran:
+				class, title = "covered", fmt.Sprintf("ran %d times, %s", l.count, strings.Join(l.missed, ", "))
+			case has:
+				class, title = "uncovered", "never ran"
+			}
+
+			fmt.Fprintf(bw, "<span class=\"lineno\">%5d</span> ", n+1)
+			if class != "" {
+				fmt.Fprintf(bw, "<span class=\"%s\" title=\"%s\">%s</span>", class, html.EscapeString(title), html.EscapeString(text))
+			} else {
+				bw.WriteString(html.EscapeString(text))
+			}
+			if len(l.missed) > 0 {
+				fmt.Fprintf(bw, "  <span class=\"missed\">%s</span>", html.EscapeString(strings.Join(l.missed, ", ")))
+			}
+			bw.WriteString("\n")
+		}
+
+		fmt.Fprintf(bw, "</pre>\n")
+	}
+
+	fmt.Fprintf(bw, "</body>\n</html>\n")
+
+	return bw.Flush()
+}
@@ -0,0 +1,388 @@
+package debug
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/diag"
+	"github.com/pdk/gosh/reader"
+	"github.com/pdk/gosh/wire"
+)
+
+// threadID is the only thread of a program.
+const threadID = 1
+
+// Server is a Debug Adapter Protocol server, debugging one program for an
+// editor. The program is named by the launch request, and runs once the
+// client is done configuring breakpoints.
+type Server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	vars *compile.Variables
+
+	mu  sync.Mutex // guards writing, seq and err
+	seq int
+	err error // the first failed write, after which nothing more is sent
+
+	path        string
+	d           *compile.Debugger
+	stopOnEntry bool
+	started     bool
+
+	// variable references handed out since the program last stopped
+	references [][]compile.Variable
+}
+
+// NewServer returns a server reading requests from in, and writing responses
+// and events to out. The program runs in the global scope vars.
+func NewServer(in io.Reader, out io.Writer, vars *compile.Variables) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		vars: vars,
+	}
+}
+
+// Serve handles requests until the client disconnects, or closes the input.
+// If a message to the client can't be written, the session ends, and Serve
+// returns the error.
+func (s *Server) Serve() error {
+
+	for {
+		if err := s.writeError(); err != nil {
+			return err
+		}
+
+		body, err := wire.Read(s.in)
+		if err == io.EOF {
+			return s.writeError()
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return fmt.Errorf("bad request: %s", err)
+		}
+
+		if req.Command == "disconnect" || req.Command == "terminate" {
+			s.reply(req, nil)
+			if req.Command == "terminate" {
+				s.send("terminated", nil)
+			}
+			return s.writeError()
+		}
+
+		s.handle(req)
+	}
+}
+
+// write writes a message, numbering it. Events are written as the program
+// runs, so a failure is kept for Serve to return, rather than returned here.
+func (s *Server) write(msg interface{}, m *message) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+
+	s.seq++
+	m.Seq = s.seq
+
+	s.err = wire.Write(s.out, msg)
+}
+
+// writeError returns the error of the first failed write, if any.
+func (s *Server) writeError() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+func (s *Server) reply(req request, body interface{}) {
+	r := response{
+		message:    message{Type: "response"},
+		RequestSeq: req.Seq,
+		Success:    true,
+		Command:    req.Command,
+		Body:       body,
+	}
+	s.write(&r, &r.message)
+}
+
+func (s *Server) fail(req request, format string, args ...interface{}) {
+	r := response{
+		message:    message{Type: "response"},
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Message:    fmt.Sprintf(format, args...),
+	}
+	s.write(&r, &r.message)
+}
+
+func (s *Server) send(name string, body interface{}) {
+	e := event{
+		message: message{Type: "event"},
+		Event:   name,
+		Body:    body,
+	}
+	s.write(&e, &e.message)
+}
+
+// handle handles a request.
+func (s *Server) handle(req request) {
+
+	if s.d == nil && req.Command != "initialize" && req.Command != "launch" {
+		s.fail(req, "no program is launched")
+		return
+	}
+
+	switch req.Command {
+	case "initialize":
+		s.reply(req, capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		})
+
+	case "launch":
+		var args launchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "%s", err)
+			return
+		}
+		if err := s.launch(args); err != nil {
+			s.fail(req, "%s", err)
+			return
+		}
+		s.reply(req, nil)
+		s.send("initialized", nil)
+
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "%s", err)
+			return
+		}
+		s.reply(req, s.setBreakpoints(args))
+
+	case "configurationDone":
+		s.reply(req, nil)
+		if !s.started {
+			s.started = true
+			go s.forward()
+			s.d.Start(s.vars, s.stopOnEntry)
+		}
+
+	case "threads":
+		s.reply(req, threadsResponse{Threads: []thread{{ID: threadID, Name: "main"}}})
+
+	case "stackTrace":
+		frames := []stackFrame{}
+		for i, f := range s.d.Stack() {
+			frames = append(frames, stackFrame{
+				ID:     i,
+				Name:   f.Name,
+				Source: source{Name: filepath.Base(s.path), Path: s.path},
+				Line:   f.Line,
+				Column: f.Column,
+			})
+		}
+		s.reply(req, stackTraceResponse{StackFrames: frames, TotalFrames: len(frames)})
+
+	case "scopes":
+		var args frameArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "%s", err)
+			return
+		}
+		scopes, err := s.d.Scopes(args.FrameID)
+		if err != nil {
+			s.fail(req, "%s", err)
+			return
+		}
+		result := []scope{}
+		for _, sc := range scopes {
+			result = append(result, scope{Name: sc.Name, VariablesReference: s.reference(sc.Variables)})
+		}
+		s.reply(req, scopesResponse{Scopes: result})
+
+	case "variables":
+		var args variablesArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "%s", err)
+			return
+		}
+		s.reply(req, variablesResponse{Variables: s.variables(args.VariablesReference)})
+
+	case "evaluate":
+		var args evaluateArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "%s", err)
+			return
+		}
+		vals, err := s.d.Evaluate(args.FrameID, args.Expression)
+		if err != nil {
+			s.fail(req, "%s", strings.TrimSpace(diag.FromError(err).Message))
+			return
+		}
+		s.reply(req, evaluateResponse{Result: values(vals)})
+
+	case "continue":
+		s.resume(req, continueResponse{AllThreadsContinued: true}, s.d.Continue)
+
+	case "next":
+		s.resume(req, nil, s.d.StepOver)
+
+	case "stepIn":
+		s.resume(req, nil, s.d.StepIn)
+
+	case "stepOut":
+		s.resume(req, nil, s.d.StepOut)
+
+	case "pause":
+		s.reply(req, nil)
+		s.d.Pause()
+
+	default:
+		s.fail(req, "request not supported: %s", req.Command)
+	}
+}
+
+// launch compiles the program to debug.
+func (s *Server) launch(args launchArguments) error {
+
+	if s.d != nil {
+		return fmt.Errorf("a program is already launched")
+	}
+
+	input, err := reader.ReadLines(args.Program)
+	if err != nil {
+		return err
+	}
+
+	prog, err := compile.Compile(args.Program, input)
+	if err != nil {
+		var messages []string
+		for _, d := range diag.FromErrors(err) {
+			messages = append(messages, d.Text(false))
+		}
+		return fmt.Errorf("%s", strings.TrimSpace(strings.Join(messages, "")))
+	}
+
+	d, err := compile.NewDebugger(prog)
+	if err != nil {
+		return err
+	}
+
+	s.path = args.Program
+	s.d = d
+	s.stopOnEntry = args.StopOnEntry
+
+	return nil
+}
+
+// setBreakpoints sets the breakpoints of the program, which replace any set
+// before. Breakpoints on lines where no statement starts aren't verified.
+func (s *Server) setBreakpoints(args setBreakpointsArguments) setBreakpointsResponse {
+
+	var lines []int
+	for _, b := range args.Breakpoints {
+		lines = append(lines, b.Line)
+	}
+
+	placed := make(map[int]bool)
+	for _, l := range s.d.SetBreakpoints(lines) {
+		placed[l] = true
+	}
+
+	result := setBreakpointsResponse{Breakpoints: []breakpoint{}}
+	for _, l := range lines {
+		b := breakpoint{Verified: placed[l], Line: l}
+		if !b.Verified {
+			b.Message = "no statement starts on this line"
+		}
+		result.Breakpoints = append(result.Breakpoints, b)
+	}
+
+	return result
+}
+
+// resume replies to a request, then resumes the program. The variables
+// referenced while it was stopped are forgotten.
+func (s *Server) resume(req request, body interface{}, resume func()) {
+
+	s.references = nil
+	s.reply(req, body)
+	resume()
+}
+
+// reference returns a reference the client can ask for variables by. 0 means
+// there are none.
+func (s *Server) reference(vars []compile.Variable) int {
+
+	if len(vars) == 0 {
+		return 0
+	}
+
+	s.references = append(s.references, vars)
+
+	return len(s.references)
+}
+
+// variables returns the variables of a reference. The variables captured by a
+// func are referenced in turn.
+func (s *Server) variables(ref int) []variable {
+
+	result := []variable{}
+	if ref < 1 || ref > len(s.references) {
+		return result
+	}
+
+	for _, v := range s.references[ref-1] {
+		result = append(result, variable{
+			Name:               v.Name,
+			Value:              v.Value,
+			Type:               v.Type,
+			VariablesReference: s.reference(v.Captured()),
+		})
+	}
+
+	return result
+}
+
+// forward sends the events of the program to the client, until it exits.
+func (s *Server) forward() {
+
+	for e := range s.d.Events() {
+		if !e.Exited() {
+			s.send("stopped", stoppedEvent{Reason: e.Reason, ThreadID: threadID, AllThreadsStopped: true})
+			continue
+		}
+
+		exitCode := 0
+		if e.Err != nil {
+			exitCode = 1
+			for _, d := range diag.FromErrors(e.Err) {
+				s.send("output", outputEvent{Category: "stderr", Output: d.Text(false)})
+			}
+		}
+		if len(e.Values) > 0 {
+			s.send("output", outputEvent{Category: "stdout", Output: values(e.Values) + "\n"})
+		}
+
+		s.send("exited", exitedEvent{ExitCode: exitCode})
+		s.send("terminated", nil)
+	}
+}
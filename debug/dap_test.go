@@ -0,0 +1,206 @@
+package debug_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/debug"
+	"github.com/pdk/gosh/wire"
+)
+
+// client is a scripted client, talking to a server over pipes.
+type client struct {
+	t   *testing.T
+	in  io.WriteCloser
+	out *bufio.Reader
+	seq int
+}
+
+func (c *client) receive() map[string]interface{} {
+
+	c.t.Helper()
+
+	body, err := wire.Read(c.out)
+	if err != nil {
+		c.t.Fatalf("failed to read from the server: %s", err)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("expected JSON from the server, got %s", body)
+	}
+
+	return msg
+}
+
+// call sends a request, and returns the body of its response, skipping any
+// events sent before it.
+func (c *client) call(command string, args interface{}) map[string]interface{} {
+
+	c.t.Helper()
+
+	c.seq++
+	err := wire.Write(c.in, map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.receive()
+		if msg["type"] != "response" {
+			continue
+		}
+		if seq, _ := msg["request_seq"].(float64); int(seq) != c.seq || msg["success"] != true {
+			c.t.Fatalf("expected %s to succeed, got %v", command, msg)
+		}
+		body, _ := msg["body"].(map[string]interface{})
+		return body
+	}
+}
+
+// expect waits for an event, skipping any others, and returns its body.
+func (c *client) expect(name string) map[string]interface{} {
+
+	c.t.Helper()
+
+	for {
+		msg := c.receive()
+		if msg["type"] == "event" && msg["event"] == name {
+			body, _ := msg["body"].(map[string]interface{})
+			return body
+		}
+	}
+}
+
+func TestDAP(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gosh-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	program := filepath.Join(dir, "add.gosh")
+	if err := ioutil.WriteFile(program, []byte(input+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- debug.NewServer(inR, outW, compile.GlobalScope()).Serve()
+		outW.Close()
+	}()
+
+	c := &client{t: t, in: inW, out: bufio.NewReader(outR)}
+
+	c.call("initialize", map[string]interface{}{"adapterID": "gosh", "linesStartAt1": true})
+	c.call("launch", map[string]interface{}{"program": program})
+	c.expect("initialized")
+
+	body := c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": program},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 3}, map[string]interface{}{"line": 5}},
+	})
+	b, _ := json.Marshal(body["breakpoints"])
+	if string(b) != `[{"line":3,"verified":true},{"line":5,"message":"no statement starts on this line","verified":false}]` {
+		t.Errorf("expected line 3 to be verified and 5 not, got %s", b)
+	}
+
+	c.call("configurationDone", nil)
+	if reason := c.expect("stopped")["reason"]; reason != "breakpoint" {
+		t.Errorf("expected to stop at a breakpoint, got %v", reason)
+	}
+
+	body = c.call("stackTrace", map[string]interface{}{"threadId": 1})
+	frames, _ := body["stackFrames"].([]interface{})
+	var stack []string
+	for _, f := range frames {
+		f := f.(map[string]interface{})
+		stack = append(stack, fmt.Sprintf("%v %v:%v %v", f["name"], f["line"], f["column"], f["source"].(map[string]interface{})["path"]))
+	}
+	if got := strings.Join(stack, ", "); got != "add 3:6 "+program+", <top level> 6:3 "+program {
+		t.Errorf("expected the stack of add, got %s", got)
+	}
+
+	body = c.call("scopes", map[string]interface{}{"frameId": 0})
+	scopes, _ := body["scopes"].([]interface{})
+	if len(scopes) != 2 {
+		t.Fatalf("expected locals and globals, got %v", body)
+	}
+
+	locals := scopes[0].(map[string]interface{})
+	body = c.call("variables", map[string]interface{}{"variablesReference": locals["variablesReference"]})
+	b, _ = json.Marshal(body["variables"])
+	if string(b) != `[{"name":"a","type":"int64","value":"1","variablesReference":0},`+
+		`{"name":"b","type":"int64","value":"2","variablesReference":0},`+
+		`{"name":"sum","type":"nil","value":"nil","variablesReference":0}]` {
+		t.Errorf("expected the locals a, b and sum, got %s", b)
+	}
+
+	body = c.call("evaluate", map[string]interface{}{"expression": "a + b", "frameId": 0})
+	if body["result"] != "3" {
+		t.Errorf("expected a + b to be 3, got %v", body)
+	}
+
+	c.call("stepOut", map[string]interface{}{"threadId": 1})
+	if reason := c.expect("stopped")["reason"]; reason != "step" {
+		t.Errorf("expected to stop after a step, got %v", reason)
+	}
+
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": program},
+		"breakpoints": []interface{}{},
+	})
+	c.call("continue", map[string]interface{}{"threadId": 1})
+
+	if output := c.expect("output")["output"]; output != "9\n" {
+		t.Errorf("expected the program to output 9, got %v", output)
+	}
+	if code := c.expect("exited")["exitCode"]; code != 0.0 {
+		t.Errorf("expected the program to exit with 0, got %v", code)
+	}
+	c.expect("terminated")
+
+	c.call("disconnect", nil)
+	if err := <-done; err != nil {
+		t.Errorf("did not expect error serving, got %s", err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestDAPWriteError(t *testing.T) {
+
+	// a response which can't be written ends the session, with the error
+	var in strings.Builder
+	for _, command := range []string{"initialize", "threads"} {
+		body := fmt.Sprintf(`{"seq": 1, "type": "request", "command": %q}`, command)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	err := debug.NewServer(strings.NewReader(in.String()), failingWriter{}, compile.GlobalScope()).Serve()
+	if err != io.ErrClosedPipe {
+		t.Errorf("expected %s, got %v", io.ErrClosedPipe, err)
+	}
+}
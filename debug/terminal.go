@@ -0,0 +1,300 @@
+// Package debug runs gosh programs under the debugger, either interactively
+// in a terminal, or for an editor, as a Debug Adapter Protocol server.
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/diag"
+)
+
+// Prompt is shown when the program is stopped, waiting for a command.
+var Prompt = "(debug) "
+
+const help = `commands:
+  b, break N      set a breakpoint at line N
+  clear N         clear the breakpoint at line N
+  c, continue     run until a breakpoint
+  s, step         step to the next statement, into calls
+  n, next         step to the next statement, over calls
+  o, out          step out of the current call
+  bt, stack       show the active calls
+  f, frame N      select call N of the stack
+  v, vars         show the variables visible from the selected call
+  p, print EXPR   evaluate an expression in the selected call
+  l, list         show the source around the current line
+  q, quit         stop debugging
+An empty line repeats the last step.
+`
+
+// terminal is a debugging session in a terminal.
+type terminal struct {
+	d           *compile.Debugger
+	input       []string
+	out         io.Writer
+	breakpoints map[int]bool
+	frame       int // selected, counting from the most recent call
+	line        int // where the program is stopped
+}
+
+// Start debugs a program, reading commands from in. The program stops before
+// its first statement, so that breakpoints can be set.
+func Start(prog *compile.Program, input []string, vars *compile.Variables, in io.Reader, out io.Writer) error {
+
+	d, err := compile.NewDebugger(prog)
+	if err != nil {
+		return err
+	}
+
+	t := &terminal{
+		d:           d,
+		input:       input,
+		out:         out,
+		breakpoints: make(map[int]bool),
+	}
+
+	fmt.Fprintf(out, "debugging %s, type help for commands\n", prog.Name())
+
+	d.Start(vars, true)
+
+	scanner := bufio.NewScanner(in)
+	last := ""
+
+	for e := range d.Events() {
+		if e.Exited() {
+			t.exited(e)
+			return nil
+		}
+
+		t.stopped(e)
+
+		for resumed := false; !resumed; {
+			fmt.Fprint(out, Prompt)
+			if !scanner.Scan() {
+				return nil
+			}
+
+			command := strings.TrimSpace(scanner.Text())
+			if command == "" {
+				command = last
+			}
+
+			var quit bool
+			resumed, quit = t.command(command)
+			if quit {
+				return nil
+			}
+
+			if resumed {
+				last = command
+			}
+		}
+	}
+
+	return nil
+}
+
+// stopped shows where the program stopped.
+func (t *terminal) stopped(e compile.Event) {
+
+	t.frame = 0
+	t.line = e.Line
+
+	fmt.Fprintf(t.out, "stopped at line %d (%s)\n", e.Line, e.Reason)
+	t.list(e.Line, 0)
+}
+
+// exited shows the results of the program, or the error it failed with.
+func (t *terminal) exited(e compile.Event) {
+
+	if e.Err != nil {
+		for _, d := range diag.FromErrors(e.Err) {
+			fmt.Fprint(t.out, d.Text(false))
+		}
+	}
+
+	fmt.Fprintf(t.out, "program exited: %s\n", values(e.Values))
+}
+
+// values formats the results of a program, or an evaluation.
+func values(vals []compile.Value) string {
+
+	var printable []string
+	for _, v := range vals {
+		printable = append(printable, compile.ToString(v))
+	}
+
+	return strings.Join(printable, ", ")
+}
+
+// command runs a command, returning whether it resumed the program, or asked
+// to quit.
+func (t *terminal) command(command string) (resumed, quit bool) {
+
+	verb, arg := command, ""
+	if i := strings.IndexByte(command, ' '); i > 0 {
+		verb, arg = command[:i], strings.TrimSpace(command[i+1:])
+	}
+
+	switch verb {
+	case "c", "continue":
+		t.d.Continue()
+		return true, false
+
+	case "s", "step":
+		t.d.StepIn()
+		return true, false
+
+	case "n", "next":
+		t.d.StepOver()
+		return true, false
+
+	case "o", "out":
+		t.d.StepOut()
+		return true, false
+
+	case "q", "quit":
+		return false, true
+
+	case "b", "break":
+		t.setBreakpoint(arg, true)
+
+	case "clear":
+		t.setBreakpoint(arg, false)
+
+	case "bt", "stack":
+		for i, f := range t.d.Stack() {
+			marker := " "
+			if i == t.frame {
+				marker = "*"
+			}
+			fmt.Fprintf(t.out, "%s %d  %s at line %d\n", marker, i, f.Name, f.Line)
+		}
+
+	case "f", "frame":
+		n, err := strconv.Atoi(arg)
+		stack := t.d.Stack()
+		if err != nil || n < 0 || n >= len(stack) {
+			fmt.Fprintf(t.out, "expected a frame from 0 to %d\n", len(stack)-1)
+			break
+		}
+		t.frame = n
+		fmt.Fprintf(t.out, "%s at line %d\n", stack[n].Name, stack[n].Line)
+		t.list(stack[n].Line, 0)
+
+	case "v", "vars":
+		t.vars()
+
+	case "p", "print":
+		vals, err := t.d.Evaluate(t.frame, arg)
+		if err != nil {
+			for _, d := range diag.FromErrors(err) {
+				fmt.Fprint(t.out, d.Text(false))
+			}
+			break
+		}
+		fmt.Fprintln(t.out, values(vals))
+
+	case "l", "list":
+		line := t.line
+		if stack := t.d.Stack(); t.frame < len(stack) {
+			line = stack[t.frame].Line
+		}
+		t.list(line, 5)
+
+	case "h", "help":
+		fmt.Fprint(t.out, help)
+
+	default:
+		fmt.Fprintf(t.out, "unknown command %q, type help for commands\n", command)
+	}
+
+	return false, false
+}
+
+// setBreakpoint sets or clears a breakpoint.
+func (t *terminal) setBreakpoint(arg string, set bool) {
+
+	line, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintf(t.out, "expected a line number, got %q\n", arg)
+		return
+	}
+
+	if set {
+		t.breakpoints[line] = true
+	} else {
+		delete(t.breakpoints, line)
+	}
+
+	var lines []int
+	for l := range t.breakpoints {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+
+	placed := make(map[int]bool)
+	for _, l := range t.d.SetBreakpoints(lines) {
+		placed[l] = true
+	}
+
+	switch {
+	case !set:
+		fmt.Fprintf(t.out, "breakpoint at line %d cleared\n", line)
+	case placed[line]:
+		fmt.Fprintf(t.out, "breakpoint set at line %d\n", line)
+	default:
+		delete(t.breakpoints, line)
+		fmt.Fprintf(t.out, "no statement starts at line %d\n", line)
+	}
+}
+
+// vars shows the variables visible from the selected frame. The variables
+// captured by funcs are shown under them.
+func (t *terminal) vars() {
+
+	scopes, err := t.d.Scopes(t.frame)
+	if err != nil {
+		fmt.Fprintln(t.out, err)
+		return
+	}
+
+	for _, s := range scopes {
+		fmt.Fprintf(t.out, "%s:\n", s.Name)
+		for _, v := range s.Variables {
+			fmt.Fprintf(t.out, "  %s = %s (%s)\n", v.Name, v.Value, v.Type)
+			for _, c := range v.Captured() {
+				fmt.Fprintf(t.out, "    captured %s = %s (%s)\n", c.Name, c.Value, c.Type)
+			}
+		}
+	}
+}
+
+// list shows the lines of the input around a line.
+func (t *terminal) list(line, around int) {
+
+	for l := line - around; l <= line+around; l++ {
+		if l < 1 || l > len(t.input) {
+			continue
+		}
+
+		marker := " "
+		if l == line {
+			marker = ">"
+		}
+		if t.breakpoints[l] {
+			marker = "*"
+			if l == line {
+				marker = "@"
+			}
+		}
+
+		fmt.Fprintf(t.out, "%s %4d | %s\n", marker, l, t.input[l-1])
+	}
+}
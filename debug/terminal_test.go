@@ -0,0 +1,78 @@
+package debug_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/debug"
+)
+
+const input = `total := 0
+add := func(a, b) {
+	sum := a + b
+	return sum
+}
+x := add(1, 2)
+y := add(x, 3)
+total := x + y`
+
+func TestTerminal(t *testing.T) {
+
+	lines := strings.Split(input, "\n")
+
+	prog, err := compile.Compile("add.gosh", lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := strings.Join([]string{
+		"b 5",
+		"b 3",
+		"c",
+		"bt",
+		"p a + b",
+		"frame 1",
+		"vars",
+		"o",
+		"n",
+		"",
+		"clear 3",
+		"c",
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := debug.Start(prog, lines, compile.GlobalScope(), strings.NewReader(commands), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `debugging add.gosh, type help for commands
+stopped at line 1 (entry)
+>    1 | total := 0
+(debug) no statement starts at line 5
+(debug) breakpoint set at line 3
+(debug) stopped at line 3 (breakpoint)
+@    3 | 	sum := a + b
+(debug) * 0  add at line 3
+  1  <top level> at line 6
+(debug) 3
+(debug) <top level> at line 6
+>    6 | x := add(1, 2)
+(debug) Globals:
+  add = func(a, b)[]{...} (func)
+  total = 0 (int64)
+(debug) stopped at line 7 (step)
+>    7 | y := add(x, 3)
+(debug) stopped at line 3 (breakpoint)
+@    3 | 	sum := a + b
+(debug) stopped at line 4 (step)
+>    4 | 	return sum
+(debug) breakpoint at line 3 cleared
+(debug) program exited: 9
+`
+
+	if out.String() != expected {
+		t.Errorf("expected session\n%s\ngot\n%s", expected, out.String())
+	}
+}
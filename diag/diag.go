@@ -0,0 +1,304 @@
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/parse"
+	"github.com/pdk/gosh/token"
+)
+
+// Severity is how serious a diagnostic is.
+type Severity string
+
+// The severities of diagnostics.
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a script, with where it was found. Line and
+// Column count from 1, and are 0 if the location is not known. EndColumn is
+// the column just after the offending lexeme. Suggestions are the names which
+// may have been meant, e.g. of a misspelled variable.
+type Diagnostic struct {
+	Severity    Severity `json:"severity"`
+	File        string   `json:"file,omitempty"`
+	Line        int      `json:"line,omitempty"`
+	Column      int      `json:"column,omitempty"`
+	EndColumn   int      `json:"endColumn,omitempty"`
+	Message     string   `json:"message"`
+	Source      string   `json:"source,omitempty"`
+	Notes       []string `json:"notes,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// FromErrors makes diagnostics of an error, which may be several errors, e.g.
+// the syntax errors of an input.
+func FromErrors(err error) []Diagnostic {
+
+	if many, ok := err.(interface{ Unwrap() []error }); ok {
+		var ds []Diagnostic
+		for _, e := range many.Unwrap() {
+			ds = append(ds, FromErrors(e)...)
+		}
+		return ds
+	}
+
+	return []Diagnostic{FromError(err)}
+}
+
+// FromError makes a diagnostic of an error. Errors found at a lexeme are
+// located there, and the calls in which a runtime error was raised become
+// notes.
+func FromError(err error) Diagnostic {
+
+	d := Diagnostic{
+		Severity: Error,
+		Message:  err.Error(),
+	}
+
+	var lexErr *lexer.Error
+	var parseErr *parse.Error
+
+	switch {
+	case errors.As(err, &lexErr):
+		d.Message = lexErr.Message
+		d.locate(lexErr.Lexeme)
+
+	case errors.As(err, &parseErr):
+		d.Message = "syntax error: " + parseErr.Message
+		if parseErr.Lexeme != nil {
+			d.locate(*parseErr.Lexeme)
+		}
+	}
+
+	for _, line := range strings.Split(compile.Trace(err), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			d.Notes = append(d.Notes, line)
+		}
+	}
+
+	return d
+}
+
+// FromProblem makes a diagnostic of a problem found by checking a program.
+func FromProblem(p compile.Problem) Diagnostic {
+
+	d := FromError(p.Err)
+	if p.Warning {
+		d.Severity = Warning
+	}
+
+	return d
+}
+
+// locate places the diagnostic at a lexeme.
+func (d *Diagnostic) locate(lex lexer.Lexeme) {
+
+	if lex.Token() == token.EOF && lex.Lexer() != nil {
+		// just after the end of the last line
+		input := lex.Lexer().Input()
+		if len(input) > 0 {
+			last := input[len(input)-1]
+			lex = lex.Lexer().LexemeAt(token.EOF, "", len(input), utf8.RuneCountInString(last)+1)
+		}
+	}
+
+	d.Line = lex.LineNo()
+	d.Column = lex.CharNo()
+	d.Source = lex.SourceLine()
+	d.EndColumn = d.Column + span(d.Source, d.Column, lex.Literal())
+
+	if lex.Lexer() != nil {
+		d.File = lex.Lexer().InputName()
+	}
+}
+
+// span returns how many characters of the source line, starting at column,
+// the literal of a lexeme covers. String literals don't include their quotes,
+// and some lexemes are made by the compiler, so the literal is only trusted
+// when it's found in the source.
+func span(source string, column int, literal string) int {
+
+	runes := []rune(source)
+	if column < 1 || column > len(runes) {
+		return 1
+	}
+	rest := string(runes[column-1:])
+
+	n := utf8.RuneCountInString(literal)
+	switch {
+	case n > 0 && strings.HasPrefix(rest, literal):
+		return n
+	case len(rest) > 0 && strings.ContainsRune("\"'`", rune(rest[0])) && strings.HasPrefix(rest[1:], literal):
+		return minInt(n+2, len(runes)-column+1)
+	}
+
+	return 1
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Suggest adds suggestions for the unbound identifiers of a program found on
+// the line of the diagnostic, and named by its message.
+func (d *Diagnostic) Suggest(unbound []compile.Unbound) {
+
+	for _, u := range unbound {
+		if u.Line != d.Line || !strings.Contains(d.Message, u.Name) {
+			continue
+		}
+
+		if len(u.Suggestions) == 0 {
+			d.Notes = append(d.Notes, fmt.Sprintf("`%s` is not defined in any enclosing scope", u.Name))
+		}
+
+		for _, s := range u.Suggestions {
+			d.Suggestions = append(d.Suggestions, s)
+		}
+	}
+}
+
+// Location returns the file, line and column of the diagnostic, e.g.
+// "foo.gosh:12:4", or "" if it has no location.
+func (d Diagnostic) Location() string {
+
+	if d.Line == 0 {
+		return d.File
+	}
+
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+}
+
+// ANSI escape sequences used to color text.
+const (
+	reset = "\x1b[0m"
+	bold  = "\x1b[1m"
+	red   = "\x1b[1;31m"
+	amber = "\x1b[1;33m"
+	blue  = "\x1b[1;34m"
+	cyan  = "\x1b[1;36m"
+)
+
+// Text formats the diagnostic for people to read: the message, the location,
+// and the source line with the offending lexeme underlined, followed by notes
+// and suggestions. If color is true, ANSI escapes are used to color it.
+func (d Diagnostic) Text(color bool) string {
+
+	paint := func(style, s string) string {
+		if !color {
+			return s
+		}
+		return style + s + reset
+	}
+
+	severity := red
+	if d.Severity == Warning {
+		severity = amber
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s%s\n", paint(severity, string(d.Severity)+":"), paint(bold, " "+d.Message))
+
+	lineNo := fmt.Sprintf("%d", d.Line)
+	gutter := strings.Repeat(" ", len(lineNo))
+
+	if loc := d.Location(); loc != "" {
+		fmt.Fprintf(&sb, "%s%s %s\n", gutter, paint(blue, "-->"), loc)
+	}
+
+	if d.Line > 0 && d.Column > 0 {
+		bar := paint(blue, " |")
+		fmt.Fprintf(&sb, "%s%s\n", gutter, bar)
+		fmt.Fprintf(&sb, "%s%s %s\n", paint(blue, lineNo), bar, d.Source)
+		fmt.Fprintf(&sb, "%s%s %s%s\n", gutter, bar, indent(d.Source, d.Column), paint(severity, underline(d.Column, d.EndColumn)))
+	}
+
+	for _, note := range d.Notes {
+		fmt.Fprintf(&sb, "%s %s %s\n", gutter, paint(blue, "="), paint(bold, "note:")+" "+note)
+	}
+
+	for _, s := range d.Suggestions {
+		fmt.Fprintf(&sb, "%s %s %s\n", gutter, paint(blue, "="), paint(cyan, "help:")+" "+Suggestion(s))
+	}
+
+	return sb.String()
+}
+
+// Suggestion describes a suggested name for people to read.
+func Suggestion(name string) string {
+	return fmt.Sprintf("did you mean `%s`?", name)
+}
+
+// indent returns the whitespace which lines up with a column of the source
+// line, keeping its tabs so that the underline lands under the lexeme.
+func indent(source string, column int) string {
+
+	var sb strings.Builder
+
+	for i, r := range []rune(source) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+
+	return sb.String()
+}
+
+// underline returns carets spanning the columns of the offending lexeme.
+func underline(column, endColumn int) string {
+
+	if endColumn <= column {
+		return "^"
+	}
+
+	return strings.Repeat("^", endColumn-column)
+}
+
+// JSON formats the diagnostic as a single line of JSON, for editors and other
+// tools.
+func (d Diagnostic) JSON() string {
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		// a Diagnostic is only strings and ints, which always marshal
+		panic(err)
+	}
+
+	return string(b)
+}
+
+// Printer writes diagnostics in one of the formats.
+type Printer struct {
+	Out   io.Writer
+	JSON  bool // one JSON object per line, rather than text
+	Color bool // color text with ANSI escapes
+}
+
+// Print writes a diagnostic.
+func (p Printer) Print(d Diagnostic) {
+
+	if p.JSON {
+		fmt.Fprintln(p.Out, d.JSON())
+		return
+	}
+
+	fmt.Fprint(p.Out, d.Text(p.Color))
+}
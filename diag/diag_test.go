@@ -0,0 +1,150 @@
+package diag_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/diag"
+)
+
+// diagnose compiles and runs a script, expecting it to fail, and returns the
+// diagnostic of the error.
+func diagnose(t *testing.T, input string) diag.Diagnostic {
+
+	t.Helper()
+
+	lines := strings.Split(input, "\n")
+
+	prog, err := compile.Compile("test.gosh", lines)
+	if err != nil {
+		return diag.FromError(err)
+	}
+
+	_, err = prog.Run(compile.GlobalScope())
+	if err == nil {
+		t.Fatalf("expected an error running %q", input)
+	}
+
+	d := diag.FromError(err)
+	d.Suggest(prog.Unbound())
+
+	return d
+}
+
+func TestText(t *testing.T) {
+
+	d := diagnose(t, "count := 1\nx := cuont + 1")
+
+	expected := "error: attempt to access undefined variable cuont\n" +
+		" --> test.gosh:2:6\n" +
+		"  |\n" +
+		"2 | x := cuont + 1\n" +
+		"  |      ^^^^^\n" +
+		"  = help: did you mean `count`?\n"
+
+	if got := d.Text(false); got != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, got)
+	}
+}
+
+func TestUnderline(t *testing.T) {
+
+	// tabs are kept, so the carets line up with the lexeme
+	d := diagnose(t, "f := func(x) {\n\treturn x * \"a\"\n}\nf(1)")
+
+	lines := strings.Split(d.Text(false), "\n")
+	if lines[4] != "  | \t         ^" {
+		t.Errorf("expected caret under *, got %q", lines[4])
+	}
+
+	if len(d.Notes) != 1 || d.Notes[0] != "at f, called at test.gosh:4:1" {
+		t.Errorf("expected a note of the call of f, got %q", d.Notes)
+	}
+
+	// an operator is underlined alone
+	d = diagnose(t, `x := -"abc"`)
+	if d.Column != 6 || d.EndColumn != 7 {
+		t.Errorf("expected columns 6 to 7, got %d to %d", d.Column, d.EndColumn)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+
+	d := diagnose(t, "total := 0\ntotl + 1")
+	if len(d.Suggestions) != 1 || d.Suggestions[0] != "total" {
+		t.Errorf("expected to suggest total, got %q", d.Suggestions)
+	}
+
+	d = diagnose(t, "zzzzzz + 1")
+	if len(d.Suggestions) != 0 || len(d.Notes) != 1 || d.Notes[0] != "`zzzzzz` is not defined in any enclosing scope" {
+		t.Errorf("expected a note that zzzzzz is not defined, got %q and %q", d.Notes, d.Suggestions)
+	}
+}
+
+func TestJSON(t *testing.T) {
+
+	d := diagnose(t, "count := 1\nx := cuont + 1")
+
+	var got diag.Diagnostic
+	if err := json.Unmarshal([]byte(d.JSON()), &got); err != nil {
+		t.Fatalf("expected JSON, got %s: %s", err, d.JSON())
+	}
+
+	if got.Severity != diag.Error || got.File != "test.gosh" || got.Line != 2 || got.Column != 6 || got.EndColumn != 11 {
+		t.Errorf("expected error at test.gosh:2:6-11, got %+v", got)
+	}
+
+	if len(got.Suggestions) != 1 || got.Suggestions[0] != "count" {
+		t.Errorf("expected the suggestion count, got %q", got.Suggestions)
+	}
+
+	if strings.Contains(d.JSON(), "\n") {
+		t.Errorf("expected one line of JSON, got %s", d.JSON())
+	}
+}
+
+func TestColor(t *testing.T) {
+
+	d := diagnose(t, "x := 1 / 0")
+
+	if strings.Contains(d.Text(false), "\x1b[") {
+		t.Errorf("expected no escapes without color")
+	}
+
+	if !strings.Contains(d.Text(true), "\x1b[1;31merror:\x1b[0m") {
+		t.Errorf("expected a red error, got %q", d.Text(true))
+	}
+}
+
+func TestEndOfInput(t *testing.T) {
+
+	d := diagnose(t, "x := (1 +")
+
+	if d.Location() != "test.gosh:1:10" {
+		t.Errorf("expected error just after the input, got %q", d.Text(false))
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+
+	_, err := compile.Compile("test.gosh", []string{
+		"if x { 1 } else 2",
+		"y := 3",
+		"z := f(]",
+	})
+
+	ds := diag.FromErrors(err)
+	if len(ds) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %d: %v", len(ds), err)
+	}
+
+	if ds[0].Location() != "test.gosh:1:17" || ds[0].Message != "syntax error: expected `{` or `if`, found number `2`" {
+		t.Errorf("expected error at else, got %s: %s", ds[0].Location(), ds[0].Message)
+	}
+
+	if ds[1].Location() != "test.gosh:3:8" || ds[1].EndColumn != 9 {
+		t.Errorf("expected error at ], got %s-%d", ds[1].Location(), ds[1].EndColumn)
+	}
+}
@@ -0,0 +1,435 @@
+// Package doc documents gosh scripts, from the comments just before their
+// declarations: a pkg, the funcs and structs assigned at the top level, the
+// methods of structs, and enums. Comments are dropped by the parser, so
+// declarations are found in the lexemes, as gosh fmt finds them, and files
+// which use features not yet parsed, e.g. enums, can still be documented.
+package doc
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/token"
+)
+
+// Package is the documentation of a file: its pkg, if it declares one, and
+// its declarations, in the order they appear.
+type Package struct {
+	File  string
+	Name  string // of the pkg, or "" if the file doesn't declare one
+	Doc   string
+	Decls []Decl
+}
+
+// Decl is a declaration: a func, struct or enum, or a method of a struct.
+type Decl struct {
+	Name     string // e.g. Point.String, for a method
+	Kind     string // func, method, struct or enum
+	Params   []string
+	Channels []string
+	Fields   []string // of a struct, or the values of an enum
+	Values   []string // given to the values of an enum, or "" for each without
+	Methods  []Decl   // of a struct
+	Doc      string
+	Line     int
+}
+
+// Find returns the files named by paths. A path may be a file, which is
+// returned whatever its name, or a directory, which is searched, recursively,
+// for files named *.gosh, other than tests. Directories starting with "." are
+// skipped.
+func Find(paths []string) ([]string, error) {
+
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		var found []string
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && p != path && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() && strings.HasSuffix(info.Name(), ".gosh") && !strings.HasSuffix(info.Name(), "_test.gosh") {
+				found = append(found, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+
+	return files, nil
+}
+
+// Parse finds the declarations of a file, and their comments. The comment of
+// a declaration is the lines of comments just before it. The comment of the
+// file is that before its pkg, or, if it has no pkg, its first comment, if a
+// blank line separates that from whatever follows.
+func Parse(fileName string, input []string) *Package {
+
+	p := &Package{File: fileName}
+
+	comments := make(map[int]string) // the lines of only a comment
+	code := make(map[int]bool)       // the lines with code
+	var lexemes []lexer.Lexeme
+
+	for _, lex := range lexer.New(fileName, input).Lexemes() {
+		switch lex.Token() {
+		case token.EOF:
+		case token.COMMENT:
+			// a #! line, naming the interpreter, isn't documentation
+			if !code[lex.LineNo()] && !strings.HasPrefix(lex.Literal(), "#!") {
+				comments[lex.LineNo()] = commentText(lex.Literal())
+			}
+		default:
+			code[lex.LineNo()] = true
+			lexemes = append(lexemes, lex)
+		}
+	}
+
+	// before returns the comment on the lines just before a line.
+	before := func(line int) string {
+		var lines []string
+		for l := line - 1; l > 0 && !code[l]; l-- {
+			text, ok := comments[l]
+			if !ok {
+				break
+			}
+			lines = append([]string{text}, lines...)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	for _, stmt := range statements(lexemes) {
+		if d, ok := declaration(stmt, before); ok {
+			p.Decls = append(p.Decls, d)
+			continue
+		}
+
+		if is(stmt, token.PKG, token.IDENT) && p.Name == "" {
+			p.Name = stmt[1].Literal()
+			p.Doc = before(stmt[0].LineNo())
+		}
+	}
+
+	if p.Name == "" {
+		p.Doc = fileComment(input, comments, code)
+	}
+
+	return p
+}
+
+// fileComment returns the first comment of a file, if a blank line follows it.
+func fileComment(input []string, comments map[int]string, code map[int]bool) string {
+
+	var lines []string
+
+	for l := 1; l <= len(input); l++ {
+		text, ok := comments[l]
+		switch {
+		case ok:
+			lines = append(lines, text)
+		case code[l]:
+			return ""
+		case len(lines) > 0:
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	return ""
+}
+
+// commentText strips the # of a comment, and the space after it.
+func commentText(comment string) string {
+
+	text := strings.TrimPrefix(comment, "#")
+	text = strings.TrimPrefix(text, " ")
+
+	return strings.TrimRight(text, " \t")
+}
+
+// statements splits lexemes into statements, at the semicolons outside any
+// brackets.
+func statements(lexemes []lexer.Lexeme) [][]lexer.Lexeme {
+
+	var stmts [][]lexer.Lexeme
+	depth, start := 0, 0
+
+	for i, lex := range lexemes {
+		switch lex.Token() {
+		case token.LPAREN, token.LSQR, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RSQR, token.RBRACE:
+			depth--
+		case token.SEMI:
+			if depth == 0 {
+				if i > start {
+					stmts = append(stmts, lexemes[start:i])
+				}
+				start = i + 1
+			}
+		}
+	}
+
+	if start < len(lexemes) {
+		stmts = append(stmts, lexemes[start:])
+	}
+
+	return stmts
+}
+
+// is checks if a statement starts with tokens.
+func is(stmt []lexer.Lexeme, toks ...token.Token) bool {
+
+	if len(stmt) < len(toks) {
+		return false
+	}
+
+	for i, tok := range toks {
+		if stmt[i].Token() != tok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// inside returns the lexemes inside the brackets opened at stmt[open].
+func inside(stmt []lexer.Lexeme, open int) []lexer.Lexeme {
+
+	depth := 0
+
+	for i := open; i < len(stmt); i++ {
+		switch stmt[i].Token() {
+		case token.LPAREN, token.LSQR, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RSQR, token.RBRACE:
+			depth--
+			if depth == 0 {
+				return stmt[open+1 : i]
+			}
+		}
+	}
+
+	return stmt[open+1:]
+}
+
+// idents returns the identifiers outside any further brackets.
+func idents(lexemes []lexer.Lexeme) []string {
+
+	var names []string
+
+	for _, stmt := range split(lexemes, token.COMMA) {
+		if len(stmt) > 0 && stmt[0].Token() == token.IDENT {
+			names = append(names, stmt[0].Literal())
+		}
+	}
+
+	return names
+}
+
+// split splits lexemes at a token outside any brackets.
+func split(lexemes []lexer.Lexeme, at token.Token) [][]lexer.Lexeme {
+
+	var parts [][]lexer.Lexeme
+	depth, start := 0, 0
+
+	for i, lex := range lexemes {
+		switch lex.Token() {
+		case token.LPAREN, token.LSQR, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RSQR, token.RBRACE:
+			depth--
+		case at:
+			if depth == 0 {
+				parts = append(parts, lexemes[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, lexemes[start:])
+}
+
+// declaration recognizes the declaration of a func, struct or enum:
+//
+//	name := func(params)[channels] { ... }
+//	name := struct { ... }
+//	struct name { ... }
+//	enum name { ... }
+func declaration(stmt []lexer.Lexeme, before func(int) string) (Decl, bool) {
+
+	switch {
+	case is(stmt, token.IDENT, token.ASSIGN, token.FUNC, token.LPAREN):
+		d := Decl{Name: stmt[0].Literal(), Kind: "func"}
+		signature(&d, stmt, 3)
+		d.Line, d.Doc = stmt[0].LineNo(), before(stmt[0].LineNo())
+		return d, true
+
+	case is(stmt, token.IDENT, token.ASSIGN, token.STRUCT, token.LBRACE):
+		return structDecl(stmt[0].Literal(), stmt, 3, before), true
+
+	case is(stmt, token.STRUCT, token.IDENT, token.LBRACE):
+		return structDecl(stmt[1].Literal(), stmt, 2, before), true
+
+	case is(stmt, token.ENUM, token.IDENT, token.LBRACE):
+		d := Decl{Name: stmt[1].Literal(), Kind: "enum"}
+		for _, value := range statements(inside(stmt, 2)) {
+			if value[0].Token() != token.IDENT {
+				continue
+			}
+			d.Fields = append(d.Fields, value[0].Literal())
+			if is(value, token.IDENT, token.COLON) {
+				d.Values = append(d.Values, literal(value[2:]))
+			} else {
+				d.Values = append(d.Values, "")
+			}
+		}
+		d.Line, d.Doc = stmt[0].LineNo(), before(stmt[0].LineNo())
+		return d, true
+	}
+
+	return Decl{}, false
+}
+
+// literal formats the lexemes of a value, e.g. "B" or -1, as they're written.
+func literal(lexemes []lexer.Lexeme) string {
+
+	var sb strings.Builder
+
+	for i, lex := range lexemes {
+		if i > 0 && lexemes[i-1].Token() != token.MINUS {
+			sb.WriteString(" ")
+		}
+
+		switch lex.Token() {
+		case token.STRING:
+			sb.WriteString(strconv.Quote(lex.Literal()))
+		case token.CHAR:
+			sb.WriteString("'" + lex.Literal() + "'")
+		default:
+			sb.WriteString(lex.Literal())
+		}
+	}
+
+	return sb.String()
+}
+
+// signature notes the parameters, and channels, of a func whose parameters
+// are opened at stmt[open].
+func signature(d *Decl, stmt []lexer.Lexeme, open int) {
+
+	d.Params = idents(inside(stmt, open))
+
+	// the channels follow the ) closing the parameters
+	if end := open + len(inside(stmt, open)) + 1; end+1 < len(stmt) && stmt[end+1].Token() == token.LSQR {
+		d.Channels = idents(inside(stmt, end+1))
+	}
+}
+
+// structDecl notes the fields and methods of a struct whose body is opened at
+// stmt[open].
+func structDecl(name string, stmt []lexer.Lexeme, open int, before func(int) string) Decl {
+
+	d := Decl{Name: name, Kind: "struct", Line: stmt[0].LineNo(), Doc: before(stmt[0].LineNo())}
+
+	for _, field := range statements(inside(stmt, open)) {
+		switch {
+		case is(field, token.IDENT, token.ASSIGN, token.FUNC, token.LPAREN):
+			m := Decl{Name: name + "." + field[0].Literal(), Kind: "method"}
+			signature(&m, field, 3)
+			m.Line, m.Doc = field[0].LineNo(), before(field[0].LineNo())
+			d.Methods = append(d.Methods, m)
+
+		case field[0].Token() == token.IDENT:
+			d.Fields = append(d.Fields, field[0].Literal())
+		}
+	}
+
+	return d
+}
+
+// Lookup finds the declarations, and pkgs, named name, e.g. area, Point,
+// Point.String, or, in pkg geometry, geometry.area. A pkg is returned as a
+// Decl of kind pkg, with the declarations of the file as its methods.
+func Lookup(pkgs []*Package, name string) []Decl {
+
+	var found []Decl
+
+	for _, p := range pkgs {
+		if p.Name != "" && p.Name == name {
+			found = append(found, Decl{Name: p.Name, Kind: "pkg", Doc: p.Doc, Methods: p.Decls})
+		}
+
+		name := name
+		if p.Name != "" {
+			name = strings.TrimPrefix(name, p.Name+".")
+		}
+
+		for _, d := range p.Decls {
+			if d.Name == name {
+				found = append(found, d)
+			}
+			for _, m := range d.Methods {
+				if m.Name == name {
+					found = append(found, m)
+				}
+			}
+		}
+	}
+
+	return found
+}
+
+// Signature describes how a declaration is written, e.g.
+//
+//	area := func(w, h)
+func (d Decl) Signature() string {
+
+	switch d.Kind {
+	case "func", "method":
+		name := d.Name
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		s := name + " := func(" + strings.Join(d.Params, ", ") + ")"
+		if len(d.Channels) > 0 {
+			s += "[" + strings.Join(d.Channels, ", ") + "]"
+		}
+		return s
+	}
+
+	return d.Kind + " " + d.Name
+}
+
+// Synopsis returns the first sentence of a comment.
+func Synopsis(doc string) string {
+
+	doc = strings.Join(strings.Fields(doc), " ")
+
+	if i := strings.Index(doc, ". "); i >= 0 {
+		return doc[:i+1]
+	}
+
+	return doc
+}
@@ -0,0 +1,184 @@
+package doc_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/doc"
+)
+
+const source = `#!/usr/bin/env gosh
+# Package geometry measures shapes.
+pkg geometry
+
+# area returns the area of a rectangle. Both sides must
+# be positive.
+area := func(w, h) {
+	w * h
+}
+
+# Point is a point on the plane.
+struct Point {
+	x := 0   # across
+	y := 0
+	# String formats the point.
+	String := func(p) {
+		"${p.x},${p.y}"
+	}
+}
+
+# not the comment of Color
+
+enum Color {
+	blue: "B"
+	red: "R"
+	green
+	black: -1
+}
+
+send := func(a)[out] { out << a }`
+
+func TestParse(t *testing.T) {
+
+	p := doc.Parse("geometry.gosh", strings.Split(source, "\n"))
+
+	if p.Name != "geometry" || p.Doc != "Package geometry measures shapes." {
+		t.Errorf("expected pkg geometry, with its comment, got %q, %q", p.Name, p.Doc)
+	}
+
+	expected := []doc.Decl{
+		{Name: "area", Kind: "func", Params: []string{"w", "h"}, Line: 7,
+			Doc: "area returns the area of a rectangle. Both sides must\nbe positive."},
+		{Name: "Point", Kind: "struct", Fields: []string{"x", "y"}, Line: 12, Doc: "Point is a point on the plane.",
+			Methods: []doc.Decl{{Name: "Point.String", Kind: "method", Params: []string{"p"}, Line: 16, Doc: "String formats the point."}}},
+		{Name: "Color", Kind: "enum", Fields: []string{"blue", "red", "green", "black"},
+			Values: []string{`"B"`, `"R"`, "", "-1"}, Line: 23},
+		{Name: "send", Kind: "func", Params: []string{"a"}, Channels: []string{"out"}, Line: 30},
+	}
+
+	if !reflect.DeepEqual(p.Decls, expected) {
+		t.Errorf("expected declarations\n%+v\ngot\n%+v", expected, p.Decls)
+	}
+
+	// without a pkg, the comment of the file is its first, if it stands apart
+	for input, comment := range map[string]string{
+		"# Helpers.\n\n# twice doubles.\ntwice := func(x) { x * 2 }": "Helpers.",
+		"# twice doubles.\ntwice := func(x) { x * 2 }":               "",
+	} {
+		if p := doc.Parse("helpers.gosh", strings.Split(input, "\n")); p.Doc != comment {
+			t.Errorf("expected the comment of the file to be %q, got %q", comment, p.Doc)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+
+	pkgs := []*doc.Package{doc.Parse("geometry.gosh", strings.Split(source, "\n"))}
+
+	for name, kind := range map[string]string{
+		"area":          "func",
+		"geometry.area": "func",
+		"Point.String":  "method",
+		"Color":         "enum",
+		"geometry":      "pkg",
+	} {
+		found := doc.Lookup(pkgs, name)
+		if len(found) != 1 || found[0].Kind != kind {
+			t.Errorf("expected to find the %s %s, got %+v", kind, name, found)
+		}
+	}
+
+	if found := doc.Lookup(pkgs, "perimeter"); len(found) != 0 {
+		t.Errorf("expected nothing named perimeter, got %+v", found)
+	}
+
+	var out bytes.Buffer
+	if err := doc.Text(&out, doc.Lookup(pkgs, "Point")[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `struct Point {
+	x
+	y
+	String := func(p)
+}
+
+    Point is a point on the plane.
+
+String := func(p)
+    String formats the point.
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := doc.Text(&out, doc.Lookup(pkgs, "Color")[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "enum Color {\n\tblue: \"B\"\n\tred: \"R\"\n\tgreen\n\tblack: -1\n}\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestRender(t *testing.T) {
+
+	pkgs := []*doc.Package{doc.Parse("geometry.gosh", strings.Split(source, "\n"))}
+
+	var index bytes.Buffer
+	if err := doc.Index(&index, pkgs); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `pkg geometry
+    Package geometry measures shapes.
+
+    area := func(w, h)
+        area returns the area of a rectangle.
+
+    struct Point
+        Point is a point on the plane.
+
+    enum Color
+
+    send := func(a)[out]
+`
+	if index.String() != expected {
+		t.Errorf("expected index\n%s\ngot\n%s", expected, index.String())
+	}
+
+	var markdown bytes.Buffer
+	if err := doc.Markdown(&markdown, pkgs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# pkg geometry\n\nPackage geometry measures shapes.\n",
+		"- [struct Point](#struct-point)\n  - [Point.String](#method-pointstring)\n",
+		"## enum Color\n\n    enum Color {\n    \tblue: \"B\"\n    \tred: \"R\"\n    \tgreen\n    \tblack: -1\n    }\n",
+	} {
+		if !strings.Contains(markdown.String(), want) {
+			t.Errorf("expected the Markdown to contain\n%s\ngot\n%s", want, markdown.String())
+		}
+	}
+
+	var page bytes.Buffer
+	if err := doc.HTML(&page, pkgs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<li><a href="#func-area">func area</a></li>`,
+		`<h3 id="method-pointstring">method Point.String</h3>`,
+		`<p class="doc">Point is a point on the plane.</p>`,
+		"<pre>enum Color {\n\tblue: &#34;B&#34;\n\tred: &#34;R&#34;\n\tgreen\n\tblack: -1\n}</pre>",
+	} {
+		if !strings.Contains(page.String(), want) {
+			t.Errorf("expected the page to contain %s, got\n%s", want, page.String())
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package doc
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// title names a package by its pkg, or its file.
+func (p *Package) title() string {
+
+	if p.Name != "" {
+		return "pkg " + p.Name
+	}
+
+	return p.File
+}
+
+// declaration returns the source of a declaration, without the bodies of
+// funcs, e.g.
+//
+//	struct Point {
+//		x
+//		y
+//		String := func(p)
+//	}
+func (d Decl) declaration() []string {
+
+	switch d.Kind {
+	case "struct", "enum":
+		lines := []string{d.Kind + " " + d.Name + " {"}
+		for i, f := range d.Fields {
+			if i < len(d.Values) && d.Values[i] != "" {
+				f += ": " + d.Values[i]
+			}
+			lines = append(lines, "\t"+f)
+		}
+		for _, m := range d.Methods {
+			lines = append(lines, "\t"+m.Signature())
+		}
+		return append(lines, "}")
+
+	case "pkg":
+		return []string{"pkg " + d.Name}
+	}
+
+	return []string{d.Signature()}
+}
+
+// indent indents the lines of text.
+func indent(text, by string) string {
+
+	if text == "" {
+		return ""
+	}
+
+	return by + strings.ReplaceAll(text, "\n", "\n"+by)
+}
+
+// Index writes a line for each declaration of each package, with the first
+// sentence of its comment.
+func Index(w io.Writer, pkgs []*Package) error {
+
+	bw := bufio.NewWriter(w)
+
+	for i, p := range pkgs {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "%s\n", p.title())
+		if p.Doc != "" {
+			fmt.Fprintf(bw, "%s\n", indent(Synopsis(p.Doc), "    "))
+		}
+
+		for _, d := range p.Decls {
+			fmt.Fprintf(bw, "\n    %s\n", d.Signature())
+			if d.Doc != "" {
+				fmt.Fprintf(bw, "        %s\n", Synopsis(d.Doc))
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Text writes the documentation of a declaration, e.g. for gosh doc name: its
+// declaration, then its comment. The methods of a struct, and declarations
+// of a pkg, follow, with their comments.
+func Text(w io.Writer, d Decl) error {
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%s\n", strings.Join(d.declaration(), "\n"))
+	if d.Doc != "" {
+		fmt.Fprintf(bw, "\n%s\n", indent(d.Doc, "    "))
+	}
+
+	for _, m := range d.Methods {
+		fmt.Fprintf(bw, "\n%s\n", m.Signature())
+		if m.Doc != "" {
+			fmt.Fprintf(bw, "%s\n", indent(Synopsis(m.Doc), "    "))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// anchor returns the id of the heading of a declaration, as GitHub makes them.
+func anchor(heading string) string {
+
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(heading) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// heading returns the heading of a declaration, e.g. func area.
+func (d Decl) heading() string {
+	return d.Kind + " " + d.Name
+}
+
+// Markdown writes the documentation of packages as Markdown: an index of each,
+// linking to each declaration, with its source and comment.
+func Markdown(w io.Writer, pkgs []*Package) error {
+
+	bw := bufio.NewWriter(w)
+
+	for i, p := range pkgs {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "# %s\n\n", p.title())
+		if p.Doc != "" {
+			fmt.Fprintf(bw, "%s\n\n", p.Doc)
+		}
+
+		fmt.Fprintf(bw, "## Index\n\n")
+		for _, d := range p.Decls {
+			fmt.Fprintf(bw, "- [%s](#%s)\n", d.heading(), anchor(d.heading()))
+			for _, m := range d.Methods {
+				fmt.Fprintf(bw, "  - [%s](#%s)\n", m.Name, anchor(m.heading()))
+			}
+		}
+
+		for _, d := range p.Decls {
+			fmt.Fprintf(bw, "\n## %s\n\n%s\n", d.heading(), indent(strings.Join(d.declaration(), "\n"), "    "))
+			if d.Doc != "" {
+				fmt.Fprintf(bw, "\n%s\n", d.Doc)
+			}
+
+			for _, m := range d.Methods {
+				fmt.Fprintf(bw, "\n### %s\n\n    %s\n", m.heading(), m.Signature())
+				if m.Doc != "" {
+					fmt.Fprintf(bw, "\n%s\n", m.Doc)
+				}
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+const style = `body { font-family: sans-serif; max-width: 50em; margin: auto; }
+pre { font-family: monospace; background: #f4f4f4; padding: 0.5em; }
+.doc { white-space: pre-wrap; }`
+
+// HTML writes the documentation of packages as a page: an index of each,
+// linking to each declaration, with its source and comment.
+func HTML(w io.Writer, pkgs []*Package) error {
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>gosh doc</title>\n<style>\n%s\n</style>\n</head>\n<body>\n", style)
+
+	comment := func(doc string) {
+		if doc != "" {
+			fmt.Fprintf(bw, "<p class=\"doc\">%s</p>\n", html.EscapeString(doc))
+		}
+	}
+
+	for _, p := range pkgs {
+		fmt.Fprintf(bw, "<h1>%s</h1>\n", html.EscapeString(p.title()))
+		comment(p.Doc)
+
+		fmt.Fprintf(bw, "<h2>Index</h2>\n<ul>\n")
+		for _, d := range p.Decls {
+			fmt.Fprintf(bw, "<li><a href=\"#%s\">%s</a>", anchor(d.heading()), html.EscapeString(d.heading()))
+			if len(d.Methods) > 0 {
+				fmt.Fprintf(bw, "\n<ul>\n")
+				for _, m := range d.Methods {
+					fmt.Fprintf(bw, "<li><a href=\"#%s\">%s</a></li>\n", anchor(m.heading()), html.EscapeString(m.Name))
+				}
+				fmt.Fprintf(bw, "</ul>\n")
+			}
+			fmt.Fprintf(bw, "</li>\n")
+		}
+		fmt.Fprintf(bw, "</ul>\n")
+
+		for _, d := range p.Decls {
+			fmt.Fprintf(bw, "<h2 id=\"%s\">%s</h2>\n", anchor(d.heading()), html.EscapeString(d.heading()))
+			fmt.Fprintf(bw, "<pre>%s</pre>\n", html.EscapeString(strings.Join(d.declaration(), "\n")))
+			comment(d.Doc)
+
+			for _, m := range d.Methods {
+				fmt.Fprintf(bw, "<h3 id=\"%s\">%s</h3>\n", anchor(m.heading()), html.EscapeString(m.heading()))
+				fmt.Fprintf(bw, "<pre>%s</pre>\n", html.EscapeString(m.Signature()))
+				comment(m.Doc)
+			}
+		}
+	}
+
+	fmt.Fprintf(bw, "</body>\n</html>\n")
+
+	return bw.Flush()
+}
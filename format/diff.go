@@ -0,0 +1,129 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff returns a unified diff of two versions of a file, with three lines of
+// context around each change, or "" if they're the same.
+func Diff(fileName string, before, after []string) string {
+
+	edits := diffLines(before, after)
+
+	var sb strings.Builder
+
+	const context = 3
+
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// a hunk runs from a change, through any others within twice the
+		// context of each other.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for j := i; j < len(edits) && j <= end+2*context; j++ {
+			if edits[j].op != ' ' {
+				end = j
+			}
+		}
+		end += context + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fileName, fileName)
+		}
+
+		oldStart, newStart := edits[start].old, edits[start].new
+		oldLines, newLines := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				oldLines++
+			}
+			if e.op != '-' {
+				newLines++
+			}
+		}
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldStart, oldLines), hunkRange(newStart, newLines))
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(&sb, "%c%s\n", e.op, e.text)
+		}
+
+		i = end
+	}
+
+	return sb.String()
+}
+
+// hunkRange formats the start and length of a hunk, where start counts from
+// 0, as a unified diff does.
+func hunkRange(start, length int) string {
+
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// edit is a line of a diff: kept (' '), removed ('-') or added ('+'), with the
+// number of lines of each version before it.
+type edit struct {
+	op       byte
+	text     string
+	old, new int
+}
+
+// diffLines finds the edits turning one list of lines into another, keeping
+// their longest common subsequence.
+func diffLines(a, b []string) []edit {
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	return edits
+}
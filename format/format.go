@@ -0,0 +1,560 @@
+// Package format prints gosh source in a canonical layout: blocks indented by
+// tabs, one statement per line, single spaces around binary operators, and the
+// fields of structs aligned. Comments, and single blank lines between
+// statements, are kept.
+package format
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/parse"
+	"github.com/pdk/gosh/token"
+)
+
+// Source formats an input. It is an error if the input doesn't parse.
+func Source(inputName string, input []string) ([]string, error) {
+
+	_, err := parse.New(lexer.New(inputName, input)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &printer{}
+	p.print(chunks(input, lexer.New(inputName, input).Lexemes()))
+
+	return p.output(), nil
+}
+
+// chunk is a piece of the source to print: a lexeme, or a whole string
+// literal, which may contain the lexemes of interpolated expressions.
+type chunk struct {
+	tok     token.Token
+	text    string
+	line    int // where the chunk starts
+	endLine int // where the chunk ends, if it spans lines
+}
+
+// position is the location of a lexeme.
+type position struct {
+	line, char int
+}
+
+func (p position) before(q position) bool {
+	return p.line < q.line || (p.line == q.line && p.char < q.char)
+}
+
+func positionOf(lex lexer.Lexeme) position {
+	return position{lex.LineNo(), lex.CharNo()}
+}
+
+// chunks groups the lexemes of an input into chunks. Literals whose text
+// isn't the literal of their lexemes, i.e. strings and chars, are copied from
+// the input, up to the lexeme which follows them.
+func chunks(input []string, lexemes []lexer.Lexeme) []chunk {
+
+	var starts []position
+	for _, lex := range lexemes {
+		starts = append(starts, positionOf(lex))
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].before(starts[j]) })
+
+	// next returns where the lexeme following a position starts.
+	next := func(p position) position {
+		i := sort.Search(len(starts), func(i int) bool { return p.before(starts[i]) })
+		if i == len(starts) {
+			return position{len(input) + 1, 0}
+		}
+		return starts[i]
+	}
+
+	var cs []chunk
+
+	for i := 0; i < len(lexemes); i++ {
+		lex := lexemes[i]
+		c := chunk{
+			tok:     lex.Token(),
+			text:    lex.Literal(),
+			line:    lex.LineNo(),
+			endLine: lex.LineNo(),
+		}
+
+		switch lex.Token() {
+		case token.EOF:
+			continue
+
+		case token.COMMENT:
+			c.text = strings.TrimRightFunc(c.text, unicode.IsSpace)
+
+		case token.STRING, token.CHAR, token.DOLLAR, token.DDOLLAR, token.INTERP_BEG:
+			last := positionOf(lex)
+			if lex.Token() == token.INTERP_BEG {
+				// the whole interpolated string, up to its matching end.
+				for depth := 1; depth > 0 && i+1 < len(lexemes); {
+					i++
+					switch lexemes[i].Token() {
+					case token.INTERP_BEG:
+						depth++
+					case token.INTERP_END:
+						depth--
+					}
+					if p := positionOf(lexemes[i]); last.before(p) {
+						last = p
+					}
+				}
+				c.tok = token.STRING
+			}
+			c.text = source(input, positionOf(lex), next(last))
+			c.endLine = c.line + strings.Count(c.text, "\n")
+		}
+
+		cs = append(cs, c)
+	}
+
+	return cs
+}
+
+// source returns the text of the input from one position up to another,
+// without trailing space.
+func source(input []string, from, to position) string {
+
+	var sb strings.Builder
+
+	for line := from.line; line <= to.line && line <= len(input); line++ {
+		runes := []rune(input[line-1])
+
+		start, end := 0, len(runes)
+		if line == from.line {
+			start = from.char - 1
+		}
+		if line == to.line && to.char-1 < end {
+			end = to.char - 1
+		}
+
+		if line > from.line {
+			sb.WriteString("\n")
+		}
+		if start < end {
+			sb.WriteString(string(runes[start:end]))
+		}
+	}
+
+	return strings.TrimRightFunc(sb.String(), unicode.IsSpace)
+}
+
+// line is a line of output.
+type line struct {
+	indent  int
+	text    string
+	comment string // after the code on the line, aligned with those around it
+	field   int    // length of the name of a struct field assigned on the line, or 0
+	alone   bool   // the line is only a comment
+}
+
+// block is a brace-delimited block being printed.
+type block struct {
+	inline   bool // printed on one line, e.g. { return x }
+	isStruct bool
+}
+
+// printer lays out chunks.
+type printer struct {
+	lines  []line
+	blocks []block
+	indent int
+
+	cur      []chunk // of the line being printed
+	text     string  // of the line being printed
+	cont     bool    // the line continues a statement from the line before
+	lastLine int     // of the input, where the last chunk printed ended
+
+	groups     []bool // for each open ( or [, whether it's the parameters of a func
+	closedFunc bool   // the last chunk closed the parameters of a func
+}
+
+func (p *printer) print(cs []chunk) {
+
+	inline := make(map[int]bool) // by the offset of a {
+
+	for i, c := range cs {
+
+		switch c.tok {
+		case token.SEMI:
+			p.flush()
+			p.cont = false
+
+		case token.COMMENT:
+			p.comment(c)
+
+		case token.LBRACE:
+			if _, ok := inline[i]; !ok {
+				// the arms of an if are all on one line, or none are
+				chain := arms(cs, i)
+				all := true
+				for _, j := range chain {
+					all = all && inlineBlock(cs, j)
+				}
+				for _, j := range chain {
+					inline[j] = all
+				}
+			}
+			p.openBlock(c, inline[i])
+
+		case token.RBRACE:
+			p.closeBlock(c)
+
+		default:
+			p.add(c)
+		}
+
+		if c.endLine > p.lastLine {
+			p.lastLine = c.endLine
+		}
+	}
+
+	p.flush()
+}
+
+// inlineBlock checks if the block opened at cs[i] is all on one line, without
+// separate statements or comments, so it can stay on one line.
+func inlineBlock(cs []chunk, i int) bool {
+
+	depth := 0
+	for j := i; j < len(cs); j++ {
+		switch cs[j].tok {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			depth--
+			if depth == 0 {
+				return cs[j].line == cs[i].line
+			}
+		case token.SEMI, token.COMMENT:
+			return false
+		}
+	}
+
+	return false
+}
+
+// arms returns the offsets of the { of the block opened at cs[i], and of the
+// else blocks which follow it, if it's the block of an if.
+func arms(cs []chunk, i int) []int {
+
+	offsets := []int{i}
+
+	for {
+		j := closing(cs, i)
+		if j < 0 || j+1 >= len(cs) || cs[j+1].tok != token.ELSE {
+			return offsets
+		}
+
+		i = -1
+		for k := j + 2; k < len(cs) && i < 0; k++ {
+			if cs[k].tok == token.LBRACE {
+				i = k
+			}
+		}
+		if i < 0 {
+			return offsets
+		}
+
+		offsets = append(offsets, i)
+	}
+}
+
+// closing returns the offset of the } closing the block opened at cs[i], or -1.
+func closing(cs []chunk, i int) int {
+
+	depth := 0
+	for j := i; j < len(cs); j++ {
+		switch cs[j].tok {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return -1
+}
+
+// add adds a chunk to the line being printed.
+func (p *printer) add(c chunk) {
+
+	if len(p.cur) == 0 {
+		p.blankLine(c)
+	} else if p.spaceBefore(c) {
+		p.text += " "
+	}
+
+	switch c.tok {
+	case token.LPAREN, token.LSQR:
+		p.groups = append(p.groups, c.tok == token.LPAREN && p.lastIs(token.FUNC))
+	}
+
+	p.closedFunc = false
+	switch c.tok {
+	case token.RPAREN, token.RSQR:
+		if n := len(p.groups); n > 0 {
+			p.closedFunc = p.groups[n-1]
+			p.groups = p.groups[:n-1]
+		}
+	}
+
+	p.text += c.text
+	p.cur = append(p.cur, c)
+}
+
+// lastIs checks if the last chunk of the line being printed is one of the
+// tokens.
+func (p *printer) lastIs(toks ...token.Token) bool {
+
+	if len(p.cur) == 0 {
+		return false
+	}
+
+	last := p.cur[len(p.cur)-1].tok
+	for _, tok := range toks {
+		if last == tok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// spaceBefore checks if a space separates a chunk from the one before it.
+func (p *printer) spaceBefore(c chunk) bool {
+
+	if p.lastIs(token.LPAREN, token.LSQR, token.PERIOD, token.NOT, token.COLON) || p.afterPrefix() {
+		return false
+	}
+
+	switch c.tok {
+	case token.RPAREN, token.RSQR, token.COMMA, token.PERIOD, token.COLON:
+		return false
+
+	case token.LPAREN:
+		// a call, or the parameters of a func
+		return !p.lastIs(token.IDENT, token.RPAREN, token.RSQR, token.STRING,
+			token.FUNC, token.NIL, token.TRY)
+
+	case token.LSQR:
+		// indexing, rather than a list, or the channels of a func
+		return p.closedFunc || !p.lastIs(token.IDENT, token.RPAREN, token.RSQR, token.STRING)
+	}
+
+	return true
+}
+
+// afterPrefix checks if the last chunk of the line is a - or ^ used as a
+// prefix operator, e.g. -x.
+func (p *printer) afterPrefix() bool {
+
+	n := len(p.cur)
+	if n == 0 || !p.lastIs(token.MINUS, token.BIT_XOR) {
+		return false
+	}
+
+	if n == 1 {
+		return true
+	}
+
+	before := p.cur[n-2].tok
+	switch before {
+	case token.RPAREN, token.RSQR, token.RBRACE,
+		token.TRUE, token.FALSE, token.NIL, token.BREAK, token.CONTINUE:
+		return false
+	}
+
+	return before.IsOperator() || before.IsKeyword()
+}
+
+// blankLine keeps a blank line before a chunk starting a line, if there was
+// one in the input, except at the start or end of a block.
+func (p *printer) blankLine(c chunk) {
+
+	if len(p.lines) == 0 || c.line <= p.lastLine+1 || c.tok == token.RBRACE {
+		return
+	}
+
+	last := p.lines[len(p.lines)-1].text
+	if last == "" || strings.HasSuffix(last, "{") {
+		return
+	}
+
+	p.lines = append(p.lines, line{})
+}
+
+// comment prints a comment, either after the code on the same line of the
+// input, or on its own line.
+func (p *printer) comment(c chunk) {
+
+	if c.line == p.lastLine && (len(p.cur) > 0 || len(p.lines) > 0) {
+		if len(p.cur) > 0 {
+			// in the middle of a statement, which continues on the next line.
+			p.text += " " + c.text
+			p.flush()
+			p.cont = true
+			return
+		}
+		p.lines[len(p.lines)-1].comment = c.text
+		return
+	}
+
+	cont := len(p.cur) > 0 || p.cont
+	p.flush()
+	p.cont = cont
+
+	p.blankLine(c)
+	p.text = c.text
+	p.cur = append(p.cur, c)
+	p.flush()
+	p.cont = cont
+}
+
+// openBlock prints a {, either keeping the block on one line, or starting a
+// new line for its statements.
+func (p *printer) openBlock(c chunk, inline bool) {
+
+	isStruct := p.lastIs(token.STRUCT) ||
+		(len(p.cur) >= 2 && p.cur[len(p.cur)-2].tok == token.STRUCT)
+
+	p.add(c)
+	p.blocks = append(p.blocks, block{inline: inline, isStruct: isStruct})
+
+	if inline {
+		return
+	}
+
+	p.flush()
+	p.cont = false
+	p.indent++
+}
+
+// closeBlock prints a }, on the line of the block, or on a new line.
+func (p *printer) closeBlock(c chunk) {
+
+	inline := false
+	if n := len(p.blocks); n > 0 {
+		inline = p.blocks[n-1].inline
+		p.blocks = p.blocks[:n-1]
+	}
+
+	if inline {
+		if !p.lastIs(token.LBRACE) {
+			p.text += " "
+		}
+		p.text += c.text
+		p.cur = append(p.cur, c)
+		return
+	}
+
+	p.flush()
+	p.cont = false
+	if p.indent > 0 {
+		p.indent--
+	}
+
+	p.text = c.text
+	p.cur = append(p.cur, c)
+}
+
+// flush ends the line being printed.
+func (p *printer) flush() {
+
+	if len(p.cur) == 0 {
+		return
+	}
+
+	l := line{indent: p.indent, text: p.text}
+	if p.cont {
+		l.indent++
+	}
+	l.alone = len(p.cur) == 1 && p.cur[0].tok == token.COMMENT
+
+	inStruct := len(p.blocks) > 0 && p.blocks[len(p.blocks)-1].isStruct
+	if inStruct && !p.cont && len(p.cur) >= 3 &&
+		p.cur[0].tok == token.IDENT && p.cur[1].tok == token.ASSIGN &&
+		p.cur[len(p.cur)-1].tok != token.LBRACE {
+
+		l.field = utf8.RuneCountInString(p.cur[0].text)
+	}
+
+	p.lines = append(p.lines, l)
+	p.cur = nil
+	p.text = ""
+}
+
+// output returns the lines printed, with struct fields aligned. Lines with
+// strings spanning lines are split.
+func (p *printer) output() []string {
+
+	alignFields(p.lines)
+
+	var out []string
+	for _, l := range p.lines {
+		if l.text == "" {
+			out = append(out, "")
+			continue
+		}
+		text := strings.Repeat("\t", l.indent) + l.text
+		if l.comment != "" {
+			text += " " + l.comment
+		}
+		out = append(out, strings.Split(text, "\n")...)
+	}
+
+	return out
+}
+
+// alignFields lines up the := of consecutive lines assigning struct fields,
+// and then their trailing comments. Lines of only a comment don't break a run
+// of fields.
+func alignFields(lines []line) {
+
+	for i := 0; i < len(lines); {
+		if lines[i].field == 0 {
+			i++
+			continue
+		}
+
+		j, width := i, 0
+		for ; j < len(lines) && (lines[j].field > 0 || lines[j].alone); j++ {
+			if lines[j].field > width {
+				width = lines[j].field
+			}
+		}
+
+		code := 0
+		for k := i; k < j; k++ {
+			l := &lines[k]
+			if l.field == 0 {
+				continue
+			}
+			name := string([]rune(l.text)[:l.field])
+			rest := string([]rune(l.text)[l.field:])
+			l.text = name + strings.Repeat(" ", width-l.field) + rest
+
+			if n := utf8.RuneCountInString(l.text); l.comment != "" && n > code {
+				code = n
+			}
+		}
+
+		for k := i; k < j; k++ {
+			l := &lines[k]
+			if l.field > 0 && l.comment != "" && !strings.Contains(l.text, "\n") {
+				l.text += strings.Repeat(" ", code-utf8.RuneCountInString(l.text))
+			}
+		}
+
+		i = j
+	}
+}
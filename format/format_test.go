@@ -0,0 +1,176 @@
+package format_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/format"
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/parse"
+)
+
+// checkFormat formats an input, expecting the output, and checks that
+// formatting the output changes nothing, and that it parses to the same tree
+// as the input.
+func checkFormat(t *testing.T, input, expected string) {
+
+	t.Helper()
+
+	got := formatted(t, input)
+	if got != expected {
+		t.Errorf("formatting\n%s\nexpected\n%s\nbut got\n%s", input, expected, got)
+		return
+	}
+
+	if again := formatted(t, got); again != got {
+		t.Errorf("formatting is not idempotent, reformatting\n%s\ngot\n%s", got, again)
+	}
+
+	if before, after := sexpr(t, input), sexpr(t, got); before != after {
+		t.Errorf("formatting changed the program from\n%s\nto\n%s", before, after)
+	}
+}
+
+func formatted(t *testing.T, input string) string {
+
+	t.Helper()
+
+	out, err := format.Source("test.gosh", strings.Split(input, "\n"))
+	if err != nil {
+		t.Fatalf("failed to format %q: %s", input, err)
+	}
+
+	return strings.Join(out, "\n")
+}
+
+func sexpr(t *testing.T, input string) string {
+
+	t.Helper()
+
+	ast, err := parse.New(lexer.New("test.gosh", strings.Split(input, "\n"))).Parse()
+	if err != nil {
+		t.Fatalf("failed to parse %q: %s", input, err)
+	}
+
+	return ast.Sexpr()
+}
+
+func TestSpacing(t *testing.T) {
+
+	checkFormat(t, "x:=1+2*-3", "x := 1 + 2 * -3")
+	checkFormat(t, "k := -1 - -2", "k := -1 - -2")
+	checkFormat(t, "y := [1,2,3]", "y := [1, 2, 3]")
+	checkFormat(t, "v := a.b.c( 1 )[2]", "v := a.b.c(1)[2]")
+	checkFormat(t, "ok:=!done&&^mask<x", "ok := !done && ^mask < x")
+	checkFormat(t, "e := f() - 1", "e := f() - 1")
+	checkFormat(t, "t := try( f(1) )", "t := try(f(1))")
+	checkFormat(t, "total+=i", "total += i")
+	checkFormat(t, "s := l[ 1 : n-1 ]+l[:0]", "s := l[1:n - 1] + l[:0]")
+}
+
+func TestLiterals(t *testing.T) {
+
+	// strings are kept as they're written
+	checkFormat(t, `s := "a  b"+'c'`, `s := "a  b" + 'c'`)
+	checkFormat(t, `z := "hi ${x+1} there"`, `z := "hi ${x+1} there"`)
+	checkFormat(t, "r := `raw\n  string`", "r := `raw\n  string`")
+	checkFormat(t, "s := \"\"\"multi\nline\"\"\"", "s := \"\"\"multi\nline\"\"\"")
+}
+
+func TestBlocks(t *testing.T) {
+
+	checkFormat(t,
+		"f := func(a, b) {\nreturn a+b\n}",
+		"f := func(a, b) {\n\treturn a + b\n}")
+
+	// the arms of an if are laid out alike
+	checkFormat(t,
+		"if x>1 { y } else {\n    z := 2\n  }",
+		"if x > 1 {\n\ty\n} else {\n\tz := 2\n}")
+	checkFormat(t,
+		"if a {\nb\n} else if c { d } else { e }",
+		"if a {\n\tb\n} else if c {\n\td\n} else {\n\te\n}")
+	checkFormat(t,
+		"v := if a { 1 } else if b { 2 } else { 3 }",
+		"v := if a { 1 } else if b { 2 } else { 3 }")
+
+	checkFormat(t,
+		"while x < 3 {\nif x {\nx += 1\n}\n}",
+		"while x < 3 {\n\tif x {\n\t\tx += 1\n\t}\n}")
+
+	checkFormat(t,
+		"g := func() [i, j] {\n\ti << 1\n}",
+		"g := func() [i, j] {\n\ti << 1\n}")
+
+	// one statement per line
+	checkFormat(t, "x := 1; y := 2", "x := 1\ny := 2")
+}
+
+func TestComments(t *testing.T) {
+
+	checkFormat(t,
+		"# a comment\nx := 1   # trailing",
+		"# a comment\nx := 1 # trailing")
+
+	checkFormat(t,
+		"while x {\n  # inside\n      x := 1\n}",
+		"while x {\n\t# inside\n\tx := 1\n}")
+
+	// a comment in the middle of a statement continues it on the next line
+	checkFormat(t,
+		"x := [1, # one\n2]",
+		"x := [1, # one\n\t2]")
+}
+
+func TestBlankLines(t *testing.T) {
+
+	checkFormat(t,
+		"x := 1\n\n\n\ny := 2",
+		"x := 1\n\ny := 2")
+
+	checkFormat(t,
+		"while x {\n\n  x := 1\n\n}",
+		"while x {\n\tx := 1\n}")
+}
+
+func TestStructFields(t *testing.T) {
+
+	checkFormat(t,
+		"Point := struct {\nx := 0\nlongname := 1\n}",
+		"Point := struct {\n\tx        := 0\n\tlongname := 1\n}")
+
+	// trailing comments keep the fields aligned, and are aligned after them
+	checkFormat(t,
+		"Point := struct {\nx := 0 # across\nlongname := 1 # down\n# the label\nlabel := \"\"\n}",
+		"Point := struct {\n\tx        := 0 # across\n\tlongname := 1 # down\n\t# the label\n\tlabel    := \"\"\n}")
+
+	checkFormat(t,
+		"s := struct {\nname := \"\" # full name\nn := 0\nlonger := 420 # answer\n}",
+		"s := struct {\n\tname   := \"\"  # full name\n\tn      := 0\n\tlonger := 420 # answer\n}")
+}
+
+func TestSyntaxError(t *testing.T) {
+
+	_, err := format.Source("test.gosh", []string{"x := (1 +"})
+	if err == nil {
+		t.Errorf("expected an error formatting an input that doesn't parse")
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	before := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	after := []string{"a", "b", "c", "D", "e", "f", "g", "h", "i"}
+
+	expected := "--- x.gosh\n+++ x.gosh\n" +
+		"@@ -1,8 +1,9 @@\n" +
+		" a\n b\n c\n-d\n+D\n e\n f\n g\n h\n+i\n"
+
+	if got := format.Diff("x.gosh", before, after); got != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, got)
+	}
+
+	if got := format.Diff("x.gosh", before, before); got != "" {
+		t.Errorf("expected no diff of the same lines, got\n%s", got)
+	}
+}
@@ -1,32 +1,55 @@
 package lexer
 
 import (
-	"errors"
 	"fmt"
 )
 
-// PrintError prints an error message, and the offending line of input.
-// func (lex *Lexeme) PrintError(message string, args ...interface{}) {
-// 	fmt.Fprintf(os.Stderr, message+"\n", args...)
-// 	fmt.Fprintf(os.Stderr, "%s\n", lex.lexer.input[lex.lineNumber-1])
-// 	fmt.Fprintf(os.Stderr, "%*s\n", lex.charNumber, "^")
-// }
-
-func (lex *Lexeme) Error(message string, args ...interface{}) error {
+// Error is an error found at a particular Lexeme.
+type Error struct {
+	Lexeme  Lexeme
+	Message string
+}
 
-	message = fmt.Sprintf(message, args...)
+// Error formats the error with the location, and the offending line of input.
+func (e *Error) Error() string {
 
-	offender := lex.lexer.input[lex.lineNumber-1]
 	// callout := fmt.Sprintf("%s【%s】%s",
 	// 	offender[0:lex.charNumber-1],
 	// 	lex.literal,
 	// 	offender[lex.charNumber+len(lex.literal)-1:])
 
-	message = fmt.Sprintf("%s:%d:%d: %s: %s",
-		lex.lexer.inputName,
-		lex.lineNumber, lex.charNumber,
-		offender,
-		message)
+	return fmt.Sprintf("%s: %s: %s",
+		e.Lexeme.Location(),
+		e.Lexeme.SourceLine(),
+		e.Message)
+}
+
+func (lex *Lexeme) Error(message string, args ...interface{}) error {
+
+	return &Error{
+		Lexeme:  *lex,
+		Message: fmt.Sprintf(message, args...),
+	}
+}
+
+// Location returns the name of the input, and the line and char number of the
+// lexeme, e.g. "foo.gosh:12:4".
+func (lex Lexeme) Location() string {
+
+	name := ""
+	if lex.lexer != nil {
+		name = lex.lexer.inputName
+	}
+
+	return fmt.Sprintf("%s:%d:%d", name, lex.lineNumber, lex.charNumber)
+}
+
+// SourceLine returns the line of input where the lexeme was found.
+func (lex Lexeme) SourceLine() string {
+
+	if lex.lexer == nil || lex.lineNumber < 1 || lex.lineNumber > len(lex.lexer.input) {
+		return ""
+	}
 
-	return errors.New(message)
+	return lex.lexer.input[lex.lineNumber-1]
 }
@@ -176,7 +176,7 @@ func (lex *Lexeme) Literal() string {
 // String returns a string representation of a Lexeme for user-friendly viewing.
 func (lex Lexeme) String() string {
 	lit := lex.literal
-	if lex.token == token.STRING {
+	if lex.token.IsString() {
 		lit = strconv.Quote(lex.literal)
 	}
 	return fmt.Sprintf("%3d, %3d %-10s %s", lex.lineNumber, lex.charNumber, lex.token.String(), lit)
@@ -185,7 +185,7 @@ func (lex Lexeme) String() string {
 // IndentString useful for printing trees of Lexemes.
 func (lex Lexeme) IndentString(n int) string {
 	lit := lex.literal
-	if lex.token == token.STRING {
+	if lex.token.IsString() {
 		lit = strconv.Quote(lex.literal)
 	}
 	return fmt.Sprintf("%3d, %3d %-10s %s%s", lex.lineNumber, lex.charNumber, lex.token.String(), strings.Repeat(" ", n), lit)
@@ -199,8 +199,10 @@ func New(name string, input []string) *Lexer {
 		input:     input,
 	}
 
-	for lineOffset := range input {
-		l.lexed = append(l.lexed, l.processOneLine(lineOffset)...)
+	for lineOffset := 0; lineOffset < len(input); lineOffset++ {
+		var xems []Lexeme
+		xems, lineOffset = l.processLine(lineOffset)
+		l.lexed = append(l.lexed, xems...)
 	}
 
 	eof := l.NewLexeme(token.EOF, "").at(len(input)+1, 0)
@@ -209,6 +211,30 @@ func New(name string, input []string) *Lexer {
 	return l
 }
 
+// Source returns a Lexer for an input, without lexing it. It is used to give
+// locations to lexemes restored by LexemeAt, e.g. when loading compiled code.
+func Source(name string, input []string) *Lexer {
+	return &Lexer{
+		inputName: name,
+		input:     input,
+	}
+}
+
+// InputName returns the name of the input.
+func (lex *Lexer) InputName() string {
+	return lex.inputName
+}
+
+// Input returns the lines of the input.
+func (lex *Lexer) Input() []string {
+	return lex.input
+}
+
+// LexemeAt makes a new Lexeme found at the given location.
+func (lex *Lexer) LexemeAt(tok token.Token, lit string, lineNo, charNo int) Lexeme {
+	return lex.NewLexeme(tok, lit).at(lineNo, charNo)
+}
+
 // Last returns a token relative to the end of the lexed tokens.
 func (lex *Lexer) Last(i int) token.Token {
 	l := len(lex.lexed) + i
@@ -219,7 +245,11 @@ func (lex *Lexer) Last(i int) token.Token {
 	return lex.lexed[l].token
 }
 
-func (lex *Lexer) processOneLine(lineOffset int) []Lexeme {
+// processLine lexes one line of input. A string literal may continue onto
+// following lines, in which case lexing continues to the end of the line where
+// the string ends. Returns the lexemes found, and the offset of the last line
+// consumed.
+func (lex *Lexer) processLine(lineOffset int) ([]Lexeme, int) {
 
 	line := lex.input[lineOffset]
 	lineNo := lineOffset + 1
@@ -234,15 +264,28 @@ func (lex *Lexer) processOneLine(lineOffset int) []Lexeme {
 
 		i += countWhitespace(chars[i:])
 
-		nt, c := lex.nextLexeme(chars[i:])
-		if nt.token != token.NADA {
-			xems = append(xems, nt.at(lineNo, i+1))
+		if isMultilineStart(chars[i:]) {
+			strs, endOffset, endChar := lex.scanMultiline(lineOffset, i)
+			xems = append(xems, strs...)
+
+			if endOffset != lineOffset {
+				lineOffset = endOffset
+				lineNo = lineOffset + 1
+				chars = stringRunes(lex.input[lineOffset])
+				l = len(chars)
+			}
+
+			i = endChar
+			continue
 		}
+
+		found, c := lex.lexOne(lineNo, chars, i)
+		xems = append(xems, found...)
 		i += c
 	}
 
 	if len(xems) == 0 {
-		return xems
+		return xems, lineOffset
 	}
 
 	// Need to check last token on the line to see if we should add a semicolon.
@@ -256,7 +299,7 @@ func (lex *Lexer) processOneLine(lineOffset int) []Lexeme {
 
 	if len(xems) == 0 {
 		// comment was the only thing on the line
-		return comment
+		return comment, lineOffset
 	}
 
 	lastTok := xems[len(xems)-1].token
@@ -265,7 +308,25 @@ func (lex *Lexer) processOneLine(lineOffset int) []Lexeme {
 	}
 
 	// reattach comment (if any) and done
-	return append(xems, comment...)
+	return append(xems, comment...), lineOffset
+}
+
+// lexOne lexes the token(s) starting at chars[i]. Usually that's one token, but
+// an interpolated string produces a token for each literal segment, plus the
+// tokens of the embedded expressions. Returns the lexemes found, and the number
+// of chars consumed.
+func (lex *Lexer) lexOne(lineNo int, chars []rune, i int) ([]Lexeme, int) {
+
+	if i < len(chars) && chars[i] == '"' {
+		return lex.scanQuoted(lineNo, chars, i)
+	}
+
+	nt, c := lex.nextLexeme(chars[i:])
+	if nt.token == token.NADA {
+		return nil, c
+	}
+
+	return []Lexeme{nt.at(lineNo, i+1)}, c
 }
 
 // doAddSemiAfter returns true if we should append a semicolon to the end of the
@@ -279,9 +340,13 @@ func doAddSemiAfter(lastTok token.Token) bool {
 		lastTok == token.FLOAT ||
 		lastTok == token.CHAR ||
 		lastTok == token.STRING ||
+		lastTok == token.INTERP_END ||
 		lastTok == token.BREAK ||
 		lastTok == token.CONTINUE ||
 		lastTok == token.RETURN ||
+		lastTok == token.TRUE ||
+		lastTok == token.FALSE ||
+		lastTok == token.NIL ||
 		lastTok == token.RPAREN ||
 		lastTok == token.RSQR ||
 		lastTok == token.RBRACE ||
@@ -367,7 +432,10 @@ func (lex *Lexer) nextLexeme(chars []rune) (Lexeme, int) {
 		if peek == '&' {
 			return lex.NewLexeme(token.LOG_AND, "&&"), 2
 		}
-		return lex.NewLexeme(token.ILLEGAL, "&"), 1
+		if peek == '^' {
+			return lex.NewLexeme(token.BIT_CLEAR, "&^"), 2
+		}
+		return lex.NewLexeme(token.BIT_AND, "&"), 1
 
 	case '=':
 		if peek == '=' {
@@ -379,7 +447,13 @@ func (lex *Lexer) nextLexeme(chars []rune) (Lexeme, int) {
 		if peek == '|' {
 			return lex.NewLexeme(token.LOG_OR, "||"), 2
 		}
-		return lex.NewLexeme(token.ILLEGAL, "|"), 1
+		return lex.NewLexeme(token.BIT_OR, "|"), 1
+
+	case '^':
+		if peek == '^' {
+			return lex.NewLexeme(token.LOG_XOR, "^^"), 2
+		}
+		return lex.NewLexeme(token.BIT_XOR, "^"), 1
 
 	case '?':
 		if peek == '=' {
@@ -414,6 +488,13 @@ func (lex *Lexer) nextLexeme(chars []rune) (Lexeme, int) {
 	case '%':
 		return lex.NewLexeme(token.MODULO, "%"), 1
 
+	case '\'':
+		r, l, ok := scanChar(chars)
+		if !ok {
+			return lex.NewLexeme(token.ILLEGAL, string(chars[:l])), l
+		}
+		return lex.NewLexeme(token.CHAR, string(r)), l
+
 	case '$':
 		tok := token.DOLLAR
 		x := 1
@@ -425,7 +506,7 @@ func (lex *Lexer) nextLexeme(chars []rune) (Lexeme, int) {
 		return lex.NewLexeme(tok, string(command)), x + l
 	}
 
-	if unicode.IsDigit(ch) {
+	if isDecimal(ch) {
 		number, isFloat := scanNumeric(chars)
 		if isFloat {
 			return lex.NewLexeme(token.FLOAT, string(number)), len(number)
@@ -433,40 +514,15 @@ func (lex *Lexer) nextLexeme(chars []rune) (Lexeme, int) {
 		return lex.NewLexeme(token.INT, string(number)), len(number)
 	}
 
-	if unicode.IsLetter(ch) || ch == '_' {
-		ident := string(scanIdent(chars))
-		which := token.CheckIdent(ident)
-		return lex.NewLexeme(which, ident), len(ident)
-	}
-
-	if ch == '"' {
-		str0 := string(scanString(chars))
-		str, err := strconv.Unquote(str0)
-		if err != nil {
-			return lex.NewLexeme(token.ILLEGAL, str0), len(str0)
-		}
-		return lex.NewLexeme(token.STRING, str), len(str0)
+	if isLetter(ch) {
+		ident := scanIdent(chars)
+		which := token.CheckIdent(string(ident))
+		return lex.NewLexeme(which, string(ident)), len(ident)
 	}
 
 	return lex.NewLexeme(token.ILLEGAL, string(ch)), 1
 }
 
-func scanString(chars []rune) []rune {
-	var r []rune
-
-	lastC := '\\'
-	for _, c := range chars {
-		if lastC != '\\' && c == '"' {
-			r = append(r, c)
-			return r
-		}
-		r = append(r, c)
-		lastC = c
-	}
-
-	return r
-}
-
 // scanCommand reads in a "command" which is stuff after a "$" or a "$$". It
 // might be a single symbol, or it might be a complex string in braces, kind of
 // like a quoted string.
@@ -495,7 +551,7 @@ func scanCommand(chars []rune) ([]rune, int) {
 		return r, c
 	}
 
-	if unicode.IsLetter(chars[0]) || chars[0] == '_' {
+	if isLetter(chars[0]) {
 		ident := scanIdent(chars)
 		return ident, c + len(ident)
 	}
@@ -528,7 +584,7 @@ func scanNumeric(chars []rune) ([]rune, bool) {
 			}
 			gotDot = true
 		}
-		if unicode.IsDigit(c) || c == '.' {
+		if isDecimal(c) || c == '.' {
 			r = append(r, c)
 		} else {
 			return r, gotDot
@@ -538,10 +594,12 @@ func scanNumeric(chars []rune) ([]rune, bool) {
 	return r, gotDot
 }
 
+// scanIdent reads an identifier. Same rules as go: a letter (or _) followed by
+// letters and digits.
 func scanIdent(chars []rune) []rune {
 	var r []rune
 	for _, c := range chars {
-		if unicode.IsLetter(c) || c == '_' {
+		if isLetter(c) || unicode.IsDigit(c) {
 			r = append(r, c)
 		} else {
 			return r
@@ -551,6 +609,17 @@ func scanIdent(chars []rune) []rune {
 	return r
 }
 
+// isLetter checks if c can start an identifier.
+func isLetter(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
+}
+
+// isDecimal checks if c is a digit of a number. Only ASCII digits: other
+// unicode digits may appear in identifiers, but not numbers.
+func isDecimal(c rune) bool {
+	return '0' <= c && c <= '9'
+}
+
 func countWhitespace(chars []rune) int {
 
 	i := 0
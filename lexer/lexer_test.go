@@ -131,3 +131,147 @@ func TestStrings(t *testing.T) {
 	checkLexed(t, `"hell   o   " "  wor   ld"`, token.STRING, token.STRING, token.SEMI, token.EOF)
 	checkLexed(t, `"\"hello\" \"world\""`, token.STRING, token.SEMI, token.EOF)
 }
+
+func TestRawStrings(t *testing.T) {
+
+	checkLexed(t, "`hello`", token.STRING, token.SEMI, token.EOF)
+	checkLexed(t, "`a\\nb`", token.STRING, token.SEMI, token.EOF)
+	checkLexed(t, "x := `select *\n  from things\n where x = \"y\"`\ny",
+		token.IDENT, token.ASSIGN, token.STRING, token.SEMI, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "`unterminated\n", token.ILLEGAL, token.EOF)
+
+	checkLiteral(t, "`a\\nb`", 0, `a\nb`)
+	checkLiteral(t, "`one\n  two\nthree`", 0, "one\n  two\nthree")
+	checkLiteral(t, "`${not} interpolated`", 0, "${not} interpolated")
+}
+
+func TestTripleQuotedStrings(t *testing.T) {
+
+	checkLexed(t, `"""hello"""`, token.STRING, token.SEMI, token.EOF)
+	checkLexed(t, "x := \"\"\"\n    {\n      \"a\": 1\n    }\n    \"\"\"\ny",
+		token.IDENT, token.ASSIGN, token.STRING, token.SEMI, token.IDENT, token.SEMI, token.EOF)
+
+	checkLiteral(t, "\"\"\"\n    {\n      \"a\": 1\n    }\n    \"\"\"", 0, "{\n  \"a\": 1\n}")
+	checkLiteral(t, "\"\"\"\n\tone\\ttab\n\n\ttwo\n\"\"\"", 0, "one\ttab\n\ntwo")
+	checkLiteral(t, `"""say \"""hi\""" """`, 0, `say """hi""" `)
+
+	checkLexed(t, "\"\"\"\n  hi ${name}\n  bye\n  \"\"\"",
+		token.INTERP_BEG, token.IDENT, token.INTERP_END, token.SEMI, token.EOF)
+	checkLiteral(t, "\"\"\"\n  hi ${name}\n  bye\n  \"\"\"", 2, "\nbye")
+}
+
+func TestInterpolation(t *testing.T) {
+
+	checkLexed(t, `"hello ${name}"`, token.INTERP_BEG, token.IDENT, token.INTERP_END, token.SEMI, token.EOF)
+	checkLexed(t, `"hello ${name}, you are ${age+1}"`,
+		token.INTERP_BEG, token.IDENT, token.INTERP_MID, token.IDENT, token.PLUS, token.INT,
+		token.INTERP_END, token.SEMI, token.EOF)
+	checkLexed(t, `"${f("x}")}"`,
+		token.INTERP_BEG, token.IDENT, token.LPAREN, token.STRING, token.RPAREN, token.INTERP_END,
+		token.SEMI, token.EOF)
+	checkLexed(t, `"a ${ "b ${c}" } d"`,
+		token.INTERP_BEG, token.INTERP_BEG, token.IDENT, token.INTERP_END, token.INTERP_END,
+		token.SEMI, token.EOF)
+
+	checkLexed(t, `"cost: \${price}"`, token.STRING, token.SEMI, token.EOF)
+	checkLiteral(t, `"cost: \${price}"`, 0, "cost: ${price}")
+	checkLiteral(t, `"cost: $5"`, 0, "cost: $5")
+
+	checkLexed(t, `"empty ${}"`, token.ILLEGAL, token.EOF)
+	checkLexed(t, `"open ${x"`, token.ILLEGAL, token.EOF)
+	checkLexed(t, `"bad \q"`, token.ILLEGAL, token.EOF)
+
+	lex := lexer.New("testing", []string{`"hello ${name}, you are ${age+1}"`})
+	xems := lex.Lexemes()
+	if xems[1].CharNo() != 10 || xems[3].CharNo() != 27 {
+		t.Errorf("embedded expression positions: expected 10 and 27, got %d and %d",
+			xems[1].CharNo(), xems[3].CharNo())
+	}
+}
+
+// checkLiteral checks the literal value of the i'th lexeme.
+func checkLiteral(t *testing.T, input string, i int, expected string) {
+
+	lines := reader.ReadLinesToStrings(strings.NewReader(input))
+	lex := lexer.New("testing", lines)
+
+	got := lex.Lexemes()[i]
+	if got.Literal() != expected {
+		lex.LogDump()
+		t.Errorf("expected literal %q, got %q", expected, got.Literal())
+	}
+}
+
+func TestIdentDigits(t *testing.T) {
+
+	checkLexed(t, "x1", token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "a1b2", token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "_9", token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "__", token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "sha256(x)", token.IDENT, token.LPAREN, token.IDENT, token.RPAREN, token.SEMI, token.EOF)
+	checkLexed(t, "utf8.md5sum", token.IDENT, token.PERIOD, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "x1+2", token.IDENT, token.PLUS, token.INT, token.SEMI, token.EOF)
+	checkLexed(t, "9a", token.INT, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "for1 if2 nil3", token.IDENT, token.IDENT, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "$foo2", token.DOLLAR, token.SEMI, token.EOF)
+
+	checkLiteral(t, "a1b2", 0, "a1b2")
+	checkLiteral(t, "_9", 0, "_9")
+	checkLiteral(t, "9a", 0, "9")
+	checkLiteral(t, "9a", 1, "a")
+	checkLiteral(t, "$foo2", 0, "foo2")
+}
+
+func TestIdentUnicode(t *testing.T) {
+
+	checkLexed(t, "café", token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "日本語 := 1", token.IDENT, token.ASSIGN, token.INT, token.SEMI, token.EOF)
+	checkLexed(t, "Δx1", token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "x٣", token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "٣", token.ILLEGAL, token.EOF)
+	checkLexed(t, "a·b", token.IDENT, token.ILLEGAL, token.IDENT, token.SEMI, token.EOF)
+
+	checkLiteral(t, "café", 0, "café")
+	checkLiteral(t, "x٣ := 3", 0, "x٣")
+	checkLiteral(t, "日本語 := 1", 0, "日本語")
+
+	lex := lexer.New("testing", []string{"ÿx1 + 日本 + z"})
+	xems := lex.Lexemes()
+	if xems[2].CharNo() != 7 || xems[4].CharNo() != 12 {
+		lex.LogDump()
+		t.Errorf("expected char positions 7 and 12, got %d and %d", xems[2].CharNo(), xems[4].CharNo())
+	}
+}
+
+func TestOperators(t *testing.T) {
+
+	checkLexed(t, "a&b|c^d", token.IDENT, token.BIT_AND, token.IDENT, token.BIT_OR, token.IDENT,
+		token.BIT_XOR, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "a&&b||c^^d", token.IDENT, token.LOG_AND, token.IDENT, token.LOG_OR, token.IDENT,
+		token.LOG_XOR, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "a&^b", token.IDENT, token.BIT_CLEAR, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "a shl b shr c", token.IDENT, token.SHL, token.IDENT, token.SHR, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "a >> b << c", token.IDENT, token.RPIPE, token.IDENT, token.LPIPE, token.IDENT, token.SEMI, token.EOF)
+}
+
+func TestSemiAfterConstants(t *testing.T) {
+
+	checkLexed(t, "x := true\ny", token.IDENT, token.ASSIGN, token.TRUE, token.SEMI, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "x := false\ny", token.IDENT, token.ASSIGN, token.FALSE, token.SEMI, token.IDENT, token.SEMI, token.EOF)
+	checkLexed(t, "x := nil\ny", token.IDENT, token.ASSIGN, token.NIL, token.SEMI, token.IDENT, token.SEMI, token.EOF)
+}
+
+func TestRuneLiterals(t *testing.T) {
+
+	checkLexed(t, "'a'", token.CHAR, token.SEMI, token.EOF)
+	checkLexed(t, "x < 'b'", token.IDENT, token.LESS, token.CHAR, token.SEMI, token.EOF)
+	checkLexed(t, "'a','b'", token.CHAR, token.COMMA, token.CHAR, token.SEMI, token.EOF)
+	checkLexed(t, "''", token.ILLEGAL, token.EOF)
+	checkLexed(t, "'ab'", token.ILLEGAL, token.EOF)
+
+	checkLiteral(t, "'a'", 0, "a")
+	checkLiteral(t, `'\n'`, 0, "\n")
+	checkLiteral(t, `'\''`, 0, "'")
+	checkLiteral(t, "'世'", 0, "世")
+	checkLiteral(t, `'é' + 1`, 1, "+")
+}
@@ -0,0 +1,390 @@
+package lexer
+
+import (
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pdk/gosh/token"
+)
+
+// There are three kinds of string literals:
+//
+//   "..."       single line, with escapes and ${...} interpolation
+//   `...`       raw, may span lines. no escapes, no interpolation
+//   """..."""   may span lines, with escapes and interpolation. indentation
+//               common to all lines is stripped.
+//
+// An interpolated string is lexed as a series of tokens, the literal segments
+// of the string with the tokens of the embedded expressions in between:
+//
+//   "hello ${name}, you are ${age+1}"
+//
+// becomes
+//
+//   INTERP_BEG("hello ") IDENT(name) INTERP_MID(", you are ")
+//   IDENT(age) PLUS INT(1) INTERP_END("")
+//
+// and the parser assembles those into an expression.
+
+const tripleQuote = `"""`
+
+// srcRune is a rune of a string literal, along with its location in the input.
+type srcRune struct {
+	ch     rune
+	lineNo int
+	charNo int
+}
+
+// hasPrefix checks if chars starts with the given prefix.
+func hasPrefix(chars []rune, prefix string) bool {
+
+	i := 0
+	for _, c := range prefix {
+		if i >= len(chars) || chars[i] != c {
+			return false
+		}
+		i++
+	}
+
+	return true
+}
+
+// isMultilineStart checks if chars starts a string literal that may span lines.
+func isMultilineStart(chars []rune) bool {
+	return hasPrefix(chars, "`") || hasPrefix(chars, tripleQuote)
+}
+
+// scanMultiline scans a raw or triple-quoted string which starts at char i of
+// the line at lineOffset. Returns the lexemes of the string, and the line
+// offset and char index just past the closing delimiter.
+func (lex *Lexer) scanMultiline(lineOffset, i int) ([]Lexeme, int, int) {
+
+	lineNo := lineOffset + 1
+	chars := stringRunes(lex.input[lineOffset])
+
+	delim := "`"
+	if hasPrefix(chars[i:], tripleQuote) {
+		delim = tripleQuote
+	}
+
+	var content []srcRune
+	j := i + len(delim)
+
+	for {
+		for j < len(chars) {
+
+			if delim == tripleQuote && chars[j] == '\\' && j+1 < len(chars) {
+				// keep escapes intact, to be processed with the rest of the
+				// content. but don't let \" end the string.
+				content = append(content,
+					srcRune{chars[j], lineOffset + 1, j + 1},
+					srcRune{chars[j+1], lineOffset + 1, j + 2})
+				j += 2
+				continue
+			}
+
+			if hasPrefix(chars[j:], delim) {
+
+				if delim == tripleQuote {
+					return lex.scanTemplate(dedent(content), lineNo, i+1), lineOffset, j + len(delim)
+				}
+
+				raw := lex.NewLexeme(token.STRING, runesString(content)).at(lineNo, i+1)
+				return []Lexeme{raw}, lineOffset, j + len(delim)
+			}
+
+			content = append(content, srcRune{chars[j], lineOffset + 1, j + 1})
+			j++
+		}
+
+		if lineOffset+1 >= len(lex.input) {
+			// ran out of input before finding the closing delimiter
+			illegal := lex.NewLexeme(token.ILLEGAL, delim+runesString(content)).at(lineNo, i+1)
+			return []Lexeme{illegal}, lineOffset, j
+		}
+
+		content = append(content, srcRune{'\n', lineOffset + 1, len(chars) + 1})
+
+		lineOffset++
+		chars = stringRunes(lex.input[lineOffset])
+		j = 0
+	}
+}
+
+// scanQuoted scans a double-quoted string which starts at chars[i]. Returns the
+// lexemes of the string, and the number of chars consumed.
+func (lex *Lexer) scanQuoted(lineNo int, chars []rune, i int) ([]Lexeme, int) {
+
+	var content []srcRune
+	add := func(from, to int) {
+		for k := from; k < to; k++ {
+			content = append(content, srcRune{chars[k], lineNo, k + 1})
+		}
+	}
+
+	j := i + 1
+	for j < len(chars) {
+
+		switch {
+		case chars[j] == '\\' && j+1 < len(chars):
+			add(j, j+2)
+			j += 2
+			continue
+
+		case chars[j] == '"':
+			return lex.scanTemplate(content, lineNo, i+1), j + 1 - i
+
+		case hasPrefix(chars[j:], "${"):
+			// skip over the embedded expression, which may contain quotes.
+			end := matchBrace(chars, j+1)
+			if end < 0 {
+				j = len(chars)
+				continue
+			}
+			add(j, end+1)
+			j = end + 1
+			continue
+		}
+
+		add(j, j+1)
+		j++
+	}
+
+	illegal := lex.NewLexeme(token.ILLEGAL, string(chars[i:])).at(lineNo, i+1)
+	return []Lexeme{illegal}, len(chars) - i
+}
+
+// scanTemplate processes escapes and interpolations in the content of a string
+// literal. Returns either a single STRING lexeme, or the lexemes of an
+// interpolated string.
+func (lex *Lexer) scanTemplate(content []srcRune, lineNo, charNo int) []Lexeme {
+
+	chars := make([]rune, len(content))
+	for i, c := range content {
+		chars[i] = c.ch
+	}
+
+	illegal := func() []Lexeme {
+		return []Lexeme{lex.NewLexeme(token.ILLEGAL, string(chars)).at(lineNo, charNo)}
+	}
+
+	var xems []Lexeme
+	var lit []rune
+
+	segTok := token.INTERP_BEG
+	segLine, segChar := lineNo, charNo
+
+	j := 0
+	for j < len(chars) {
+
+		switch {
+		case hasPrefix(chars[j:], `\$`):
+			lit = append(lit, '$')
+			j += 2
+
+		case chars[j] == '\\':
+			end := j + 10 // longest escape is \UXXXXXXXX
+			if end > len(chars) {
+				end = len(chars)
+			}
+			escaped := string(chars[j:end])
+
+			value, _, tail, err := strconv.UnquoteChar(escaped, '"')
+			if err != nil {
+				return illegal()
+			}
+
+			lit = append(lit, value)
+			j += utf8.RuneCountInString(escaped) - utf8.RuneCountInString(tail)
+
+		case hasPrefix(chars[j:], "${"):
+			end := matchBrace(chars, j+1)
+			if end < 0 {
+				return illegal()
+			}
+
+			embedded, ok := lex.lexEmbedded(content[j+2 : end])
+			if !ok {
+				return illegal()
+			}
+
+			xems = append(xems, lex.NewLexeme(segTok, string(lit)).at(segLine, segChar))
+			xems = append(xems, embedded...)
+
+			lit = nil
+			segTok = token.INTERP_MID
+			segLine, segChar = content[end].lineNo, content[end].charNo
+			j = end + 1
+
+		default:
+			lit = append(lit, chars[j])
+			j++
+		}
+	}
+
+	if len(xems) == 0 {
+		return []Lexeme{lex.NewLexeme(token.STRING, string(lit)).at(lineNo, charNo)}
+	}
+
+	return append(xems, lex.NewLexeme(token.INTERP_END, string(lit)).at(segLine, segChar))
+}
+
+// lexEmbedded lexes the expression embedded in an interpolated string. The
+// expression must not be empty, and must be entirely on one line.
+func (lex *Lexer) lexEmbedded(expr []srcRune) ([]Lexeme, bool) {
+
+	if len(expr) == 0 {
+		return nil, false
+	}
+
+	lineNo := expr[0].lineNo
+	for _, c := range expr {
+		if c.lineNo != lineNo || c.ch == '\n' {
+			return nil, false
+		}
+	}
+
+	from := expr[0].charNo - 1
+	to := expr[len(expr)-1].charNo
+	chars := stringRunes(lex.input[lineNo-1])[:to]
+
+	var xems []Lexeme
+
+	i := from + countWhitespace(chars[from:])
+	for i < to {
+		found, c := lex.lexOne(lineNo, chars, i)
+		xems = append(xems, found...)
+		i += c
+		i += countWhitespace(chars[i:])
+	}
+
+	return xems, len(xems) > 0
+}
+
+// matchBrace finds the index of the brace that closes the one at chars[open],
+// skipping over nested braces and quoted strings. Returns -1 if there is no
+// closing brace.
+func matchBrace(chars []rune, open int) int {
+
+	depth := 0
+	for k := open + 1; k < len(chars); k++ {
+
+		switch chars[k] {
+		case '{':
+			depth++
+
+		case '}':
+			if depth == 0 {
+				return k
+			}
+			depth--
+
+		case '`':
+			for k++; k < len(chars) && chars[k] != '`'; k++ {
+			}
+
+		case '"':
+			for k++; k < len(chars) && chars[k] != '"'; k++ {
+				if chars[k] == '\\' {
+					k++
+				} else if hasPrefix(chars[k:], "${") {
+					k = matchBrace(chars, k+1)
+					if k < 0 {
+						return -1
+					}
+				}
+			}
+		}
+	}
+
+	return -1
+}
+
+// dedent strips the indentation common to all the non-blank lines of a
+// multi-line string. A blank first line (following the opening quotes) and a
+// blank last line (preceding the closing quotes) are dropped.
+func dedent(content []srcRune) []srcRune {
+
+	var lines [][]srcRune
+	start := 0
+	for i, c := range content {
+		if c.ch == '\n' {
+			lines = append(lines, content[start:i])
+			start = i + 1
+		}
+	}
+	lines = append(lines, content[start:])
+
+	if len(lines) > 1 && isBlank(lines[0]) {
+		lines = lines[1:]
+	}
+
+	if len(lines) > 1 && isBlank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	indent := -1
+	for _, line := range lines {
+		if isBlank(line) {
+			continue
+		}
+
+		n := 0
+		for n < len(line) && unicode.IsSpace(line[n].ch) {
+			n++
+		}
+
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	var result []srcRune
+	for i, line := range lines {
+		if i > 0 {
+			result = append(result, srcRune{ch: '\n'})
+		}
+
+		if isBlank(line) {
+			continue
+		}
+
+		result = append(result, line[indent:]...)
+	}
+
+	return result
+}
+
+func isBlank(line []srcRune) bool {
+	for _, c := range line {
+		if !unicode.IsSpace(c.ch) {
+			return false
+		}
+	}
+	return true
+}
+
+func runesString(content []srcRune) string {
+	chars := make([]rune, len(content))
+	for i, c := range content {
+		chars[i] = c.ch
+	}
+	return string(chars)
+}
+
+// scanChar scans a rune literal, e.g. 'a' or '\n', which starts at chars[0].
+// Returns the rune, the number of chars consumed, and whether the literal is
+// valid.
+func scanChar(chars []rune) (rune, int, bool) {
+
+	s := string(chars[1:])
+	value, _, tail, err := strconv.UnquoteChar(s, '\'')
+	if err != nil || len(tail) == 0 || tail[0] != '\'' {
+		return 0, len(chars), false
+	}
+
+	consumed := len(chars) - utf8.RuneCountInString(tail) + 1
+
+	return value, consumed, true
+}
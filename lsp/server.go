@@ -0,0 +1,458 @@
+// Package lsp is a Language Server Protocol server for gosh, for editors. It
+// speaks JSON-RPC over a pair of streams, usually stdin and stdout, and
+// provides diagnostics, go-to-definition, find-references, hover, document
+// symbols and completion.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/diag"
+	"github.com/pdk/gosh/wire"
+)
+
+// Server serves one client.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document
+	shutdown bool
+	err      error // the first failed write
+}
+
+// document is an open file, and the program analyzed from it, if it could be.
+type document struct {
+	uri   string
+	lines []string
+	prog  *compile.Program
+}
+
+// NewServer returns a server reading requests from in, and writing responses
+// and notifications to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// Serve handles requests until the client sends exit, or closes the input. It
+// returns an error if a request can't be read, or a response can't be written.
+func (s *Server) Serve() error {
+
+	for {
+		if s.err != nil {
+			return s.err
+		}
+
+		body, err := wire.Read(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.replyError(nil, parseError, err.Error())
+			continue
+		}
+
+		if req.Method == "exit" {
+			return s.err
+		}
+
+		result, rerr := s.handle(req)
+
+		if req.ID == nil {
+			// a notification, which has no response
+			continue
+		}
+
+		if rerr != nil {
+			s.replyError(req.ID, rerr.Code, rerr.Message)
+			continue
+		}
+
+		s.write(response{JSONRPC: "2.0", ID: req.ID, Result: result})
+	}
+}
+
+// write writes a message. Once a write fails, the client can't be reached:
+// later writes are skipped, and Serve returns the error.
+func (s *Server) write(msg interface{}) {
+
+	if s.err == nil {
+		s.err = wire.Write(s.out, msg)
+	}
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, message string) {
+	s.write(response{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &responseError{Code: code, Message: message},
+	})
+}
+
+// handle handles a request or notification, returning its result.
+func (s *Server) handle(req request) (interface{}, *responseError) {
+
+	if s.shutdown && req.Method != "exit" {
+		return nil, &responseError{Code: invalidRequest, Message: "server is shut down"}
+	}
+
+	switch req.Method {
+	case "initialize":
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:       syncFull,
+				DefinitionProvider:     true,
+				ReferencesProvider:     true,
+				HoverProvider:          true,
+				DocumentSymbolProvider: true,
+				CompletionProvider:     completionOptions{},
+			},
+			ServerInfo: serverInfo{Name: "gosh"},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		s.open(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.open(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.publish(params.TextDocument.URI, nil)
+		return nil, nil
+
+	case "textDocument/definition":
+		return s.withPosition(req, s.definition)
+
+	case "textDocument/references":
+		var params referenceParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		return s.references(params), nil
+
+	case "textDocument/hover":
+		return s.withPosition(req, s.hover)
+
+	case "textDocument/completion":
+		return s.withPosition(req, s.completion)
+
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, badParams(err)
+		}
+		return s.symbols(params.TextDocument.URI), nil
+	}
+
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		// notifications the server doesn't handle, e.g. initialized, are
+		// ignored.
+		return nil, nil
+	}
+
+	return nil, &responseError{Code: methodNotFound, Message: "method not supported: " + req.Method}
+}
+
+func badParams(err error) *responseError {
+	return &responseError{Code: invalidParams, Message: err.Error()}
+}
+
+// withPosition handles a request about a position in a document.
+func (s *Server) withPosition(req request, f func(*document, position) interface{}) (interface{}, *responseError) {
+
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, badParams(err)
+	}
+
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil {
+		return nil, nil
+	}
+
+	return f(doc, params.Position), nil
+}
+
+// open analyzes the text of a document, and publishes its diagnostics.
+func (s *Server) open(uri, text string) {
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	doc := &document{uri: uri, lines: lines}
+	s.docs[uri] = doc
+
+	var ds []diag.Diagnostic
+
+	prog, err := compile.Analyze(fileName(uri), lines)
+	if err != nil {
+		ds = diag.FromErrors(err)
+	} else {
+		doc.prog = prog
+		for _, problem := range prog.Problems() {
+			d := diag.FromProblem(problem)
+			d.Suggest(prog.Unbound())
+			ds = append(ds, d)
+		}
+	}
+
+	diagnostics := []diagnostic{}
+	for _, d := range ds {
+		diagnostics = append(diagnostics, doc.diagnostic(d))
+	}
+
+	s.publish(uri, diagnostics)
+}
+
+// publish sends the diagnostics of a document.
+func (s *Server) publish(uri string, diagnostics []diagnostic) {
+
+	if diagnostics == nil {
+		diagnostics = []diagnostic{}
+	}
+
+	s.write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+// fileName returns the path of a file: URI, or the URI itself.
+func fileName(uri string) string {
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return u.Path
+}
+
+// diagnostic converts a diagnostic to the protocol's.
+func (doc *document) diagnostic(d diag.Diagnostic) diagnostic {
+
+	severity := severityError
+	if d.Severity == diag.Warning {
+		severity = severityWarning
+	}
+
+	message := d.Message
+	for _, note := range d.Notes {
+		message += "\n" + note
+	}
+	for _, s := range d.Suggestions {
+		message += "\n" + diag.Suggestion(s)
+	}
+
+	start := doc.position(d.Line, d.Column)
+	end := doc.position(d.Line, d.EndColumn)
+	if d.Line == 0 {
+		start, end = position{}, position{}
+	}
+
+	return diagnostic{
+		Range:    textRange{Start: start, End: end},
+		Severity: severity,
+		Source:   "gosh",
+		Message:  message,
+	}
+}
+
+// position converts a line and column, counting runes from 1, to a protocol
+// position.
+func (doc *document) position(line, column int) position {
+
+	if line < 1 || line > len(doc.lines) {
+		return position{Line: line - 1, Character: column - 1}
+	}
+
+	runes := []rune(doc.lines[line-1])
+	if column-1 > len(runes) {
+		return position{Line: line - 1, Character: len(utf16.Encode(runes)) + column - 1 - len(runes)}
+	}
+
+	return position{Line: line - 1, Character: len(utf16.Encode(runes[:column-1]))}
+}
+
+// lineColumn converts a protocol position to a line and column, counting runes
+// from 1.
+func (doc *document) lineColumn(p position) (int, int) {
+
+	if p.Line < 0 || p.Line >= len(doc.lines) {
+		return p.Line + 1, p.Character + 1
+	}
+
+	units := 0
+	for i, r := range []rune(doc.lines[p.Line]) {
+		if units >= p.Character {
+			return p.Line + 1, i + 1
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+
+	return p.Line + 1, len([]rune(doc.lines[p.Line])) + 1 + p.Character - units
+}
+
+// span returns the range of a name at a line and column.
+func (doc *document) span(line, column int, name string) textRange {
+	return textRange{
+		Start: doc.position(line, column),
+		End:   doc.position(line, column+len([]rune(name))),
+	}
+}
+
+func (s *Server) definition(doc *document, p position) interface{} {
+
+	if doc.prog == nil {
+		return nil
+	}
+
+	def, ok := doc.prog.Definition(doc.lineColumn(p))
+	if !ok {
+		return nil
+	}
+
+	return location{URI: doc.uri, Range: doc.span(def.Line, def.Column, def.Name)}
+}
+
+func (s *Server) references(params referenceParams) interface{} {
+
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil || doc.prog == nil {
+		return nil
+	}
+
+	line, column := doc.lineColumn(params.Position)
+
+	def, hasDef := doc.prog.Definition(line, column)
+
+	locations := []location{}
+	for _, o := range doc.prog.References(line, column) {
+		if !params.Context.IncludeDeclaration && hasDef && o == def {
+			continue
+		}
+		locations = append(locations, location{URI: doc.uri, Range: doc.span(o.Line, o.Column, o.Name)})
+	}
+
+	return locations
+}
+
+func (s *Server) hover(doc *document, p position) interface{} {
+
+	if doc.prog == nil {
+		return nil
+	}
+
+	line, column := doc.lineColumn(p)
+
+	text, ok := doc.prog.Describe(line, column)
+	if !ok {
+		return nil
+	}
+
+	var r textRange
+	for _, o := range doc.prog.References(line, column) {
+		if o.Line == line && o.Column <= column && column <= o.Column+len([]rune(o.Name)) {
+			r = doc.span(o.Line, o.Column, o.Name)
+		}
+	}
+
+	return hover{
+		Contents: markupContent{Kind: "markdown", Value: "```gosh\n" + text + "\n```"},
+		Range:    r,
+	}
+}
+
+func (s *Server) completion(doc *document, p position) interface{} {
+
+	items := []completionItem{}
+
+	var names []string
+	if doc.prog != nil {
+		names = doc.prog.NamesInScope(doc.lineColumn(p))
+	} else {
+		names = compile.BuiltinNames()
+	}
+
+	for _, name := range names {
+		kind := completionVariable
+		if compile.IsBuiltin(name) {
+			kind = completionFunction
+		}
+		items = append(items, completionItem{Label: name, Kind: kind})
+	}
+
+	return items
+}
+
+func (s *Server) symbols(uri string) interface{} {
+
+	doc := s.docs[uri]
+	if doc == nil || doc.prog == nil {
+		return []documentSymbol{}
+	}
+
+	symbols := []documentSymbol{}
+	for _, sym := range doc.prog.Symbols() {
+		kind := symbolFunction
+		switch sym.Kind {
+		case "method":
+			kind = symbolMethod
+		case "struct":
+			kind = symbolStruct
+		}
+
+		r := textRange{
+			Start: doc.position(sym.Line, sym.Column),
+			End:   doc.position(sym.EndLine, sym.EndColumn),
+		}
+
+		symbols = append(symbols, documentSymbol{
+			Name:           sym.Name,
+			Detail:         sym.Detail,
+			Kind:           kind,
+			Range:          r,
+			SelectionRange: textRange{Start: r.Start, End: r.Start},
+		})
+	}
+
+	return symbols
+}
@@ -0,0 +1,385 @@
+package lsp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/lsp"
+)
+
+// client is a scripted client, talking to a server over pipes.
+type client struct {
+	t             *testing.T
+	in            io.WriteCloser
+	out           *bufio.Reader
+	id            int
+	notifications []map[string]interface{}
+	done          chan error
+}
+
+func start(t *testing.T) *client {
+
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	c := &client{t: t, in: inW, out: bufio.NewReader(outR), done: make(chan error, 1)}
+
+	go func() {
+		err := lsp.NewServer(inR, outW).Serve()
+		outW.Close()
+		c.done <- err
+	}()
+
+	c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}})
+	c.notify("initialized", map[string]interface{}{})
+
+	return c
+}
+
+func (c *client) send(msg map[string]interface{}) {
+
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// receive reads the next message from the server.
+func (c *client) receive() map[string]interface{} {
+
+	c.t.Helper()
+
+	length := 0
+	for {
+		line, err := c.out.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("failed to read from the server: %s", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length: ") {
+			length, _ = strconv.Atoi(strings.TrimPrefix(line, "Content-Length: "))
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.out, body); err != nil {
+		c.t.Fatalf("failed to read from the server: %s", err)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("expected JSON from the server, got %s", body)
+	}
+
+	return msg
+}
+
+// call sends a request, and returns its response, keeping any notifications
+// sent before it.
+func (c *client) call(method string, params interface{}) map[string]interface{} {
+
+	c.t.Helper()
+
+	c.id++
+	c.send(map[string]interface{}{"id": c.id, "method": method, "params": params})
+
+	for {
+		msg := c.receive()
+		if _, ok := msg["id"]; !ok {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if id, _ := msg["id"].(float64); int(id) != c.id {
+			c.t.Fatalf("expected a response to request %d, got %v", c.id, msg)
+		}
+		return msg
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// result calls a method, and returns its result re-encoded as JSON, for
+// comparing.
+func (c *client) result(method string, params interface{}) string {
+
+	c.t.Helper()
+
+	resp := c.call(method, params)
+	if resp["error"] != nil {
+		c.t.Fatalf("expected a result of %s, got %v", method, resp["error"])
+	}
+
+	b, _ := json.Marshal(resp["result"])
+
+	return string(b)
+}
+
+// diagnostics waits for the next diagnostics published.
+func (c *client) diagnostics() []interface{} {
+
+	c.t.Helper()
+
+	for len(c.notifications) == 0 {
+		msg := c.receive()
+		if _, ok := msg["id"]; ok {
+			c.t.Fatalf("expected a notification, got %v", msg)
+		}
+		c.notifications = append(c.notifications, msg)
+	}
+
+	msg := c.notifications[0]
+	c.notifications = c.notifications[1:]
+
+	if msg["method"] != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %v", msg)
+	}
+
+	return msg["params"].(map[string]interface{})["diagnostics"].([]interface{})
+}
+
+func (c *client) stop() {
+
+	c.t.Helper()
+
+	c.call("shutdown", nil)
+	c.notify("exit", nil)
+
+	if err := <-c.done; err != nil {
+		c.t.Errorf("expected the server to exit cleanly, got %s", err)
+	}
+}
+
+const uri = "file:///tmp/test.gosh"
+
+func open(c *client, text string) {
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "gosh", "version": 1, "text": text},
+	})
+}
+
+func at(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}
+}
+
+const script = `total := 0
+add := func(a, b) {
+	return a + b
+}
+x := add(1, 2) + total
+`
+
+func TestInitialize(t *testing.T) {
+
+	c := start(t)
+	defer c.stop()
+
+	c.id++
+	c.send(map[string]interface{}{"id": c.id, "method": "initialize", "params": map[string]interface{}{}})
+	resp := c.receive()
+
+	caps := resp["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	for _, capability := range []string{"definitionProvider", "referencesProvider", "hoverProvider", "documentSymbolProvider"} {
+		if caps[capability] != true {
+			t.Errorf("expected capability %s, got %v", capability, caps)
+		}
+	}
+
+	resp = c.call("workspace/unknown", nil)
+	if resp["error"] == nil {
+		t.Errorf("expected an error calling an unknown method, got %v", resp)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestWriteError(t *testing.T) {
+
+	// a response which can't be written ends the session, with the error
+	body := `{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {}}`
+	in := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	err := lsp.NewServer(strings.NewReader(in), failingWriter{}).Serve()
+	if err != io.ErrClosedPipe {
+		t.Errorf("expected %s, got %v", io.ErrClosedPipe, err)
+	}
+}
+
+func TestTooLong(t *testing.T) {
+
+	// a message longer than wire.MaxLength is refused, before reading it
+	in := "Content-Length: 999999999999\r\n\r\n{}"
+
+	err := lsp.NewServer(strings.NewReader(in), io.Discard).Serve()
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("expected a message too long, got %v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+
+	c := start(t)
+	defer c.stop()
+
+	open(c, "count := 1\nx := cuont + 1")
+
+	ds := c.diagnostics()
+	if len(ds) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %v", ds)
+	}
+
+	b, _ := json.Marshal(ds[0])
+	expected := `{"message":"undefined variable cuont\ndid you mean ` + "`count`" + `?",` +
+		`"range":{"end":{"character":10,"line":1},"start":{"character":5,"line":1}},"severity":1,"source":"gosh"}`
+	if string(b) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b)
+	}
+
+	// fixing the error clears it
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []interface{}{map[string]interface{}{"text": "count := 1\nx := count + 1"}},
+	})
+
+	if ds := c.diagnostics(); len(ds) != 0 {
+		t.Errorf("expected no diagnostics, got %v", ds)
+	}
+
+	// syntax errors are reported
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 3},
+		"contentChanges": []interface{}{map[string]interface{}{"text": "x := (1 +"}},
+	})
+
+	ds = c.diagnostics()
+	if len(ds) != 1 || !strings.HasPrefix(ds[0].(map[string]interface{})["message"].(string), "syntax error") {
+		t.Errorf("expected a syntax error, got %v", ds)
+	}
+}
+
+func TestNavigation(t *testing.T) {
+
+	c := start(t)
+	defer c.stop()
+
+	open(c, script)
+	c.diagnostics()
+
+	// add, on line 5
+	got := c.result("textDocument/definition", at(4, 6))
+	expected := `{"range":{"end":{"character":3,"line":1},"start":{"character":0,"line":1}},"uri":"file:///tmp/test.gosh"}`
+	if got != expected {
+		t.Errorf("expected definition\n%s\ngot\n%s", expected, got)
+	}
+
+	// a, the parameter
+	params := at(2, 9)
+	params["context"] = map[string]interface{}{"includeDeclaration": true}
+	got = c.result("textDocument/references", params)
+	expected = `[{"range":{"end":{"character":13,"line":1},"start":{"character":12,"line":1}},"uri":"file:///tmp/test.gosh"},` +
+		`{"range":{"end":{"character":9,"line":2},"start":{"character":8,"line":2}},"uri":"file:///tmp/test.gosh"}]`
+	if got != expected {
+		t.Errorf("expected references\n%s\ngot\n%s", expected, got)
+	}
+
+	params["context"] = map[string]interface{}{"includeDeclaration": false}
+	if got := c.result("textDocument/references", params); strings.Count(got, "uri") != 1 {
+		t.Errorf("expected references without the declaration, got %s", got)
+	}
+
+	// nothing at :=
+	if got := c.result("textDocument/definition", at(0, 7)); got != "null" {
+		t.Errorf("expected no definition, got %s", got)
+	}
+}
+
+func TestHover(t *testing.T) {
+
+	c := start(t)
+	defer c.stop()
+
+	open(c, script)
+	c.diagnostics()
+
+	got := c.result("textDocument/hover", at(4, 5))
+	if !strings.Contains(got, "add := func(a, b)[]{...}") {
+		t.Errorf("expected hover of the parameters of add, got %s", got)
+	}
+
+	got = c.result("textDocument/hover", at(0, 1))
+	if !strings.Contains(got, "total int64") {
+		t.Errorf("expected hover of the type of total, got %s", got)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+
+	c := start(t)
+	defer c.stop()
+
+	open(c, script+"struct Point {\n\tx := 0\n\tString := func() { \"point\" }\n}\n")
+	c.diagnostics()
+
+	var symbols []struct {
+		Name string
+		Kind int
+	}
+	got := c.result("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+	})
+	json.Unmarshal([]byte(got), &symbols)
+
+	expected := "add 12, Point 23, Point.String 6"
+
+	var names []string
+	for _, s := range symbols {
+		names = append(names, fmt.Sprintf("%s %d", s.Name, s.Kind))
+	}
+
+	if strings.Join(names, ", ") != expected {
+		t.Errorf("expected symbols %s, got %s", expected, got)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+
+	c := start(t)
+	defer c.stop()
+
+	open(c, script)
+	c.diagnostics()
+
+	inside := c.result("textDocument/completion", at(2, 1))
+	for _, name := range []string{`"a"`, `"b"`, `"total"`, `"add"`} {
+		if !strings.Contains(inside, `"label":`+name) {
+			t.Errorf("expected %s to complete inside add, got %s", name, inside)
+		}
+	}
+
+	outside := c.result("textDocument/completion", at(4, 0))
+	if strings.Contains(outside, `"label":"a"`) {
+		t.Errorf("expected a not to complete outside add, got %s", outside)
+	}
+}
@@ -0,0 +1,92 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/token"
+)
+
+// MaxErrors limits how many syntax errors are reported for an input.
+const MaxErrors = 10
+
+// Error is a syntax error: where it was found, the token found there, and the
+// tokens which were expected instead, or what was, e.g. "an expression".
+// Lexeme is nil if the input ran out.
+type Error struct {
+	Lexeme   *lexer.Lexeme
+	Found    token.Token
+	Expected []token.Token
+	Want     string // what was expected, when it's not particular tokens
+	Message  string // "expected X, found Y"
+}
+
+// Error formats the error with its position.
+func (e *Error) Error() string {
+
+	if e.Lexeme == nil {
+		return fmt.Sprintf("parse error: %s", e.Message)
+	}
+
+	return fmt.Sprintf("parse error on line %d, pos %d, token %s: %s",
+		e.Lexeme.LineNo(), e.Lexeme.CharNo(), e.Lexeme.Literal(), e.Message)
+}
+
+// expected describes what was expected, e.g. "`{` or `if`".
+func (e *Error) expected() string {
+
+	if e.Want != "" {
+		return e.Want
+	}
+
+	var texts []string
+	for _, tok := range e.Expected {
+		texts = append(texts, tok.Text())
+	}
+
+	if n := len(texts); n > 1 {
+		return strings.Join(texts[:n-1], ", ") + " or " + texts[n-1]
+	}
+
+	return strings.Join(texts, "")
+}
+
+// found describes the token found, with its text if that isn't implied by
+// the token, e.g. "identifier `x`".
+func (e *Error) found() string {
+
+	if e.Lexeme != nil {
+		switch e.Found {
+		case token.ILLEGAL, token.IDENT, token.INT, token.FLOAT, token.CHAR:
+			return fmt.Sprintf("%s `%s`", e.Found.Text(), e.Lexeme.Literal())
+		}
+	}
+
+	return e.Found.Text()
+}
+
+// Errors is the syntax errors found in an input, in the order found.
+type Errors []*Error
+
+// Error formats the errors, one per line.
+func (es Errors) Error() string {
+
+	var lines []string
+	for _, e := range es {
+		lines = append(lines, e.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns the errors, so that errors.As finds them.
+func (es Errors) Unwrap() []error {
+
+	var errs []error
+	for _, e := range es {
+		errs = append(errs, e)
+	}
+
+	return errs
+}
@@ -9,7 +9,10 @@ import (
 
 // Parser processes a list of lexed nodes.
 type Parser struct {
-	lexer *lexer.Lexer
+	lexer  *lexer.Lexer
+	depth  int      // of braces
+	errors []*Error // found so far
+	gaveUp bool     // found too many errors to continue
 }
 
 // New makes a new Parser given a Lexer.
@@ -19,12 +22,21 @@ func New(lexer *lexer.Lexer) *Parser {
 	}
 }
 
-// Parse returns the result of parsing the input.
+// Parse returns the result of parsing the input. If there are syntax errors,
+// they are all returned, up to MaxErrors of them, as Errors. If there are more,
+// the last of the Errors says there are too many, where the next was found.
 func (p *Parser) Parse() (*Node, error) {
 
-	ast, err := p.expression(0)
-	if err != nil {
-		return ast, err
+	ast, _ := p.statements(0)
+
+	for !p.gaveUp && !p.atEnd() {
+		// e.g. a stray }
+		p.record(parseError(newNode(p.next()), "a statement"))
+		p.statements(0)
+	}
+
+	if len(p.errors) > 0 {
+		return ast, Errors(p.errors)
 	}
 
 	ast = ast.applyTransforms()
@@ -32,14 +44,100 @@ func (p *Parser) Parse() (*Node, error) {
 	return ast, nil
 }
 
+// statements parses statements up to the end of the input, or of the block
+// being parsed. When a statement has a syntax error, the error is recorded, and
+// parsing resumes at the next statement.
+func (p *Parser) statements(rbp int) (*Node, error) {
+
+	depth := p.depth
+
+	for {
+		exp, err := p.expression(rbp)
+		if err == nil || !p.record(err) {
+			return exp, err
+		}
+
+		p.synchronize(depth)
+
+		if p.atEnd() || (depth > 0 && p.peekIs(token.RBRACE)) {
+			return exp, nil
+		}
+	}
+}
+
+// record keeps a syntax error, returning false if parsing should stop, having
+// found too many errors.
+func (p *Parser) record(err error) bool {
+
+	if p.gaveUp {
+		return false
+	}
+
+	if len(p.errors) >= MaxErrors {
+		p.gaveUp = true
+
+		tooMany := &Error{Found: token.EOF, Message: "too many errors"}
+		if parseErr, ok := err.(*Error); ok {
+			tooMany.Lexeme, tooMany.Found = parseErr.Lexeme, parseErr.Found
+		}
+		p.errors = append(p.errors, tooMany)
+
+		return false
+	}
+
+	if parseErr, ok := err.(*Error); ok {
+		p.errors = append(p.errors, parseErr)
+	} else {
+		p.errors = append(p.errors, &Error{Message: err.Error()})
+	}
+
+	return true
+}
+
+// synchronize skips the rest of a statement with a syntax error, up to the
+// end of the statement, or of the block it's in.
+func (p *Parser) synchronize(depth int) {
+
+	for !p.atEnd() {
+
+		if p.depth == depth && depth > 0 && p.peekIs(token.RBRACE) {
+			return
+		}
+
+		if p.depth <= depth && p.peekIs(token.SEMI) {
+			p.next()
+			return
+		}
+
+		p.next()
+	}
+}
+
+// atEnd returns true if there is no more input to parse.
+func (p *Parser) atEnd() bool {
+	return p.peek() == nil || p.peekIs(token.EOF)
+}
+
 // peek return the upcoming token from the lexer.
 func (p *Parser) peek() *lexer.Lexeme {
 	return p.lexer.Peek()
 }
 
-// next returns the next Lexeme from the lexer.
+// next returns the next Lexeme from the lexer, keeping track of the depth of
+// braces.
 func (p *Parser) next() *lexer.Lexeme {
-	return p.lexer.Next()
+
+	l := p.lexer.Next()
+
+	switch {
+	case l == nil:
+	case l.Token() == token.LBRACE:
+		p.depth++
+	case l.Token() == token.RBRACE && p.depth > 0:
+		p.depth--
+	}
+
+	return l
 }
 
 // pToken returns the token of the peek.
@@ -107,17 +205,26 @@ func init() {
 	tdopRegistry[token.BREAK] = self()
 	tdopRegistry[token.CONTINUE] = self()
 
+	tdopRegistry[token.INTERP_BEG] = interpolation()
+
 	tdopRegistry[token.PKG] = prefix(P_PREFIX)
 	tdopRegistry[token.NOT] = prefix(P_PREFIX)
+	tdopRegistry[token.TRY] = prefix(P_PREFIX)
 
 	tdopRegistry[token.MINUS] = prefixInfix(P_PREFIX, P_PLUSMINUS)
+	tdopRegistry[token.BIT_XOR] = prefixInfix(P_PREFIX, P_PLUSMINUS)
 	tdopRegistry[token.RETURN] = prefixOrNaught(P_RETURN)
 
 	tdopRegistry[token.PERIOD] = infix(P_PERIOD)
 	tdopRegistry[token.MULT] = infix(P_MULTDIV)
 	tdopRegistry[token.DIV] = infix(P_MULTDIV)
 	tdopRegistry[token.MODULO] = infix(P_MULTDIV)
+	tdopRegistry[token.BIT_AND] = infix(P_MULTDIV)
+	tdopRegistry[token.BIT_CLEAR] = infix(P_MULTDIV)
+	tdopRegistry[token.SHL] = infix(P_MULTDIV)
+	tdopRegistry[token.SHR] = infix(P_MULTDIV)
 	tdopRegistry[token.PLUS] = infix(P_PLUSMINUS)
+	tdopRegistry[token.BIT_OR] = infix(P_PLUSMINUS)
 	tdopRegistry[token.EQUAL] = infix(P_COMPARE)
 	tdopRegistry[token.LESS] = infix(P_COMPARE)
 	tdopRegistry[token.GRTR] = infix(P_COMPARE)
@@ -129,6 +236,7 @@ func init() {
 	tdopRegistry[token.COMMA] = infix(P_COMMA)
 	tdopRegistry[token.LOG_AND] = infix(P_LOGIC)
 	tdopRegistry[token.LOG_OR] = infix(P_LOGIC)
+	tdopRegistry[token.LOG_XOR] = infix(P_LOGIC)
 	tdopRegistry[token.LPIPE] = infix(P_PIPE)
 
 	tdopRegistry[token.SEMI] = infixOrNaught(P_SEPARATOR)
@@ -139,12 +247,13 @@ func init() {
 	tdopRegistry[token.QASSIGN] = rinfix(P_ASSIGN)
 
 	tdopRegistry[token.LPAREN] = leftBracket(P_BRACKET, token.RPAREN)
-	tdopRegistry[token.LSQR] = leftBracket(P_BRACKET, token.RSQR)
+	tdopRegistry[token.LSQR] = squareBracket(P_BRACKET)
 
 	tdopRegistry[token.WHILE] = whileExpr(P_CONTROL)
 	tdopRegistry[token.FUNC] = funcExpr(P_CONTROL)
 	tdopRegistry[token.IF] = ifExpr(P_CONTROL)
 	tdopRegistry[token.EXTERN] = externExpr(P_CONTROL)
+	tdopRegistry[token.STRUCT] = structExpr(P_CONTROL)
 
 	// TODO
 	tdopRegistry[token.COLON] = tdopEntry{}   // named parameters on function invocation
@@ -152,7 +261,6 @@ func init() {
 	tdopRegistry[token.DDOLLAR] = tdopEntry{} // execute bash command, return pipe
 	tdopRegistry[token.FOR] = tdopEntry{}     // pipe consumption loop
 	tdopRegistry[token.IMPORT] = tdopEntry{}  // load another file
-	tdopRegistry[token.STRUCT] = tdopEntry{}  // define a compound type
 	tdopRegistry[token.SWITCH] = tdopEntry{}  // multibranch conditional
 	tdopRegistry[token.ENUM] = tdopEntry{}    // define an enumeration
 	tdopRegistry[token.SYS] = tdopEntry{}     // synonym for $, $$, but take expression
@@ -190,6 +298,9 @@ func (n *Node) IsRighty() bool {
 
 // bindPowerOf looks up the binding power in the registry.
 func bindPowerOf(lex *lexer.Lexeme) int {
+	if lex == nil {
+		return 0
+	}
 	return tdopRegistry[lex.Token()].bindingPower
 }
 
@@ -238,7 +349,7 @@ func ifExprNud(node *Node, p *Parser) (*Node, error) {
 		break
 
 	default:
-		return node, parseError(newNode(p.peek()), "expecting either { or if")
+		return node, parseError(newNode(p.peek()), "", token.LBRACE, token.IF)
 	}
 
 	return node, err
@@ -277,7 +388,7 @@ func externExpr(bp int) tdopEntry {
 				}
 
 				node := newNode(p.next())
-				return node, parseError(node, "extern expecting identifier")
+				return node, parseError(node, "", token.IDENT)
 			}
 		},
 	}
@@ -350,6 +461,24 @@ func funcExpr(bp int) tdopEntry {
 	}
 }
 
+// structExpr parses a struct definition or literal:
+// struct name {...}
+// struct {...}
+func structExpr(bp int) tdopEntry {
+	return tdopEntry{
+		bindingPower: bp,
+		nud: func(node *Node, p *Parser) (*Node, error) {
+
+			if p.peekIs(token.IDENT) {
+				node.children = append(node.children, newNode(p.next()))
+			}
+
+			// Use standard block parser to add the fields.
+			return parseBlockToChild(node, p)
+		},
+	}
+}
+
 // parseBlockToChild is used for various command structures that expect a
 // brace-bounded collection of statements. Used for if, for, while, func, ...
 func parseBlockToChild(node *Node, p *Parser) (*Node, error) {
@@ -368,7 +497,7 @@ func parseBlockToChild(node *Node, p *Parser) (*Node, error) {
 		return node, nil
 	}
 
-	exp, err := p.expression(P_UNEXPECTED)
+	exp, err := p.statements(P_UNEXPECTED)
 	node.children = append(node.children, exp)
 	if err != nil {
 		return node, err
@@ -419,6 +548,70 @@ func leftBracket(bindPower int, rightBracket token.Token) tdopEntry {
 	}
 }
 
+// squareBracket is a list, or an index or slice of the value on its left. In a
+// slice, the bounds are the children of the colon, and a missing bound is nil.
+// [a, b] a list
+// a[x] list/map/struct lookup
+// a[x:y] a sub-list, from offset x to y, inclusive
+func squareBracket(bindPower int) tdopEntry {
+	return tdopEntry{
+		bindingPower: bindPower,
+		nud: func(node *Node, p *Parser) (*Node, error) {
+			return parseCommaListUntil(node, p, token.RSQR)
+		},
+		led: func(node *Node, p *Parser, left *Node) (*Node, error) {
+			node.children = append(node.children, left)
+
+			if !p.peekIs(token.COLON) && !p.peekIs(token.RSQR) {
+				exp, err := p.expression(P_COMMA)
+				node.children = append(node.children, exp)
+				if err != nil {
+					return node, err
+				}
+
+				for p.peekIs(token.COMMA) {
+					p.next()
+				}
+			}
+
+			if !p.peekIs(token.COLON) {
+				return parseCommaListUntil(node, p, token.RSQR)
+			}
+
+			colon := newNode(p.next())
+			if len(node.children) > 2 {
+				return node, parseError(colon, "", token.RSQR)
+			}
+			if len(node.children) == 2 {
+				colon.children = append(colon.children, node.children[1])
+			} else {
+				colon.children = append(colon.children, nilNode(colon))
+			}
+			node.children = append(node.children[:1], colon)
+
+			if p.peekIs(token.RSQR) {
+				colon.children = append(colon.children, nilNode(colon))
+			} else {
+				exp, err := p.expression(P_COMMA)
+				colon.children = append(colon.children, exp)
+				if err != nil {
+					return node, err
+				}
+			}
+
+			_, err := p.advance(token.RSQR)
+			return node, err
+		},
+	}
+}
+
+// nilNode makes a nil, at the position of another node, for something left
+// out.
+func nilNode(at *Node) *Node {
+	xeme := at.lexeme.WithToken(token.NIL).WithLiteral("nil")
+	return newNode(&xeme)
+}
+
 func prefix(rightBP int) tdopEntry {
 	return tdopEntry{
 		bindingPower: 0,
@@ -548,6 +741,55 @@ func prefixInfix(prefixBP, infixBP int) tdopEntry {
 	}
 }
 
+// interpolation parses an interpolated string. The lexer provides the literal
+// segments of the string, with the tokens of the embedded expressions between:
+// "a ${x} b ${y}" ==> INTERP_BEG(a) x INTERP_MID(b) y INTERP_END()
+// ==> (interp a x b y)
+func interpolation() tdopEntry {
+	return tdopEntry{
+		bindingPower: P_SELF,
+		nud: func(node *Node, p *Parser) (*Node, error) {
+
+			node.children = appendSegment(node.children, node.lexeme)
+			node.lexeme = node.lexeme.Rewrite(token.INTERP, "interp")
+
+			for {
+				exp, err := p.expression(0)
+				node.children = append(node.children, exp)
+				if err != nil {
+					return node, err
+				}
+
+				segment := p.next()
+				if segment == nil {
+					return node, parseError(nil, "", token.INTERP_END)
+				}
+
+				switch segment.Token() {
+				case token.INTERP_MID:
+					node.children = appendSegment(node.children, segment)
+				case token.INTERP_END:
+					node.children = appendSegment(node.children, segment)
+					return node, nil
+				default:
+					return node, parseError(newNode(segment), "", token.INTERP_END)
+				}
+			}
+		},
+	}
+}
+
+// appendSegment adds a (non-empty) literal segment of an interpolated string as
+// a STRING node.
+func appendSegment(children []*Node, segment *lexer.Lexeme) []*Node {
+
+	if segment.Literal() == "" {
+		return children
+	}
+
+	return append(children, newNode(segment.Rewrite(token.STRING, segment.Literal())))
+}
+
 func self() tdopEntry {
 	return tdopEntry{
 		bindingPower: P_SELF,
@@ -565,13 +807,25 @@ func (n *Node) led() ledFunc {
 	return tdopRegistry[n.Token()].led
 }
 
-func parseError(at *Node, mesg string) error {
-	if at == nil || at.lexeme == nil {
-		return fmt.Errorf("parse error: %s", mesg)
+// parseError makes a syntax error found at a node, with what was wanted
+// instead, e.g. "an expression", or else the tokens which were expected. A nil
+// node means the input ran out.
+func parseError(at *Node, want string, expected ...token.Token) error {
+
+	e := &Error{
+		Found:    token.EOF,
+		Expected: expected,
+		Want:     want,
 	}
 
-	return fmt.Errorf("parse error on line %d, pos %d, token %s: %s",
-		at.lexeme.LineNo(), at.lexeme.CharNo(), at.lexeme.Literal(), mesg)
+	if at != nil && at.lexeme != nil {
+		e.Lexeme = at.lexeme
+		e.Found = at.lexeme.Token()
+	}
+
+	e.Message = fmt.Sprintf("expected %s, found %s", e.expected(), e.found())
+
+	return e
 }
 
 // expression is the magical driver of the top down operator precedence parser.
@@ -584,11 +838,14 @@ func (p *Parser) expression(rbp int) (*Node, error) {
 	node := newNode(p.next()).righty()
 
 	if node.Token() == token.EOF {
+		if rbp > P_SEPARATOR {
+			return node, parseError(node, "an expression")
+		}
 		return node, nil
 	}
 
 	if node.nud() == nil {
-		return node, parseError(node, "unexpected token (left)")
+		return node, parseError(node, "an expression")
 	}
 
 	left, err = node.nud()(node, p)
@@ -600,7 +857,7 @@ func (p *Parser) expression(rbp int) (*Node, error) {
 
 		node := newNode(p.next()).lefty()
 		if node.led() == nil {
-			return node, parseError(node, "unexpected token (right)")
+			return node, parseError(node, "an operator")
 		}
 
 		left, err = node.led()(node, p, left)
@@ -618,13 +875,13 @@ func (p *Parser) advance(match token.Token) (*Node, error) {
 
 	l := p.next()
 	if l == nil {
-		return nil, parseError(nil, "ran out of input: no more tokens")
+		return nil, parseError(nil, "", match)
 	}
 
 	next := newNode(l)
 
 	if next.Token() != match {
-		return next, parseError(next, fmt.Sprintf("expecting %s", match.String()))
+		return next, parseError(next, "", match)
 	}
 
 	return next, nil
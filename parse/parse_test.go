@@ -1,12 +1,14 @@
 package parse_test
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
 	"github.com/pdk/gosh/lexer"
 	"github.com/pdk/gosh/parse"
 	"github.com/pdk/gosh/reader"
+	"github.com/pdk/gosh/token"
 )
 
 func TestBinaries(t *testing.T) {
@@ -206,8 +208,8 @@ func TestIf(t *testing.T) {
 		`(:= v (if (== a b) (stmts (f-apply f 1) (f-apply g 12) "first branch") (> b c) (stmts (f-apply blip) (m-apply biff glop 23 arf) "second branch") (stmts (:= a 23) (+ (m-apply bo go) 1) "third branch")))`,
 		"big if")
 
-	checkParseErr(t, `if true {} else 1+2`, "expecting either { or if")
-	checkParseErr(t, `if true return`, "expecting LBRACE")
+	checkParseErr(t, `if true {} else 1+2`, "expected `{` or `if`, found number `1`")
+	checkParseErr(t, `if true return`, "expected `{`, found `return`")
 }
 
 func TestEmbedIf(t *testing.T) {
@@ -271,6 +273,12 @@ func TestSquareBracket(t *testing.T) {
 	checkSexpr(t, "[a,b,c]", `([ a b c)`, "righty bracket 3 item")
 
 	checkSexpr(t, "[a]", `([ a)`, "list of a")
+
+	checkSexpr(t, "a[b:c]", `([ a (: b c))`, "slice")
+	checkSexpr(t, "a[b:]", `([ a (: b nil))`, "slice to the end")
+	checkSexpr(t, "a[:c+1]", `([ a (: nil (+ c 1)))`, "slice from the start")
+	checkSexpr(t, "a[:]", `([ a (: nil nil))`, "slice of everything")
+	checkLefty(t, "a[b:c]")
 }
 
 func TestFunc(t *testing.T) {
@@ -281,9 +289,9 @@ func TestFunc(t *testing.T) {
 	checkSexpr(t, `func(){}`, `(func "(" [ stmts)`, "no arg, empty")
 	checkSexpr(t, `func()[]{}`, `(func "(" [ stmts)`, "no arg, empty")
 
-	checkParseErr(t, "func{}", "expecting LPAREN")
-	checkParseErr(t, "func()", "expecting LBRACE")
-	checkParseErr(t, "func foo()", "expecting LPAREN")
+	checkParseErr(t, "func{}", "expected `(`, found `{`")
+	checkParseErr(t, "func()", "expected `{`, found end of statement")
+	checkParseErr(t, "func foo()", "expected `(`, found identifier `foo`")
 
 	checkSexpr(t, "f := func(a) {\nreturn a+1\n}",
 		`(:= f (func a [ (return (+ a 1))))`, "assign func")
@@ -317,15 +325,121 @@ func TestWhile(t *testing.T) {
 		`(while true (while (< (+ a b) 10) (if (== x 3) (return 27) (while (> (- i j) 0) (return ack)))))`,
 		`while complicated`)
 
-	checkParseErr(t, `while true if`, "token if: unexpected token")
+	checkParseErr(t, `while true if`, "token if: expected an operator, found `if`")
 
 	checkSexpr(t, `while if true {false} {}`, `(while (if true false) stmts)`, "stmt as expr")
 }
 
+func TestErrorRecovery(t *testing.T) {
+
+	checkParseErrs(t, `
+		a := (1 +
+		b := 2
+		if b { 3 } else 4
+		c := 5`,
+		"line 3, pos 5, token :=: expected an expression, found `:=`",
+		"line 4, pos 19, token 4: expected `{` or `if`, found number `4`")
+
+	// errors in blocks resume at the next statement of the block
+	checkParseErrs(t, `
+		f := func() {
+			x := ]
+			y := )
+			return x
+		}
+		g := func(]`,
+		"line 3, pos 9, token ]: expected an expression, found `]`",
+		"line 4, pos 9, token ): expected an expression, found `)`",
+		"line 7, pos 13, token ]: expected an expression, found `]`")
+
+	checkParseErrs(t, `
+		x := 1
+		}
+		y := 2`,
+		"line 3, pos 3, token }: expected a statement, found `}`")
+
+	// too many errors
+	input := strings.Repeat("x := )\n", parse.MaxErrors+5)
+	_, err := parseInput(input)
+	errs, ok := err.(parse.Errors)
+	if !ok || len(errs) != parse.MaxErrors+1 {
+		t.Fatalf("expected %d errors, and too many errors, got %v", parse.MaxErrors, err)
+	}
+	if last := errs[parse.MaxErrors].Error(); last != "parse error on line 11, pos 6, token ): too many errors" {
+		t.Errorf("expected too many errors on line 11, got %s", last)
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+
+	_, err := parseInput(`func() return`)
+
+	var parseErr *parse.Error
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a *parse.Error, got %T: %v", err, err)
+	}
+
+	if parseErr.Lexeme == nil || parseErr.Lexeme.LineNo() != 1 || parseErr.Lexeme.CharNo() != 8 {
+		t.Errorf("expected error at 1:8, got %v", parseErr.Lexeme)
+	}
+
+	if parseErr.Found != token.RETURN || len(parseErr.Expected) != 1 || parseErr.Expected[0] != token.LBRACE {
+		t.Errorf("expected LBRACE, found RETURN, got %s, found %s", parseErr.Expected, parseErr.Found)
+	}
+
+	if parseErr.Message != "expected `{`, found `return`" {
+		t.Errorf("expected the message to name the tokens as written, got %s", parseErr.Message)
+	}
+
+	// each failure says what was expected, and what was found instead
+	for input, message := range map[string]string{
+		`x := (1 +`:  "expected an expression, found end of input",
+		`extern 1`:   "expected identifier, found number `1`",
+		`if x {`:     "expected `}`, found end of input",
+		`x := 'ab'`:  "expected an expression, found invalid token `'ab'`",
+		`a := b c`:   "expected an operator, found identifier `c`",
+		`while x 1 `: "expected an operator, found number `1`",
+	} {
+		_, err := parseInput(input)
+		if !errors.As(err, &parseErr) || parseErr.Message != message {
+			t.Errorf("expected %q parsing %q, got %v", message, input, err)
+		}
+	}
+
+	_, err = parseInput(`x := (1 +`)
+	if !errors.As(err, &parseErr) || parseErr.Found != token.EOF {
+		t.Errorf("expected to find EOF, got %v", err)
+	}
+}
+
 //
 // Helpers below
 //
 
+// checkParseErrs checks that parsing finds exactly the errors matching
+// matchErrs, in order.
+func checkParseErrs(t *testing.T, input string, matchErrs ...string) {
+
+	_, err := parseInput(input)
+
+	errs, ok := err.(parse.Errors)
+	if !ok {
+		t.Errorf("expected parse.Errors for input %q, got %v", input, err)
+		return
+	}
+
+	if len(errs) != len(matchErrs) {
+		t.Errorf("expected %d errors, got %d: %s", len(matchErrs), len(errs), err)
+		return
+	}
+
+	for i, e := range errs {
+		if !strings.Contains(e.Error(), matchErrs[i]) {
+			t.Errorf("expected error with %q, but got %s", matchErrs[i], e)
+		}
+	}
+}
+
 func checkParseErr(t *testing.T, input, matchErr string) {
 
 	_, err := parseInput(input)
@@ -356,7 +470,7 @@ func checkSexpr(t *testing.T, input, expected, preface string) {
 func parseInput(input string) (*parse.Node, error) {
 
 	lines := reader.ReadLinesToStrings(strings.NewReader(input))
-	lxr := lexer.New(lines)
+	lxr := lexer.New("testing", lines)
 	parser := parse.New(lxr)
 
 	return parser.Parse()
@@ -387,3 +501,92 @@ func checkRighty(t *testing.T, input string) {
 		t.Errorf("expected %s to be Righty, but it's not", input)
 	}
 }
+
+func TestInterpolation(t *testing.T) {
+
+	checkSexpr(t, `"hello ${name}"`, `(interp "hello " name)`, "simple interpolation")
+	checkSexpr(t, `"hello ${name}, you are ${age+1}"`, `(interp "hello " name ", you are " (+ age 1))`, "two expressions")
+	checkSexpr(t, `"${a}${b}"`, `(interp a b)`, "adjacent expressions")
+	checkSexpr(t, `x := "n=${f(1, 2)}!"`, `(:= x (interp n= (f-apply f 1 2) !))`, "function call")
+	checkSexpr(t, `"a ${ "b ${c}" } d"`, `(interp "a " (interp "b " c) " d")`, "nested")
+	checkSexpr(t, "s := \"\"\"\n  select ${cols}\n  from t\n  \"\"\"", `(:= s (interp "select " cols "\nfrom t"))`, "multi-line")
+
+	checkParseErr(t, `"x ${a b}"`, "expected an operator, found identifier `b`")
+}
+
+func TestEndOfLineConstants(t *testing.T) {
+
+	// a line ending with true, false or nil ends a statement, as one ending
+	// with a number does
+	checkSexpr(t, "x := true\ny := false", "(stmts (:= x true) (:= y false))", "true, false")
+	checkSexpr(t, "x := nil\nx", "(stmts (:= x nil) x)", "nil")
+	checkSexpr(t, "if a { b := nil\n}", "(if a (:= b nil))", "nil ending a block")
+}
+
+func TestEndOfInput(t *testing.T) {
+
+	// an expression cut short by the end of the input is an error, not a crash
+	checkParseErr(t, "a +", "expected an expression, found end of input")
+	checkParseErr(t, "x :=", "expected an expression, found end of input")
+	checkParseErr(t, "a shl", "expected an expression, found end of input")
+	checkParseErr(t, "a &", "expected an expression, found end of input")
+	checkParseErr(t, "f(1) ||", "expected an expression, found end of input")
+
+	// but an empty input, or one ending with a statement, is fine
+	checkSexpr(t, "", "", "empty")
+	checkSexpr(t, "a\n", "a", "trailing newline")
+}
+
+func TestBitwise(t *testing.T) {
+
+	checkSexpr(t, "a & b", "(& a b)", "bit and")
+	checkSexpr(t, "a | b", "(| a b)", "bit or")
+	checkSexpr(t, "a ^ b", "(^ a b)", "bit xor")
+	checkSexpr(t, "a &^ b", "(&^ a b)", "bit clear")
+	checkSexpr(t, "^a", "(^ a)", "complement")
+	checkSexpr(t, "a shl 2", "(shl a 2)", "shift left")
+	checkSexpr(t, "a shr 2", "(shr a 2)", "shift right")
+
+	// same precedence as go: shifts, & and &^ with *; | and ^ with +
+	checkSexpr(t, "a | b & c", "(| a (& b c))", "and binds tighter than or")
+	checkSexpr(t, "a ^ b * c", "(^ a (* b c))", "xor with plus")
+	checkSexpr(t, "a + b shl c", "(+ a (shl b c))", "shift with mult")
+	checkSexpr(t, "a shl b shr c", "(shr (shl a b) c)", "shifts left to right")
+	checkSexpr(t, "a & b == c | d", "(== (& a b) (| c d))", "bitwise before compare")
+	checkSexpr(t, "x := flags &^ mask | bit", "(:= x (| (&^ flags mask) bit))", "clear and set")
+	checkSexpr(t, "^a & b", "(& (^ a) b)", "complement binds tight")
+	checkSexpr(t, "a ^ ^b", "(^ a (^ b))", "xor complement")
+
+	// pipes are still pipes
+	checkSexpr(t, "a >> b", "(>> a b)", "rpipe")
+	checkSexpr(t, "a << b", "(<< a b)", "lpipe")
+}
+
+func TestLogicalXor(t *testing.T) {
+
+	checkSexpr(t, "a ^^ b", "(^^ a b)", "xor")
+	checkSexpr(t, "a && b ^^ c", "(^^ (&& a b) c)", "logic left to right")
+	checkSexpr(t, "a == 1 ^^ b == 2", "(^^ (== a 1) (== b 2))", "xor of comparisons")
+	checkSexpr(t, "a ^^ b ^ c", "(^^ a (^ b c))", "logic xor vs bit xor")
+}
+
+func TestTry(t *testing.T) {
+
+	checkSexpr(t, "try(a/b)", "(try (/ a b))", "try expr")
+	checkSexpr(t, "v := try f(x)", "(:= v (try (f-apply f x)))", "try call")
+	checkSexpr(t, "try(a) || b", "(|| (try a) b)", "try binds tight")
+}
+
+func TestParenApply(t *testing.T) {
+
+	checkSexpr(t, "(f(1))", "(f-apply f 1)", "paren around apply")
+	checkSexpr(t, "x := (f())", "(:= x (f-apply f))", "paren around apply no args")
+	checkSexpr(t, "((o.m(1)))", "(m-apply o m 1)", "paren around method")
+}
+
+func TestStruct(t *testing.T) {
+
+	checkSexpr(t, "struct p { x := 0 }", "(struct p (:= x 0))", "named struct")
+	checkSexpr(t, "s := struct { x := 0; y := 1 }", "(:= s (struct (stmts (:= x 0) (:= y 1))))", "struct literal")
+	checkSexpr(t, "struct e {}", "(struct e stmts)", "empty struct")
+}
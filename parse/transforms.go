@@ -6,8 +6,8 @@ import "github.com/pdk/gosh/token"
 // things up a bit".
 func (n *Node) applyTransforms() *Node {
 
-	n = n.transformFuncApply()
 	n = n.raiseSingleTuples()
+	n = n.transformFuncApply()
 	n = n.raiseComma()
 
 	for i, c := range n.children {
@@ -65,9 +65,10 @@ func (n *Node) transformFuncApply() *Node {
 
 // raiseSingleTuples
 // ("(" x) ==> x
+// Not for a lefty "(", which is a function application, e.g. f().
 func (n *Node) raiseSingleTuples() *Node {
 
-	if n.Token() != token.LPAREN || len(n.children) != 1 {
+	if n.Token() != token.LPAREN || n.IsLefty() || len(n.children) != 1 {
 		return n
 	}
 
@@ -0,0 +1,239 @@
+// Package pprof writes the profiles of gosh programs in the format of pprof,
+// so that go tool pprof shows the time spent, and memory allocated, by gosh
+// funcs and lines, e.g. as flame graphs.
+package pprof
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/pdk/gosh/compile"
+)
+
+// The fields of the messages of profile.proto.
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+	lineColumn     = 3
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
+
+// Write writes a profile, as a gzipped profile.proto. Each sample has the
+// number of times its stack was sampled, the time spent, which go tool pprof
+// shows by default, and the bytes allocated.
+func Write(w io.Writer, p compile.Profile) error {
+
+	e := newEncoder()
+
+	for _, t := range [][2]string{{"samples", "count"}, {"time", "nanoseconds"}, {"alloc_space", "bytes"}} {
+		e.message(profileSampleType, e.valueType(t[0], t[1]))
+	}
+
+	for _, s := range p.Samples {
+		var ids []uint64
+		for _, f := range s.Stack {
+			ids = append(ids, e.location(f))
+		}
+
+		var m buffer
+		m.packed(sampleLocationID, ids)
+		m.packed(sampleValue, []uint64{uint64(s.Count), uint64(s.Time.Nanoseconds()), uint64(s.Bytes)})
+		e.message(profileSample, m)
+	}
+
+	e.out.uint64(profileTimeNanos, uint64(p.Start.UnixNano()))
+	e.out.uint64(profileDurationNanos, uint64(p.Duration.Nanoseconds()))
+	e.message(profilePeriodType, e.valueType("time", "nanoseconds"))
+	e.out.uint64(profilePeriod, uint64(p.Period.Nanoseconds()))
+	e.out.uint64(profileDefaultSampleType, e.str("time"))
+
+	// the locations, functions and strings used, now they're all known
+	e.out.append(e.locations)
+	e.out.append(e.functions)
+
+	for _, s := range e.strings {
+		e.out.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(e.out.data); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// encoder encodes a profile, numbering its strings, functions and locations as
+// they're first used.
+type encoder struct {
+	out       buffer
+	locations buffer
+	functions buffer
+
+	strings     []string
+	stringIDs   map[string]uint64
+	functionIDs map[[2]string]uint64
+	locationIDs map[compile.ProfileFrame]uint64
+}
+
+func newEncoder() *encoder {
+	return &encoder{
+		strings:     []string{""},
+		stringIDs:   map[string]uint64{"": 0},
+		functionIDs: make(map[[2]string]uint64),
+		locationIDs: make(map[compile.ProfileFrame]uint64),
+	}
+}
+
+func (e *encoder) message(tag int, m buffer) {
+	e.out.message(tag, m)
+}
+
+// str returns the index of a string in the string table.
+func (e *encoder) str(s string) uint64 {
+
+	id, ok := e.stringIDs[s]
+	if !ok {
+		id = uint64(len(e.strings))
+		e.strings = append(e.strings, s)
+		e.stringIDs[s] = id
+	}
+
+	return id
+}
+
+func (e *encoder) valueType(typ, unit string) buffer {
+
+	var m buffer
+	m.uint64(valueTypeType, e.str(typ))
+	m.uint64(valueTypeUnit, e.str(unit))
+
+	return m
+}
+
+// function returns the id of a function of a file. Names like <top level> lose
+// their angle brackets, which pprof would strip, as C++ template arguments.
+func (e *encoder) function(name, file string) uint64 {
+
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "<"), ">")
+
+	key := [2]string{name, file}
+	if id, ok := e.functionIDs[key]; ok {
+		return id
+	}
+
+	id := uint64(len(e.functionIDs) + 1)
+	e.functionIDs[key] = id
+
+	var m buffer
+	m.uint64(functionID, id)
+	m.uint64(functionName, e.str(name))
+	m.uint64(functionSystemName, e.str(name))
+	m.uint64(functionFilename, e.str(file))
+	e.functions.message(profileFunction, m)
+
+	return id
+}
+
+// location returns the id of the location of a frame: its function and line.
+func (e *encoder) location(f compile.ProfileFrame) uint64 {
+
+	if id, ok := e.locationIDs[f]; ok {
+		return id
+	}
+
+	id := uint64(len(e.locationIDs) + 1)
+	e.locationIDs[f] = id
+
+	var line buffer
+	line.uint64(lineFunctionID, e.function(f.Function, f.File))
+	line.uint64(lineLine, uint64(f.Line))
+	line.uint64(lineColumn, uint64(f.Column))
+
+	var m buffer
+	m.uint64(locationID, id)
+	m.message(locationLine, line)
+	e.locations.message(profileLocation, m)
+
+	return id
+}
+
+// buffer is an encoded protocol buffer message.
+type buffer struct {
+	data []byte
+}
+
+func (b *buffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// key encodes the key of a field: its tag, and wire type, 0 for a varint, or
+// 2 for bytes.
+func (b *buffer) key(tag int, wireType uint64) {
+	b.varint(uint64(tag)<<3 | wireType)
+}
+
+func (b *buffer) uint64(tag int, x uint64) {
+	b.key(tag, 0)
+	b.varint(x)
+}
+
+func (b *buffer) bytes(tag int, data []byte) {
+	b.key(tag, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *buffer) string(tag int, s string) {
+	b.bytes(tag, []byte(s))
+}
+
+func (b *buffer) message(tag int, m buffer) {
+	b.bytes(tag, m.data)
+}
+
+// packed encodes repeated varints.
+func (b *buffer) packed(tag int, xs []uint64) {
+
+	var p buffer
+	for _, x := range xs {
+		p.varint(x)
+	}
+
+	b.bytes(tag, p.data)
+}
+
+// append appends the fields of another message.
+func (b *buffer) append(m buffer) {
+	b.data = append(b.data, m.data...)
+}
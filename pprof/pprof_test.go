@@ -0,0 +1,138 @@
+package pprof_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/pprof"
+)
+
+// fields decodes the fields of a protocol buffer message, by tag: varints as
+// uint64s, and bytes as []byte.
+func fields(t *testing.T, data []byte) map[int][]interface{} {
+
+	t.Helper()
+
+	varint := func() uint64 {
+		var x uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatal("truncated varint")
+			}
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+
+	m := make(map[int][]interface{})
+	for len(data) > 0 {
+		key := varint()
+		tag := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			m[tag] = append(m[tag], varint())
+		case 2:
+			n := varint()
+			m[tag] = append(m[tag], data[:n])
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+
+	return m
+}
+
+func TestWrite(t *testing.T) {
+
+	p := compile.Profile{
+		Start:    time.Unix(1, 0),
+		Duration: time.Second,
+		Period:   10 * time.Millisecond,
+		Samples: []compile.ProfileSample{
+			{
+				Stack: []compile.ProfileFrame{
+					{Function: "square", File: "sq.gosh", Line: 2, Column: 2},
+					{Function: "<top level>", File: "sq.gosh", Line: 5, Column: 10},
+				},
+				Count: 3,
+				Time:  30 * time.Millisecond,
+				Bytes: 1024,
+			},
+			{
+				Stack: []compile.ProfileFrame{{Function: "<top level>", File: "sq.gosh", Line: 5, Column: 10}},
+				Count: 1,
+				Time:  10 * time.Millisecond,
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := pprof.Write(&out, p); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := fields(t, data)
+
+	var strs []string
+	for _, s := range profile[6] {
+		strs = append(strs, string(s.([]byte)))
+	}
+	str := func(i interface{}) string {
+		return strs[i.(uint64)]
+	}
+
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("expected the string table to start with the empty string, got %q", strs)
+	}
+
+	var types []string
+	for _, st := range profile[1] {
+		vt := fields(t, st.([]byte))
+		types = append(types, str(vt[1][0])+"/"+str(vt[2][0]))
+	}
+	if len(types) != 3 || types[0] != "samples/count" || types[1] != "time/nanoseconds" || types[2] != "alloc_space/bytes" {
+		t.Errorf("expected sample types of samples, time and alloc_space, got %v", types)
+	}
+	if str(profile[14][0]) != "time" {
+		t.Errorf("expected the default sample type to be time, got %s", str(profile[14][0]))
+	}
+
+	var functions []string
+	for _, f := range profile[5] {
+		functions = append(functions, str(fields(t, f.([]byte))[2][0]))
+	}
+	if len(functions) != 2 || functions[0] != "square" || functions[1] != "top level" {
+		t.Errorf("expected functions square and top level, got %v", functions)
+	}
+
+	// the frame of the top level is one location, shared by both samples
+	if len(profile[4]) != 2 || len(profile[2]) != 2 {
+		t.Fatalf("expected 2 locations and 2 samples, got %d and %d", len(profile[4]), len(profile[2]))
+	}
+
+	sample := fields(t, profile[2][0].([]byte))
+	if locations := sample[1][0].([]byte); !bytes.Equal(locations, []byte{1, 2}) {
+		t.Errorf("expected the first sample at locations 1 and 2, got %v", locations)
+	}
+	if values := sample[2][0].([]byte); !bytes.Equal(values, []byte{3, 0x80, 0x87, 0xa7, 0x0e, 0x80, 0x08}) {
+		t.Errorf("expected the first sample to have values 3, 30000000, 1024, got %v", values)
+	}
+}
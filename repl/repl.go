@@ -7,7 +7,7 @@ import (
 	"strings"
 
 	"github.com/pdk/gosh/compile"
-	"github.com/pdk/gosh/lexer"
+	"github.com/pdk/gosh/diag"
 	"github.com/pdk/gosh/parse"
 )
 
@@ -37,7 +37,7 @@ func Start(in io.Reader, out, errout io.Writer) {
 	var parenCount, bracketCount int
 
 	for {
-		fmt.Fprintf(out, Prompt)
+		fmt.Fprint(out, Prompt)
 		scanned := scanner.Scan()
 		if !scanned {
 			return
@@ -56,7 +56,9 @@ func Start(in io.Reader, out, errout io.Writer) {
 
 		vals, err := Evaluate("REPL", input, topContext)
 		if err != nil {
-			fmt.Fprintf(errout, "%s\n", err)
+			for _, d := range diag.FromErrors(err) {
+				fmt.Fprint(errout, d.Text(false))
+			}
 		}
 
 		if len(vals) > 0 {
@@ -75,25 +77,12 @@ func Start(in io.Reader, out, errout io.Writer) {
 // Evaluate lexes, parses, analyzes, compiles, and then evaluates the input.
 func Evaluate(inputName string, input []string, env *compile.Variables) ([]compile.Value, error) {
 
-	l := lexer.New(inputName, input)
-	p := parse.New(l)
-
-	result, err := p.Parse()
-	if err != nil {
-		return compile.Values(), err
-	}
-
-	ast, err := Analyze(result)
-	if err != nil {
-		return compile.Values(), err
-	}
-
-	eval, err := ast.Evaluator()
+	prog, err := compile.Compile(inputName, input)
 	if err != nil {
 		return compile.Values(), err
 	}
 
-	return eval(env)
+	return prog.Run(env)
 }
 
 func countBrackets(line string, parenCount, bracketCount int) (int, int) {
@@ -0,0 +1,305 @@
+// Package test runs the tests of gosh scripts. Tests are the top level funcs
+// named test_*, in files named *_test.gosh. Each test runs in a fresh global
+// scope, in which the top level of its file has run, and fails if it raises an
+// error, e.g. by a failed assert.
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/cover"
+	"github.com/pdk/gosh/diag"
+	"github.com/pdk/gosh/reader"
+)
+
+// Suffix names the files holding tests.
+const Suffix = "_test.gosh"
+
+// Prefix names the funcs which are tests.
+const Prefix = "test_"
+
+// Result is the outcome of a test.
+type Result struct {
+	Name    string
+	Line    int           // where the test is defined
+	Err     error         // why the test failed, or nil if it passed
+	Elapsed time.Duration // how long the test took
+}
+
+// Passed checks if the test passed.
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Find returns the test files named by paths. A path may be a file, which is
+// returned whatever its name, or a directory, which is searched, recursively,
+// for files named *_test.gosh. Directories starting with "." are skipped.
+func Find(paths []string) ([]string, error) {
+
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		var found []string
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && p != path && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() && strings.HasSuffix(info.Name(), Suffix) {
+				found = append(found, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+
+	return files, nil
+}
+
+// Tests returns the tests of a program, in the order they're defined, which
+// match run, or all of them if run is nil.
+func Tests(prog *compile.Program, run *regexp.Regexp) []compile.Symbol {
+
+	var tests []compile.Symbol
+
+	for _, sym := range prog.Symbols() {
+		if sym.Kind != "func" || !strings.HasPrefix(sym.Name, Prefix) {
+			continue
+		}
+		if run != nil && !run.MatchString(sym.Name) {
+			continue
+		}
+		tests = append(tests, sym)
+	}
+
+	return tests
+}
+
+// Run runs a test of a program in a new scope. The top level of the program
+// runs first, defining the test. A failure of the top level fails the test.
+func Run(prog *compile.Program, test compile.Symbol, vars *compile.Variables) Result {
+	return runTest(prog, test, vars, nil)
+}
+
+// runTest runs a test, calling between, if not nil, after the top level and
+// before the test itself.
+func runTest(prog *compile.Program, test compile.Symbol, vars *compile.Variables, between func()) Result {
+
+	start := time.Now()
+
+	result := Result{Name: test.Name, Line: test.Line}
+	if len(test.Parameters) > 0 {
+		result.Err = tooManyParameters(test.Name, test.Parameters)
+		return result
+	}
+
+	result.Err = run(prog, test.Name, vars, between)
+	result.Elapsed = time.Since(start)
+
+	return result
+}
+
+func run(prog *compile.Program, name string, vars *compile.Variables, between func()) error {
+
+	_, err := prog.Run(vars)
+	if err != nil {
+		return err
+	}
+
+	if between != nil {
+		between()
+	}
+
+	val, err := vars.Value(name)
+	if err != nil {
+		return fmt.Errorf("%s is not defined at the top level", name)
+	}
+
+	f, ok := val.(compile.Function)
+	if !ok {
+		return fmt.Errorf("%s is not a func, it's a %s", name, compile.TypeName(val))
+	}
+
+	_, err = compile.Apply(f)
+
+	return err
+}
+
+// tooManyParameters is the error of a test which takes parameters.
+func tooManyParameters(name string, params []string) error {
+	return fmt.Errorf("%s takes %d parameter(s), but tests take none", name, len(params))
+}
+
+// Runner runs the tests of files, reporting as it goes.
+type Runner struct {
+	Printer diag.Printer              // reports results, and failures
+	Run     *regexp.Regexp            // selects the tests to run, nil for all
+	Verbose bool                      // report tests which pass, not just files
+	Scope   func() *compile.Variables // makes the global scope of each test
+
+	// Coverage, if not nil, records the coverage of the files, which is
+	// reported with the results of each.
+	Coverage *compile.Coverage
+}
+
+// Files runs the tests of files, returning false if any failed, or a file
+// couldn't be read or compiled.
+func (r *Runner) Files(fileNames []string) bool {
+
+	ok := true
+
+	for _, fileName := range fileNames {
+		start := time.Now()
+
+		passed, count := r.file(fileName)
+		ok = ok && passed
+
+		status := "ok  "
+		if !passed {
+			status = "FAIL"
+		}
+
+		elapsed := fmt.Sprintf("%.3fs", time.Since(start).Seconds())
+		if count == 0 && passed {
+			elapsed = "[no tests to run]"
+		}
+
+		if r.Coverage != nil && count > 0 {
+			elapsed += "\tcoverage: " + r.coverage(fileName)
+		}
+
+		fmt.Fprintf(r.Printer.Out, "%s\t%s\t%s\n", status, fileName, elapsed)
+	}
+
+	return ok
+}
+
+// file runs the tests of a file, returning whether they all passed, and how
+// many ran.
+func (r *Runner) file(fileName string) (bool, int) {
+
+	input, err := reader.ReadLines(fileName)
+	if err != nil {
+		r.Printer.Print(diag.FromError(err))
+		return false, 0
+	}
+
+	prog, err := compile.Compile(fileName, input)
+	if err != nil {
+		for _, d := range diag.FromErrors(err) {
+			r.Printer.Print(d)
+		}
+		return false, 0
+	}
+
+	passed := true
+	for _, problem := range prog.Check() {
+		if problem.Warning {
+			continue
+		}
+		d := diag.FromProblem(problem)
+		d.Suggest(prog.Unbound())
+		r.Printer.Print(d)
+		passed = false
+	}
+	if !passed {
+		return false, 0
+	}
+
+	tests := Tests(prog, r.Run)
+
+	if r.Coverage != nil && len(tests) > 0 {
+		if err := r.Coverage.Add(prog); err != nil {
+			r.Printer.Print(diag.FromError(err))
+			return false, 0
+		}
+	}
+
+	for i, test := range tests {
+		if len(test.Parameters) > 0 {
+			// reported at the definition, rather than run
+			passed = false
+			fmt.Fprintf(r.Printer.Out, "--- FAIL: %s (%s:%d)\n", test.Name, fileName, test.Line)
+			r.Printer.Print(diag.Diagnostic{
+				Severity:  diag.Error,
+				File:      fileName,
+				Line:      test.Line,
+				Column:    test.Column,
+				EndColumn: test.Column + len("func"),
+				Message:   tooManyParameters(test.Name, test.Parameters).Error(),
+				Source:    input[test.Line-1],
+			})
+			continue
+		}
+
+		vars := r.Scope()
+
+		// the top level runs before every test, but its coverage is recorded
+		// only with the first
+		var between func()
+		if r.Coverage != nil && i == 0 {
+			vars.SetCoverage(r.Coverage)
+		} else if r.Coverage != nil {
+			between = func() { vars.SetCoverage(r.Coverage) }
+		}
+
+		result := runTest(prog, test, vars, between)
+
+		if result.Passed() {
+			if r.Verbose {
+				fmt.Fprintf(r.Printer.Out, "--- PASS: %s (%.3fs)\n", result.Name, result.Elapsed.Seconds())
+			}
+			continue
+		}
+
+		passed = false
+		fmt.Fprintf(r.Printer.Out, "--- FAIL: %s (%s:%d, %.3fs)\n", result.Name, fileName, result.Line, result.Elapsed.Seconds())
+		for _, d := range diag.FromErrors(result.Err) {
+			d.Suggest(prog.Unbound())
+			r.Printer.Print(d)
+		}
+	}
+
+	return passed, len(tests)
+}
+
+// coverage describes the coverage of a file.
+func (r *Runner) coverage(fileName string) string {
+
+	var blocks []compile.Block
+	for _, b := range r.Coverage.Blocks() {
+		if b.File == fileName {
+			blocks = append(blocks, b)
+		}
+	}
+
+	for _, s := range cover.Summarize(blocks) {
+		return s.String()
+	}
+
+	return "[no statements]"
+}
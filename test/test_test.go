@@ -0,0 +1,239 @@
+package test_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/diag"
+	"github.com/pdk/gosh/test"
+)
+
+const mathTests = `seen := []
+
+add := func(a, b) { a + b }
+
+test_add := func() {
+	seen.append(1)
+	assert_eq(add(1, 2), 3)
+	assert_eq(seen.len(), 1, "each test runs in a fresh scope")
+}
+
+test_again := func() {
+	seen.append(1)
+	assert_eq(seen.len(), 1, "each test runs in a fresh scope")
+}
+
+test_lists := func() {
+	assert_eq([add(1, 1), 3], [2, 4])
+}
+`
+
+// files writes files to a new directory, returning its name.
+func files(t *testing.T, contents map[string]string) string {
+
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gosh-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range contents {
+		fileName := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestFind(t *testing.T) {
+
+	dir := files(t, map[string]string{
+		"a_test.gosh":         "",
+		"lib/b_test.gosh":     "",
+		"lib/b.gosh":          "",
+		".hidden/c_test.gosh": "",
+	})
+	defer os.RemoveAll(dir)
+
+	found, err := test.Find([]string{dir, filepath.Join(dir, "lib/b.gosh")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range found {
+		found[i] = strings.TrimPrefix(found[i], dir+"/")
+	}
+
+	expected := []string{"a_test.gosh", "lib/b_test.gosh", "lib/b.gosh"}
+	if strings.Join(found, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected to find %v, got %v", expected, found)
+	}
+
+	if _, err := test.Find([]string{filepath.Join(dir, "nope")}); err == nil {
+		t.Errorf("expected an error finding a missing path")
+	}
+}
+
+// runTests runs the tests of a file, returning what was reported, and whether
+// they passed.
+func runTests(t *testing.T, content string, run *regexp.Regexp, verbose bool) (string, bool) {
+
+	t.Helper()
+
+	dir := files(t, map[string]string{"math_test.gosh": content})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	r := test.Runner{
+		Printer: diag.Printer{Out: &out},
+		Run:     run,
+		Verbose: verbose,
+		Scope:   compile.GlobalScope,
+	}
+
+	passed := r.Files([]string{filepath.Join(dir, "math_test.gosh")})
+
+	// the times and directory vary
+	report := regexp.MustCompile(`\d+\.\d+s`).ReplaceAllString(out.String(), "T")
+	report = strings.Replace(report, dir+"/", "", -1)
+
+	return report, passed
+}
+
+func TestRunner(t *testing.T) {
+
+	report, passed := runTests(t, mathTests, nil, true)
+	if passed {
+		t.Errorf("expected the tests to fail")
+	}
+
+	expected := `--- PASS: test_add (T)
+--- PASS: test_again (T)
+--- FAIL: test_lists (math_test.gosh:16, T)
+error: assert_eq failed: got [2, 3], want [2, 4]
+	[1]: got 3, want 4
+  --> math_test.gosh:17:2
+   |
+17 | 	assert_eq([add(1, 1), 3], [2, 4])
+   | 	^^^^^^^^^
+   = note: at test_lists
+FAIL	math_test.gosh	T
+`
+	if report != expected {
+		t.Errorf("expected report\n%s\ngot\n%s", expected, report)
+	}
+
+	report, passed = runTests(t, mathTests, regexp.MustCompile("add"), false)
+	if !passed || report != "ok  \tmath_test.gosh\tT\n" {
+		t.Errorf("expected test_add alone to pass, got %v\n%s", passed, report)
+	}
+
+	report, passed = runTests(t, mathTests, regexp.MustCompile("nothing"), false)
+	if !passed || report != "ok  \tmath_test.gosh\t[no tests to run]\n" {
+		t.Errorf("expected no tests to run, got %v\n%s", passed, report)
+	}
+}
+
+func TestRunnerErrors(t *testing.T) {
+
+	report, passed := runTests(t, "test_x := func() { y }\n", nil, false)
+	if passed || !strings.Contains(report, "undefined variable y") || !strings.HasSuffix(report, "FAIL\tmath_test.gosh\tT\n") {
+		t.Errorf("expected an unbound name to fail the file, got\n%s", report)
+	}
+
+	report, passed = runTests(t, "div := func(a, b) { a / b }\ntest_x := func() { div(1, 0) }\n", nil, false)
+	if passed || !strings.Contains(report, "--- FAIL: test_x (math_test.gosh:2, T)\nerror: integer division by zero") {
+		t.Errorf("expected a failure to fail the test, got\n%s", report)
+	}
+
+	report, passed = runTests(t, "test_x := func(a) { a }\ntest_y := func() { 1 }\n", nil, true)
+	expected := `--- FAIL: test_x (math_test.gosh:1)
+error: test_x takes 1 parameter(s), but tests take none
+ --> math_test.gosh:1:11
+  |
+1 | test_x := func(a) { a }
+  |           ^^^^
+--- PASS: test_y (T)
+FAIL	math_test.gosh	T
+`
+	if passed || report != expected {
+		t.Errorf("expected a test taking parameters to fail at its definition, got\n%s", report)
+	}
+}
+
+func TestRunnerCoverage(t *testing.T) {
+
+	dir := files(t, map[string]string{"abs_test.gosh": `abs := func(x) {
+	if x < 0 {
+		return -x
+	}
+	x
+}
+
+test_abs := func() {
+	assert_eq(abs(2), 2)
+}
+`})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	r := test.Runner{
+		Printer:  diag.Printer{Out: &out},
+		Scope:    compile.GlobalScope,
+		Coverage: compile.NewCoverage(),
+	}
+
+	if !r.Files([]string{filepath.Join(dir, "abs_test.gosh")}) {
+		t.Fatalf("expected the tests to pass, got\n%s", out.String())
+	}
+
+	if !strings.HasSuffix(out.String(), "\tcoverage: 83.3% of statements, 50.0% of branches\n") {
+		t.Errorf("expected the coverage of abs_test.gosh, got %s", out.String())
+	}
+}
+
+func TestRunnerCoverageCounts(t *testing.T) {
+
+	// the top level runs before each test, but is counted once
+	dir := files(t, map[string]string{"count_test.gosh": `double := func(x) { x * 2 }
+
+test_one := func() { assert_eq(double(1), 2) }
+
+test_two := func() { assert_eq(double(2), 4) }
+`})
+	defer os.RemoveAll(dir)
+
+	coverage := compile.NewCoverage()
+	r := test.Runner{
+		Printer:  diag.Printer{Out: &bytes.Buffer{}},
+		Scope:    compile.GlobalScope,
+		Coverage: coverage,
+	}
+
+	if !r.Files([]string{filepath.Join(dir, "count_test.gosh")}) {
+		t.Fatalf("expected the tests to pass")
+	}
+
+	var counts []string
+	for _, b := range coverage.Blocks() {
+		counts = append(counts, fmt.Sprintf("%d:%d %d", b.Line, b.Column, b.Count))
+	}
+
+	expected := "1:8 1, 1:23 2, 3:10 1, 3:31 1, 5:10 1, 5:31 1"
+	if got := strings.Join(counts, ", "); got != expected {
+		t.Errorf("expected counts %s, got %s", expected, got)
+	}
+}
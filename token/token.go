@@ -17,6 +17,9 @@ const (
 	FLOAT                     // 123.45
 	CHAR                      // 'a'
 	STRING                    // "abc"
+	INTERP_BEG                // "abc${
+	INTERP_MID                // }abc${
+	INTERP_END                // }abc"
 	LiteralEnd                // end of literals
 	OperatorBeg               // start of operators and delimiters
 	PLUS                      // +
@@ -29,6 +32,11 @@ const (
 	ACCUM                     // +=
 	LOG_AND                   // &&
 	LOG_OR                    // ||
+	LOG_XOR                   // ^^
+	BIT_AND                   // &
+	BIT_OR                    // |
+	BIT_XOR                   // ^
+	BIT_CLEAR                 // &^
 	EQUAL                     // ==
 	LESS                      // <
 	GRTR                      // >
@@ -73,11 +81,15 @@ const (
 	ENUM                      // enum
 	EXTERN                    // extern
 	SYS                       // sys
+	SHL                       // shl
+	SHR                       // shr
+	TRY                       // try
 	KeywordEnd                // end of reserved/key words
 	TransformResultsBeg       // start of items produced by parser transforms
 	FUNCAPPLY                 // function apply
 	METHAPPLY                 // method apply
 	STMTS                     //  statements
+	INTERP                    // string interpolation
 	TransformResultsEnd       // end of items produced by parser transforms
 )
 
@@ -90,6 +102,9 @@ var tokens = [...]string{
 	FLOAT:      "FLOAT",
 	CHAR:       "CHAR",
 	STRING:     "STRING",
+	INTERP_BEG: "INTERP_BEG",
+	INTERP_MID: "INTERP_MID",
+	INTERP_END: "INTERP_END",
 	PLUS:       "PLUS",
 	MINUS:      "MINUS",
 	MULT:       "MULT",
@@ -100,6 +115,11 @@ var tokens = [...]string{
 	ACCUM:      "ACCUM",
 	LOG_AND:    "LOG_AND",
 	LOG_OR:     "LOG_OR",
+	LOG_XOR:    "LOG_XOR",
+	BIT_AND:    "BIT_AND",
+	BIT_OR:     "BIT_OR",
+	BIT_XOR:    "BIT_XOR",
+	BIT_CLEAR:  "BIT_CLEAR",
 	EQUAL:      "EQUAL",
 	LESS:       "LESS",
 	GRTR:       "GRTR",
@@ -142,9 +162,13 @@ var tokens = [...]string{
 	ENUM:       "ENUM",
 	EXTERN:     "EXTERN",
 	SYS:        "SYS",
+	SHL:        "SHL",
+	SHR:        "SHR",
+	TRY:        "TRY",
 	FUNCAPPLY:  "FUNCAPPLY",
 	METHAPPLY:  "METHAPPLY",
 	STMTS:      "STMTS",
+	INTERP:     "INTERP",
 }
 
 // String returns a string of a Token.
@@ -159,6 +183,101 @@ func (t Token) String() string {
 	return s
 }
 
+// operators are the operators and delimiters, as written.
+var operators = [...]string{
+	PLUS:       "+",
+	MINUS:      "-",
+	MULT:       "*",
+	DIV:        "/",
+	MODULO:     "%",
+	LPIPE:      "<<",
+	RPIPE:      ">>",
+	ACCUM:      "+=",
+	LOG_AND:    "&&",
+	LOG_OR:     "||",
+	LOG_XOR:    "^^",
+	BIT_AND:    "&",
+	BIT_OR:     "|",
+	BIT_XOR:    "^",
+	BIT_CLEAR:  "&^",
+	EQUAL:      "==",
+	LESS:       "<",
+	GRTR:       ">",
+	ASSIGN:     ":=",
+	QASSIGN:    "?=",
+	NOT:        "!",
+	NOT_EQUAL:  "!=",
+	LESS_EQUAL: "<=",
+	GRTR_EQUAL: ">=",
+	LPAREN:     "(",
+	LSQR:       "[",
+	LBRACE:     "{",
+	COMMA:      ",",
+	PERIOD:     ".",
+	RPAREN:     ")",
+	RSQR:       "]",
+	RBRACE:     "}",
+	SEMI:       ";",
+	COLON:      ":",
+	DOLLAR:     "$",
+	DDOLLAR:    "$$",
+}
+
+// Text describes a token for messages to users: operators and keywords as
+// they're written, in backquotes, and other tokens by what they are, e.g.
+// identifier.
+func (t Token) Text() string {
+
+	switch t {
+	case ILLEGAL:
+		return "invalid token"
+	case EOF:
+		return "end of input"
+	case SEMI:
+		return "end of statement"
+	case IDENT:
+		return "identifier"
+	case INT, FLOAT:
+		return "number"
+	case CHAR:
+		return "character"
+	case STRING, INTERP_BEG:
+		return "string"
+	case INTERP_MID, INTERP_END:
+		return "end of interpolation"
+	case COMMENT:
+		return "comment"
+	}
+
+	if t.IsOperator() && int(t) < len(operators) && operators[t] != "" {
+		return "`" + operators[t] + "`"
+	}
+
+	for word, tok := range reserved {
+		if tok == t {
+			return "`" + word + "`"
+		}
+	}
+
+	return t.String()
+}
+
+// IsString returns true if the token is a string literal, or a literal segment
+// of an interpolated string.
+func (t Token) IsString() bool {
+	return t == STRING || t == INTERP_BEG || t == INTERP_MID || t == INTERP_END
+}
+
+// IsOperator returns true if the token is an operator or delimiter.
+func (t Token) IsOperator() bool {
+	return OperatorBeg < t && t < OperatorEnd
+}
+
+// IsKeyword returns true if the token is a reserved word.
+func (t Token) IsKeyword() bool {
+	return KeywordBeg < t && t < KeywordEnd
+}
+
 var reserved = map[string]Token{
 	"break":    BREAK,
 	"continue": CONTINUE,
@@ -181,6 +300,9 @@ var reserved = map[string]Token{
 	"enum":     ENUM,
 	"extern":   EXTERN,
 	"sys":      SYS,
+	"shl":      SHL,
+	"shr":      SHR,
+	"try":      TRY,
 }
 
 // CheckIdent checks if it's a reserved/keyword. Return either the reserved
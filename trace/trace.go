@@ -0,0 +1,130 @@
+// Package trace writes the traces of gosh programs: a line for each node
+// evaluated, as text, indented by the depth of calls, or as JSON.
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/lexer"
+)
+
+// Writer writes the events of a trace, each as a line.
+type Writer struct {
+	w    io.Writer
+	json bool
+	err  error // the first error writing
+}
+
+// NewWriter returns a writer of a trace in a format: text, or json, for JSON
+// lines.
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown trace format %q, expected text or json", format)
+	}
+
+	return &Writer{w: w, json: format == "json"}, nil
+}
+
+// event is the JSON of an event.
+type event struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Token    string   `json:"token"`
+	Literal  string   `json:"literal"`
+	Function string   `json:"function"`
+	Depth    int      `json:"depth"`
+	Inputs   []string `json:"inputs,omitempty"`
+	Results  []string `json:"results,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Log writes an event. Writing stops at the first error, which Err returns.
+func (tw *Writer) Log(e compile.TraceEvent) {
+
+	if tw.err != nil {
+		return
+	}
+
+	var line []byte
+
+	if tw.json {
+		j := event{
+			File:     e.File,
+			Line:     e.Line,
+			Column:   e.Column,
+			Token:    e.Token,
+			Literal:  e.Literal,
+			Function: e.Function,
+			Depth:    e.Depth,
+			Inputs:   e.Inputs,
+			Results:  e.Results,
+		}
+		if e.Err != nil {
+			j.Error = message(e.Err)
+		}
+
+		// without escaping, e.g. the <> of <top level>, which isn't html
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if tw.err = enc.Encode(j); tw.err != nil {
+			return
+		}
+		line = buf.Bytes()
+	} else {
+		line = []byte(Text(e) + "\n")
+	}
+
+	_, tw.err = tw.w.Write(line)
+}
+
+// Err returns the first error writing the trace.
+func (tw *Writer) Err() error {
+	return tw.err
+}
+
+// Text describes an event as a line of text, indented by its depth of calls,
+// e.g.
+//
+//	square.gosh:2:9 MULT * (3, 3) => 9
+func Text(e compile.TraceEvent) string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s%s:%d:%d %s %s", strings.Repeat("  ", e.Depth), e.File, e.Line, e.Column, e.Token, e.Literal)
+
+	if len(e.Inputs) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(e.Inputs, ", "))
+	}
+
+	switch {
+	case e.Err != nil:
+		fmt.Fprintf(&b, " => error: %s", message(e.Err))
+	case len(e.Results) == 0:
+		b.WriteString(" => ()")
+	default:
+		fmt.Fprintf(&b, " => %s", strings.Join(e.Results, ", "))
+	}
+
+	return b.String()
+}
+
+// message returns the message of an error, without its location and source
+// line, so that each event is a line.
+func message(err error) string {
+
+	var lexErr *lexer.Error
+	if errors.As(err, &lexErr) {
+		return lexErr.Message
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,51 @@
+package trace_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pdk/gosh/compile"
+	"github.com/pdk/gosh/trace"
+)
+
+var events = []compile.TraceEvent{
+	{Token: "MULT", Literal: "*", File: "sq.gosh", Line: 2, Column: 11, Function: "square", Depth: 1, Inputs: []string{"3", "3"}, Results: []string{"9"}},
+	{Token: "FUNCAPPLY", Literal: "f-apply", File: "sq.gosh", Line: 4, Column: 16, Function: "<top level>", Err: errors.New("oops")},
+	{Token: "IDENT", Literal: "done", File: "sq.gosh", Line: 5, Column: 1, Function: "<top level>"},
+}
+
+func TestWriter(t *testing.T) {
+
+	for _, c := range []struct {
+		format   string
+		expected string
+	}{
+		{"text", `  sq.gosh:2:11 MULT * (3, 3) => 9
+sq.gosh:4:16 FUNCAPPLY f-apply => error: oops
+sq.gosh:5:1 IDENT done => ()
+`},
+		{"json", `{"file":"sq.gosh","line":2,"column":11,"token":"MULT","literal":"*","function":"square","depth":1,"inputs":["3","3"],"results":["9"]}
+{"file":"sq.gosh","line":4,"column":16,"token":"FUNCAPPLY","literal":"f-apply","function":"<top level>","depth":0,"error":"oops"}
+{"file":"sq.gosh","line":5,"column":1,"token":"IDENT","literal":"done","function":"<top level>","depth":0}
+`},
+	} {
+		var out bytes.Buffer
+		tw, err := trace.NewWriter(&out, c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range events {
+			tw.Log(e)
+		}
+
+		if tw.Err() != nil || out.String() != c.expected {
+			t.Errorf("expected %s trace\n%s\ngot\n%s%v", c.format, c.expected, out.String(), tw.Err())
+		}
+	}
+
+	if _, err := trace.NewWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
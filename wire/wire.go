@@ -0,0 +1,66 @@
+// Package wire reads and writes JSON messages framed by a Content-Length
+// header, as the Language Server and Debug Adapter protocols send them.
+package wire
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxLength is the largest message body Read accepts.
+const MaxLength = 64 << 20
+
+// Read reads the body of the next message. It returns io.EOF if the input
+// ends between messages.
+func Read(in *bufio.Reader) ([]byte, error) {
+
+	length := -1
+
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(line[:i], "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("bad Content-Length: %s", line)
+			}
+			if length > MaxLength {
+				return nil, fmt.Errorf("message of %d bytes exceeds the limit of %d", length, MaxLength)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+
+	body := make([]byte, length)
+	_, err := io.ReadFull(in, body)
+
+	return body, err
+}
+
+// Write writes a message, encoded as JSON.
+func Write(out io.Writer, msg interface{}) error {
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	return err
+}